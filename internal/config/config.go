@@ -2,8 +2,12 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -20,6 +24,9 @@ type Config struct {
 	Douyin     DouyinConfig     `mapstructure:"douyin"`
 	Database   DatabaseConfig   `mapstructure:"database"`
 	Security   SecurityConfig   `mapstructure:"security"`
+	Webhooks   WebhooksConfig   `mapstructure:"webhooks"`
+	Sites      []SiteConfig     `mapstructure:"sites"`
+	Proxy      ProxyConfig      `mapstructure:"proxy"`
 }
 
 // ServerConfig 服务器配置
@@ -42,8 +49,13 @@ type LogConfig struct {
 type DownloaderConfig struct {
 	OutputDir     string `mapstructure:"output_dir"`
 	MaxRetries    int    `mapstructure:"max_retries"`
-	Timeout       int    `mapstructure:"timeout"`
+	Timeout       int    `mapstructure:"timeout"`       // 单个任务的最长下载时间（秒），0 表示不限制
+	StallTimeout  int    `mapstructure:"stall_timeout"` // 没有进度时中止下载的时间（秒），0 表示不检测
+	InfoTimeout   int    `mapstructure:"info_timeout"`  // 获取视频信息的超时时间（秒）
 	MaxConcurrent int    `mapstructure:"max_concurrent"`
+	MaxQueue      int    `mapstructure:"max_queue"`
+	UserQuotaMB   int    `mapstructure:"user_quota_mb"` // 启用认证时每个用户下载目录的默认容量（MB），0 表示不限制
+	Dedupe        string `mapstructure:"dedupe"`        // 重复视频的处理方式：reuse、skip 或 force
 }
 
 // YtDlpConfig yt-dlp 配置
@@ -53,9 +65,9 @@ type YtDlpConfig struct {
 	CookiesFile  string `mapstructure:"cookies_file"`
 	Proxy        string `mapstructure:"proxy"`
 	Format       string `mapstructure:"format"`
-	ExtractAudio bool   `mapstructure:"extract_audio"`
-	AudioFormat  string `mapstructure:"audio_format"`
-	AudioQuality string `mapstructure:"audio_quality"`
+	ExtractAudio bool   `mapstructure:"extract_audio"` // 请求未指定 mode 时只下载音频
+	AudioFormat  string `mapstructure:"audio_format"`  // 音频模式默认的 --audio-format
+	AudioQuality string `mapstructure:"audio_quality"` // 音频模式默认的 --audio-quality
 }
 
 // Aria2Config aria2c 配置
@@ -64,6 +76,9 @@ type Aria2Config struct {
 	MaxConnections int    `mapstructure:"max_connections"`
 	MinSplitSize   int    `mapstructure:"min_split_size"`
 	Continue       bool   `mapstructure:"continue"`
+	Enabled        bool   `mapstructure:"enabled"`    // yt-dlp 使用 aria2c 作为外部下载器
+	RPCURL         string `mapstructure:"rpc_url"`    // aria2 JSON-RPC 地址，配置后直链文件由 aria2 下载
+	RPCSecret      string `mapstructure:"rpc_secret"` // aria2 --rpc-secret
 }
 
 // DouyinConfig 抖音下载配置
@@ -83,9 +98,210 @@ type DatabaseConfig struct {
 // SecurityConfig 安全配置
 type SecurityConfig struct {
 	CorsOrigins       []string      `mapstructure:"cors_origins"`
+	TrustedProxies    []string      `mapstructure:"trusted_proxies"` // 可信反向代理的 IP 或网段，只有来自它们的 X-Forwarded-For 才会被采信
 	RateLimit         int           `mapstructure:"rate_limit"`
 	RateLimitWindow   string        `mapstructure:"rate_limit_window"`
 	RateLimitDuration time.Duration `mapstructure:"-"`
+	RateLimits        RateLimits    `mapstructure:"rate_limits"`
+	Auth              AuthConfig    `mapstructure:"auth"`
+	CookieSecret      string        `mapstructure:"cookie_secret"` // 加密上传的 Cookie，为空时使用数据库目录下自动生成的 cookies.key
+}
+
+// AuthConfig API 认证配置
+type AuthConfig struct {
+	Enabled bool           `mapstructure:"enabled"`
+	Keys    []APIKeyConfig `mapstructure:"keys"`
+}
+
+// APIKeyConfig 静态API密钥，通过 Authorization: Bearer 或 X-API-Key 请求头传递
+type APIKeyConfig struct {
+	Name    string   `mapstructure:"name"`
+	Key     string   `mapstructure:"key"`
+	Scopes  []string `mapstructure:"scopes"`   // read / download / admin
+	QuotaMB int      `mapstructure:"quota_mb"` // 下载目录容量（MB），0 表示使用 downloader.user_quota_mb
+}
+
+// RateLimits 各接口每个客户端 IP 在时间窗口内的请求预算，0 表示使用 rate_limit
+type RateLimits struct {
+	VideoInfo      int `mapstructure:"video_info"`
+	Download       int `mapstructure:"download"`
+	DirectDownload int `mapstructure:"direct_download"`
+}
+
+// WebhooksConfig webhook 配置
+type WebhooksConfig struct {
+	MaxRetries int             `mapstructure:"max_retries"` // 投递失败后的最多重试次数
+	Timeout    int             `mapstructure:"timeout"`     // 单次请求的超时时间（秒）
+	Endpoints  []WebhookConfig `mapstructure:"endpoints"`
+}
+
+// WebhookConfig 配置文件中的 webhook 订阅，接收所有任务的事件
+type WebhookConfig struct {
+	Name   string   `mapstructure:"name"`
+	URL    string   `mapstructure:"url"`
+	Secret string   `mapstructure:"secret"` // 用于 HMAC-SHA256 签名，为空时不签名
+	Events []string `mapstructure:"events"` // created / started / completed / failed / cancelled，为空时订阅所有事件
+}
+
+// SiteConfig 按主机名匹配的网站配置，获取视频信息、yt-dlp 下载和抖音原生请求共用
+type SiteConfig struct {
+	Name               string            `mapstructure:"name"`
+	Hosts              []string          `mapstructure:"hosts"`   // 主机名，同时匹配子域名；*.example.com 只匹配子域名
+	Headers            map[string]string `mapstructure:"headers"` // 额外的请求头，名称不区分大小写
+	UserAgent          string            `mapstructure:"user_agent"`
+	Referer            string            `mapstructure:"referer"`
+	ExtractorArgs      []string          `mapstructure:"extractor_args"` // yt-dlp --extractor-args，例如 douyin:app_version=9.9.10
+	Format             string            `mapstructure:"format"`         // 请求未指定格式时使用
+	CookiesFile        string            `mapstructure:"cookies_file"`   // 为空时使用 ytdlp.cookies_file
+	NoCheckCertificate bool              `mapstructure:"no_check_certificate"`
+}
+
+// Matches 判断主机名是否属于该网站
+func (s *SiteConfig) Matches(host string) bool {
+	return matchHost(s.Hosts, host)
+}
+
+// matchHost 判断主机名是否匹配列表中的主机名或其子域名，*.example.com 只匹配子域名
+func matchHost(patterns []string, host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern || strings.HasSuffix(host, "."+pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Site 返回链接匹配的第一个网站配置，没有匹配时返回 nil
+func (c *Config) Site(rawURL string) *SiteConfig {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	for i := range c.Sites {
+		if c.Sites[i].Matches(u.Hostname()) {
+			return &c.Sites[i]
+		}
+	}
+	return nil
+}
+
+// ProxyConfig 代理配置，按主机名选择直连、固定代理或代理池
+type ProxyConfig struct {
+	Default string            `mapstructure:"default"` // 没有匹配的规则时使用，为空时使用 ytdlp.proxy
+	Rules   []ProxyRule       `mapstructure:"rules"`
+	Pools   []ProxyPoolConfig `mapstructure:"pools"`
+}
+
+// ProxyRule 按主机名匹配的代理规则
+type ProxyRule struct {
+	Hosts []string `mapstructure:"hosts"` // 与 sites 的 hosts 规则相同
+	Proxy string   `mapstructure:"proxy"` // direct、代理地址或 pool:<代理池名称>
+}
+
+// ProxyPoolConfig 代理池，轮流使用其中可用的代理
+type ProxyPoolConfig struct {
+	Name          string   `mapstructure:"name"`
+	Proxies       []string `mapstructure:"proxies"`
+	CheckURL      string   `mapstructure:"check_url"`      // 健康检查地址，返回 2xx/3xx 视为可用
+	CheckInterval int      `mapstructure:"check_interval"` // 健康检查间隔（秒）
+	MaxFailures   int      `mapstructure:"max_failures"`   // 连续失败多少次后标记为不可用
+}
+
+// ProxyFor 返回链接使用的代理配置：第一条匹配的规则，其次是 proxy.default 和 ytdlp.proxy，
+// 都为空时返回空字符串（沿用环境变量中的代理）
+func (c *Config) ProxyFor(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Hostname() != "" {
+		for _, rule := range c.Proxy.Rules {
+			if matchHost(rule.Hosts, u.Hostname()) {
+				return rule.Proxy
+			}
+		}
+	}
+	if c.Proxy.Default != "" {
+		return c.Proxy.Default
+	}
+	return c.YtDlp.Proxy
+}
+
+// browserHeaders 网站配置默认使用的浏览器请求头
+func browserHeaders(language string) map[string]string {
+	return map[string]string{
+		"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9",
+		"Accept-Language":           language,
+		"Accept-Encoding":           "gzip, deflate, br",
+		"DNT":                       "1",
+		"Connection":                "keep-alive",
+		"Upgrade-Insecure-Requests": "1",
+		"Sec-Fetch-Dest":            "document",
+		"Sec-Fetch-Mode":            "navigate",
+		"Sec-Fetch-Site":            "none",
+	}
+}
+
+// defaultSites 未配置 sites 时使用的网站配置；抖音沿用 douyin.mobile_ua，douyin-app 用于抖音移动端接口
+func defaultSites(config *Config) []SiteConfig {
+	spankbang := browserHeaders("en-US,en;q=0.5")
+	spankbang["Accept"] = "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8"
+	spankbang["Accept-Encoding"] = "gzip, deflate"
+	spankbang["Sec-Fetch-User"] = "?1"
+	spankbang["Cache-Control"] = "max-age=0"
+
+	pinterest := browserHeaders("en-US,en;q=0.9")
+	for _, key := range []string{"Sec-Fetch-Dest", "Sec-Fetch-Mode", "Sec-Fetch-Site"} {
+		delete(pinterest, key)
+	}
+
+	douyinUA := ""
+	if config.Douyin.UseMobileUA {
+		douyinUA = config.Douyin.MobileUA
+	}
+
+	return []SiteConfig{
+		{
+			Name:    "spankbang",
+			Hosts:   []string{"spankbang.com"},
+			Headers: spankbang,
+			Referer: "https://spankbang.com/",
+		},
+		{
+			Name:               "bilibili",
+			Hosts:              []string{"bilibili.com", "b23.tv"},
+			Headers:            browserHeaders("zh-CN,zh;q=0.9,en;q=0.8"),
+			NoCheckCertificate: true,
+		},
+		{
+			Name:               "douyin",
+			Hosts:              []string{"douyin.com", "iesdouyin.com"},
+			Headers:            browserHeaders("zh-CN,zh;q=0.9,en;q=0.8"),
+			UserAgent:          douyinUA,
+			Referer:            "https://www.douyin.com/",
+			ExtractorArgs:      []string{"douyin:app_version=9.9.10", "douyin:device_platform=android"},
+			NoCheckCertificate: true,
+		},
+		{
+			// 抖音移动端接口 (aweme.snssdk.com)，模拟抖音 APP
+			Name:  "douyin-app",
+			Hosts: []string{"snssdk.com"},
+			Headers: map[string]string{
+				"Accept":          "application/json",
+				"Accept-Language": "zh-CN,zh;q=0.9",
+			},
+			UserAgent: "com.ss.android.ugc.aweme/800 (Linux; U; Android 10; zh_CN; Pixel 4; Build/QQ3A.200805.001; Cronet/58.0.2991.0)",
+		},
+		{
+			Name:    "pinterest",
+			Hosts:   []string{"pinterest.com", "pin.it"},
+			Headers: pinterest,
+		},
+	}
 }
 
 // LoadConfig 加载配置文件
@@ -143,12 +359,18 @@ func setDefaults() {
 	viper.SetDefault("downloader.output_dir", "./downloads")
 	viper.SetDefault("downloader.max_retries", 3)
 	viper.SetDefault("downloader.timeout", 300)
+	viper.SetDefault("downloader.stall_timeout", 0)
+	viper.SetDefault("downloader.info_timeout", 60)
 	viper.SetDefault("downloader.max_concurrent", 3)
+	viper.SetDefault("downloader.max_queue", 100)
+	viper.SetDefault("downloader.user_quota_mb", 0)
+	viper.SetDefault("downloader.dedupe", "reuse")
 
 	viper.SetDefault("ytdlp.path", "yt-dlp")
 	viper.SetDefault("ytdlp.user_agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 	viper.SetDefault("ytdlp.cookies_file", "")
 	viper.SetDefault("ytdlp.proxy", "")
+	viper.SetDefault("proxy.default", "")
 	viper.SetDefault("ytdlp.format", "best")
 	viper.SetDefault("ytdlp.extract_audio", false)
 	viper.SetDefault("ytdlp.audio_format", "mp3")
@@ -158,6 +380,9 @@ func setDefaults() {
 	viper.SetDefault("aria2.max_connections", 16)
 	viper.SetDefault("aria2.min_split_size", 1)
 	viper.SetDefault("aria2.continue", true)
+	viper.SetDefault("aria2.enabled", false)
+	viper.SetDefault("aria2.rpc_url", "")
+	viper.SetDefault("aria2.rpc_secret", "")
 
 	viper.SetDefault("douyin.enable_direct_api", true)
 	viper.SetDefault("douyin.use_mobile_ua", true)
@@ -168,8 +393,17 @@ func setDefaults() {
 	viper.SetDefault("database.dsn", "./data/video-hunter.db")
 
 	viper.SetDefault("security.cors_origins", []string{"*"})
+	viper.SetDefault("security.trusted_proxies", []string{})
 	viper.SetDefault("security.rate_limit", 100)
 	viper.SetDefault("security.rate_limit_window", "1m")
+	viper.SetDefault("security.rate_limits.video_info", 30)
+	viper.SetDefault("security.rate_limits.download", 30)
+	viper.SetDefault("security.rate_limits.direct_download", 10)
+	viper.SetDefault("security.auth.enabled", false)
+	viper.SetDefault("security.cookie_secret", "")
+
+	viper.SetDefault("webhooks.max_retries", 5)
+	viper.SetDefault("webhooks.timeout", 10)
 }
 
 // createDefaultConfig 创建默认配置文件
@@ -214,5 +448,218 @@ func processConfig(config *Config) error {
 		config.Security.RateLimitDuration = duration
 	}
 
+	// 校验重复视频的处理方式
+	switch config.Downloader.Dedupe {
+	case "reuse", "skip", "force":
+	default:
+		return fmt.Errorf("downloader.dedupe 必须是 reuse、skip 或 force: %s", config.Downloader.Dedupe)
+	}
+
+	// 校验音频模式的默认设置
+	if err := ValidateAudio(config.YtDlp.AudioFormat, config.YtDlp.AudioQuality); err != nil {
+		return fmt.Errorf("ytdlp: %w", err)
+	}
+
+	// 网站配置
+	if !viper.IsSet("sites") {
+		config.Sites = defaultSites(config)
+	}
+	if err := validateSites(config.Sites); err != nil {
+		return err
+	}
+
+	// 代理配置
+	if err := validateProxy(config); err != nil {
+		return err
+	}
+
+	// 校验API密钥
+	if err := validateAuth(&config.Security.Auth); err != nil {
+		return err
+	}
+
+	// 校验 webhook
+	if err := validateWebhooks(config.Webhooks.Endpoints); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateSites 校验网站配置的名称和主机名
+func validateSites(sites []SiteConfig) error {
+	for i, site := range sites {
+		if len(site.Hosts) == 0 {
+			return fmt.Errorf("sites[%d] (%s) 没有配置 hosts", i, site.Name)
+		}
+		for _, host := range site.Hosts {
+			if host == "" || host == "*." || strings.ContainsAny(host, "/: ") {
+				return fmt.Errorf("sites[%d] (%s) 的主机名无效: %q", i, site.Name, host)
+			}
+		}
+	}
+	return nil
+}
+
+// validateProxy 校验代理规则和代理池，并为代理池填充默认的健康检查参数
+func validateProxy(config *Config) error {
+	proxy := &config.Proxy
+	pools := make(map[string]bool)
+	for i := range proxy.Pools {
+		pool := &proxy.Pools[i]
+		if pool.Name == "" || pools[pool.Name] {
+			return fmt.Errorf("proxy.pools[%d] 的 name 为空或重复: %q", i, pool.Name)
+		}
+		pools[pool.Name] = true
+		if len(pool.Proxies) == 0 {
+			return fmt.Errorf("proxy.pools[%d] (%s) 没有配置 proxies", i, pool.Name)
+		}
+		for _, proxyURL := range pool.Proxies {
+			if err := validateProxyURL(proxyURL); err != nil {
+				return fmt.Errorf("proxy.pools[%d] (%s): %w", i, pool.Name, err)
+			}
+		}
+		if pool.CheckURL == "" {
+			pool.CheckURL = "https://www.gstatic.com/generate_204"
+		}
+		if pool.CheckInterval <= 0 {
+			pool.CheckInterval = 300
+		}
+		if pool.MaxFailures <= 0 {
+			pool.MaxFailures = 3
+		}
+	}
+
+	check := func(name, value string) error {
+		if value == "" || value == "direct" {
+			return nil
+		}
+		if pool, ok := strings.CutPrefix(value, "pool:"); ok {
+			if !pools[pool] {
+				return fmt.Errorf("%s 引用了不存在的代理池: %s", name, pool)
+			}
+			return nil
+		}
+		if err := validateProxyURL(value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+	if err := check("proxy.default", proxy.Default); err != nil {
+		return err
+	}
+	if err := check("ytdlp.proxy", config.YtDlp.Proxy); err != nil {
+		return err
+	}
+	for i, rule := range proxy.Rules {
+		if len(rule.Hosts) == 0 {
+			return fmt.Errorf("proxy.rules[%d] 没有配置 hosts", i)
+		}
+		if rule.Proxy == "" {
+			return fmt.Errorf("proxy.rules[%d] 没有配置 proxy", i)
+		}
+		if err := check(fmt.Sprintf("proxy.rules[%d]", i), rule.Proxy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateProxyURL 校验代理地址，yt-dlp、curl 和 net/http 都支持 http、https 和 socks5 代理
+func validateProxyURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("代理地址无效: %s", rawURL)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return nil
+	default:
+		return fmt.Errorf("不支持的代理协议: %s", u.Scheme)
+	}
+}
+
+// validateAuth 校验API密钥的名称、密钥和权限范围
+func validateAuth(auth *AuthConfig) error {
+	if auth.Enabled && len(auth.Keys) == 0 {
+		return fmt.Errorf("已启用 security.auth 但没有配置任何密钥")
+	}
+
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for i, key := range auth.Keys {
+		if key.Name == "" || key.Key == "" {
+			return fmt.Errorf("security.auth.keys[%d] 缺少 name 或 key", i)
+		}
+		if names[key.Name] || keys[key.Key] {
+			return fmt.Errorf("security.auth.keys[%d] 的 name 或 key 重复: %s", i, key.Name)
+		}
+		names[key.Name] = true
+		keys[key.Key] = true
+
+		if len(key.Scopes) == 0 {
+			return fmt.Errorf("security.auth.keys[%d] (%s) 没有配置 scopes", i, key.Name)
+		}
+		for _, scope := range key.Scopes {
+			switch scope {
+			case "read", "download", "admin":
+			default:
+				return fmt.Errorf("security.auth.keys[%d] (%s) 的权限范围无效: %s", i, key.Name, scope)
+			}
+		}
+	}
+	return nil
+}
+
+// AudioFormats yt-dlp --audio-format 支持的音频格式，best 表示保留原始格式
+var AudioFormats = []string{"best", "aac", "alac", "flac", "m4a", "mp3", "opus", "vorbis", "wav"}
+
+// audioQualityRegex yt-dlp --audio-quality：0（最好）到 10（最差）的 VBR 等级，或 192K 这样的码率
+var audioQualityRegex = regexp.MustCompile(`^(?:10|[0-9]|[1-9][0-9]*[kK])$`)
+
+// ValidateAudio 校验音频格式和音质，配置文件和下载请求共用
+func ValidateAudio(format, quality string) error {
+	if format != "" && !slices.Contains(AudioFormats, format) {
+		return fmt.Errorf("不支持的音频格式: %s", format)
+	}
+	if quality != "" && !audioQualityRegex.MatchString(quality) {
+		return fmt.Errorf("音质必须是 0-10 或 128K 这样的码率: %s", quality)
+	}
+	return nil
+}
+
+// WebhookEvents 可以订阅的任务事件
+var WebhookEvents = []string{"created", "started", "completed", "failed", "cancelled"}
+
+// ValidateWebhook 校验 webhook 地址和事件，配置文件和 /api/webhooks 共用
+func ValidateWebhook(rawURL string, events []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook 地址无效: %s", rawURL)
+	}
+	for _, event := range events {
+		if !slices.Contains(WebhookEvents, event) {
+			return fmt.Errorf("未知的 webhook 事件: %s", event)
+		}
+	}
+	return nil
+}
+
+// validateWebhooks 校验配置文件中的 webhook
+func validateWebhooks(endpoints []WebhookConfig) error {
+	names := make(map[string]bool)
+	for i, endpoint := range endpoints {
+		if endpoint.Name == "" {
+			return fmt.Errorf("webhooks.endpoints[%d] 缺少 name", i)
+		}
+		if names[endpoint.Name] {
+			return fmt.Errorf("webhooks.endpoints[%d] 的 name 重复: %s", i, endpoint.Name)
+		}
+		names[endpoint.Name] = true
+
+		if err := ValidateWebhook(endpoint.URL, endpoint.Events); err != nil {
+			return fmt.Errorf("webhooks.endpoints[%d] (%s): %w", i, endpoint.Name, err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,535 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"video-hunter/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// aria2PollInterval 查询 aria2 任务状态的间隔
+const aria2PollInterval = 500 * time.Millisecond
+
+// directMediaExtensions 可以由 aria2 直接下载的媒体文件扩展名
+var directMediaExtensions = map[string]bool{
+	".mp4": true, ".m4v": true, ".webm": true, ".mkv": true, ".mov": true, ".flv": true, ".avi": true,
+	".m4a": true, ".mp3": true, ".aac": true, ".ogg": true, ".opus": true, ".wav": true, ".flac": true,
+}
+
+// Aria2Downloader 通过 aria2 JSON-RPC 下载直链媒体文件
+type Aria2Downloader struct {
+	config  *config.Config
+	proxies *ProxyRouter
+	client  *aria2Client
+	tracker *taskTracker
+}
+
+// NewAria2Downloader 创建连接 aria2.rpc_url 的下载器实例
+func NewAria2Downloader(cfg *config.Config, proxies *ProxyRouter) *Aria2Downloader {
+	return &Aria2Downloader{
+		config:  cfg,
+		proxies: proxies,
+		client: &aria2Client{
+			url:    cfg.Aria2.RPCURL,
+			secret: cfg.Aria2.RPCSecret,
+			http:   &http.Client{Timeout: 10 * time.Second},
+		},
+		tracker: newTaskTracker(),
+	}
+}
+
+var _ Provider = (*Aria2Downloader)(nil)
+
+// Name 提供者名称
+func (a *Aria2Downloader) Name() string {
+	return "aria2"
+}
+
+// Priority 直链优先使用 aria2 多连接下载，失败后回退到yt-dlp
+func (a *Aria2Downloader) Priority() int {
+	return 50
+}
+
+// CanHandle 只处理指向媒体文件的 http(s) 直链
+func (a *Aria2Downloader) CanHandle(rawURL string) bool {
+	return directMediaExtension(rawURL) != ""
+}
+
+// GetProgress 获取下载进度
+func (a *Aria2Downloader) GetProgress(id string) (*DownloadResponse, error) {
+	return a.tracker.get(id)
+}
+
+// Cancel 取消下载
+func (a *Aria2Downloader) Cancel(id string) error {
+	return a.tracker.cancel(id)
+}
+
+// GetVideoInfo 通过 HEAD 请求获取直链文件的信息
+func (a *Aria2Downloader) GetVideoInfo(ctx context.Context, rawURL string) (*VideoInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("User-Agent", resolveSite(a.config, rawURL).UserAgent)
+
+	resp, err := a.proxies.client(0).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("获取文件信息失败: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, URL: rawURL}
+	}
+
+	ext := directMediaExtension(rawURL)
+	return &VideoInfo{
+		Title: strings.TrimSuffix(path.Base(resp.Request.URL.Path), ext),
+		Formats: []VideoFormat{
+			{
+				FormatID:  "direct",
+				Extension: strings.TrimPrefix(ext, "."),
+				Filesize:  resp.ContentLength,
+				URL:       rawURL,
+				Quality:   "原始文件",
+			},
+		},
+		Metadata: map[string]string{
+			"source":       "aria2",
+			"content_type": resp.Header.Get("Content-Type"),
+		},
+		CanDownload: true,
+	}, nil
+}
+
+// checkFormat 直链只有一种格式，格式表达式有限制时通过 HEAD 请求检查文件的扩展名和大小是否符合
+func (a *Aria2Downloader) checkFormat(ctx context.Context, req *DownloadRequest) error {
+	format := req.Format
+	if format == "" {
+		format = resolveSite(a.config, req.URL).Format
+	}
+	spec, err := ParseFormat(format)
+	if err != nil || spec.Raw() || spec.Selector == "" || req.Mode == ModeAudio {
+		return err
+	}
+
+	info, err := a.GetVideoInfo(ctx, req.URL)
+	if err != nil {
+		logrus.Warnf("获取直链文件信息失败，不检查格式 [%s]: %v", req.TaskID, err)
+		return nil
+	}
+	_, err = spec.Choose(info.Formats)
+	return err
+}
+
+// Download 将直链提交给 aria2 并轮询进度，ctx 取消时从 aria2 中移除任务
+func (a *Aria2Downloader) Download(ctx context.Context, req *DownloadRequest, callback ProgressCallback) (*DownloadResponse, error) {
+	ctx, resp := a.tracker.start(ctx, req.TaskID, "")
+	defer a.tracker.finish(req.TaskID)
+
+	if err := a.checkFormat(ctx, req); err != nil {
+		return nil, err
+	}
+	dest, err := a.outputPath(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var gid string
+	if err := a.client.call(ctx, "aria2.addUri", &gid, []string{req.URL}, a.options(ctx, req, dest)); err != nil {
+		return nil, fmt.Errorf("提交 aria2 任务失败: %w", err)
+	}
+	logrus.Infof("已提交 aria2 任务 [%s]: gid=%s, 文件=%s", req.TaskID, gid, dest)
+
+	ticker := time.NewTicker(aria2PollInterval)
+	defer ticker.Stop()
+
+	lastCompleted := ""
+	for {
+		select {
+		case <-ctx.Done():
+			a.remove(gid)
+			return nil, fmt.Errorf("下载已取消: %w", ctx.Err())
+		case <-ticker.C:
+		}
+
+		var status aria2Status
+		if err := a.client.call(ctx, "aria2.tellStatus", &status, gid, aria2StatusKeys); err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			a.remove(gid)
+			return nil, fmt.Errorf("查询 aria2 任务状态失败: %w", err)
+		}
+
+		progress := status.progress()
+		// 只在有新数据时回调，避免没有进度的下载被当作仍在进行
+		if status.CompletedLength != lastCompleted {
+			lastCompleted = status.CompletedLength
+			a.tracker.update(req.TaskID, progress)
+			if callback != nil {
+				callback(progress)
+			}
+		}
+
+		switch status.Status {
+		case "complete":
+			a.removeResult(gid)
+			file := dest
+			if len(status.Files) > 0 && status.Files[0].Path != "" {
+				file = status.Files[0].Path
+			}
+			// 音频模式从下载的视频中提取音轨
+			file, err := toAudio(ctx, req, file, callback)
+			if err != nil {
+				return nil, err
+			}
+			resp.Status = StatusCompleted
+			resp.Progress = 100
+			resp.File = file
+			if info, err := os.Stat(file); err == nil {
+				resp.Size = info.Size()
+			} else {
+				resp.Size = status.total()
+			}
+			resp.Updated = time.Now()
+			return resp, nil
+		case "error":
+			a.removeResult(gid)
+			return nil, &aria2Error{Code: status.ErrorCode, Message: status.ErrorMessage}
+		case "removed":
+			a.removeResult(gid)
+			return nil, errors.New("aria2 任务已被移除")
+		}
+	}
+}
+
+// outputPath 计算下载文件的绝对路径，aria2 以守护进程的工作目录解析相对路径
+func (a *Aria2Downloader) outputPath(req *DownloadRequest) (string, error) {
+	ext := directMediaExtension(req.URL)
+
+	dest := req.Output
+	if dest == "" {
+		dest = filepath.Join(a.config.Downloader.OutputDir, req.TaskID+"_"+sanitizeFilename(path.Base(urlPath(req.URL))))
+	} else if ext != "" && !strings.EqualFold(filepath.Ext(dest), ext) {
+		// 默认输出文件名使用 .mp4，以直链的实际扩展名为准
+		dest = strings.TrimSuffix(dest, filepath.Ext(dest)) + ext
+	}
+
+	abs, err := filepath.Abs(dest)
+	if err != nil {
+		return "", fmt.Errorf("无法获取输出文件的绝对路径: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+		return "", fmt.Errorf("创建下载目录失败: %w", err)
+	}
+	return abs, nil
+}
+
+// options 构造 aria2.addUri 的下载选项，Cookie 与 yt-dlp 一样依次取请求中的 cookies、ctx 中的 Cookie 文件和网站配置
+func (a *Aria2Downloader) options(ctx context.Context, req *DownloadRequest, dest string) map[string]interface{} {
+	connections := aria2Connections(a.config.Aria2.MaxConnections)
+	site := resolveSite(a.config, req.URL)
+	options := map[string]interface{}{
+		"dir":                       filepath.Dir(dest),
+		"out":                       filepath.Base(dest),
+		"max-connection-per-server": strconv.Itoa(connections),
+		"split":                     strconv.Itoa(connections),
+		"min-split-size":            fmt.Sprintf("%dM", aria2SplitSize(a.config.Aria2.MinSplitSize)),
+		"continue":                  strconv.FormatBool(a.config.Aria2.Continue),
+		"user-agent":                site.UserAgent,
+	}
+
+	var headers []string
+	for key, value := range req.Headers {
+		headers = append(headers, key+": "+value)
+	}
+	cookies := site.CookiesFile
+	if path := cookieFileFrom(ctx); path != "" {
+		cookies = path
+	}
+	if req.Cookies != "" {
+		cookies = req.Cookies
+	}
+	if header := cookieHeader(cookies, req.URL); header != "" {
+		headers = append(headers, "Cookie: "+header)
+	}
+	if len(headers) > 0 {
+		options["header"] = headers
+	}
+	if req.Referer != "" {
+		options["referer"] = req.Referer
+	} else if site.Referer != "" {
+		options["referer"] = site.Referer
+	}
+	// aria2 只支持 HTTP 代理
+	if proxy := a.proxies.route(req.URL); strings.HasPrefix(proxy.URL, "http") {
+		options["all-proxy"] = proxy.URL
+	} else if proxy.URL != "" {
+		logrus.Warnf("aria2 不支持代理 %s，直接下载", redactProxy(proxy.URL))
+	}
+	return options
+}
+
+// remove 从 aria2 中移除任务，使用独立的上下文以便在任务取消后仍能执行
+func (a *Aria2Downloader) remove(gid string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var result string
+	if err := a.client.call(ctx, "aria2.forceRemove", &result, gid); err != nil {
+		logrus.Warnf("移除 aria2 任务失败 [%s]: %v", gid, err)
+	}
+	// 等待 aria2 停止任务后再清理结果
+	time.Sleep(aria2PollInterval)
+	a.removeResult(gid)
+}
+
+// removeResult 清理 aria2 中已结束任务的记录
+func (a *Aria2Downloader) removeResult(gid string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var result string
+	if err := a.client.call(ctx, "aria2.removeDownloadResult", &result, gid); err != nil {
+		logrus.Debugf("清理 aria2 任务记录失败 [%s]: %v", gid, err)
+	}
+}
+
+// aria2StatusKeys tellStatus 需要返回的字段
+var aria2StatusKeys = []string{"gid", "status", "totalLength", "completedLength", "downloadSpeed", "connections", "errorCode", "errorMessage", "files"}
+
+// aria2Status aria2.tellStatus 的返回值，数值字段均为字符串
+type aria2Status struct {
+	GID             string `json:"gid"`
+	Status          string `json:"status"`
+	TotalLength     string `json:"totalLength"`
+	CompletedLength string `json:"completedLength"`
+	DownloadSpeed   string `json:"downloadSpeed"`
+	Connections     string `json:"connections"`
+	ErrorCode       string `json:"errorCode"`
+	ErrorMessage    string `json:"errorMessage"`
+	Files           []struct {
+		Path string `json:"path"`
+	} `json:"files"`
+}
+
+func (s *aria2Status) total() int64 {
+	n, _ := strconv.ParseInt(s.TotalLength, 10, 64)
+	return n
+}
+
+// progress 将 aria2 的任务状态转换为下载进度
+func (s *aria2Status) progress() *DownloadResponse {
+	total := s.total()
+	completed, _ := strconv.ParseInt(s.CompletedLength, 10, 64)
+	speed, _ := strconv.ParseInt(s.DownloadSpeed, 10, 64)
+
+	resp := &DownloadResponse{
+		Status:          StatusDownloading,
+		BytesDownloaded: completed,
+		SpeedBps:        float64(speed),
+		Stage:           StageDownloadingVideo,
+		Metadata: map[string]string{
+			"downloaded":       formatBytes(completed),
+			"downloaded_bytes": strconv.FormatInt(completed, 10),
+			"connections":      s.Connections,
+			"aria2_gid":        s.GID,
+		},
+		Updated: time.Now(),
+	}
+	if total > 0 {
+		resp.Progress = float64(completed) / float64(total) * 100
+		resp.StageProgress = resp.Progress
+		resp.BytesTotal = total
+		resp.Metadata["total_size"] = formatBytes(total)
+		if speed > 0 {
+			resp.ETASeconds = (total - completed) / speed
+			resp.ETA = formatETA(time.Duration(resp.ETASeconds) * time.Second)
+		}
+	}
+	if speed > 0 {
+		resp.Speed = formatBytes(speed) + "/s"
+	}
+	return resp
+}
+
+// aria2Error aria2 报告的下载错误
+type aria2Error struct {
+	Code    string
+	Message string
+}
+
+func (e *aria2Error) Error() string {
+	return fmt.Sprintf("aria2 下载失败 (错误码 %s): %s", e.Code, e.Message)
+}
+
+// retryable 网络问题、超时、服务器错误等错误码可以重试
+// 参见 https://aria2.github.io/manual/en/html/aria2c.html#exit-status
+func (e *aria2Error) retryable() bool {
+	switch e.Code {
+	case "2", "6", "19", "22", "29":
+		return true
+	}
+	return false
+}
+
+// aria2Client aria2 JSON-RPC 客户端
+type aria2Client struct {
+	url    string
+	secret string
+	http   *http.Client
+	nextID atomic.Int64
+}
+
+// call 调用 aria2 RPC 方法，配置了 rpc_secret 时自动添加令牌参数
+func (c *aria2Client) call(ctx context.Context, method string, result interface{}, params ...interface{}) error {
+	if c.secret != "" {
+		params = append([]interface{}{"token:" + c.secret}, params...)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      strconv.FormatInt(c.nextID.Add(1), 10),
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return fmt.Errorf("解析 aria2 响应失败 (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf("aria2 %s 调用失败 (%d): %s", method, rpcResp.Error.Code, rpcResp.Error.Message)
+	}
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+// directMediaExtension 返回直链的媒体扩展名，不是媒体直链时返回空字符串
+func directMediaExtension(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	if directMediaExtensions[ext] {
+		return ext
+	}
+	return ""
+}
+
+// urlPath 返回URL的路径部分，解析失败时返回原字符串
+func urlPath(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Path
+	}
+	return rawURL
+}
+
+// aria2Connections aria2 每个服务器最多允许16个连接
+func aria2Connections(n int) int {
+	if n <= 0 {
+		return 1
+	}
+	if n > 16 {
+		return 16
+	}
+	return n
+}
+
+// aria2SplitSize aria2 的最小分片为1M
+func aria2SplitSize(mb int) int {
+	if mb < 1 {
+		return 1
+	}
+	return mb
+}
+
+// aria2Args 启用 aria2.enabled 时返回让 yt-dlp 使用 aria2c 作为外部下载器的参数，
+// 请求选项中已指定下载器时以请求为准
+func (y *YtdlpDownloader) aria2Args(req *DownloadRequest) []string {
+	cfg := y.config.Aria2
+	if !cfg.Enabled {
+		return nil
+	}
+	for _, key := range []string{"downloader", "external-downloader"} {
+		if _, ok := req.Options[key]; ok {
+			return nil
+		}
+	}
+
+	path := cfg.Path
+	if path == "" {
+		path = "aria2c"
+	}
+	connections := aria2Connections(cfg.MaxConnections)
+	downloaderArgs := fmt.Sprintf("aria2c:-x %d -s %d -k %dM", connections, connections, aria2SplitSize(cfg.MinSplitSize))
+	if cfg.Continue {
+		downloaderArgs += " -c"
+	}
+	return []string{"--downloader", path, "--downloader-args", downloaderArgs}
+}
+
+// aria2ProgressRegex 匹配 aria2c 控制台输出的进度，例如 [#2089b0 400KiB/1.0MiB(39%) CN:1 DL:115KiB ETA:5s]
+var aria2ProgressRegex = regexp.MustCompile(`\[#\w+\s+(\d+(?:\.\d+)?[KMGT]?i?B)/(\d+(?:\.\d+)?[KMGT]?i?B)\((\d+)%\)(?:\s+CN:\d+)?(?:\s+DL:(\d+(?:\.\d+)?[KMGT]?i?B))?(?:\s+ETA:(\w+))?`)
+
+// parseAria2Progress 解析 yt-dlp 调用 aria2c 下载时 aria2c 输出的一行进度，不是进度行时返回 false
+func parseAria2Progress(line string) (*DownloadResponse, bool) {
+	m := aria2ProgressRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+
+	resp := &DownloadResponse{
+		Status:  StatusDownloading,
+		Stage:   StageDownloadingVideo,
+		Updated: time.Now(),
+	}
+	resp.BytesDownloaded, _ = parseSize(strings.ToLower(m[1]))
+	resp.BytesTotal, _ = parseSize(strings.ToLower(m[2]))
+	resp.Progress, _ = strconv.ParseFloat(m[3], 64)
+	if speed, err := parseSize(strings.ToLower(m[4])); err == nil {
+		resp.SpeedBps = float64(speed)
+		resp.Speed = formatBytes(speed) + "/s"
+	}
+	if eta, err := time.ParseDuration(m[5]); err == nil {
+		resp.ETASeconds = int64(eta.Seconds())
+		resp.ETA = formatETA(eta)
+	}
+	return resp, true
+}
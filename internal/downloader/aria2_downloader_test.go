@@ -0,0 +1,283 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"video-hunter/internal/config"
+)
+
+func TestParseAria2Progress(t *testing.T) {
+	tests := []struct {
+		line         string
+		wantOK       bool
+		wantProgress float64
+		wantBytes    int64
+		wantTotal    int64
+		wantSpeed    float64
+		wantETA      int64
+	}{
+		{
+			line:   "[#2089b0 400KiB/1.0MiB(39%) CN:1 DL:115KiB ETA:5s]",
+			wantOK: true, wantProgress: 39, wantBytes: 400 << 10, wantTotal: 1 << 20, wantSpeed: 115 << 10, wantETA: 5,
+		},
+		{
+			line:   "[#a1 1.5GiB/3.0GiB(50%) CN:16 DL:12MiB ETA:2m8s]",
+			wantOK: true, wantProgress: 50, wantBytes: 3 << 29, wantTotal: 3 << 30, wantSpeed: 12 << 20, wantETA: 128,
+		},
+		{
+			line:   "[#a1 0B/0B(0%) CN:1]",
+			wantOK: true,
+		},
+		{line: "[download] Destination: video.mp4"},
+		{line: "[#a1 SEEDING]"},
+		{line: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := parseAria2Progress(tt.line)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, 期望 %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got.Progress != tt.wantProgress || got.BytesDownloaded != tt.wantBytes || got.BytesTotal != tt.wantTotal {
+				t.Errorf("进度 = %v %d/%d, 期望 %v %d/%d",
+					got.Progress, got.BytesDownloaded, got.BytesTotal, tt.wantProgress, tt.wantBytes, tt.wantTotal)
+			}
+			if got.SpeedBps != tt.wantSpeed || got.ETASeconds != tt.wantETA {
+				t.Errorf("速度和剩余时间 = %v, %d, 期望 %v, %d", got.SpeedBps, got.ETASeconds, tt.wantSpeed, tt.wantETA)
+			}
+			if got.Stage != StageDownloadingVideo {
+				t.Errorf("Stage = %q", got.Stage)
+			}
+		})
+	}
+}
+
+func TestYtdlpAria2Args(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  config.Aria2Config
+		options map[string]string
+		want    []string
+	}{
+		{name: "未启用", config: config.Aria2Config{MaxConnections: 8}},
+		{
+			name:   "默认路径",
+			config: config.Aria2Config{Enabled: true, MaxConnections: 8, MinSplitSize: 4, Continue: true},
+			want:   []string{"--downloader", "aria2c", "--downloader-args", "aria2c:-x 8 -s 8 -k 4M -c"},
+		},
+		{
+			name:   "连接数和分片大小的范围",
+			config: config.Aria2Config{Enabled: true, Path: "/usr/local/bin/aria2c", MaxConnections: 32},
+			want:   []string{"--downloader", "/usr/local/bin/aria2c", "--downloader-args", "aria2c:-x 16 -s 16 -k 1M"},
+		},
+		{
+			name:    "请求已指定下载器",
+			config:  config.Aria2Config{Enabled: true, MaxConnections: 8},
+			options: map[string]string{"downloader": "ffmpeg"},
+		},
+		{
+			name:    "请求已指定外部下载器",
+			config:  config.Aria2Config{Enabled: true, MaxConnections: 8},
+			options: map[string]string{"external-downloader": "curl"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			y := &YtdlpDownloader{config: &config.Config{Aria2: tt.config}}
+			if got := y.aria2Args(&DownloadRequest{Options: tt.options}); !slices.Equal(got, tt.want) {
+				t.Errorf("aria2Args = %q, 期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirectMediaExtension(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://cdn.example.com/v/clip.mp4", ".mp4"},
+		{"http://cdn.example.com/a/b.MKV?token=1#x", ".mkv"},
+		{"https://cdn.example.com/audio.flac", ".flac"},
+		{"https://www.youtube.com/watch?v=abc", ""},
+		{"https://cdn.example.com/playlist.m3u8", ""},
+		{"ftp://cdn.example.com/clip.mp4", ""},
+	}
+	for _, tt := range tests {
+		if got := directMediaExtension(tt.url); got != tt.want {
+			t.Errorf("directMediaExtension(%q) = %q, 期望 %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestAria2StatusProgress(t *testing.T) {
+	status := &aria2Status{GID: "g1", TotalLength: "1000", CompletedLength: "250", DownloadSpeed: "250", Connections: "4"}
+	got := status.progress()
+	if got.Progress != 25 || got.StageProgress != 25 || got.BytesDownloaded != 250 || got.BytesTotal != 1000 {
+		t.Errorf("进度 = %v %d/%d", got.Progress, got.BytesDownloaded, got.BytesTotal)
+	}
+	if got.SpeedBps != 250 || got.ETASeconds != 3 || got.Metadata["aria2_gid"] != "g1" {
+		t.Errorf("速度和剩余时间 = %v, %d, %v", got.SpeedBps, got.ETASeconds, got.Metadata)
+	}
+
+	// 未知大小时只报告已下载的字节数
+	got = (&aria2Status{CompletedLength: "100"}).progress()
+	if got.Progress != 0 || got.BytesTotal != 0 || got.ETA != "" || got.Speed != "" {
+		t.Errorf("未知大小时的进度 = %+v", got)
+	}
+}
+
+// fakeAria2 模拟 aria2 JSON-RPC：addUri 返回 gid，tellStatus 依次返回 statuses 中的状态
+type fakeAria2 struct {
+	mu       sync.Mutex
+	secret   string
+	statuses []map[string]interface{}
+	methods  []string
+	options  map[string]interface{}
+}
+
+func (f *fakeAria2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     string            `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.methods = append(f.methods, req.Method)
+
+	reply := func(result interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}
+	// 配置了 rpc_secret 时第一个参数为令牌
+	if f.secret != "" {
+		var token string
+		if len(req.Params) > 0 {
+			json.Unmarshal(req.Params[0], &token)
+		}
+		if token != "token:"+f.secret {
+			json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "error": map[string]interface{}{"code": 1, "message": "Unauthorized"}})
+			return
+		}
+		req.Params = req.Params[1:]
+	}
+
+	switch req.Method {
+	case "aria2.addUri":
+		json.Unmarshal(req.Params[1], &f.options)
+		reply("gid1")
+	case "aria2.tellStatus":
+		status := f.statuses[0]
+		if len(f.statuses) > 1 {
+			f.statuses = f.statuses[1:]
+		}
+		reply(status)
+	default:
+		reply("OK")
+	}
+}
+
+func TestAria2Download(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "t1_clip.mp4")
+
+	tests := []struct {
+		name     string
+		secret   string
+		statuses []map[string]interface{}
+		wantErr  error
+	}{
+		{
+			name:   "下载完成",
+			secret: "s3cret",
+			statuses: []map[string]interface{}{
+				{"gid": "gid1", "status": "active", "totalLength": "10", "completedLength": "5", "downloadSpeed": "5"},
+				{"gid": "gid1", "status": "complete", "totalLength": "10", "completedLength": "10", "files": []map[string]string{{"path": dest}}},
+			},
+		},
+		{
+			name: "aria2 报告错误",
+			statuses: []map[string]interface{}{
+				{"gid": "gid1", "status": "error", "errorCode": "6", "errorMessage": "Network problem"},
+			},
+			wantErr: &aria2Error{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rpc := &fakeAria2{secret: tt.secret, statuses: tt.statuses}
+			server := httptest.NewServer(rpc)
+			defer server.Close()
+			if err := os.WriteFile(dest, []byte("0123456789"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &config.Config{
+				Downloader: config.DownloaderConfig{OutputDir: dir},
+				Aria2:      config.Aria2Config{RPCURL: server.URL, RPCSecret: tt.secret, MaxConnections: 4},
+			}
+			a := NewAria2Downloader(cfg, NewProxyRouter(cfg))
+			var reports []float64
+			resp, err := a.Download(context.Background(), &DownloadRequest{URL: "https://cdn.example.com/v/clip.mp4", TaskID: "t1"}, func(progress *DownloadResponse) {
+				reports = append(reports, progress.Progress)
+			})
+
+			if tt.wantErr != nil {
+				var aerr *aria2Error
+				if !errors.As(err, &aerr) || aerr.Code != "6" || !IsRetryable(err) {
+					t.Fatalf("错误 = %v, 期望可以重试的 aria2 错误", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Download 失败: %v", err)
+				}
+				if resp.Status != StatusCompleted || resp.File != dest || resp.Size != 10 {
+					t.Errorf("下载结果 = %+v", resp)
+				}
+				if !slices.Equal(reports, []float64{50, 100}) {
+					t.Errorf("报告的进度 = %v, 期望 [50 100]", reports)
+				}
+			}
+
+			rpc.mu.Lock()
+			defer rpc.mu.Unlock()
+			if rpc.options["dir"] != dir || rpc.options["out"] != "t1_clip.mp4" || rpc.options["split"] != "4" {
+				t.Errorf("下载选项 = %v", rpc.options)
+			}
+			if last := rpc.methods[len(rpc.methods)-1]; last != "aria2.removeDownloadResult" {
+				t.Errorf("结束后应清理 aria2 任务记录, 最后调用了 %s", last)
+			}
+		})
+	}
+}
+
+func TestAria2ClientError(t *testing.T) {
+	server := httptest.NewServer(&fakeAria2{secret: "right"})
+	defer server.Close()
+
+	client := &aria2Client{url: server.URL, secret: "wrong", http: server.Client()}
+	var result string
+	if err := client.call(context.Background(), "aria2.getVersion", &result); err == nil {
+		t.Error("令牌错误时应返回错误")
+	}
+}
@@ -0,0 +1,159 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"video-hunter/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ApplyMode 校验请求的 mode、audio_format 和 audio_quality，并用 ytdlp 配置补全音频模式未指定的设置；
+// 服务层在创建任务时调用，保存的任务请求中记录实际使用的模式
+func ApplyMode(cfg *config.Config, req *DownloadRequest) error {
+	switch req.Mode {
+	case "":
+		req.Mode = ModeVideo
+		if cfg.YtDlp.ExtractAudio {
+			req.Mode = ModeAudio
+		}
+	case ModeVideo, ModeAudio:
+	default:
+		return fmt.Errorf("mode 必须是 video 或 audio: %s", req.Mode)
+	}
+
+	if req.Mode != ModeAudio {
+		req.AudioFormat, req.AudioQuality = "", ""
+		return nil
+	}
+	if req.AudioFormat == "" {
+		req.AudioFormat = cfg.YtDlp.AudioFormat
+	}
+	if req.AudioQuality == "" {
+		req.AudioQuality = cfg.YtDlp.AudioQuality
+	}
+	return config.ValidateAudio(req.AudioFormat, req.AudioQuality)
+}
+
+// audioArgs 返回音频模式的 yt-dlp 参数
+func audioArgs(req *DownloadRequest) []string {
+	if req.Mode != ModeAudio {
+		return nil
+	}
+	args := []string{"-x"}
+	if req.AudioFormat != "" {
+		args = append(args, "--audio-format", req.AudioFormat)
+	}
+	if req.AudioQuality != "" {
+		args = append(args, "--audio-quality", req.AudioQuality)
+	}
+	return args
+}
+
+// audioExtensions 音频模式可能生成的文件扩展名
+var audioExtensions = map[string]bool{
+	".mp3": true, ".m4a": true, ".aac": true, ".opus": true, ".ogg": true, ".flac": true, ".wav": true,
+}
+
+// isAudioFile 根据扩展名判断是否为音频文件
+func isAudioFile(path string) bool {
+	return audioExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// audioFileFor 返回下载文件对应的音频文件：yt-dlp 提取音频后会删除原文件，
+// 没有从输出中识别到提取后的文件名时，按相同的文件名查找音频文件
+func audioFileFor(path string) string {
+	if isAudioFile(path) {
+		return path
+	}
+	matches, _ := filepath.Glob(globEscape(strings.TrimSuffix(path, filepath.Ext(path))) + ".*")
+	for _, match := range matches {
+		if isAudioFile(match) {
+			return match
+		}
+	}
+	return path
+}
+
+// globEscape 转义文件名中 filepath.Glob 的特殊字符
+func globEscape(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// toAudio 音频模式下将下载得到的视频文件转换为音频，已是音频文件时直接返回
+func toAudio(ctx context.Context, req *DownloadRequest, file string, callback ProgressCallback) (string, error) {
+	if req.Mode != ModeAudio || isAudioFile(file) {
+		return file, nil
+	}
+	if callback != nil {
+		callback(&DownloadResponse{Status: StatusDownloading, Stage: StagePostProcessing, Postprocessor: "ExtractAudio", Updated: time.Now()})
+	}
+	return extractAudioTrack(ctx, file, req.AudioFormat, req.AudioQuality)
+}
+
+// ffmpegAudioCodecs 音频格式对应的文件扩展名和 ffmpeg 编码器，best 保留原始音轨
+var ffmpegAudioCodecs = map[string][2]string{
+	"best":   {"m4a", "copy"},
+	"aac":    {"aac", "aac"},
+	"m4a":    {"m4a", "aac"},
+	"alac":   {"m4a", "alac"},
+	"mp3":    {"mp3", "libmp3lame"},
+	"opus":   {"opus", "libopus"},
+	"vorbis": {"ogg", "libvorbis"},
+	"flac":   {"flac", "flac"},
+	"wav":    {"wav", "pcm_s16le"},
+}
+
+// extractAudioTrack 使用 ffmpeg 从视频文件中提取音轨，成功后删除视频文件并返回音频文件路径；
+// 音质为码率时使用 -b:a，为 0-10 时只对 mp3 使用 -q:a
+func extractAudioTrack(ctx context.Context, src, format, quality string) (string, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return "", fmt.Errorf("提取音频需要 ffmpeg: %w", err)
+	}
+	codec, ok := ffmpegAudioCodecs[format]
+	if !ok {
+		codec = ffmpegAudioCodecs["best"]
+	}
+	dest := strings.TrimSuffix(src, filepath.Ext(src)) + "." + codec[0]
+
+	args := []string{"-y", "-i", src, "-vn", "-c:a", codec[1]}
+	switch {
+	case codec[1] == "copy" || format == "flac" || format == "wav" || format == "alac":
+	case strings.HasSuffix(strings.ToLower(quality), "k"):
+		args = append(args, "-b:a", strings.ToLower(quality))
+	case format == "mp3" && quality != "":
+		if level, err := strconv.Atoi(quality); err == nil {
+			args = append(args, "-q:a", strconv.Itoa(min(level, 9)))
+		}
+	}
+	args = append(args, dest)
+
+	cmd := newCommand(ctx, "ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(dest)
+		return "", &ProcessError{Command: "ffmpeg", Args: args, Stderr: stderr.String(), Err: err}
+	}
+
+	if err := os.Remove(src); err != nil {
+		logrus.Warnf("删除提取音频后的视频文件失败: %v", err)
+	}
+	logrus.Infof("已提取音频: %s", dest)
+	return dest, nil
+}
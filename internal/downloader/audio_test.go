@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"video-hunter/internal/config"
+)
+
+func TestApplyMode(t *testing.T) {
+	ytdlp := config.YtDlpConfig{AudioFormat: "mp3", AudioQuality: "0"}
+	extract := ytdlp
+	extract.ExtractAudio = true
+
+	tests := []struct {
+		name        string
+		config      config.YtDlpConfig
+		req         DownloadRequest
+		wantMode    DownloadMode
+		wantFormat  string
+		wantQuality string
+		wantErr     bool
+	}{
+		{name: "默认为视频", config: ytdlp, req: DownloadRequest{}, wantMode: ModeVideo},
+		{name: "extract_audio 时默认为音频", config: extract, req: DownloadRequest{}, wantMode: ModeAudio, wantFormat: "mp3", wantQuality: "0"},
+		{name: "请求指定视频", config: extract, req: DownloadRequest{Mode: ModeVideo, AudioFormat: "opus"}, wantMode: ModeVideo},
+		{
+			name:        "请求的音频设置优先",
+			config:      ytdlp,
+			req:         DownloadRequest{Mode: ModeAudio, AudioFormat: "opus", AudioQuality: "128K"},
+			wantMode:    ModeAudio,
+			wantFormat:  "opus",
+			wantQuality: "128K",
+		},
+		{name: "未知的模式", config: ytdlp, req: DownloadRequest{Mode: "subtitle"}, wantErr: true},
+		{name: "不支持的音频格式", config: ytdlp, req: DownloadRequest{Mode: ModeAudio, AudioFormat: "wma"}, wantErr: true},
+		{name: "无效的音质", config: ytdlp, req: DownloadRequest{Mode: ModeAudio, AudioQuality: "11"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := ApplyMode(&config.Config{YtDlp: tt.config}, &req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("期望返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyMode 失败: %v", err)
+			}
+			if req.Mode != tt.wantMode || req.AudioFormat != tt.wantFormat || req.AudioQuality != tt.wantQuality {
+				t.Errorf("请求 = %s, %q, %q, 期望 %s, %q, %q",
+					req.Mode, req.AudioFormat, req.AudioQuality, tt.wantMode, tt.wantFormat, tt.wantQuality)
+			}
+		})
+	}
+}
+
+func TestAudioArgs(t *testing.T) {
+	tests := []struct {
+		req  DownloadRequest
+		want []string
+	}{
+		{DownloadRequest{Mode: ModeVideo, AudioFormat: "mp3"}, nil},
+		{DownloadRequest{Mode: ModeAudio}, []string{"-x"}},
+		{DownloadRequest{Mode: ModeAudio, AudioFormat: "mp3", AudioQuality: "0"}, []string{"-x", "--audio-format", "mp3", "--audio-quality", "0"}},
+		{DownloadRequest{Mode: ModeAudio, AudioQuality: "192K"}, []string{"-x", "--audio-quality", "192K"}},
+	}
+	for _, tt := range tests {
+		if got := audioArgs(&tt.req); !slices.Equal(got, tt.want) {
+			t.Errorf("audioArgs(%+v) = %q, 期望 %q", tt.req, got, tt.want)
+		}
+	}
+}
+
+func TestAudioFileFor(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a_song.mp3", "b_[live]*.opus", "c_clip.webm"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"a_song.MP3", "a_song.MP3"},
+		{"a_song.webm", "a_song.mp3"},
+		{"b_[live]*.mp4", "b_[live]*.opus"},
+		{"c_clip.webm", "c_clip.webm"},
+		{"d_missing.mp4", "d_missing.mp4"},
+	}
+	for _, tt := range tests {
+		got := audioFileFor(filepath.Join(dir, tt.path))
+		if want := filepath.Join(dir, tt.want); got != want {
+			t.Errorf("audioFileFor(%q) = %q, 期望 %q", tt.path, got, want)
+		}
+	}
+}
+
+func TestToAudioSkipsAudioFiles(t *testing.T) {
+	tests := []struct {
+		req  DownloadRequest
+		file string
+	}{
+		{DownloadRequest{Mode: ModeVideo}, "/downloads/a.mp4"},
+		{DownloadRequest{Mode: ModeAudio}, "/downloads/a.m4a"},
+	}
+	for _, tt := range tests {
+		got, err := toAudio(context.Background(), &tt.req, tt.file, func(*DownloadResponse) {
+			t.Error("不需要转换时不应报告后处理进度")
+		})
+		if err != nil || got != tt.file {
+			t.Errorf("toAudio(%s, %q) = %q, %v", tt.req.Mode, tt.file, got, err)
+		}
+	}
+}
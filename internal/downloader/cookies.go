@@ -0,0 +1,170 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// cookieFileKey ctx 中 Cookie 文件路径的键
+type cookieFileKey struct{}
+
+// WithCookieFile 返回携带 Netscape 格式 Cookie 文件的 ctx，yt-dlp 使用 --cookies 读取，
+// 抖音原生请求按域名从中选择 Cookie；请求中的 cookies 优先
+func WithCookieFile(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, cookieFileKey{}, path)
+}
+
+// cookieFileFrom 返回 ctx 中的 Cookie 文件路径
+func cookieFileFrom(ctx context.Context) string {
+	path, _ := ctx.Value(cookieFileKey{}).(string)
+	return path
+}
+
+// ParseNetscapeCookies 解析 Netscape cookies.txt（yt-dlp、curl 和浏览器扩展使用的格式），
+// Domain 以 . 开头表示同时匹配子域名，会话 Cookie 的 Expires 为零值
+func ParseNetscapeCookies(data []byte) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := false
+		if rest, ok := strings.CutPrefix(line, "#HttpOnly_"); ok {
+			line, httpOnly = rest, true
+		} else if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("第 %d 行不是有效的 Netscape Cookie 记录", n)
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行的过期时间无效: %s", n, fields[4])
+		}
+
+		cookie := &http.Cookie{
+			Domain:   strings.ToLower(fields[0]),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+			Name:     fields[5],
+			Value:    fields[6],
+		}
+		if strings.EqualFold(fields[1], "TRUE") && !strings.HasPrefix(cookie.Domain, ".") {
+			cookie.Domain = "." + cookie.Domain
+		}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cookies, nil
+}
+
+// FormatNetscapeCookies 生成 Netscape cookies.txt
+func FormatNetscapeCookies(cookies []*http.Cookie) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Netscape HTTP Cookie File\n")
+	for _, cookie := range cookies {
+		if cookie.HttpOnly {
+			buf.WriteString("#HttpOnly_")
+		}
+		path := cookie.Path
+		if path == "" {
+			path = "/"
+		}
+		var expires int64
+		if !cookie.Expires.IsZero() {
+			expires = cookie.Expires.Unix()
+		}
+		fmt.Fprintf(&buf, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			cookie.Domain, netscapeBool(strings.HasPrefix(cookie.Domain, ".")), path,
+			netscapeBool(cookie.Secure), expires, cookie.Name, cookie.Value)
+	}
+	return buf.Bytes()
+}
+
+// netscapeBool 返回 Netscape 格式中的布尔值
+func netscapeBool(value bool) string {
+	if value {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// CookieMatchesHost 判断 Cookie 是否会发送给该主机名
+func CookieMatchesHost(cookie *http.Cookie, host string) bool {
+	host = strings.ToLower(host)
+	if domain, ok := strings.CutPrefix(cookie.Domain, "."); ok {
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	}
+	return host == cookie.Domain
+}
+
+// addCookies 为原生 HTTP 请求添加 ctx 中 Cookie 文件里与请求地址匹配且未过期的 Cookie
+func addCookies(ctx context.Context, req *http.Request) {
+	for _, cookie := range matchingCookies(cookieFileFrom(ctx), req.URL) {
+		req.AddCookie(cookie)
+	}
+}
+
+// cookieHeader 返回 Cookie 文件中与地址匹配的 Cookie 请求头的值，没有匹配时返回空字符串
+func cookieHeader(path, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	var pairs []string
+	for _, cookie := range matchingCookies(path, u) {
+		pairs = append(pairs, cookie.String())
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// matchingCookies 读取 Cookie 文件，返回与地址匹配且未过期的 Cookie（只保留名称和值）
+func matchingCookies(path string, u *url.URL) []*http.Cookie {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logrus.Warnf("读取 Cookie 文件失败: %v", err)
+		return nil
+	}
+	cookies, err := ParseNetscapeCookies(data)
+	if err != nil {
+		logrus.Warnf("解析 Cookie 文件失败: %v", err)
+		return nil
+	}
+
+	now := time.Now()
+	requestPath := u.Path
+	if requestPath == "" {
+		requestPath = "/"
+	}
+	var matched []*http.Cookie
+	for _, cookie := range cookies {
+		if !CookieMatchesHost(cookie, u.Hostname()) ||
+			(cookie.Secure && u.Scheme != "https") ||
+			(!cookie.Expires.IsZero() && cookie.Expires.Before(now)) ||
+			!strings.HasPrefix(requestPath, cookie.Path) {
+			continue
+		}
+		matched = append(matched, &http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	return matched
+}
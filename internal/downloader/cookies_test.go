@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNetscapeCookies(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string // Domain|Path|Name=Value
+		secure  []bool
+		http    []bool
+		expires []int64
+		wantErr string
+	}{
+		{
+			name: "普通记录",
+			data: "# Netscape HTTP Cookie File\n\n.bilibili.com\tTRUE\t/\tTRUE\t1900000000\tSESSDATA\tabc\n",
+			want: []string{".bilibili.com|/|SESSDATA=abc"}, secure: []bool{true}, http: []bool{false}, expires: []int64{1900000000},
+		},
+		{
+			name: "HttpOnly 前缀",
+			data: "#HttpOnly_www.example.com\tFALSE\t/path\tFALSE\t0\tid\t1\r\n",
+			want: []string{"www.example.com|/path|id=1"}, secure: []bool{false}, http: []bool{true}, expires: []int64{0},
+		},
+		{
+			name: "包含子域名时补全点号",
+			data: "Example.COM\tTRUE\t/\tFALSE\t0\ta\tb\n",
+			want: []string{".example.com|/|a=b"}, secure: []bool{false}, http: []bool{false}, expires: []int64{0},
+		},
+		{
+			name: "值为空",
+			data: ".a.com\tTRUE\t/\tFALSE\t0\tempty\t\n",
+			want: []string{".a.com|/|empty="}, secure: []bool{false}, http: []bool{false}, expires: []int64{0},
+		},
+		{name: "只有注释", data: "# comment\n\n"},
+		{name: "字段数量错误", data: "# c\n.a.com\tTRUE\t/\tFALSE\t0\tname\n", wantErr: "第 2 行"},
+		{name: "过期时间无效", data: ".a.com\tTRUE\t/\tFALSE\tsoon\tname\tvalue\n", wantErr: "过期时间无效"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookies, err := ParseNetscapeCookies([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("错误 = %v, 期望包含 %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseNetscapeCookies 失败: %v", err)
+			}
+			if len(cookies) != len(tt.want) {
+				t.Fatalf("解析出 %d 个 Cookie, 期望 %d", len(cookies), len(tt.want))
+			}
+			for i, c := range cookies {
+				if got := c.Domain + "|" + c.Path + "|" + c.Name + "=" + c.Value; got != tt.want[i] {
+					t.Errorf("Cookie = %q, 期望 %q", got, tt.want[i])
+				}
+				if c.Secure != tt.secure[i] || c.HttpOnly != tt.http[i] {
+					t.Errorf("Secure/HttpOnly = %v/%v, 期望 %v/%v", c.Secure, c.HttpOnly, tt.secure[i], tt.http[i])
+				}
+				var expires int64
+				if !c.Expires.IsZero() {
+					expires = c.Expires.Unix()
+				}
+				if expires != tt.expires[i] {
+					t.Errorf("Expires = %d, 期望 %d", expires, tt.expires[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFormatNetscapeCookiesRoundTrip(t *testing.T) {
+	data := "# Netscape HTTP Cookie File\n" +
+		".bilibili.com\tTRUE\t/\tTRUE\t1900000000\tSESSDATA\tabc\n" +
+		"#HttpOnly_www.example.com\tFALSE\t/\tFALSE\t0\tid\t1\n"
+	cookies, err := ParseNetscapeCookies([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseNetscapeCookies 失败: %v", err)
+	}
+	if got := string(FormatNetscapeCookies(cookies)); got != data {
+		t.Errorf("FormatNetscapeCookies =\n%s\n期望\n%s", got, data)
+	}
+	if !cookies[0].Expires.Equal(time.Unix(1900000000, 0)) {
+		t.Errorf("Expires = %v", cookies[0].Expires)
+	}
+}
+
+func TestCookieMatchesHost(t *testing.T) {
+	tests := []struct {
+		domain string
+		host   string
+		want   bool
+	}{
+		{".bilibili.com", "bilibili.com", true},
+		{".bilibili.com", "www.bilibili.com", true},
+		{".bilibili.com", "WWW.Bilibili.com", true},
+		{".bilibili.com", "notbilibili.com", false},
+		{"www.example.com", "www.example.com", true},
+		{"www.example.com", "a.www.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := CookieMatchesHost(&http.Cookie{Domain: tt.domain}, tt.host); got != tt.want {
+			t.Errorf("CookieMatchesHost(%q, %q) = %v, 期望 %v", tt.domain, tt.host, got, tt.want)
+		}
+	}
+}
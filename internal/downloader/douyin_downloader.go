@@ -1,37 +1,91 @@
 package downloader
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
-	"sync"
 	"time"
+
+	"video-hunter/internal/config"
+
+	"github.com/sirupsen/logrus"
 )
 
+// douyinNoWatermarkAPI 根据视频URI获取无水印视频的播放接口
+const douyinNoWatermarkAPI = "https://aweme.snssdk.com/aweme/v1/play/?video_id=%s&ratio=1080p&line=0"
+
 // DouyinDownloader 抖音下载器
 type DouyinDownloader struct {
-	client    *http.Client
-	tasks     map[string]*DownloadResponse
-	taskMutex sync.RWMutex
+	config         *config.Config
+	proxies        *ProxyRouter
+	client         *http.Client // 页面和接口请求
+	downloadClient *http.Client // 视频文件下载，不限制整体时长
+	tracker        *taskTracker
+}
+
+// douyinVideo 从页面 RENDER_DATA 中解析出的视频数据
+type douyinVideo struct {
+	AwemeID   string
+	Desc      string
+	Width     int
+	Height    int
+	Duration  int      // 毫秒
+	Cover     string   // 封面地址
+	PlayURLs  []string // 候选播放地址，无水印地址在前
+	MusicURLs []string // 背景音乐地址，音频模式没有 ffmpeg 时使用
+	Formats   []douyinFormat
+}
+
+// douyinFormat 多码率列表中的一种格式及其播放地址
+type douyinFormat struct {
+	format VideoFormat
+	urls   []string
 }
 
 // NewDouyinDownloader 创建新的抖音下载器实例
-func NewDouyinDownloader() *DouyinDownloader {
+func NewDouyinDownloader(cfg *config.Config, proxies *ProxyRouter) *DouyinDownloader {
+	timeout := time.Duration(cfg.Douyin.APITimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
 	return &DouyinDownloader{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		tasks: make(map[string]*DownloadResponse),
+		config:         cfg,
+		proxies:        proxies,
+		client:         proxies.client(timeout),
+		downloadClient: proxies.downloadClient(),
+		tracker:        newTaskTracker(),
 	}
 }
 
+// Name 提供者名称
+func (d *DouyinDownloader) Name() string {
+	return "douyin"
+}
+
+// Priority 抖音链接优先使用原生下载器，失败后再回退到yt-dlp
+func (d *DouyinDownloader) Priority() int {
+	return 100
+}
+
+// CanHandle 判断是否为抖音链接
+func (d *DouyinDownloader) CanHandle(url string) bool {
+	return d.isDouyinURL(url)
+}
+
 // Download 实现下载接口
-func (d *DouyinDownloader) Download(req *DownloadRequest) (*DownloadResponse, error) {
+func (d *DouyinDownloader) Download(ctx context.Context, req *DownloadRequest, callback ProgressCallback) (*DownloadResponse, error) {
 	if req == nil {
 		return nil, errors.New("下载请求不能为空")
 	}
@@ -42,83 +96,67 @@ func (d *DouyinDownloader) Download(req *DownloadRequest) (*DownloadResponse, er
 	}
 
 	// 获取视频信息
-	videoInfo, err := d.GetVideoInfo(req.URL)
+	if callback != nil {
+		callback(&DownloadResponse{Status: StatusDownloading, Stage: StageResolving, Updated: time.Now()})
+	}
+	video, videoInfo, err := d.resolveVideo(ctx, req.URL)
 	if err != nil {
 		return nil, fmt.Errorf("获取视频信息失败: %w", err)
 	}
 
-	// 创建下载响应
-	resp := &DownloadResponse{
-		ID:       req.TaskID,
-		Status:   StatusPending,
-		Progress: 0,
-		Created:  time.Now(),
-		Updated:  time.Now(),
-		Title:    videoInfo.Title,
-	}
-
-	// 保存任务信息
-	d.taskMutex.Lock()
-	d.tasks[req.TaskID] = resp
-	d.taskMutex.Unlock()
-
-	// 异步开始下载
-	go d.startDownload(req, resp, videoInfo)
+	// 记录任务，便于查询进度和取消
+	ctx, resp := d.tracker.start(ctx, req.TaskID, videoInfo.Title)
+	defer d.tracker.finish(req.TaskID)
 
+	if err := d.startDownload(ctx, req, resp, video, callback); err != nil {
+		return nil, err
+	}
 	return resp, nil
 }
 
 // GetProgress 获取下载进度
 func (d *DouyinDownloader) GetProgress(id string) (*DownloadResponse, error) {
-	d.taskMutex.RLock()
-	defer d.taskMutex.RUnlock()
-
-	if resp, ok := d.tasks[id]; ok {
-		return resp, nil
-	}
-	return nil, errors.New("任务不存在")
+	return d.tracker.get(id)
 }
 
 // Cancel 取消下载
 func (d *DouyinDownloader) Cancel(id string) error {
-	d.taskMutex.Lock()
-	defer d.taskMutex.Unlock()
-
-	if resp, ok := d.tasks[id]; ok {
-		resp.Status = StatusCancelled
-		return nil
-	}
-	return errors.New("任务不存在")
+	return d.tracker.cancel(id)
 }
 
 // GetVideoInfo 获取视频信息
-func (d *DouyinDownloader) GetVideoInfo(urlStr string) (*VideoInfo, error) {
+func (d *DouyinDownloader) GetVideoInfo(ctx context.Context, urlStr string) (*VideoInfo, error) {
+	_, info, err := d.resolveVideo(ctx, urlStr)
+	return info, err
+}
+
+// resolveVideo 请求视频页面并解析视频数据
+func (d *DouyinDownloader) resolveVideo(ctx context.Context, urlStr string) (*douyinVideo, *VideoInfo, error) {
 	// 解析并清理URL
-	cleanURL, err := d.cleanURL(urlStr)
+	cleanURL, err := d.cleanURL(ctx, urlStr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// 发送请求获取页面内容
-	req, err := http.NewRequest("GET", cleanURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", cleanURL, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// 设置请求头
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.114 Safari/537.36")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	// 设置网站配置中的请求头和上传的 Cookie
+	d.site().setHeaders(req.Header)
+	addCookies(ctx, req)
 
 	resp, err := d.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// 解析视频信息
@@ -142,7 +180,7 @@ func (d *DouyinDownloader) isDouyinURL(urlStr string) bool {
 }
 
 // cleanURL 清理并规范化URL
-func (d *DouyinDownloader) cleanURL(urlStr string) (string, error) {
+func (d *DouyinDownloader) cleanURL(ctx context.Context, urlStr string) (string, error) {
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
 		return "", err
@@ -150,10 +188,12 @@ func (d *DouyinDownloader) cleanURL(urlStr string) (string, error) {
 
 	// 如果是短链接，进行重定向获取真实链接
 	if strings.Contains(parsedURL.Host, "v.douyin.com") {
-		req, err := http.NewRequest("GET", urlStr, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
 		if err != nil {
 			return "", err
 		}
+		d.site().setHeaders(req.Header)
+		addCookies(ctx, req)
 		resp, err := d.client.Do(req)
 		if err != nil {
 			return "", err
@@ -166,7 +206,7 @@ func (d *DouyinDownloader) cleanURL(urlStr string) (string, error) {
 }
 
 // parseVideoInfo 从页面内容解析视频信息
-func (d *DouyinDownloader) parseVideoInfo(content string) (*VideoInfo, error) {
+func (d *DouyinDownloader) parseVideoInfo(content string) (*douyinVideo, *VideoInfo, error) {
 	// 提取视频标题
 	titleRegex := regexp.MustCompile(`<title[^>]*>(.*?)</title>`)
 	titleMatch := titleRegex.FindStringSubmatch(content)
@@ -179,55 +219,460 @@ func (d *DouyinDownloader) parseVideoInfo(content string) (*VideoInfo, error) {
 	videoDataRegex := regexp.MustCompile(`<script id="RENDER_DATA" type="application/json">(.*?)</script>`)
 	videoDataMatch := videoDataRegex.FindStringSubmatch(content)
 	if len(videoDataMatch) < 2 {
-		return nil, errors.New("无法找到视频数据")
+		return nil, nil, errors.New("无法找到视频数据")
 	}
 
 	// 解码视频数据
 	decodedData, err := url.QueryUnescape(videoDataMatch[1])
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// 解析JSON数据
 	var data map[string]interface{}
 	if err := json.Unmarshal([]byte(decodedData), &data); err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// 查找视频详情
+	video := findDouyinVideo(data)
+	if video == nil || len(video.PlayURLs) == 0 {
+		return nil, nil, errors.New("视频数据中没有播放地址")
+	}
+	if video.Desc != "" {
+		title = video.Desc
 	}
 
 	// 构建视频信息
 	info := &VideoInfo{
 		Title:       title,
+		Thumbnail:   video.Cover,
 		CanDownload: true,
 		Formats:     make([]VideoFormat, 0),
+		Metadata: map[string]string{
+			"source":    "douyin",
+			"aweme_id":  video.AwemeID,
+			"video_url": video.PlayURLs[0],
+			"parsed_by": "render_data",
+		},
+	}
+	if len(video.MusicURLs) > 0 {
+		info.Metadata["music_url"] = video.MusicURLs[0]
+	}
+	if video.Duration > 0 {
+		info.Duration = formatETA(time.Duration(video.Duration) * time.Millisecond)
+	}
+
+	resolution := "最佳质量"
+	if video.Width > 0 && video.Height > 0 {
+		resolution = fmt.Sprintf("%dx%d", video.Width, video.Height)
 	}
 
 	// 添加默认格式
 	info.Formats = append(info.Formats, VideoFormat{
 		FormatID:   "best",
 		Extension:  "mp4",
-		Resolution: "最佳质量",
+		Resolution: resolution,
+		URL:        video.PlayURLs[0],
 		Quality:    "高清",
 	})
+	for _, format := range video.Formats {
+		info.Formats = append(info.Formats, format.format)
+	}
+
+	return video, info, nil
+}
+
+// findDouyinVideo 在 RENDER_DATA 中递归查找包含播放地址的视频详情
+// 页面结构经常调整，因此不依赖固定路径，而是查找带有 video.playAddr/play_addr 的对象
+func findDouyinVideo(node interface{}) *douyinVideo {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if videoData, ok := v["video"].(map[string]interface{}); ok {
+			if playURLs := douyinPlayURLs(videoData); len(playURLs) > 0 {
+				video := &douyinVideo{
+					AwemeID:   firstString(v, "awemeId", "aweme_id"),
+					Desc:      firstString(v, "desc"),
+					Width:     int(getInt64(videoData, "width")),
+					Height:    int(getInt64(videoData, "height")),
+					Duration:  int(getInt64(videoData, "duration")),
+					Cover:     normalizeDouyinURL(firstString(videoData, "cover", "originCover")),
+					PlayURLs:  playURLs,
+					MusicURLs: douyinMusicURLs(v["music"]),
+					Formats:   douyinFormats(videoData),
+				}
+				return video
+			}
+		}
 
-	return info, nil
+		// 按键名排序遍历，保证结果稳定
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if video := findDouyinVideo(v[key]); video != nil {
+				return video
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if video := findDouyinVideo(item); video != nil {
+				return video
+			}
+		}
+	}
+	return nil
 }
 
-// startDownload 开始下载过程
-func (d *DouyinDownloader) startDownload(req *DownloadRequest, resp *DownloadResponse, info *VideoInfo) {
+// douyinPlayURLs 从视频对象中提取候选播放地址，无水印地址排在前面
+func douyinPlayURLs(videoData map[string]interface{}) []string {
+	var uri string
+	var raw []string
+
+	// Web页面格式: playAddr: [{src: "//..."}], playApi: "//..."
+	raw = append(raw, srcList(videoData["playAddr"])...)
+	if api := firstString(videoData, "playApi"); api != "" {
+		raw = append(raw, api)
+	}
+
+	// 接口格式: play_addr: {uri: "...", url_list: ["..."]}
+	if playAddr, ok := videoData["play_addr"].(map[string]interface{}); ok {
+		uri = firstString(playAddr, "uri")
+		raw = append(raw, stringList(playAddr["url_list"])...)
+	}
+
+	// 多码率列表，按码率从高到低
+	if bitRates, ok := videoData["bitRateList"].([]interface{}); ok {
+		type bitRate struct {
+			rate int64
+			urls []string
+		}
+		var list []bitRate
+		for _, item := range bitRates {
+			if m, ok := item.(map[string]interface{}); ok {
+				list = append(list, bitRate{rate: getInt64(m, "bitRate"), urls: srcList(m["playAddr"])})
+			}
+		}
+		sort.SliceStable(list, func(i, j int) bool { return list[i].rate > list[j].rate })
+		for _, br := range list {
+			raw = append(raw, br.urls...)
+		}
+	}
+
+	if uri == "" {
+		uri = firstString(videoData, "uri", "vid")
+	}
+
+	var urls []string
+	seen := make(map[string]bool)
+	add := func(u string) {
+		u = normalizeDouyinURL(u)
+		if u != "" && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+
+	// 优先使用根据URI拼接的无水印地址
+	if uri != "" && !strings.HasPrefix(uri, "http") {
+		add(fmt.Sprintf(douyinNoWatermarkAPI, url.QueryEscape(uri)))
+	}
+	// 其次是把 playwm 替换为 play 的无水印地址
+	for _, u := range raw {
+		if strings.Contains(u, "playwm") {
+			add(strings.Replace(u, "playwm", "play", 1))
+		}
+	}
+	// 最后是页面提供的原始地址
+	for _, u := range raw {
+		add(u)
+	}
+
+	return urls
+}
+
+// douyinFormats 解析多码率列表，Web页面为 bitRateList，接口为 bit_rate
+func douyinFormats(videoData map[string]interface{}) []douyinFormat {
+	var formats []douyinFormat
+	seen := make(map[string]bool)
+	for _, key := range []string{"bitRateList", "bit_rate"} {
+		items, _ := videoData[key].([]interface{})
+		for _, item := range items {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			urls := srcList(m["playAddr"])
+			width, height := getInt64(m, "width"), getInt64(m, "height")
+			size := getInt64(m, "dataSize")
+			if playAddr, ok := m["play_addr"].(map[string]interface{}); ok {
+				urls = append(urls, stringList(playAddr["url_list"])...)
+				width, height = max(width, getInt64(playAddr, "width")), max(height, getInt64(playAddr, "height"))
+				size = max(size, getInt64(playAddr, "data_size"))
+			}
+			for i, u := range urls {
+				urls[i] = normalizeDouyinURL(u)
+			}
+			if len(urls) == 0 {
+				continue
+			}
+
+			id := firstString(m, "gearName", "gear_name")
+			if id == "" {
+				id = fmt.Sprintf("bitrate_%d", max(getInt64(m, "bitRate"), getInt64(m, "bit_rate")))
+			}
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			vcodec := "h264"
+			if getInt64(m, "isH265") == 1 || getInt64(m, "is_h265") == 1 || getInt64(m, "isBytevc1") == 1 || getInt64(m, "is_bytevc1") == 1 {
+				vcodec = "hevc"
+			}
+			ext := firstString(m, "format")
+			if ext == "" {
+				ext = "mp4"
+			}
+			format := VideoFormat{
+				FormatID:  id,
+				Extension: ext,
+				Filesize:  size,
+				URL:       urls[0],
+				Quality:   id,
+				VCodec:    vcodec,
+				ACodec:    "aac",
+			}
+			if width > 0 && height > 0 {
+				format.Resolution = fmt.Sprintf("%dx%d", width, height)
+			}
+			formats = append(formats, douyinFormat{format: format, urls: urls})
+		}
+	}
+	return formats
+}
+
+// douyinMusicURLs 提取背景音乐地址，Web页面为 music.playUrl.urlList，接口为 music.play_url.url_list
+func douyinMusicURLs(value interface{}) []string {
+	music, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var raw []string
+	for _, key := range []string{"playUrl", "play_url"} {
+		switch v := music[key].(type) {
+		case string:
+			raw = append(raw, v)
+		case map[string]interface{}:
+			raw = append(raw, stringList(v["urlList"])...)
+			raw = append(raw, stringList(v["url_list"])...)
+			if uri := firstString(v, "uri"); strings.HasPrefix(uri, "http") {
+				raw = append(raw, uri)
+			}
+		}
+	}
+
+	var urls []string
+	seen := make(map[string]bool)
+	for _, u := range raw {
+		if u = normalizeDouyinURL(u); u != "" && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// srcList 解析 [{src: "..."}] 或 ["..."] 格式的地址列表
+func srcList(value interface{}) []string {
+	var urls []string
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			urls = append(urls, v)
+		case map[string]interface{}:
+			if src := firstString(v, "src", "url"); src != "" {
+				urls = append(urls, src)
+			}
+		}
+	}
+	return urls
+}
+
+// stringList 解析字符串数组
+func stringList(value interface{}) []string {
+	var list []string
+	if items, ok := value.([]interface{}); ok {
+		for _, item := range items {
+			if str, ok := item.(string); ok && str != "" {
+				list = append(list, str)
+			}
+		}
+	}
+	return list
+}
+
+// firstString 返回第一个存在的非空字符串字段
+func firstString(data map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if str := getString(data, key); str != "" {
+			return str
+		}
+	}
+	return ""
+}
+
+// normalizeDouyinURL 补全协议相对地址
+func normalizeDouyinURL(u string) string {
+	u = strings.TrimSpace(u)
+	if strings.HasPrefix(u, "//") {
+		return "https:" + u
+	}
+	return u
+}
+
+// startDownload 下载视频文件，依次尝试无水印和带水印的播放地址
+func (d *DouyinDownloader) startDownload(ctx context.Context, req *DownloadRequest, resp *DownloadResponse, video *douyinVideo, callback ProgressCallback) error {
 	// 更新状态为下载中
 	resp.Status = StatusDownloading
 	resp.Updated = time.Now()
 
-	// TODO: 实现实际的下载逻辑
-	// 1. 获取视频真实下载地址
-	// 2. 使用适当的下载方法（支持断点续传）
-	// 3. 更新下载进度，注意控制更新频率
-	//    - 使用时间间隔控制（例如每秒最多更新一次）
-	//    - 只在进度有明显变化时更新（例如变化超过1%）
-	// 4. 处理下载完成或失败的情况
+	dest := d.outputPath(req, resp.Title)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("创建下载目录失败: %w", err)
+	}
+
+	progressCallback := func(progress *DownloadResponse) {
+		d.tracker.update(req.TaskID, progress)
+		if callback != nil {
+			callback(progress)
+		}
+	}
+
+	urls, err := d.formatURLs(req, video)
+	if err != nil {
+		return err
+	}
+
+	// 音频模式用 ffmpeg 从视频中提取音轨，没有 ffmpeg 时改为下载背景音乐
+	if req.Mode == ModeAudio {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			if len(video.MusicURLs) == 0 {
+				return errors.New("提取音频需要 ffmpeg，视频也没有背景音乐地址")
+			}
+			logrus.Warnf("未安装 ffmpeg，下载抖音视频的背景音乐 [%s]", req.TaskID)
+			urls = video.MusicURLs
+			dest = strings.TrimSuffix(dest, filepath.Ext(dest)) + musicExtension(urls[0])
+		}
+	}
+
+	var lastErr error
+	for i, playURL := range urls {
+		logrus.Infof("下载抖音视频 [%s]: %s", req.TaskID, playURL)
+
+		size, err := httpDownload(ctx, d.downloadClient, playURL, d.downloadHeaders(), dest, progressCallback)
+		if err == nil {
+			file, err := toAudio(ctx, req, dest, progressCallback)
+			if err != nil {
+				return err
+			}
+			if file != dest {
+				if info, err := os.Stat(file); err == nil {
+					size = info.Size()
+				}
+			}
+			resp.Status = StatusCompleted
+			resp.Progress = 100
+			resp.File = file
+			resp.Size = size
+			resp.Updated = time.Now()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("下载已取消: %w", ctx.Err())
+		}
+
+		lastErr = err
+		logrus.Warnf("抖音视频地址下载失败 (%d/%d): %v", i+1, len(urls), err)
+
+		// 不同地址对应的文件可能不同，已下载的部分不能复用
+		removePartFile(dest + ".part")
+	}
 
-	// 临时：标记为失败
 	resp.Status = StatusFailed
-	resp.Error = "下载功能尚未实现"
+	resp.Error = fmt.Sprintf("所有播放地址均下载失败: %v", lastErr)
 	resp.Updated = time.Now()
+	return errors.New(resp.Error)
+}
+
+// formatURLs 按请求的格式表达式从多码率列表中选择播放地址，没有指定格式限制时使用默认的无水印地址
+func (d *DouyinDownloader) formatURLs(req *DownloadRequest, video *douyinVideo) ([]string, error) {
+	format := req.Format
+	if format == "" {
+		format = d.site().Format
+	}
+	spec, err := ParseFormat(format)
+	if err != nil || req.Mode == ModeAudio {
+		return video.PlayURLs, err
+	}
+
+	formats := make([]VideoFormat, len(video.Formats))
+	for i, f := range video.Formats {
+		formats[i] = f.format
+	}
+	chosen, err := spec.Choose(formats)
+	if err != nil || chosen == nil {
+		return video.PlayURLs, err
+	}
+	for _, f := range video.Formats {
+		if f.format.FormatID == chosen.FormatID {
+			logrus.Infof("抖音视频选择格式 [%s]: %s %s", req.TaskID, chosen.FormatID, chosen.Resolution)
+			return f.urls, nil
+		}
+	}
+	return video.PlayURLs, nil
+}
+
+// musicExtension 背景音乐文件的扩展名，地址中没有音频扩展名时使用 .mp3
+func musicExtension(rawURL string) string {
+	if ext := strings.ToLower(path.Ext(urlPath(rawURL))); audioExtensions[ext] {
+		return ext
+	}
+	return ".mp3"
+}
+
+// outputPath 生成下载文件路径，服务层已为 Output 加上任务ID前缀
+func (d *DouyinDownloader) outputPath(req *DownloadRequest, title string) string {
+	if req.Output != "" {
+		return req.Output
+	}
+
+	// 抖音标题通常是带话题的长文案，截断以免超出文件名长度限制
+	name := sanitizeFilename(title)
+	if runes := []rune(name); len(runes) > 80 {
+		name = string(runes[:80])
+	}
+	if req.TaskID != "" {
+		name = req.TaskID + "_" + name
+	}
+	return filepath.Join(d.config.Downloader.OutputDir, name+".mp4")
+}
+
+// downloadHeaders 下载视频文件使用的请求头
+func (d *DouyinDownloader) downloadHeaders() map[string]string {
+	headers := d.site().httpHeaders()
+	headers["Accept"] = "*/*"
+	return headers
+}
+
+// site 抖音原生请求使用的网站配置
+func (d *DouyinDownloader) site() siteProfile {
+	return resolveSite(d.config, douyinSiteURL)
 }
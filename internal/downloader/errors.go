@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// ErrArchived 视频已记录在下载存档中，yt-dlp 跳过了下载
+var ErrArchived = errors.New("视频已在下载存档中，跳过下载")
+
+// ProcessError 外部下载进程（yt-dlp）执行失败，保留错误输出用于判断失败原因
+type ProcessError struct {
+	Command string
+	Args    []string
+	Stdout  string
+	Stderr  string
+	Err     error
+}
+
+func (e *ProcessError) Error() string {
+	return redactCredentials(fmt.Sprintf("下载失败: %v\n命令: %s\n标准输出:\n%s\n错误输出:\n%s",
+		e.Err, commandLine(e.Command, e.Args), e.Stdout, e.Stderr))
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// Summary 返回错误输出中最后一条 ERROR 信息，没有时返回最后一行输出
+func (e *ProcessError) Summary() string {
+	var lastError, last string
+	for _, line := range strings.Split(e.Stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "ERROR:") {
+			lastError = line
+		}
+		last = line
+	}
+	switch {
+	case lastError != "":
+		return redactCredentials(lastError)
+	case last != "":
+		return redactCredentials(last)
+	}
+	return redactCredentials(e.Err.Error())
+}
+
+var (
+	// permanentErrorPatterns 重试无意义的错误：链接、权限或内容本身的问题
+	permanentErrorPatterns = regexp.MustCompile(`(?i)unsupported url|video unavailable|private video|` +
+		`this video is not available|has been removed|is not a valid url|requested format is not available|` +
+		`sign in to confirm|login required|requires? (login|authentication)|fresh cookies|members-only|` +
+		`http error 40[0-4]|http error 410|no video formats found|drm protected`)
+
+	// transientErrorPatterns 网络抖动或服务器临时故障，稍后重试通常可以成功
+	transientErrorPatterns = regexp.MustCompile(`(?i)http error 5\d\d|http error 429|too many requests|` +
+		`connection (reset|refused|aborted)|reset by peer|broken pipe|timed? ?out|temporary failure|` +
+		`name resolution|network is unreachable|remote end closed|incompleteread|eof occurred|` +
+		`unable to download (video data|webpage|json)|fragment \d+|giving up after \d+ fragment|` +
+		`did not get any data blocks|unable to connect|ssl: |got server http error|` +
+		`content too short|unexpected eof`)
+)
+
+// IsRetryable 判断下载错误是否为临时性错误，临时性错误可以稍后重试
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.retryable()
+	}
+	var aria2Err *aria2Error
+	if errors.As(err, &aria2Err) {
+		return aria2Err.retryable()
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errPartMismatch) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// yt-dlp 只能根据错误输出判断，永久性错误优先；只检查 ERROR 行，
+	// -v 的调试输出中的链接、格式列表等内容可能碰巧匹配
+	message := err.Error()
+	var procErr *ProcessError
+	if errors.As(err, &procErr) {
+		message = procErr.Summary()
+	}
+	if permanentErrorPatterns.MatchString(message) {
+		return false
+	}
+	return transientErrorPatterns.MatchString(message)
+}
+
+// ErrorSummary 返回适合展示和记录的简短错误信息
+func ErrorSummary(err error) string {
+	var procErr *ProcessError
+	if errors.As(err, &procErr) {
+		return procErr.Summary()
+	}
+	return err.Error()
+}
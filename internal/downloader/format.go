@@ -0,0 +1,563 @@
+package downloader
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FormatSpec 解析后的格式表达式，翻译为 yt-dlp 的 -f 格式选择器和 -S 排序字段；
+// 无法识别的表达式原样作为 yt-dlp 格式选择器，兼容 "bestvideo+bestaudio/best"、"137+140" 等写法
+type FormatSpec struct {
+	Expr     string   // 原始表达式
+	Selector string   // -f 格式选择器，为空时使用 yt-dlp 默认的选择
+	Sort     []string // -S 排序字段
+	Merge    string   // --merge-output-format，指定容器时使用
+
+	raw       bool
+	kind      formatKind
+	worst     bool
+	minHeight int
+	maxHeight int
+	maxSize   int64
+	vcodec    *formatCodec
+	acodec    *formatCodec
+	container *formatContainer
+	prefer    []formatPreference
+}
+
+// formatKind 要下载的流
+type formatKind int
+
+const (
+	kindAV    formatKind = iota // 音视频
+	kindAudio                   // 只下载音频
+	kindVideo                   // 只下载视频
+)
+
+// formatKinds 选择下载的流和质量的关键字
+var formatKinds = map[string]struct {
+	kind  formatKind
+	worst bool
+}{
+	"best":        {kindAV, false},
+	"worst":       {kindAV, true},
+	"best-audio":  {kindAudio, false},
+	"worst-audio": {kindAudio, true},
+	"best-video":  {kindVideo, false},
+	"worst-video": {kindVideo, true},
+}
+
+// formatCodec 编码名称对应的 yt-dlp 过滤条件和排序字段
+type formatCodec struct {
+	video   bool
+	pattern string // 匹配 vcodec/acodec 的正则，同时用于 yt-dlp 的 ~= 过滤
+	sort    string
+	re      *regexp.Regexp
+}
+
+func newFormatCodec(video bool, pattern, sort string) *formatCodec {
+	return &formatCodec{video: video, pattern: pattern, sort: sort, re: regexp.MustCompile("(?i)" + pattern)}
+}
+
+var formatCodecs = func() map[string]*formatCodec {
+	h264 := newFormatCodec(true, "^(avc|h264)", "vcodec:h264")
+	h265 := newFormatCodec(true, "^(hev|hvc|h265|bytevc1)", "vcodec:h265")
+	vp9 := newFormatCodec(true, "^vp0?9", "vcodec:vp9")
+	av1 := newFormatCodec(true, "^av0?1", "vcodec:av01")
+	aac := newFormatCodec(false, "^(mp4a|aac)", "acodec:aac")
+	return map[string]*formatCodec{
+		"h264": h264, "avc": h264,
+		"h265": h265, "hevc": h265,
+		"vp9": vp9,
+		"av1": av1,
+		"aac": aac, "mp4a": aac,
+		"opus":   newFormatCodec(false, "^opus", "acodec:opus"),
+		"mp3":    newFormatCodec(false, "^mp3", "acodec:mp3"),
+		"vorbis": newFormatCodec(false, "^vorbis", "acodec:vorbis"),
+	}
+}()
+
+// formatContainer 容器对应的视频和音频扩展名
+type formatContainer struct {
+	video string
+	audio string
+}
+
+var formatContainers = map[string]*formatContainer{
+	"mp4":  {video: "mp4", audio: "m4a"},
+	"webm": {video: "webm", audio: "webm"},
+}
+
+// formatPreference prefer 指定的偏好，只影响排序
+type formatPreference struct {
+	height    int
+	codec     *formatCodec
+	container *formatContainer
+}
+
+var (
+	resolutionRegex = regexp.MustCompile(`^(<=|>=|<|>|=)?(\d+p|[248]k)$`)
+	sizeRegex       = regexp.MustCompile(`^(\d+(?:\.\d+)?)(b|[kmgt]i?b?)?$`)
+)
+
+// ParseFormat 解析格式表达式，各项以空格或逗号分隔：
+//   - 1080p、4k：优先选择不超过该分辨率的最高分辨率；<=720p、>=720p、=720p 等为硬性限制
+//   - best、worst、best-audio、worst-audio、best-video、worst-video：下载的流和质量
+//   - h264、h265、vp9、av1、aac、opus、mp3、vorbis：限定编码，可以用 + 连接，例如 h264+aac
+//   - mp4、webm：限定容器并合并为该容器
+//   - prefer <编码|容器|分辨率>：只作为排序偏好，例如 prefer av1
+//   - max <分辨率|大小>、min <分辨率>：例如 max 500MB，大小未知的格式不受限制
+//   - compat：兼容旧设备的 H.264/AAC MP4
+func ParseFormat(expr string) (*FormatSpec, error) {
+	spec := &FormatSpec{Expr: strings.TrimSpace(expr)}
+	tokens := strings.Fields(strings.ReplaceAll(strings.ToLower(spec.Expr), ",", " "))
+	if len(tokens) == 0 {
+		return spec, nil
+	}
+
+	recognized, unknown := 0, ""
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch token {
+		case "prefer", "max", "min":
+			if i+1 >= len(tokens) {
+				return nil, fmt.Errorf("%s 后缺少参数", token)
+			}
+			value := tokens[i+1]
+			i++
+			// 允许 "500 MB" 这样数字和单位分开的写法
+			if token == "max" && i+1 < len(tokens) && isNumber(value) && sizeRegex.MatchString("1"+tokens[i+1]) {
+				value += tokens[i+1]
+				i++
+			}
+			if err := spec.applyKeyword(token, value); err != nil {
+				return nil, err
+			}
+		case "compat":
+			if err := spec.applyCompat(); err != nil {
+				return nil, err
+			}
+		default:
+			ok, err := spec.applyTerm(token)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				if unknown == "" {
+					unknown = token
+				}
+				continue
+			}
+		}
+		recognized++
+	}
+
+	if unknown != "" {
+		if recognized > 0 {
+			return nil, fmt.Errorf("无法识别的格式: %s", unknown)
+		}
+		// 不是格式表达式，原样交给 yt-dlp
+		return &FormatSpec{Expr: spec.Expr, Selector: spec.Expr, raw: true}, nil
+	}
+	if spec.minHeight > 0 && spec.maxHeight > 0 && spec.minHeight > spec.maxHeight {
+		return nil, fmt.Errorf("分辨率范围为空: %s", spec.Expr)
+	}
+	spec.build()
+	return spec, nil
+}
+
+// applyTerm 处理不带参数的一项，无法识别时返回 false
+func (s *FormatSpec) applyTerm(token string) (bool, error) {
+	if kind, ok := formatKinds[token]; ok {
+		if s.kind != kindAV && s.kind != kind.kind {
+			return false, fmt.Errorf("格式冲突: %s", s.Expr)
+		}
+		s.kind, s.worst = kind.kind, kind.worst
+		return true, nil
+	}
+	if strings.ContainsAny(token[:1], "<>=") || resolutionRegex.MatchString(token) {
+		return true, s.applyResolution(token)
+	}
+
+	// h264+aac 等组合，每一部分都必须是编码或容器
+	parts := strings.Split(token, "+")
+	for _, part := range parts {
+		if formatCodecs[part] == nil && formatContainers[part] == nil {
+			return false, nil
+		}
+	}
+	for _, part := range parts {
+		if codec := formatCodecs[part]; codec != nil {
+			if err := s.setCodec(codec); err != nil {
+				return false, err
+			}
+		} else if err := s.setContainer(formatContainers[part]); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// applyKeyword 处理 prefer、max 和 min
+func (s *FormatSpec) applyKeyword(keyword, value string) error {
+	switch keyword {
+	case "prefer":
+		if codec := formatCodecs[value]; codec != nil {
+			s.prefer = append(s.prefer, formatPreference{codec: codec})
+			return nil
+		}
+		if container := formatContainers[value]; container != nil {
+			s.prefer = append(s.prefer, formatPreference{container: container})
+			return nil
+		}
+		if height, ok := parseHeight(value); ok {
+			s.prefer = append(s.prefer, formatPreference{height: height})
+			return nil
+		}
+		return fmt.Errorf("prefer 只支持编码、容器或分辨率: %s", value)
+	case "max":
+		if height, ok := parseHeight(value); ok {
+			s.maxHeight = height
+			return nil
+		}
+		size, err := parseSize(value)
+		if err != nil {
+			return err
+		}
+		s.maxSize = size
+		return nil
+	default:
+		height, ok := parseHeight(value)
+		if !ok {
+			return fmt.Errorf("min 只支持分辨率: %s", value)
+		}
+		s.minHeight = height
+		return nil
+	}
+}
+
+// applyResolution 处理分辨率，不带比较符时只作为排序偏好
+func (s *FormatSpec) applyResolution(token string) error {
+	m := resolutionRegex.FindStringSubmatch(token)
+	if m == nil {
+		return fmt.Errorf("无法识别的分辨率: %s", token)
+	}
+	height, _ := parseHeight(m[2])
+	switch m[1] {
+	case "":
+		s.prefer = append(s.prefer, formatPreference{height: height})
+	case "<=":
+		s.maxHeight = height
+	case "<":
+		s.maxHeight = height - 1
+	case ">=":
+		s.minHeight = height
+	case ">":
+		s.minHeight = height + 1
+	case "=":
+		s.minHeight, s.maxHeight = height, height
+	}
+	return nil
+}
+
+// applyCompat 限定为 H.264/AAC MP4
+func (s *FormatSpec) applyCompat() error {
+	for _, part := range []string{"h264", "aac"} {
+		if err := s.setCodec(formatCodecs[part]); err != nil {
+			return err
+		}
+	}
+	return s.setContainer(formatContainers["mp4"])
+}
+
+func (s *FormatSpec) setCodec(codec *formatCodec) error {
+	target := &s.acodec
+	if codec.video {
+		target = &s.vcodec
+	}
+	if *target != nil && *target != codec {
+		return fmt.Errorf("编码冲突: %s", s.Expr)
+	}
+	*target = codec
+	return nil
+}
+
+func (s *FormatSpec) setContainer(container *formatContainer) error {
+	if s.container != nil && s.container != container {
+		return fmt.Errorf("容器冲突: %s", s.Expr)
+	}
+	s.container = container
+	return nil
+}
+
+// build 生成 yt-dlp 格式选择器和排序字段
+func (s *FormatSpec) build() {
+	var video, audio, both strings.Builder
+	if s.minHeight > 0 {
+		fmt.Fprintf(&video, "[height>=%d]", s.minHeight)
+	}
+	if s.maxHeight > 0 {
+		fmt.Fprintf(&video, "[height<=%d]", s.maxHeight)
+	}
+	if s.vcodec != nil {
+		fmt.Fprintf(&video, "[vcodec~='%s']", s.vcodec.pattern)
+	}
+	if s.acodec != nil {
+		fmt.Fprintf(&audio, "[acodec~='%s']", s.acodec.pattern)
+	}
+	both.WriteString(video.String() + audio.String())
+	if s.container != nil {
+		fmt.Fprintf(&video, "[ext=%s]", s.container.video)
+		fmt.Fprintf(&audio, "[ext=%s]", s.container.audio)
+		fmt.Fprintf(&both, "[ext=%s]", s.container.video)
+		s.Merge = s.container.video
+	}
+	if s.maxSize > 0 {
+		size := fmt.Sprintf("[filesize<=?%d][filesize_approx<=?%d]", s.maxSize, s.maxSize)
+		video.WriteString(size)
+		audio.WriteString(size)
+		both.WriteString(size)
+	}
+
+	v, a, b := "bv*", "ba", "b"
+	if s.worst {
+		v, a, b = "wv*", "wa", "w"
+	}
+	switch s.kind {
+	case kindAudio:
+		s.Selector = a + audio.String() + "/" + b + audio.String()
+	case kindVideo:
+		s.Selector = strings.TrimSuffix(v, "*") + video.String()
+	default:
+		// 没有任何限制时使用 yt-dlp 默认的选择，由 yt-dlp 根据是否安装 ffmpeg 决定是否合并
+		if s.worst || video.Len() > 0 || audio.Len() > 0 {
+			s.Selector = v + video.String() + "+" + a + audio.String() + "/" + b + both.String()
+		}
+	}
+
+	for _, p := range s.prefer {
+		switch {
+		case p.codec != nil:
+			s.Sort = append(s.Sort, p.codec.sort)
+		case p.container != nil:
+			s.Sort = append(s.Sort, "ext:"+p.container.video+":"+p.container.audio)
+		default:
+			s.Sort = append(s.Sort, "res:"+strconv.Itoa(p.height))
+		}
+	}
+}
+
+// Args 返回 yt-dlp 的格式参数
+func (s *FormatSpec) Args() []string {
+	var args []string
+	if s.Selector != "" {
+		args = append(args, "-f", s.Selector)
+	}
+	if len(s.Sort) > 0 {
+		args = append(args, "-S", strings.Join(s.Sort, ","))
+	}
+	if s.Merge != "" {
+		args = append(args, "--merge-output-format", s.Merge)
+	}
+	return args
+}
+
+// Raw 表达式不是格式表达式，原样作为 yt-dlp 格式选择器
+func (s *FormatSpec) Raw() bool {
+	return s.raw
+}
+
+// Choose 供原生下载器从 VideoInfo.Formats 中选择格式，原生下载器不合并音视频，因此只选择同时包含音视频的格式；
+// 编码、分辨率、大小未知的格式不受限制。没有格式符合要求时返回错误，
+// 表达式没有任何限制或者是不匹配任何 format_id 的 yt-dlp 格式选择器时返回 nil，由下载器使用默认的地址
+func (s *FormatSpec) Choose(formats []VideoFormat) (*VideoFormat, error) {
+	if s.Expr == "" || len(formats) == 0 || !s.raw && s.Selector == "" && len(s.Sort) == 0 {
+		return nil, nil
+	}
+	if s.raw {
+		for i := range formats {
+			if formats[i].FormatID == s.Expr {
+				return &formats[i], nil
+			}
+		}
+		return nil, nil
+	}
+
+	var candidates []int
+	for i := range formats {
+		if s.matches(&formats[i]) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("没有符合格式 %q 的视频格式", s.Expr)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return s.better(&formats[candidates[i]], &formats[candidates[j]])
+	})
+	return &formats[candidates[0]], nil
+}
+
+// matches 判断格式是否满足表达式的限制
+func (s *FormatSpec) matches(f *VideoFormat) bool {
+	audioOnly, videoOnly := isAudioOnly(f), f.ACodec == "none"
+	switch s.kind {
+	case kindAudio:
+		if videoOnly {
+			return false
+		}
+	case kindVideo:
+		if audioOnly {
+			return false
+		}
+	default:
+		if audioOnly || videoOnly {
+			return false
+		}
+	}
+
+	if !audioOnly {
+		if h := formatHeight(f); h > 0 && (s.minHeight > 0 && h < s.minHeight || s.maxHeight > 0 && h > s.maxHeight) {
+			return false
+		}
+		if s.vcodec != nil && !codecMatches(s.vcodec, f.VCodec) {
+			return false
+		}
+	}
+	if s.acodec != nil && !codecMatches(s.acodec, f.ACodec) {
+		return false
+	}
+	if s.container != nil && f.Extension != "" {
+		ext := s.container.video
+		if audioOnly {
+			ext = s.container.audio
+		}
+		if !strings.EqualFold(f.Extension, ext) {
+			return false
+		}
+	}
+	return s.maxSize <= 0 || f.Filesize <= 0 || f.Filesize <= s.maxSize
+}
+
+// better 按 prefer 的顺序比较两个格式，其次是只下载音频时的纯音频格式，最后按分辨率和大小比较质量
+func (s *FormatSpec) better(a, b *VideoFormat) bool {
+	for _, p := range s.prefer {
+		var sa, sb int
+		switch {
+		case p.codec != nil && p.codec.video:
+			sa, sb = boolScore(p.codec.re.MatchString(a.VCodec)), boolScore(p.codec.re.MatchString(b.VCodec))
+		case p.codec != nil:
+			sa, sb = boolScore(p.codec.re.MatchString(a.ACodec)), boolScore(p.codec.re.MatchString(b.ACodec))
+		case p.container != nil:
+			sa, sb = boolScore(strings.EqualFold(a.Extension, p.container.video)), boolScore(strings.EqualFold(b.Extension, p.container.video))
+		default:
+			sa, sb = heightScore(formatHeight(a), p.height), heightScore(formatHeight(b), p.height)
+		}
+		if sa != sb {
+			return sa > sb
+		}
+	}
+
+	if s.kind == kindAudio {
+		if oa, ob := isAudioOnly(a), isAudioOnly(b); oa != ob {
+			return oa
+		}
+	}
+	qa, qb := int64(formatHeight(a)), int64(formatHeight(b))
+	if qa == qb {
+		qa, qb = a.Filesize, b.Filesize
+	}
+	if s.worst {
+		return qa < qb
+	}
+	return qa > qb
+}
+
+// codecMatches 编码未知时视为匹配
+func codecMatches(codec *formatCodec, value string) bool {
+	return value == "" || codec.re.MatchString(value)
+}
+
+func boolScore(ok bool) int {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// heightScore 与 yt-dlp 的 res:N 相同：不超过 N 时越高越好，超过 N 时越接近越好，未知的分辨率排在最后
+func heightScore(height, target int) int {
+	switch {
+	case height <= 0:
+		return math.MinInt32
+	case height <= target:
+		return height
+	default:
+		return -height
+	}
+}
+
+// isAudioOnly 判断是否为纯音频格式
+func isAudioOnly(f *VideoFormat) bool {
+	return f.VCodec == "none" || f.Resolution == "audio only"
+}
+
+// formatHeight 从 "1920x1080" 或 "720p" 形式的分辨率中取出高度，未知时返回 0
+func formatHeight(f *VideoFormat) int {
+	res := strings.ToLower(f.Resolution)
+	if i := strings.LastIndex(res, "x"); i >= 0 {
+		res = res[i+1:]
+	} else {
+		res = strings.TrimSuffix(res, "p")
+	}
+	height, _ := strconv.Atoi(res)
+	return height
+}
+
+// parseHeight 解析 720p、4k 等分辨率
+func parseHeight(value string) (int, bool) {
+	switch value {
+	case "2k":
+		return 1440, true
+	case "4k":
+		return 2160, true
+	case "8k":
+		return 4320, true
+	}
+	if !strings.HasSuffix(value, "p") {
+		return 0, false
+	}
+	height, err := strconv.Atoi(strings.TrimSuffix(value, "p"))
+	return height, err == nil && height > 0
+}
+
+// parseSize 解析 500MB、1.5GiB 等大小，单位与 yt-dlp 相同：MB 为 1000 进制，MiB 为 1024 进制
+func parseSize(value string) (int64, error) {
+	m := sizeRegex.FindStringSubmatch(value)
+	if m == nil {
+		return 0, fmt.Errorf("无法识别的大小: %s", value)
+	}
+	number, _ := strconv.ParseFloat(m[1], 64)
+	unit := strings.TrimSuffix(m[2], "b")
+	base := 1000.0
+	if strings.HasSuffix(unit, "i") {
+		base, unit = 1024, strings.TrimSuffix(unit, "i")
+	}
+	if unit != "" {
+		number *= math.Pow(base, float64(strings.Index("kmgt", unit)+1))
+	}
+	if number < 1 {
+		return 0, fmt.Errorf("无法识别的大小: %s", value)
+	}
+	return int64(number), nil
+}
+
+func isNumber(value string) bool {
+	_, err := strconv.ParseFloat(value, 64)
+	return err == nil
+}
@@ -0,0 +1,160 @@
+package downloader
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	const (
+		h264 = "[vcodec~='^(avc|h264)']"
+		aac  = "[acodec~='^(mp4a|aac)']"
+		size = "[filesize<=?500000000][filesize_approx<=?500000000]"
+	)
+
+	tests := []struct {
+		expr    string
+		want    []string
+		raw     bool
+		wantErr string
+	}{
+		{expr: "", want: nil},
+		{expr: "best", want: nil},
+		{expr: "1080p", want: []string{"-S", "res:1080"}},
+		{expr: "4k", want: []string{"-S", "res:2160"}},
+		{expr: "<=720p", want: []string{"-f", "bv*[height<=720]+ba/b[height<=720]"}},
+		{expr: ">720p", want: []string{"-f", "bv*[height>=721]+ba/b[height>=721]"}},
+		{expr: "=1080p", want: []string{"-f", "bv*[height>=1080][height<=1080]+ba/b[height>=1080][height<=1080]"}},
+		{expr: "min 720p, max 1080p", want: []string{"-f", "bv*[height>=720][height<=1080]+ba/b[height>=720][height<=1080]"}},
+		{expr: "worst", want: []string{"-f", "wv*+wa/w"}},
+		{expr: "best-audio", want: []string{"-f", "ba/b"}},
+		{expr: "worst-audio opus", want: []string{"-f", "wa[acodec~='^opus']/w[acodec~='^opus']"}},
+		{expr: "best-video h264", want: []string{"-f", "bv" + h264}},
+		{expr: "h264+aac", want: []string{"-f", "bv*" + h264 + "+ba" + aac + "/b" + h264 + aac}},
+		{
+			expr: "compat",
+			want: []string{"-f", "bv*" + h264 + "[ext=mp4]+ba" + aac + "[ext=m4a]/b" + h264 + aac + "[ext=mp4]", "--merge-output-format", "mp4"},
+		},
+		{expr: "max 500MB", want: []string{"-f", "bv*" + size + "+ba" + size + "/b" + size}},
+		{expr: "max 500 MB", want: []string{"-f", "bv*" + size + "+ba" + size + "/b" + size}},
+		{expr: "prefer av1 prefer webm", want: []string{"-S", "vcodec:av01,ext:webm:webm"}},
+		{expr: "1080p prefer h265", want: []string{"-S", "res:1080,vcodec:h265"}},
+		{expr: "bestvideo+bestaudio/best", want: []string{"-f", "bestvideo+bestaudio/best"}, raw: true},
+		{expr: "137+140", want: []string{"-f", "137+140"}, raw: true},
+		{expr: "1080p foo", wantErr: "无法识别的格式"},
+		{expr: "h264 h265", wantErr: "编码冲突"},
+		{expr: "mp4 webm", wantErr: "容器冲突"},
+		{expr: "best-audio best-video", wantErr: "格式冲突"},
+		{expr: ">=1080p <=720p", wantErr: "分辨率范围为空"},
+		{expr: "prefer", wantErr: "缺少参数"},
+		{expr: "prefer foo", wantErr: "prefer 只支持"},
+		{expr: "min 500MB", wantErr: "min 只支持分辨率"},
+		{expr: "max 0.5b", wantErr: "无法识别的大小"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			spec, err := ParseFormat(tt.expr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("错误 = %v, 期望包含 %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFormat 失败: %v", err)
+			}
+			if got := spec.Args(); !slices.Equal(got, tt.want) {
+				t.Errorf("Args = %q, 期望 %q", got, tt.want)
+			}
+			if spec.Raw() != tt.raw {
+				t.Errorf("Raw = %v, 期望 %v", spec.Raw(), tt.raw)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"500", 500},
+		{"500b", 500},
+		{"1k", 1000},
+		{"1kb", 1000},
+		{"1kib", 1024},
+		{"1.5mb", 1500000},
+		{"2gib", 2 << 30},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, 期望 %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatSpecChoose(t *testing.T) {
+	formats := []VideoFormat{
+		{FormatID: "18", Extension: "mp4", Resolution: "640x360", VCodec: "avc1.42001E", ACodec: "mp4a.40.2", Filesize: 10_000_000},
+		{FormatID: "22", Extension: "mp4", Resolution: "1280x720", VCodec: "avc1.64001F", ACodec: "mp4a.40.2", Filesize: 50_000_000},
+		{FormatID: "43", Extension: "webm", Resolution: "1920x1080", VCodec: "vp9", ACodec: "opus", Filesize: 100_000_000},
+		{FormatID: "140", Extension: "m4a", Resolution: "audio only", VCodec: "none", ACodec: "mp4a.40.2", Filesize: 3_000_000},
+		{FormatID: "137", Extension: "mp4", Resolution: "1920x1080", VCodec: "avc1.640028", ACodec: "none"},
+	}
+
+	tests := []struct {
+		expr    string
+		want    string // 为空表示返回 nil，由下载器使用默认地址
+		wantErr bool
+	}{
+		{expr: ""},
+		{expr: "best"},
+		{expr: "<=720p", want: "22"},
+		{expr: "worst", want: "18"},
+		{expr: "1080p", want: "43"},
+		{expr: "480p", want: "18"},
+		{expr: "h264", want: "22"},
+		{expr: "webm", want: "43"},
+		{expr: "best-audio", want: "140"},
+		{expr: "max 20MB", want: "18"},
+		{expr: "prefer mp4", want: "22"},
+		{expr: ">=4k", wantErr: true},
+		{expr: "22", want: "22"},
+		{expr: "bestvideo+bestaudio/best"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			spec, err := ParseFormat(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseFormat 失败: %v", err)
+			}
+			got, err := spec.Choose(formats)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望返回错误, 选择了 %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Choose 失败: %v", err)
+			}
+			id := ""
+			if got != nil {
+				id = got.FormatID
+			}
+			if id != tt.want {
+				t.Errorf("选择了 %q, 期望 %q", id, tt.want)
+			}
+		})
+	}
+
+	// 没有可选的格式时不限制
+	spec, _ := ParseFormat("<=720p")
+	if got, err := spec.Choose(nil); got != nil || err != nil {
+		t.Errorf("没有格式时 Choose = %v, %v, 期望 nil", got, err)
+	}
+}
@@ -0,0 +1,318 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// httpDownloadRetries 连接中断后从断点重试的最大次数
+	httpDownloadRetries = 3
+	// httpBufferSize 每次读取的字节数
+	httpBufferSize = 32 * 1024
+)
+
+// httpStatusError 服务器返回了非预期的状态码
+type httpStatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("服务器返回错误状态码 %d: %s", e.StatusCode, e.URL)
+}
+
+// retryable 5xx 和 429 可以重试，其他状态码重试无意义
+func (e *httpStatusError) retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// errPartMismatch 已下载的部分与服务器上的文件不一致，需要重新下载
+var errPartMismatch = errors.New("断点文件与服务器文件不一致，重新下载")
+
+// contentRangeRegex 解析 Content-Range: bytes 100-199/1000
+var contentRangeRegex = regexp.MustCompile(`^bytes\s+(\d+)-\d+/(\d+|\*)$`)
+
+// httpDownload 使用HTTP下载文件到dest，返回文件大小
+// 数据先写入 dest.part，完成后再重命名；已存在的 .part 文件会通过 Range 请求继续下载，
+// 连接中断时会自动从断点重试
+func httpDownload(ctx context.Context, client *http.Client, rawURL string, headers map[string]string, dest string, callback ProgressCallback) (int64, error) {
+	partFile := dest + ".part"
+	throttle := &progressThrottle{}
+
+	var lastErr error
+	for attempt := 0; attempt <= httpDownloadRetries; attempt++ {
+		if attempt > 0 {
+			logrus.Warnf("下载中断，将从断点继续 (第%d次重试): %v", attempt, lastErr)
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+
+		size, err := httpDownloadOnce(ctx, client, rawURL, headers, partFile, throttle, callback)
+		if err == nil {
+			os.Remove(partFile + ".total")
+			if err := os.Rename(partFile, dest); err != nil {
+				return 0, fmt.Errorf("重命名下载文件失败: %w", err)
+			}
+			return size, nil
+		}
+
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && !statusErr.retryable() {
+			return 0, err
+		}
+		lastErr = err
+	}
+
+	return 0, fmt.Errorf("下载失败，已重试%d次: %w", httpDownloadRetries, lastErr)
+}
+
+// httpDownloadOnce 发起一次下载请求，并从 partFile 的当前大小处继续写入
+func httpDownloadOnce(ctx context.Context, client *http.Client, rawURL string, headers map[string]string, partFile string, throttle *progressThrottle, callback ProgressCallback) (int64, error) {
+	var offset int64
+	if info, err := os.Stat(partFile); err == nil {
+		offset = info.Size()
+	}
+	expectedTotal := readPartTotal(partFile)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("创建请求失败: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	total := int64(-1)
+	flags := os.O_CREATE | os.O_WRONLY
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, rangeTotal, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset || (expectedTotal > 0 && rangeTotal > 0 && rangeTotal != expectedTotal) {
+			removePartFile(partFile)
+			return 0, errPartMismatch
+		}
+		flags |= os.O_APPEND
+		total = rangeTotal
+	case http.StatusOK:
+		// 服务器不支持Range或者是新的下载，从头开始
+		if offset > 0 {
+			logrus.Infof("服务器不支持断点续传，重新下载: %s", rawURL)
+		}
+		flags |= os.O_TRUNC
+		offset = 0
+		if resp.ContentLength > 0 {
+			total = resp.ContentLength
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// .part 文件可能已经完整
+		if _, rangeTotal, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && rangeTotal == offset {
+			return offset, nil
+		}
+		removePartFile(partFile)
+		return 0, errPartMismatch
+	default:
+		return 0, &httpStatusError{StatusCode: resp.StatusCode, URL: rawURL}
+	}
+
+	if total > 0 {
+		writePartTotal(partFile, total)
+	}
+
+	file, err := os.OpenFile(partFile, flags, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("创建文件失败: %w", err)
+	}
+	defer file.Close()
+
+	downloaded := offset
+	startTime := time.Now()
+	buf := make([]byte, httpBufferSize)
+
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := file.Write(buf[:n]); err != nil {
+				return downloaded, fmt.Errorf("写入文件失败: %w", err)
+			}
+			downloaded += int64(n)
+
+			if callback != nil {
+				progress := buildHTTPProgress(downloaded, total, downloaded-offset, time.Since(startTime))
+				if throttle.allow(progress.Progress) {
+					callback(progress)
+				}
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return downloaded, fmt.Errorf("读取响应失败: %w", readErr)
+		}
+	}
+
+	if total > 0 && downloaded < total {
+		return downloaded, io.ErrUnexpectedEOF
+	}
+
+	if callback != nil {
+		progress := buildHTTPProgress(downloaded, downloaded, downloaded-offset, time.Since(startTime))
+		progress.Progress = 100
+		progress.StageProgress = 100
+		callback(progress)
+	}
+
+	return downloaded, nil
+}
+
+// buildHTTPProgress 根据已下载字节数计算进度、速度和剩余时间
+func buildHTTPProgress(downloaded, total, transferred int64, elapsed time.Duration) *DownloadResponse {
+	progress := &DownloadResponse{
+		Status:          StatusDownloading,
+		BytesDownloaded: downloaded,
+		Stage:           StageDownloadingVideo,
+		Metadata: map[string]string{
+			"downloaded": formatBytes(downloaded),
+		},
+		Updated: time.Now(),
+	}
+
+	var speed float64
+	if elapsed > 0 {
+		speed = float64(transferred) / elapsed.Seconds()
+		progress.Speed = formatBytes(int64(speed)) + "/s"
+		progress.SpeedBps = speed
+	}
+
+	if total > 0 {
+		progress.Progress = float64(downloaded) / float64(total) * 100
+		progress.StageProgress = progress.Progress
+		progress.BytesTotal = total
+		progress.Metadata["total_size"] = formatBytes(total)
+		if speed > 0 {
+			remaining := time.Duration(float64(total-downloaded)/speed) * time.Second
+			progress.ETA = formatETA(remaining)
+			progress.ETASeconds = int64(remaining.Seconds())
+		}
+	}
+
+	return progress
+}
+
+// parseContentRange 解析 Content-Range 头，返回起始位置和文件总大小（未知时为-1）
+func parseContentRange(header string) (int64, int64, bool) {
+	matches := contentRangeRegex.FindStringSubmatch(strings.TrimSpace(header))
+	if len(matches) < 3 {
+		// 416 响应的格式为 bytes */1000
+		if strings.HasPrefix(header, "bytes */") {
+			total, err := strconv.ParseInt(strings.TrimPrefix(header, "bytes */"), 10, 64)
+			return 0, total, err == nil
+		}
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	total := int64(-1)
+	if matches[2] != "*" {
+		total, _ = strconv.ParseInt(matches[2], 10, 64)
+	}
+	return start, total, true
+}
+
+// readPartTotal 读取断点文件对应的文件总大小，用于判断续传的是否为同一个文件
+func readPartTotal(partFile string) int64 {
+	data, err := os.ReadFile(partFile + ".total")
+	if err != nil {
+		return 0
+	}
+	total, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return total
+}
+
+// writePartTotal 记录断点文件对应的文件总大小
+func writePartTotal(partFile string, total int64) {
+	if err := os.WriteFile(partFile+".total", []byte(strconv.FormatInt(total, 10)), 0644); err != nil {
+		logrus.Debugf("记录文件大小失败: %v", err)
+	}
+}
+
+// removePartFile 删除断点文件及其记录
+func removePartFile(partFile string) {
+	os.Remove(partFile)
+	os.Remove(partFile + ".total")
+}
+
+// formatBytes 将字节数格式化为与yt-dlp一致的显示形式，如 1.50MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatETA 将剩余时间格式化为 mm:ss 或 hh:mm:ss
+func formatETA(d time.Duration) string {
+	seconds := int(d.Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+	}
+	return fmt.Sprintf("%02d:%02d", seconds/60, seconds%60)
+}
+
+// progressThrottle 控制进度回调频率，规则与服务层处理yt-dlp进度时一致：
+// 首次更新、距离上次至少1秒、进度变化超过1%或进度达到100%时才回调
+type progressThrottle struct {
+	lastTime     time.Time
+	lastProgress float64
+}
+
+// allow 判断本次进度是否需要回调
+func (p *progressThrottle) allow(progress float64) bool {
+	now := time.Now()
+	if p.lastTime.IsZero() ||
+		now.Sub(p.lastTime) >= time.Second ||
+		progress-p.lastProgress >= 1.0 ||
+		progress >= 100.0 {
+		p.lastTime = now
+		p.lastProgress = progress
+		return true
+	}
+	return false
+}
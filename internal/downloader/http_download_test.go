@@ -0,0 +1,49 @@
+package downloader
+
+import "testing"
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header    string
+		wantStart int64
+		wantTotal int64
+		wantOK    bool
+	}{
+		{"bytes 100-199/1000", 100, 1000, true},
+		{"bytes 0-0/1", 0, 1, true},
+		{" bytes 500-999/* ", 500, -1, true},
+		{"bytes */1000", 0, 1000, true},
+		{"bytes */abc", 0, 0, false},
+		{"bytes 100-199", 0, 0, false},
+		{"items 0-1/2", 0, 0, false},
+		{"", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		start, total, ok := parseContentRange(tt.header)
+		if start != tt.wantStart || total != tt.wantTotal || ok != tt.wantOK {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, 期望 %d, %d, %v",
+				tt.header, start, total, ok, tt.wantStart, tt.wantTotal, tt.wantOK)
+		}
+	}
+}
+
+func TestHTTPStatusErrorRetryable(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{500, true},
+		{503, true},
+		{429, true},
+		{403, false},
+		{404, false},
+		{416, false},
+	}
+	for _, tt := range tests {
+		err := &httpStatusError{StatusCode: tt.status}
+		if got := err.retryable(); got != tt.want {
+			t.Errorf("状态码 %d retryable = %v, 期望 %v", tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// VideoKey 视频的规范标识，同一视频的不同链接（例如抖音短链接和完整链接）得到相同的标识
+type VideoKey struct {
+	Extractor string `json:"extractor"` // 提取器名称，小写，例如 youtube、bilibili、douyin
+	VideoID   string `json:"video_id"`  // 提取器内的视频ID
+	Format    string `json:"format"`    // 下载的格式
+}
+
+// String 返回 "<提取器>:<视频ID>:<格式>"，用于比较和保存
+func (k VideoKey) String() string {
+	return k.Extractor + ":" + k.VideoID + ":" + k.Format
+}
+
+// ArchiveID 返回 yt-dlp --download-archive 中的记录 "<提取器> <视频ID>"
+func (k VideoKey) ArchiveID() string {
+	return k.Extractor + " " + k.VideoID
+}
+
+// IdentityProvider 可以解析视频规范标识的下载提供者
+type IdentityProvider interface {
+	Provider
+	// Identify 返回链接对应的提取器和视频ID，不下载视频
+	Identify(ctx context.Context, url string) (extractor, videoID string, err error)
+}
+
+var (
+	_ IdentityProvider = (*YtdlpDownloader)(nil)
+	_ IdentityProvider = (*DouyinDownloader)(nil)
+)
+
+// ytdlpIdentity yt-dlp --dump-json 输出中用到的字段
+type ytdlpIdentity struct {
+	ID           string `json:"id"`
+	Extractor    string `json:"extractor"`
+	ExtractorKey string `json:"extractor_key"`
+}
+
+// Identify 使用 yt-dlp --dump-json 解析提取器和视频ID；抖音链接使用抖音下载器解析，
+// 保证短链接和完整链接得到相同的视频ID
+func (y *YtdlpDownloader) Identify(ctx context.Context, url string) (string, string, error) {
+	douyin := NewDouyinDownloader(y.config, y.proxies)
+	if douyin.CanHandle(url) {
+		return douyin.Identify(ctx, url)
+	}
+
+	if y.config.YtDlp.Path == "" {
+		return "", "", fmt.Errorf("yt-dlp 路径未配置，请检查 config.yaml 的 ytdlp.path")
+	}
+
+	args := []string{
+		"--dump-json",
+		"--no-playlist",
+		"--skip-download",
+		"--no-warnings",
+	}
+	args = append(args, resolveSite(y.config, url).ytdlpArgs(ctx, nil)...)
+	proxy := y.proxies.route(url)
+	args = append(args, proxy.ytdlpArgs()...)
+	args = append(args, url)
+
+	cmd := newCommand(ctx, y.config.YtDlp.Path, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	proxy.reportCommand(err, stderr.String())
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", "", fmt.Errorf("解析视频ID已取消: %w", ctx.Err())
+		}
+		return "", "", &ProcessError{
+			Command: y.config.YtDlp.Path,
+			Args:    args,
+			Stderr:  stderr.String(),
+			Err:     err,
+		}
+	}
+
+	// 只取第一行，避免链接意外展开为多个视频
+	line, _, _ := bytes.Cut(output, []byte("\n"))
+	var data ytdlpIdentity
+	if err := json.Unmarshal(line, &data); err != nil {
+		return "", "", fmt.Errorf("解析视频信息失败: %w", err)
+	}
+
+	extractor := data.ExtractorKey
+	if extractor == "" {
+		extractor = data.Extractor
+	}
+	if extractor == "" || data.ID == "" {
+		return "", "", errors.New("yt-dlp 没有返回提取器或视频ID")
+	}
+	logrus.Debugf("视频标识 %s: %s:%s", url, extractor, data.ID)
+	return strings.ToLower(extractor), data.ID, nil
+}
+
+// Identify 解析抖音视频的 aweme ID，短链接先跟随重定向得到完整链接
+func (d *DouyinDownloader) Identify(ctx context.Context, url string) (string, string, error) {
+	resolved, err := d.cleanURL(ctx, url)
+	if err != nil {
+		return "", "", fmt.Errorf("解析抖音链接失败: %w", err)
+	}
+	if matches := douyinVideoIDRegex.FindStringSubmatch(resolved); len(matches) > 1 {
+		return "douyin", matches[1], nil
+	}
+
+	// 链接中没有视频ID时从页面数据中读取
+	video, _, err := d.resolveVideo(ctx, url)
+	if err != nil {
+		return "", "", err
+	}
+	if video.AwemeID == "" {
+		return "", "", errors.New("无法从抖音页面中解析视频ID")
+	}
+	return "douyin", video.AwemeID, nil
+}
@@ -0,0 +1,202 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Playlist 播放列表、频道或多P视频的条目列表
+type Playlist struct {
+	ID       string          `json:"id"`
+	Title    string          `json:"title"`
+	Uploader string          `json:"uploader,omitempty"`
+	URL      string          `json:"url"`
+	Entries  []PlaylistEntry `json:"entries"`
+}
+
+// PlaylistEntry 播放列表中的一个条目
+type PlaylistEntry struct {
+	Index     int     `json:"index"` // 在播放列表中的序号，从1开始
+	ID        string  `json:"id"`
+	Extractor string  `json:"extractor,omitempty"` // 提取器名称，小写，与下载存档中的记录一致
+	Title     string  `json:"title"`
+	URL       string  `json:"url"`
+	Duration  float64 `json:"duration,omitempty"`
+}
+
+// PlaylistProvider 可以展开播放列表的下载提供者
+type PlaylistProvider interface {
+	Provider
+	// GetPlaylist 列出播放列表中的所有条目，不下载视频
+	GetPlaylist(ctx context.Context, url string) (*Playlist, error)
+}
+
+var _ PlaylistProvider = (*YtdlpDownloader)(nil)
+
+// ytdlpPlaylist yt-dlp --dump-single-json 输出中用到的字段
+type ytdlpPlaylist struct {
+	ID           string `json:"id"`
+	Type         string `json:"_type"`
+	ExtractorKey string `json:"extractor_key"`
+	Title        string `json:"title"`
+	Uploader     string `json:"uploader"`
+	WebpageURL   string `json:"webpage_url"`
+	Entries      []struct {
+		ID            string  `json:"id"`
+		IEKey         string  `json:"ie_key"`
+		Title         string  `json:"title"`
+		URL           string  `json:"url"`
+		WebpageURL    string  `json:"webpage_url"`
+		Duration      float64 `json:"duration"`
+		PlaylistIndex int     `json:"playlist_index"`
+	} `json:"entries"`
+}
+
+// GetPlaylist 使用 yt-dlp --flat-playlist 列出播放列表、频道或多P视频中的条目
+func (y *YtdlpDownloader) GetPlaylist(ctx context.Context, url string) (*Playlist, error) {
+	if y.config.YtDlp.Path == "" {
+		return nil, fmt.Errorf("yt-dlp 路径未配置，请检查 config.yaml 的 ytdlp.path")
+	}
+
+	args := []string{
+		"--flat-playlist",
+		"--dump-single-json",
+		"--yes-playlist",
+		"--no-warnings",
+	}
+	args = append(args, resolveSite(y.config, url).ytdlpArgs(ctx, nil)...)
+	proxy := y.proxies.route(url)
+	args = append(args, proxy.ytdlpArgs()...)
+	args = append(args, url)
+
+	cmd := newCommand(ctx, y.config.YtDlp.Path, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	logrus.Infof("获取播放列表: %s", url)
+	output, err := cmd.Output()
+	proxy.reportCommand(err, stderr.String())
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("获取播放列表已取消: %w", ctx.Err())
+		}
+		return nil, &ProcessError{
+			Command: y.config.YtDlp.Path,
+			Args:    args,
+			Stderr:  stderr.String(),
+			Err:     err,
+		}
+	}
+
+	var data ytdlpPlaylist
+	if err := json.Unmarshal(output, &data); err != nil {
+		return nil, fmt.Errorf("解析播放列表失败: %w", err)
+	}
+
+	playlist := &Playlist{
+		ID:       data.ID,
+		Title:    data.Title,
+		Uploader: data.Uploader,
+		URL:      data.WebpageURL,
+	}
+	if playlist.URL == "" {
+		playlist.URL = url
+	}
+
+	// 单个视频没有 entries，作为只有一个条目的列表返回
+	if data.Type != "playlist" && len(data.Entries) == 0 {
+		playlist.Entries = []PlaylistEntry{{
+			Index:     1,
+			ID:        data.ID,
+			Extractor: strings.ToLower(data.ExtractorKey),
+			Title:     data.Title,
+			URL:       playlist.URL,
+		}}
+		return playlist, nil
+	}
+
+	for i, entry := range data.Entries {
+		entryURL := entry.URL
+		if entry.WebpageURL != "" {
+			entryURL = entry.WebpageURL
+		}
+		if entryURL == "" {
+			logrus.Warnf("跳过没有地址的播放列表条目: %s", entry.ID)
+			continue
+		}
+
+		index := entry.PlaylistIndex
+		if index <= 0 {
+			index = i + 1
+		}
+		playlist.Entries = append(playlist.Entries, PlaylistEntry{
+			Index:     index,
+			ID:        entry.ID,
+			Extractor: strings.ToLower(entry.IEKey),
+			Title:     entry.Title,
+			URL:       entryURL,
+			Duration:  entry.Duration,
+		})
+	}
+
+	return playlist, nil
+}
+
+// ParsePlaylistItems 解析条目范围，格式与 yt-dlp --playlist-items 相同，例如 "1-3,7,10-"
+// 返回判断序号是否被选中的函数，空字符串表示全部条目
+func ParsePlaylistItems(spec string) (func(index int) bool, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return func(int) bool { return true }, nil
+	}
+
+	type itemRange struct{ start, end int }
+	var ranges []itemRange
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		start, end, isRange := strings.Cut(part, "-")
+		r := itemRange{start: 1, end: math.MaxInt}
+		if start != "" {
+			n, err := strconv.Atoi(strings.TrimSpace(start))
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("无效的条目范围: %s", part)
+			}
+			r.start = n
+		}
+		if !isRange {
+			r.end = r.start
+		} else if end != "" {
+			n, err := strconv.Atoi(strings.TrimSpace(end))
+			if err != nil || n < r.start {
+				return nil, fmt.Errorf("无效的条目范围: %s", part)
+			}
+			r.end = n
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("无效的条目范围: %s", spec)
+	}
+
+	return func(index int) bool {
+		for _, r := range ranges {
+			if index >= r.start && index <= r.end {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
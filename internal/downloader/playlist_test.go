@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParsePlaylistItems(t *testing.T) {
+	tests := []struct {
+		spec     string
+		selected []int
+		skipped  []int
+		wantErr  bool
+	}{
+		{spec: "", selected: []int{1, 2, 100}},
+		{spec: "  ", selected: []int{1, 2, 100}},
+		{spec: "3", selected: []int{3}, skipped: []int{1, 2, 4}},
+		{spec: "1-3,7", selected: []int{1, 2, 3, 7}, skipped: []int{4, 6, 8}},
+		{spec: "10-", selected: []int{10, 11, math.MaxInt}, skipped: []int{1, 9}},
+		{spec: "-2", selected: []int{1, 2}, skipped: []int{3}},
+		{spec: " 2 - 4 , ,6", selected: []int{2, 3, 4, 6}, skipped: []int{1, 5, 7}},
+		{spec: "5-5", selected: []int{5}, skipped: []int{4, 6}},
+		{spec: "0", wantErr: true},
+		{spec: "a-3", wantErr: true},
+		{spec: "3-1", wantErr: true},
+		{spec: "1-b", wantErr: true},
+		{spec: ",", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			match, err := ParsePlaylistItems(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("期望返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePlaylistItems 失败: %v", err)
+			}
+			for _, index := range tt.selected {
+				if !match(index) {
+					t.Errorf("第 %d 个条目应被选中", index)
+				}
+			}
+			for _, index := range tt.skipped {
+				if match(index) {
+					t.Errorf("第 %d 个条目不应被选中", index)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// processWaitDelay 子进程被结束后等待输出管道关闭的最长时间
+const processWaitDelay = 5 * time.Second
+
+// partialFileRegex 匹配下载过程中产生的中间文件（如 xxx.f137.mp4、xxx.mp4.part、xxx.mp4.part.total、xxx.ytdl、xxx.mp4.aria2）
+var partialFileRegex = regexp.MustCompile(`(\.part(-Frag\d+|\.total)?|\.ytdl|\.temp|\.aria2|\.f\d+\.[^.]+)$`)
+
+// newCommand 创建绑定上下文的子进程命令，上下文取消时结束整个进程组
+func newCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = processWaitDelay
+	return cmd
+}
+
+// CleanupPartialFiles 删除任务在目录中留下的未完成文件，返回被删除的文件列表
+func CleanupPartialFiles(dir, taskID string) []string {
+	if dir == "" || taskID == "" {
+		return nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, taskID+"_*"))
+	if err != nil {
+		return nil
+	}
+
+	var removed []string
+	for _, match := range matches {
+		if !partialFileRegex.MatchString(filepath.Base(match)) && !strings.HasSuffix(match, "_merged.mp4") {
+			continue
+		}
+		if err := os.Remove(match); err != nil {
+			logrus.Warnf("删除未完成文件失败 [%s]: %v", match, err)
+			continue
+		}
+		removed = append(removed, match)
+	}
+
+	return removed
+}
@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCleanupPartialFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"task_video.mp4",
+		"task_video.f137.mp4",
+		"task_video.f140.m4a",
+		"task_video.mp4.part",
+		"task_video.mp4.part-Frag12",
+		"task_video.mp4.part.total",
+		"task_video.mp4.ytdl",
+		"task_video.temp",
+		"task_video.mp4.aria2",
+		"task_video_merged.mp4",
+		"task_video.info.json",
+		"other_video.mp4.part",
+		"task.mp4.part",
+	}
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed := CleanupPartialFiles(dir, "task")
+	for i, path := range removed {
+		removed[i] = filepath.Base(path)
+	}
+	slices.Sort(removed)
+	want := []string{
+		"task_video.f137.mp4",
+		"task_video.f140.m4a",
+		"task_video.mp4.aria2",
+		"task_video.mp4.part",
+		"task_video.mp4.part-Frag12",
+		"task_video.mp4.part.total",
+		"task_video.mp4.ytdl",
+		"task_video.temp",
+		"task_video_merged.mp4",
+	}
+	if !slices.Equal(removed, want) {
+		t.Errorf("删除了 %q, 期望 %q", removed, want)
+	}
+	for _, name := range []string{"task_video.mp4", "task_video.info.json", "other_video.mp4.part", "task.mp4.part"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s 不应被删除: %v", name, err)
+		}
+	}
+
+	if got := CleanupPartialFiles(dir, ""); got != nil {
+		t.Errorf("没有任务ID时删除了 %q", got)
+	}
+}
+
+func TestTaskTracker(t *testing.T) {
+	tracker := newTaskTracker()
+
+	ctx, resp := tracker.start(context.Background(), "a", "标题")
+	if resp.Status != StatusDownloading || resp.Title != "标题" {
+		t.Fatalf("start 返回 %+v", resp)
+	}
+	tracker.update("a", &DownloadResponse{Progress: 42, Speed: "1MiB/s", ETA: "00:10"})
+	got, err := tracker.get("a")
+	if err != nil || got.Progress != 42 || got.Speed != "1MiB/s" || got.ETA != "00:10" {
+		t.Errorf("get = %+v, %v", got, err)
+	}
+
+	// 返回的是副本
+	got.Progress = 0
+	if again, _ := tracker.get("a"); again.Progress != 42 {
+		t.Error("修改 get 的结果不应影响记录的任务")
+	}
+
+	if err := tracker.cancel("a"); err != nil {
+		t.Fatalf("cancel 失败: %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Error("cancel 后上下文应被取消")
+	}
+	if got, _ := tracker.get("a"); got.Status != StatusCancelled {
+		t.Errorf("cancel 后状态 = %q, 期望 %q", got.Status, StatusCancelled)
+	}
+
+	tracker.finish("a")
+	if _, err := tracker.get("a"); err == nil {
+		t.Error("finish 后任务应被移除")
+	}
+	if err := tracker.cancel("a"); err == nil {
+		t.Error("取消不存在的任务应返回错误")
+	}
+
+	// 没有任务ID的下载不记录
+	ctx, _ = tracker.start(context.Background(), "", "")
+	if len(tracker.tasks) != 0 || ctx.Done() != nil {
+		t.Error("没有任务ID的下载不应被记录")
+	}
+}
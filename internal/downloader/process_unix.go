@@ -0,0 +1,19 @@
+//go:build !windows
+
+package downloader
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup 让子进程运行在独立的进程组中，取消时连同ffmpeg等子进程一起结束
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		if cmd.Process == nil {
+			return nil
+		}
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+
+package downloader
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestNewCommandKillsProcessGroup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("没有 sh")
+	}
+
+	// sleep 继承了输出管道，只结束 sh 时 Wait 要等到 processWaitDelay 后才返回
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := newCommand(ctx, "sh", "-c", "sleep 30 & wait")
+	done := make(chan error, 1)
+	go func() {
+		_, err := cmd.Output()
+		done <- err
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	cancel()
+
+	select {
+	case err := <-done:
+		if errors.Is(err, exec.ErrWaitDelay) {
+			t.Errorf("子进程没有被结束: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed >= processWaitDelay {
+			t.Errorf("取消后 %v 才结束", elapsed)
+		}
+	case <-time.After(2 * processWaitDelay):
+		t.Fatal("取消后命令没有结束")
+	}
+}
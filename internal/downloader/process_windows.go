@@ -0,0 +1,20 @@
+package downloader
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup 取消时使用taskkill结束整个进程树
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		if cmd.Process == nil {
+			return nil
+		}
+		kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid))
+		if err := kill.Run(); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,246 @@
+package downloader
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// yt-dlp 进度模板输出的行前缀，用于从详细输出中区分进度行
+const (
+	downloadProgressMarker    = "[video-hunter:download]"
+	postprocessProgressMarker = "[video-hunter:postprocess]"
+)
+
+// formatSelectionRegex 匹配 yt-dlp 选定格式的输出，例如 [info] BV1xx: Downloading 1 format(s): 30080+30280
+var formatSelectionRegex = regexp.MustCompile(`Downloading \d+ format\(s\): (\S+)`)
+
+// ytdlpProgressArgs 让 yt-dlp 以 JSON 格式逐行输出下载和后处理进度，
+// 同时输出正在下载的格式的编码，用于区分视频和音频阶段
+func ytdlpProgressArgs() []string {
+	return []string{
+		"--newline",
+		"--progress-template", "download:" + downloadProgressMarker + `{"vcodec":%(info.vcodec)j,"acodec":%(info.acodec)j,"progress":%(progress)j}`,
+		"--progress-template", "postprocess:" + postprocessProgressMarker + "%(progress)j",
+	}
+}
+
+// ytdlpProgressLine download 进度模板输出的一行
+type ytdlpProgressLine struct {
+	VCodec   string        `json:"vcodec"`
+	ACodec   string        `json:"acodec"`
+	Progress ytdlpProgress `json:"progress"`
+}
+
+// audioOnly 判断正在下载的是否为单独的音频，yt-dlp 对未知字段输出 "NA"
+func (l *ytdlpProgressLine) audioOnly() bool {
+	return l.VCodec == "none" && l.ACodec != "" && l.ACodec != "none" && l.ACodec != "NA"
+}
+
+// ytdlpProgress yt-dlp 进度字典中用到的字段，未知的数值为 null
+type ytdlpProgress struct {
+	Status             string  `json:"status"`
+	DownloadedBytes    float64 `json:"downloaded_bytes"`
+	TotalBytes         float64 `json:"total_bytes"`
+	TotalBytesEstimate float64 `json:"total_bytes_estimate"`
+	Speed              float64 `json:"speed"`
+	ETA                float64 `json:"eta"`
+	FragmentIndex      int     `json:"fragment_index"`
+	FragmentCount      int     `json:"fragment_count"`
+	Filename           string  `json:"filename"`
+	Postprocessor      string  `json:"postprocessor"`
+}
+
+// parseProgressLine 解析一行 yt-dlp 进度模板输出的进度，不是进度行时返回 false；
+// 返回的 Progress 为当前阶段的进度，由 stageTracker 换算为总进度
+func parseProgressLine(line string) (*DownloadResponse, bool) {
+	if i := strings.Index(line, downloadProgressMarker); i >= 0 {
+		return parseDownloadProgress(line[i+len(downloadProgressMarker):])
+	}
+	if i := strings.Index(line, postprocessProgressMarker); i >= 0 {
+		return parsePostprocessProgress(line[i+len(postprocessProgressMarker):])
+	}
+	return nil, false
+}
+
+// parseDownloadProgress 将 download 进度模板的 JSON 转换为下载进度
+func parseDownloadProgress(data string) (*DownloadResponse, bool) {
+	var l ytdlpProgressLine
+	if err := json.Unmarshal([]byte(data), &l); err != nil {
+		return nil, false
+	}
+	p := l.Progress
+
+	total := p.TotalBytes
+	if total <= 0 {
+		total = p.TotalBytesEstimate
+	}
+
+	resp := &DownloadResponse{
+		Status:          StatusDownloading,
+		BytesDownloaded: int64(p.DownloadedBytes),
+		BytesTotal:      int64(total),
+		SpeedBps:        p.Speed,
+		ETASeconds:      int64(p.ETA),
+		Fragment:        p.FragmentIndex,
+		FragmentCount:   p.FragmentCount,
+		Stage:           StageDownloadingVideo,
+		Updated:         time.Now(),
+	}
+	if l.audioOnly() {
+		resp.Stage = StageDownloadingAudio
+	}
+
+	switch {
+	case p.Status == "finished":
+		resp.Progress = 100
+	case total > 0:
+		resp.Progress = p.DownloadedBytes / total * 100
+	case p.FragmentCount > 0:
+		// HLS/DASH 分片下载可能不知道总大小，按分片计算
+		resp.Progress = float64(p.FragmentIndex) / float64(p.FragmentCount) * 100
+	}
+	if resp.Progress > 100 {
+		resp.Progress = 100
+	}
+
+	// 保留展示用的字符串字段
+	if p.Speed > 0 {
+		resp.Speed = formatBytes(int64(p.Speed)) + "/s"
+	}
+	if p.ETA > 0 {
+		resp.ETA = formatETA(time.Duration(p.ETA) * time.Second)
+	}
+	return resp, true
+}
+
+// parsePostprocessProgress 将 postprocess 进度模板的 JSON 转换为后处理阶段的进度，
+// Merger 为合并音视频，其余后处理器归为 post_processing
+func parsePostprocessProgress(data string) (*DownloadResponse, bool) {
+	var p ytdlpProgress
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		return nil, false
+	}
+
+	resp := &DownloadResponse{
+		Status:        StatusDownloading,
+		Postprocessor: p.Postprocessor,
+		Stage:         StagePostProcessing,
+		Updated:       time.Now(),
+	}
+	if p.Postprocessor == "Merger" {
+		resp.Stage = StageMerging
+	}
+	if p.Status == "finished" {
+		resp.Progress = 100
+	}
+	return resp, true
+}
+
+// stageSpan 返回阶段在总进度中所占的区间：分别下载音视频时视频占 70%、音频占 20%、
+// 合并占 8%、其他后处理占 2%；只下载一个文件时下载占 95%，后处理占 5%
+func stageSpan(stage DownloadStage, separateAudio bool) (from, to float64) {
+	switch stage {
+	case StageResolving:
+		return 0, 0
+	case StageDownloadingVideo:
+		if separateAudio {
+			return 0, 70
+		}
+		return 0, 95
+	case StageDownloadingAudio:
+		if separateAudio {
+			return 70, 90
+		}
+		return 0, 95
+	case StageMerging:
+		if separateAudio {
+			return 90, 98
+		}
+		return 95, 100
+	case StagePostProcessing:
+		if separateAudio {
+			return 98, 100
+		}
+		return 95, 100
+	}
+	return 0, 100
+}
+
+// stageTracker 记录一次下载所处的阶段，将各阶段的进度换算为加权后的总进度，
+// 避免分别下载音视频时进度在阶段之间回到 0
+type stageTracker struct {
+	mu            sync.Mutex
+	callback      ProgressCallback
+	aria2         bool // yt-dlp 使用外部下载器 aria2c，下载进度来自 aria2c 的控制台输出
+	separateAudio bool
+	stage         DownloadStage
+	overall       float64
+}
+
+// newStageTracker 创建阶段跟踪器，callback 为 nil 时只记录阶段
+func newStageTracker(callback ProgressCallback) *stageTracker {
+	return &stageTracker{callback: callback}
+}
+
+// useAria2 yt-dlp 使用外部下载器 aria2c，下载阶段不输出进度模板，改为解析 aria2c 的输出；
+// 需要在开始读取输出之前调用
+func (t *stageTracker) useAria2() {
+	t.aria2 = true
+}
+
+// enter 进入新的阶段并立即报告一次进度
+func (t *stageTracker) enter(stage DownloadStage) {
+	t.report(&DownloadResponse{
+		Status:  StatusDownloading,
+		Stage:   stage,
+		Updated: time.Now(),
+	})
+}
+
+// handleLine 处理一行 yt-dlp 输出，识别格式选择和进度行
+func (t *stageTracker) handleLine(line string) {
+	if matches := formatSelectionRegex.FindStringSubmatch(line); len(matches) > 1 {
+		t.mu.Lock()
+		t.separateAudio = strings.Contains(matches[1], "+")
+		t.mu.Unlock()
+		return
+	}
+	if progress, ok := parseProgressLine(line); ok {
+		t.report(progress)
+		return
+	}
+	if t.aria2 {
+		if progress, ok := parseAria2Progress(line); ok {
+			t.report(progress)
+		}
+	}
+}
+
+// report 将阶段进度换算为总进度后调用回调，总进度只增不减
+func (t *stageTracker) report(progress *DownloadResponse) {
+	t.mu.Lock()
+	if progress.Stage == "" {
+		progress.Stage = StageDownloadingVideo
+	}
+	// 没有看到格式选择输出时，根据是否出现音频阶段判断
+	if progress.Stage == StageDownloadingAudio && t.stage == StageDownloadingVideo {
+		t.separateAudio = true
+	}
+	t.stage = progress.Stage
+
+	from, to := stageSpan(progress.Stage, t.separateAudio)
+	progress.StageProgress = progress.Progress
+	overall := from + (to-from)*progress.Progress/100
+	if overall > t.overall {
+		t.overall = overall
+	}
+	progress.Progress = t.overall
+	t.mu.Unlock()
+
+	if t.callback != nil {
+		t.callback(progress)
+	}
+}
@@ -0,0 +1,214 @@
+package downloader
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestParseProgressLine(t *testing.T) {
+	tests := []struct {
+		name          string
+		line          string
+		wantOK        bool
+		wantStage     DownloadStage
+		wantProgress  float64
+		wantBytes     int64
+		wantTotal     int64
+		wantFragments int
+	}{
+		{
+			name:         "视频下载",
+			line:         downloadProgressMarker + `{"vcodec":"avc1","acodec":"none","progress":{"status":"downloading","downloaded_bytes":250,"total_bytes":1000,"speed":100,"eta":7}}`,
+			wantOK:       true,
+			wantStage:    StageDownloadingVideo,
+			wantProgress: 25,
+			wantBytes:    250,
+			wantTotal:    1000,
+		},
+		{
+			name:         "单独的音频",
+			line:         downloadProgressMarker + `{"vcodec":"none","acodec":"opus","progress":{"status":"downloading","downloaded_bytes":50,"total_bytes":null,"total_bytes_estimate":200}}`,
+			wantOK:       true,
+			wantStage:    StageDownloadingAudio,
+			wantProgress: 25,
+			wantBytes:    50,
+			wantTotal:    200,
+		},
+		{
+			name:      "编码未知时按视频处理",
+			line:      downloadProgressMarker + `{"vcodec":"none","acodec":"NA","progress":{"status":"downloading"}}`,
+			wantOK:    true,
+			wantStage: StageDownloadingVideo,
+		},
+		{
+			name:          "按分片计算",
+			line:          "[download] " + downloadProgressMarker + `{"vcodec":"avc1","acodec":"mp4a","progress":{"status":"downloading","fragment_index":3,"fragment_count":12}}`,
+			wantOK:        true,
+			wantStage:     StageDownloadingVideo,
+			wantProgress:  25,
+			wantFragments: 12,
+		},
+		{
+			name:         "下载完成",
+			line:         downloadProgressMarker + `{"vcodec":"avc1","acodec":"mp4a","progress":{"status":"finished","downloaded_bytes":900,"total_bytes":1000}}`,
+			wantOK:       true,
+			wantStage:    StageDownloadingVideo,
+			wantProgress: 100,
+			wantBytes:    900,
+			wantTotal:    1000,
+		},
+		{
+			name:      "合并",
+			line:      postprocessProgressMarker + `{"status":"started","postprocessor":"Merger"}`,
+			wantOK:    true,
+			wantStage: StageMerging,
+		},
+		{
+			name:         "其他后处理完成",
+			line:         postprocessProgressMarker + `{"status":"finished","postprocessor":"FFmpegMetadata"}`,
+			wantOK:       true,
+			wantStage:    StagePostProcessing,
+			wantProgress: 100,
+		},
+		{name: "aria2c 输出只在 aria2 路径中解析", line: "[#2089b0 400KiB/1.0MiB(39%) CN:1 DL:115KiB ETA:5s]"},
+		{name: "yt-dlp 默认进度输出", line: "[download]  42.0% of 10.00MiB at 1.00MiB/s ETA 00:06"},
+		{name: "无效的 JSON", line: downloadProgressMarker + `{"progress":`},
+		{name: "普通输出", line: "[info] abc: Downloading 1 format(s): 137+140"},
+		{name: "空行", line: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseProgressLine(tt.line)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, 期望 %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got.Stage != tt.wantStage {
+				t.Errorf("Stage = %q, 期望 %q", got.Stage, tt.wantStage)
+			}
+			if got.Progress != tt.wantProgress {
+				t.Errorf("Progress = %v, 期望 %v", got.Progress, tt.wantProgress)
+			}
+			if got.BytesDownloaded != tt.wantBytes || got.BytesTotal != tt.wantTotal {
+				t.Errorf("字节数 = %d/%d, 期望 %d/%d", got.BytesDownloaded, got.BytesTotal, tt.wantBytes, tt.wantTotal)
+			}
+			if got.FragmentCount != tt.wantFragments {
+				t.Errorf("FragmentCount = %d, 期望 %d", got.FragmentCount, tt.wantFragments)
+			}
+		})
+	}
+}
+
+func TestStageTrackerAria2(t *testing.T) {
+	const line = "[#2089b0 512KiB/1.0MiB(50%) CN:1 DL:128KiB ETA:4s]"
+
+	tests := []struct {
+		name  string
+		aria2 bool
+		want  int
+	}{
+		{"使用 aria2c 时解析其输出", true, 1},
+		{"未使用 aria2c 时忽略", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reports []*DownloadResponse
+			stages := newStageTracker(func(progress *DownloadResponse) {
+				reports = append(reports, progress)
+			})
+			if tt.aria2 {
+				stages.useAria2()
+			}
+			stages.handleLine(line)
+			if len(reports) != tt.want {
+				t.Fatalf("报告了 %d 次进度, 期望 %d", len(reports), tt.want)
+			}
+			if tt.want > 0 && reports[0].StageProgress != 50 {
+				t.Errorf("StageProgress = %v, 期望 50", reports[0].StageProgress)
+			}
+		})
+	}
+}
+
+func TestStageTrackerOverall(t *testing.T) {
+	video := func(status string, downloaded int) string {
+		return downloadProgressMarker + `{"vcodec":"avc1","acodec":"none","progress":{"status":"` + status + `","downloaded_bytes":` + strconv.Itoa(downloaded) + `,"total_bytes":100}}`
+	}
+	audio := func(status string, downloaded int) string {
+		return downloadProgressMarker + `{"vcodec":"none","acodec":"mp4a","progress":{"status":"` + status + `","downloaded_bytes":` + strconv.Itoa(downloaded) + `,"total_bytes":100}}`
+	}
+	postprocess := func(status, name string) string {
+		return postprocessProgressMarker + `{"status":"` + status + `","postprocessor":"` + name + `"}`
+	}
+
+	tests := []struct {
+		name       string
+		lines      []string
+		want       []float64
+		wantStages []DownloadStage
+	}{
+		{
+			name: "分别下载音视频",
+			lines: []string{
+				"[info] abc: Downloading 1 format(s): 137+140",
+				video("downloading", 50), video("finished", 100),
+				audio("downloading", 50), audio("finished", 100),
+				postprocess("started", "Merger"), postprocess("finished", "Merger"),
+				postprocess("finished", "FFmpegMetadata"),
+			},
+			want: []float64{35, 70, 80, 90, 90, 98, 100},
+			wantStages: []DownloadStage{
+				StageDownloadingVideo, StageDownloadingVideo, StageDownloadingAudio, StageDownloadingAudio,
+				StageMerging, StageMerging, StagePostProcessing,
+			},
+		},
+		{
+			name:  "只下载一个文件",
+			lines: []string{"[info] abc: Downloading 1 format(s): 22", video("downloading", 50), video("finished", 100), postprocess("finished", "FFmpegMetadata")},
+			want:  []float64{47.5, 95, 100},
+		},
+		{
+			name:  "没有格式选择输出时根据音频阶段判断",
+			lines: []string{video("downloading", 50), audio("downloading", 50)},
+			want:  []float64{47.5, 80},
+		},
+		{
+			name:  "总进度不减少",
+			lines: []string{video("downloading", 60), video("downloading", 10), video("downloading", 80)},
+			want:  []float64{57, 57, 76},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []float64
+			var stages []DownloadStage
+			tracker := newStageTracker(func(progress *DownloadResponse) {
+				got = append(got, progress.Progress)
+				stages = append(stages, progress.Stage)
+			})
+			for _, line := range tt.lines {
+				tracker.handleLine(line)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("总进度 = %v, 期望 %v", got, tt.want)
+			}
+			if tt.wantStages != nil && !slices.Equal(stages, tt.wantStages) {
+				t.Errorf("阶段 = %q, 期望 %q", stages, tt.wantStages)
+			}
+		})
+	}
+
+	// 进入新阶段时立即报告，不改变总进度
+	var reports []*DownloadResponse
+	tracker := newStageTracker(func(progress *DownloadResponse) { reports = append(reports, progress) })
+	tracker.enter(StageResolving)
+	if len(reports) != 1 || reports[0].Stage != StageResolving || reports[0].Progress != 0 {
+		t.Errorf("enter 报告了 %+v", reports)
+	}
+}
@@ -0,0 +1,415 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"video-hunter/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// proxyRoute 一次请求使用的代理
+type proxyRoute struct {
+	URL    string      // 代理地址，为空时直连
+	Direct bool        // 规则明确要求直连，不使用环境变量中的代理
+	entry  *proxyEntry // 来自代理池时记录请求结果
+}
+
+// ytdlpArgs 返回 yt-dlp 的代理参数，--proxy "" 表示直连
+func (r proxyRoute) ytdlpArgs() []string {
+	switch {
+	case r.URL != "":
+		return []string{"--proxy", r.URL}
+	case r.Direct:
+		return []string{"--proxy", ""}
+	}
+	return nil
+}
+
+// curlArgs 返回 curl 的代理参数
+func (r proxyRoute) curlArgs() []string {
+	switch {
+	case r.URL != "":
+		return []string{"-x", r.URL}
+	case r.Direct:
+		return []string{"--noproxy", "*"}
+	}
+	return nil
+}
+
+// proxyURL 供 http.Transport.Proxy 使用，没有配置代理时沿用环境变量
+func (r proxyRoute) proxyURL(req *http.Request) (*url.URL, error) {
+	switch {
+	case r.URL != "":
+		return url.Parse(r.URL)
+	case r.Direct:
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// report 记录原生 HTTP 请求的结果，请求被取消时不计入
+func (r proxyRoute) report(err error) {
+	if r.entry == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	r.entry.pool.record(r.entry, err)
+}
+
+// reportCommand 记录 yt-dlp 或 curl 的执行结果，只有输出中出现代理错误时才计为失败，
+// 视频不存在、需要登录等错误与代理无关
+func (r proxyRoute) reportCommand(err error, output string) {
+	if r.entry == nil {
+		return
+	}
+	if err == nil {
+		r.entry.pool.record(r.entry, nil)
+		return
+	}
+	if isProxyFailure(output) {
+		r.entry.pool.record(r.entry, errors.New(lastLine(output)))
+	}
+}
+
+// proxyFailurePatterns yt-dlp 和 curl 输出中表示代理不可用的内容（小写），
+// yt-dlp -v 会输出代理地址，不能只匹配 socks 等协议名
+var proxyFailurePatterns = []string{
+	"proxyerror",
+	"unable to connect to proxy",
+	"tunnel connection failed",
+	"could not resolve proxy",
+	"failed to connect to proxy",
+	"proxy connect aborted",
+	"proxy authentication required",
+	"can't complete socks",
+	"socks5 connect",
+}
+
+// isProxyFailure 判断命令输出是否表示代理不可用
+func isProxyFailure(output string) bool {
+	output = strings.ToLower(output)
+	for _, pattern := range proxyFailurePatterns {
+		if strings.Contains(output, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastLine 返回输出中最后一个非空行
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// proxyEntry 代理池中的代理，状态由所属代理池的锁保护
+type proxyEntry struct {
+	pool      *proxyPool
+	url       string
+	failures  int
+	bad       bool
+	badSince  time.Time
+	lastError string
+	lastCheck time.Time
+}
+
+// proxyPool 代理池，轮流使用可用的代理，连续失败 max_failures 次后标记为不可用，
+// 健康检查或请求成功后恢复
+type proxyPool struct {
+	config  config.ProxyPoolConfig
+	mu      sync.Mutex
+	entries []*proxyEntry
+	next    int
+}
+
+// newProxyPool 创建代理池
+func newProxyPool(cfg config.ProxyPoolConfig) *proxyPool {
+	pool := &proxyPool{config: cfg}
+	for _, proxyURL := range cfg.Proxies {
+		pool.entries = append(pool.entries, &proxyEntry{pool: pool, url: proxyURL})
+	}
+	return pool
+}
+
+// pick 轮流选择可用的代理；都不可用时使用最早被标记的代理，避免在恢复前完全无法下载
+func (p *proxyPool) pick() *proxyEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.entries)
+	for i := 0; i < n; i++ {
+		entry := p.entries[(p.next+i)%n]
+		if !entry.bad {
+			p.next = (p.next + i + 1) % n
+			return entry
+		}
+	}
+
+	oldest := p.entries[0]
+	for _, entry := range p.entries[1:] {
+		if entry.badSince.Before(oldest.badSince) {
+			oldest = entry
+		}
+	}
+	logrus.Warnf("代理池 %s 没有可用的代理，临时使用 %s", p.config.Name, redactProxy(oldest.url))
+	return oldest
+}
+
+// record 记录代理的请求或健康检查结果
+func (p *proxyPool) record(entry *proxyEntry, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		if entry.bad {
+			logrus.Infof("代理 %s 已恢复可用 [%s]", redactProxy(entry.url), p.config.Name)
+		}
+		entry.failures = 0
+		entry.bad = false
+		entry.lastError = ""
+		return
+	}
+
+	entry.failures++
+	entry.lastError = err.Error()
+	if !entry.bad && entry.failures >= p.config.MaxFailures {
+		entry.bad = true
+		entry.badSince = time.Now()
+		logrus.Warnf("代理 %s 连续失败 %d 次，已标记为不可用 [%s]: %v",
+			redactProxy(entry.url), entry.failures, p.config.Name, err)
+	}
+}
+
+// checkAll 对代理池中的所有代理执行健康检查
+func (p *proxyPool) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, entry := range p.entries {
+		wg.Add(1)
+		go func(entry *proxyEntry) {
+			defer wg.Done()
+			err := p.check(ctx, entry)
+			if ctx.Err() != nil {
+				return
+			}
+			p.mu.Lock()
+			entry.lastCheck = time.Now()
+			p.mu.Unlock()
+			p.record(entry, err)
+		}(entry)
+	}
+	wg.Wait()
+}
+
+// check 通过代理请求 check_url
+func (p *proxyPool) check(ctx context.Context, entry *proxyEntry) error {
+	proxyURL, err := url.Parse(entry.url)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   15 * time.Second,
+	}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.config.CheckURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return &httpStatusError{StatusCode: resp.StatusCode, URL: p.config.CheckURL}
+	}
+	return nil
+}
+
+// ProxyStatus 代理池中代理的状态，代理地址中的密码已隐藏
+type ProxyStatus struct {
+	Pool      string     `json:"pool"`
+	Proxy     string     `json:"proxy"`
+	Healthy   bool       `json:"healthy"`
+	Failures  int        `json:"failures"`
+	LastError string     `json:"last_error,omitempty"`
+	LastCheck *time.Time `json:"last_check,omitempty"`
+}
+
+// ProxyRouter 按 proxy 配置为请求选择代理，由服务创建一个并注入各个下载器，下载器共享代理池的状态
+type ProxyRouter struct {
+	config    *config.Config
+	pools     map[string]*proxyPool
+	transport *proxyTransport // 接口和页面请求
+	download  *proxyTransport // 大文件下载，只限制建立连接和等待响应头的时间
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	cancel    context.CancelFunc
+}
+
+// NewProxyRouter 创建代理路由，调用 Start 后开始检查代理池
+func NewProxyRouter(cfg *config.Config) *ProxyRouter {
+	r := &ProxyRouter{
+		config: cfg,
+		pools:  make(map[string]*proxyPool, len(cfg.Proxy.Pools)),
+	}
+	for _, pool := range cfg.Proxy.Pools {
+		r.pools[pool.Name] = newProxyPool(pool)
+	}
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	r.transport = &proxyTransport{router: r, base: base}
+	r.download = &proxyTransport{router: r, base: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	}}
+	for _, t := range []*proxyTransport{r.transport, r.download} {
+		t.base.Proxy = func(req *http.Request) (*url.URL, error) {
+			route, _ := req.Context().Value(proxyRouteKey{}).(proxyRoute)
+			return route.proxyURL(req)
+		}
+	}
+	return r
+}
+
+// Start 启动代理池的健康检查，启动时先检查一次
+func (r *ProxyRouter) Start() {
+	r.startOnce.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		r.cancel = cancel
+		for _, pool := range r.pools {
+			go func(pool *proxyPool) {
+				ticker := time.NewTicker(time.Duration(pool.config.CheckInterval) * time.Second)
+				defer ticker.Stop()
+				for {
+					pool.checkAll(ctx)
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+					}
+				}
+			}(pool)
+		}
+	})
+}
+
+// Stop 停止健康检查
+func (r *ProxyRouter) Stop() {
+	r.stopOnce.Do(func() {
+		if r.cancel != nil {
+			r.cancel()
+		}
+	})
+}
+
+// route 选择链接使用的代理
+func (r *ProxyRouter) route(rawURL string) proxyRoute {
+	value := r.config.ProxyFor(rawURL)
+	switch {
+	case value == "":
+		return proxyRoute{}
+	case value == "direct":
+		return proxyRoute{Direct: true}
+	case strings.HasPrefix(value, "pool:"):
+		pool, ok := r.pools[strings.TrimPrefix(value, "pool:")]
+		if !ok {
+			// 配置加载时已校验，这里只在代理池不存在时退回直连
+			return proxyRoute{Direct: true}
+		}
+		entry := pool.pick()
+		return proxyRoute{URL: entry.url, entry: entry}
+	}
+	return proxyRoute{URL: value}
+}
+
+// Status 返回所有代理池中代理的状态，按配置顺序排列
+func (r *ProxyRouter) Status() []ProxyStatus {
+	statuses := []ProxyStatus{}
+	for _, cfg := range r.config.Proxy.Pools {
+		pool := r.pools[cfg.Name]
+		pool.mu.Lock()
+		for _, entry := range pool.entries {
+			status := ProxyStatus{
+				Pool:      cfg.Name,
+				Proxy:     redactProxy(entry.url),
+				Healthy:   !entry.bad,
+				Failures:  entry.failures,
+				LastError: entry.lastError,
+			}
+			if !entry.lastCheck.IsZero() {
+				lastCheck := entry.lastCheck
+				status.LastCheck = &lastCheck
+			}
+			statuses = append(statuses, status)
+		}
+		pool.mu.Unlock()
+	}
+	return statuses
+}
+
+// client 返回按请求地址选择代理的 HTTP 客户端，用于接口和页面请求
+func (r *ProxyRouter) client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: r.transport, Timeout: timeout}
+}
+
+// downloadClient 返回用于下载大文件的 HTTP 客户端，按下载地址选择代理；
+// 不设置整体超时，避免大文件在下载途中被中断，只限制建立连接和等待响应头的时间
+func (r *ProxyRouter) downloadClient() *http.Client {
+	return &http.Client{Transport: r.download}
+}
+
+// proxyRouteKey 请求 ctx 中本次请求代理的键
+type proxyRouteKey struct{}
+
+// proxyTransport 按请求地址选择代理，重定向后的请求重新选择；经代理池发出的请求结果计入代理的状态
+type proxyTransport struct {
+	router *ProxyRouter
+	base   *http.Transport
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := t.router.route(req.URL.String())
+	req = req.WithContext(context.WithValue(req.Context(), proxyRouteKey{}, route))
+	resp, err := t.base.RoundTrip(req)
+	route.report(err)
+	return resp, err
+}
+
+// redactProxy 隐藏代理地址中的密码
+func redactProxy(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// credentialsPattern 匹配地址中的 "用户名:密码@" 部分
+var credentialsPattern = regexp.MustCompile(`(://[^\s/:@'"]+):[^\s/@'"]+@`)
+
+// redactCredentials 隐藏文本中代理等地址的密码，用于日志和错误信息
+func redactCredentials(text string) string {
+	return credentialsPattern.ReplaceAllString(text, "$1:xxxxx@")
+}
+
+// commandLine 返回隐藏了密码的命令行，用于日志
+func commandLine(name string, args []string) string {
+	return redactCredentials(strings.TrimSpace(name + " " + strings.Join(args, " ")))
+}
@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Provider 下载提供者，声明自己能处理的URL及优先级
+type Provider interface {
+	Downloader
+	// Name 提供者名称，在注册表中唯一
+	Name() string
+	// Priority 优先级，数值越大越先被尝试
+	Priority() int
+	// CanHandle 判断是否可以处理该URL
+	CanHandle(url string) bool
+}
+
+// Registry 下载提供者注册表
+type Registry struct {
+	providers []Provider
+	mu        sync.RWMutex
+}
+
+// NewRegistry 创建空的注册表
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register 注册下载提供者
+func (r *Registry) Register(p Provider) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.providers {
+		if existing.Name() == p.Name() {
+			return fmt.Errorf("下载提供者已注册: %s", p.Name())
+		}
+	}
+
+	r.providers = append(r.providers, p)
+
+	// 按优先级从高到低排序，优先级相同时保持注册顺序
+	sort.SliceStable(r.providers, func(i, j int) bool {
+		return r.providers[i].Priority() > r.providers[j].Priority()
+	})
+	return nil
+}
+
+// Resolve 返回可以处理该URL的最高优先级提供者
+func (r *Registry) Resolve(url string) (Provider, error) {
+	candidates := r.ResolveAll(url)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("没有可以处理该链接的下载器: %s", url)
+	}
+	return candidates[0], nil
+}
+
+// ResolveAll 按优先级返回所有可以处理该URL的提供者，用于失败后依次回退
+func (r *Registry) ResolveAll(url string) []Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var candidates []Provider
+	for _, p := range r.providers {
+		if p.CanHandle(url) {
+			candidates = append(candidates, p)
+		}
+	}
+	return candidates
+}
+
+// Get 按名称获取提供者
+func (r *Registry) Get(name string) (Provider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.providers {
+		if p.Name() == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Providers 按优先级返回所有已注册的提供者
+func (r *Registry) Providers() []Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	providers := make([]Provider, len(r.providers))
+	copy(providers, r.providers)
+	return providers
+}
+
+// 确保内置下载器实现了 Provider 接口
+var (
+	_ Provider = (*YtdlpDownloader)(nil)
+	_ Provider = (*DouyinDownloader)(nil)
+)
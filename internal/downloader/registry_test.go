@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"video-hunter/internal/config"
+)
+
+// fakeProvider 只用于测试注册表的提供者
+type fakeProvider struct {
+	Downloader
+	name     string
+	priority int
+	prefix   string
+}
+
+func (p *fakeProvider) Name() string              { return p.name }
+func (p *fakeProvider) Priority() int             { return p.priority }
+func (p *fakeProvider) CanHandle(url string) bool { return strings.HasPrefix(url, p.prefix) }
+
+// providerNames 返回提供者的名称
+func providerNames(providers []Provider) []string {
+	var names []string
+	for _, p := range providers {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+func TestRegistryResolve(t *testing.T) {
+	registry := NewRegistry()
+	for _, p := range []*fakeProvider{
+		{name: "generic", priority: 0, prefix: "https://"},
+		{name: "site", priority: 100, prefix: "https://site.com/"},
+		{name: "first", priority: 50, prefix: "https://"},
+		{name: "second", priority: 50, prefix: "https://"},
+	} {
+		if err := registry.Register(p); err != nil {
+			t.Fatalf("注册 %s 失败: %v", p.name, err)
+		}
+	}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"https://site.com/video/1", []string{"site", "first", "second", "generic"}},
+		{"https://other.com/video/1", []string{"first", "second", "generic"}},
+		{"ftp://site.com/video/1", nil},
+	}
+	for _, tt := range tests {
+		if got := providerNames(registry.ResolveAll(tt.url)); !slices.Equal(got, tt.want) {
+			t.Errorf("ResolveAll(%q) = %q, 期望 %q", tt.url, got, tt.want)
+		}
+		p, err := registry.Resolve(tt.url)
+		if len(tt.want) == 0 {
+			if err == nil {
+				t.Errorf("Resolve(%q) = %s, 期望返回错误", tt.url, p.Name())
+			}
+			continue
+		}
+		if err != nil || p.Name() != tt.want[0] {
+			t.Errorf("Resolve(%q) = %v, %v, 期望 %s", tt.url, p, err, tt.want[0])
+		}
+	}
+
+	if err := registry.Register(&fakeProvider{name: "site"}); err == nil {
+		t.Error("重复注册同名的提供者应返回错误")
+	}
+	if p, ok := registry.Get("first"); !ok || p.Name() != "first" {
+		t.Errorf("Get(first) = %v, %v", p, ok)
+	}
+	if _, ok := registry.Get("missing"); ok {
+		t.Error("Get(missing) 应返回 false")
+	}
+	if got := providerNames(registry.Providers()); !slices.Equal(got, []string{"site", "first", "second", "generic"}) {
+		t.Errorf("Providers = %q", got)
+	}
+}
+
+func TestBuiltinProviders(t *testing.T) {
+	cfg := &config.Config{}
+	proxies := NewProxyRouter(cfg)
+	registry := NewRegistry()
+	for _, p := range []Provider{
+		NewYtdlpDownloader(cfg, proxies),
+		NewDouyinDownloader(cfg, proxies),
+		NewAria2Downloader(cfg, proxies),
+	} {
+		if err := registry.Register(p); err != nil {
+			t.Fatalf("注册 %s 失败: %v", p.Name(), err)
+		}
+	}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"https://v.douyin.com/abc/", []string{"douyin", "ytdlp"}},
+		{"https://www.douyin.com/video/7300000000000000000", []string{"douyin", "ytdlp"}},
+		{"https://www.douyin.com/user/abc", []string{"ytdlp"}},
+		{"https://cdn.example.com/media/video.MP4?sign=1", []string{"aria2", "ytdlp"}},
+		{"https://www.youtube.com/watch?v=abc", []string{"ytdlp"}},
+		{"ftp://example.com/video.mp4", nil},
+	}
+	for _, tt := range tests {
+		if got := providerNames(registry.ResolveAll(tt.url)); !slices.Equal(got, tt.want) {
+			t.Errorf("ResolveAll(%q) = %q, 期望 %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"video-hunter/internal/config"
+)
+
+// douyinSiteURL 抖音原生请求按此链接匹配网站配置，接口和播放地址的主机名不一定在 hosts 中
+const douyinSiteURL = "https://www.douyin.com/"
+
+// siteProfile 链接对应的请求配置：sites 中匹配的网站配置，未设置的项使用 ytdlp 的通用配置
+type siteProfile struct {
+	Name               string
+	Headers            map[string]string
+	UserAgent          string
+	Referer            string
+	ExtractorArgs      []string
+	Format             string
+	CookiesFile        string
+	NoCheckCertificate bool
+}
+
+// resolveSite 解析链接使用的请求配置，获取视频信息、yt-dlp 下载和抖音原生请求都通过它读取网站配置
+func resolveSite(cfg *config.Config, rawURL string) siteProfile {
+	profile := siteProfile{
+		UserAgent:   cfg.YtDlp.UserAgent,
+		Format:      cfg.YtDlp.Format,
+		CookiesFile: cfg.YtDlp.CookiesFile,
+	}
+
+	site := cfg.Site(rawURL)
+	if site == nil {
+		return profile
+	}
+	profile.Name = site.Name
+	// viper 读取配置时会把请求头名称转为小写，这里恢复规范的写法
+	profile.Headers = make(map[string]string, len(site.Headers))
+	for key, value := range site.Headers {
+		profile.Headers[http.CanonicalHeaderKey(key)] = value
+	}
+	profile.Referer = site.Referer
+	profile.ExtractorArgs = site.ExtractorArgs
+	profile.NoCheckCertificate = site.NoCheckCertificate
+	if site.UserAgent != "" {
+		profile.UserAgent = site.UserAgent
+	}
+	if site.Format != "" {
+		profile.Format = site.Format
+	}
+	if site.CookiesFile != "" {
+		profile.CookiesFile = site.CookiesFile
+	}
+	return profile
+}
+
+// ytdlpArgs 返回 yt-dlp 的请求参数；Cookie 文件依次取请求中的 cookies、ctx 中匹配域名的 Cookie 和网站配置，
+// req 不为空时其请求头和 Referer 优先于网站配置
+func (p siteProfile) ytdlpArgs(ctx context.Context, req *DownloadRequest) []string {
+	var args []string
+	if p.UserAgent != "" {
+		args = append(args, "--user-agent", p.UserAgent)
+	}
+	for _, key := range sortedKeys(p.Headers) {
+		args = append(args, "--add-header", key+": "+p.Headers[key])
+	}
+
+	referer, cookies := p.Referer, p.CookiesFile
+	if path := cookieFileFrom(ctx); path != "" {
+		cookies = path
+	}
+	if req != nil {
+		// yt-dlp 中后出现的同名请求头覆盖前面的
+		for _, key := range sortedKeys(req.Headers) {
+			args = append(args, "--add-header", key+": "+req.Headers[key])
+		}
+		if req.Referer != "" {
+			referer = req.Referer
+		}
+		if req.Cookies != "" {
+			cookies = req.Cookies
+		}
+	}
+	if referer != "" {
+		args = append(args, "--referer", referer)
+	}
+	if cookies != "" {
+		args = append(args, "--cookies", cookies)
+	}
+
+	for _, extractorArgs := range p.ExtractorArgs {
+		args = append(args, "--extractor-args", extractorArgs)
+	}
+	if p.NoCheckCertificate {
+		args = append(args, "--no-check-certificate")
+	}
+	return args
+}
+
+// setHeaders 为原生 HTTP 请求设置网站配置中的请求头、User-Agent 和 Referer；
+// Accept-Encoding 由 net/http 处理，手动设置后响应不会自动解压，因此跳过
+func (p siteProfile) setHeaders(header http.Header) {
+	for key, value := range p.Headers {
+		if strings.EqualFold(key, "Accept-Encoding") {
+			continue
+		}
+		header.Set(key, value)
+	}
+	if p.UserAgent != "" {
+		header.Set("User-Agent", p.UserAgent)
+	}
+	if p.Referer != "" {
+		header.Set("Referer", p.Referer)
+	}
+}
+
+// httpHeaders 以 map 形式返回 setHeaders 设置的请求头，用于 httpDownload
+func (p siteProfile) httpHeaders() map[string]string {
+	header := make(http.Header)
+	p.setHeaders(header)
+	headers := make(map[string]string, len(header))
+	for key := range header {
+		headers[key] = header.Get(key)
+	}
+	return headers
+}
+
+// sortedKeys 按名称排序请求头，保证生成的命令行参数稳定
+func sortedKeys(headers map[string]string) []string {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"video-hunter/internal/config"
+)
+
+func TestResolveSite(t *testing.T) {
+	cfg := &config.Config{
+		YtDlp: config.YtDlpConfig{UserAgent: "generic-ua", Format: "1080p", CookiesFile: "/cookies/all.txt"},
+		Sites: []config.SiteConfig{
+			{
+				Name:        "bilibili",
+				Hosts:       []string{"bilibili.com", "b23.tv"},
+				Headers:     map[string]string{"accept-language": "zh-CN"},
+				Format:      "720p",
+				CookiesFile: "/cookies/bilibili.txt",
+			},
+			{Name: "cdn", Hosts: []string{"*.example.com"}, UserAgent: "cdn-ua", Referer: "https://example.com/"},
+		},
+	}
+
+	tests := []struct {
+		url         string
+		wantName    string
+		wantUA      string
+		wantFormat  string
+		wantCookies string
+		wantReferer string
+	}{
+		{"https://www.bilibili.com/video/BV1", "bilibili", "generic-ua", "720p", "/cookies/bilibili.txt", ""},
+		{"https://b23.tv/abc", "bilibili", "generic-ua", "720p", "/cookies/bilibili.txt", ""},
+		{"https://BILIBILI.COM./video/BV1", "bilibili", "generic-ua", "720p", "/cookies/bilibili.txt", ""},
+		{"https://notbilibili.com/video", "", "generic-ua", "1080p", "/cookies/all.txt", ""},
+		{"https://cdn.example.com/v.mp4", "cdn", "cdn-ua", "1080p", "/cookies/all.txt", "https://example.com/"},
+		{"https://example.com/v.mp4", "", "generic-ua", "1080p", "/cookies/all.txt", ""},
+		{"not a url", "", "generic-ua", "1080p", "/cookies/all.txt", ""},
+	}
+
+	for _, tt := range tests {
+		p := resolveSite(cfg, tt.url)
+		if p.Name != tt.wantName || p.UserAgent != tt.wantUA || p.Format != tt.wantFormat ||
+			p.CookiesFile != tt.wantCookies || p.Referer != tt.wantReferer {
+			t.Errorf("resolveSite(%q) = %+v", tt.url, p)
+		}
+	}
+
+	// viper 转为小写的请求头名称恢复为规范写法
+	if p := resolveSite(cfg, "https://www.bilibili.com/"); p.Headers["Accept-Language"] != "zh-CN" {
+		t.Errorf("Headers = %v", p.Headers)
+	}
+}
+
+func TestSiteProfileYtdlpArgs(t *testing.T) {
+	profile := siteProfile{
+		UserAgent:          "site-ua",
+		Headers:            map[string]string{"X-B": "2", "X-A": "1"},
+		Referer:            "https://site.com/",
+		CookiesFile:        "/cookies/site.txt",
+		ExtractorArgs:      []string{"douyin:app_version=9.9.10"},
+		NoCheckCertificate: true,
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *DownloadRequest
+		want []string
+	}{
+		{
+			name: "网站配置",
+			ctx:  context.Background(),
+			want: []string{
+				"--user-agent", "site-ua", "--add-header", "X-A: 1", "--add-header", "X-B: 2",
+				"--referer", "https://site.com/", "--cookies", "/cookies/site.txt",
+				"--extractor-args", "douyin:app_version=9.9.10", "--no-check-certificate",
+			},
+		},
+		{
+			name: "上传的 Cookie 优先于网站配置",
+			ctx:  WithCookieFile(context.Background(), "/tmp/uploaded.txt"),
+			req:  &DownloadRequest{},
+			want: []string{
+				"--user-agent", "site-ua", "--add-header", "X-A: 1", "--add-header", "X-B: 2",
+				"--referer", "https://site.com/", "--cookies", "/tmp/uploaded.txt",
+				"--extractor-args", "douyin:app_version=9.9.10", "--no-check-certificate",
+			},
+		},
+		{
+			name: "请求优先于网站配置",
+			ctx:  WithCookieFile(context.Background(), "/tmp/uploaded.txt"),
+			req: &DownloadRequest{
+				Headers: map[string]string{"X-A": "request"},
+				Referer: "https://request.com/",
+				Cookies: "/tmp/request.txt",
+			},
+			want: []string{
+				"--user-agent", "site-ua", "--add-header", "X-A: 1", "--add-header", "X-B: 2",
+				"--add-header", "X-A: request",
+				"--referer", "https://request.com/", "--cookies", "/tmp/request.txt",
+				"--extractor-args", "douyin:app_version=9.9.10", "--no-check-certificate",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := profile.ytdlpArgs(tt.ctx, tt.req); !slices.Equal(got, tt.want) {
+				t.Errorf("ytdlpArgs = %q\n期望 %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := (siteProfile{}).ytdlpArgs(context.Background(), nil); got != nil {
+		t.Errorf("空配置的 ytdlpArgs = %q", got)
+	}
+}
+
+func TestSiteProfileSetHeaders(t *testing.T) {
+	profile := siteProfile{
+		Headers:   map[string]string{"Accept-Encoding": "gzip, deflate, br", "Accept-Language": "zh-CN", "User-Agent": "header-ua"},
+		UserAgent: "site-ua",
+		Referer:   "https://site.com/",
+	}
+
+	header := make(http.Header)
+	profile.setHeaders(header)
+	want := map[string]string{
+		"Accept-Encoding": "",
+		"Accept-Language": "zh-CN",
+		"User-Agent":      "site-ua",
+		"Referer":         "https://site.com/",
+	}
+	for key, value := range want {
+		if got := header.Get(key); got != value {
+			t.Errorf("%s = %q, 期望 %q", key, got, value)
+		}
+	}
+
+	headers := profile.httpHeaders()
+	if len(headers) != 3 || headers["User-Agent"] != "site-ua" {
+		t.Errorf("httpHeaders = %v", headers)
+	}
+}
@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// taskTracker 记录下载器内部正在执行的任务，供 GetProgress 和 Cancel 使用
+type taskTracker struct {
+	tasks map[string]*trackedTask
+	mu    sync.RWMutex
+}
+
+// trackedTask 正在执行的任务
+type trackedTask struct {
+	resp   *DownloadResponse
+	cancel context.CancelFunc
+}
+
+// newTaskTracker 创建任务记录器
+func newTaskTracker() *taskTracker {
+	return &taskTracker{
+		tasks: make(map[string]*trackedTask),
+	}
+}
+
+// start 开始记录任务，返回可被 Cancel 取消的上下文
+func (t *taskTracker) start(ctx context.Context, id, title string) (context.Context, *DownloadResponse) {
+	now := time.Now()
+	resp := &DownloadResponse{
+		ID:      id,
+		Status:  StatusDownloading,
+		Created: now,
+		Updated: now,
+		Title:   title,
+	}
+
+	// 没有任务ID的下载无法通过 Cancel 取消，不需要记录
+	if id == "" {
+		return ctx, resp
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	t.mu.Lock()
+	t.tasks[id] = &trackedTask{resp: resp, cancel: cancel}
+	t.mu.Unlock()
+
+	return ctx, resp
+}
+
+// update 更新任务进度
+func (t *taskTracker) update(id string, progress *DownloadResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if task, ok := t.tasks[id]; ok {
+		task.resp.Progress = progress.Progress
+		task.resp.Speed = progress.Speed
+		task.resp.ETA = progress.ETA
+		task.resp.Updated = time.Now()
+	}
+}
+
+// finish 结束记录任务并释放上下文
+func (t *taskTracker) finish(id string) {
+	t.mu.Lock()
+	task, ok := t.tasks[id]
+	delete(t.tasks, id)
+	t.mu.Unlock()
+
+	if ok {
+		task.cancel()
+	}
+}
+
+// get 获取任务进度的副本
+func (t *taskTracker) get(id string) (*DownloadResponse, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	task, ok := t.tasks[id]
+	if !ok {
+		return nil, errors.New("任务不存在")
+	}
+	resp := *task.resp
+	return &resp, nil
+}
+
+// cancel 取消任务
+func (t *taskTracker) cancel(id string) error {
+	t.mu.Lock()
+	task, ok := t.tasks[id]
+	if ok {
+		task.resp.Status = StatusCancelled
+		task.resp.Updated = time.Now()
+	}
+	t.mu.Unlock()
+
+	if !ok {
+		return errors.New("任务不存在")
+	}
+	task.cancel()
+	return nil
+}
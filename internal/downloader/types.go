@@ -1,27 +1,57 @@
 package downloader
 
 import (
-	"regexp"
-	"strconv"
+	"context"
 	"time"
 )
 
 // DownloadRequest 下载请求
 type DownloadRequest struct {
-	URL         string            `json:"url"`
-	Output      string            `json:"output,omitempty"`
-	Format      string            `json:"format,omitempty"`
-	Threads     int               `json:"threads,omitempty"`
-	Timeout     int               `json:"timeout,omitempty"`
-	Metadata    map[string]string `json:"metadata,omitempty"`
-	SaveToLocal bool              `json:"save_to_local,omitempty"` // 是否保存到用户本地
-	Headers     map[string]string `json:"headers,omitempty"`
-	Cookies     string            `json:"cookies,omitempty"`
-	Referer     string            `json:"referer,omitempty"`
-	Options     map[string]string `json:"options,omitempty"`
-	TaskID      string            `json:"task_id,omitempty"` // 任务ID
+	URL          string            `json:"url"`
+	Output       string            `json:"output,omitempty"`
+	Format       string            `json:"format,omitempty"`
+	Threads      int               `json:"threads,omitempty"`
+	Timeout      int               `json:"timeout,omitempty"`       // 最长下载时间（秒），覆盖 downloader.timeout
+	StallTimeout int               `json:"stall_timeout,omitempty"` // 没有进度时中止下载的时间（秒），覆盖 downloader.stall_timeout
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	SaveToLocal  bool              `json:"save_to_local,omitempty"` // 是否保存到用户本地
+	Headers      map[string]string `json:"headers,omitempty"`
+	Cookies      string            `json:"cookies,omitempty"`
+	Referer      string            `json:"referer,omitempty"`
+	Options      map[string]string `json:"options,omitempty"`
+	TaskID       string            `json:"task_id,omitempty"`       // 任务ID
+	Priority     int               `json:"priority,omitempty"`      // 排队优先级，数值越大越先下载
+	Dedupe       DedupePolicy      `json:"dedupe,omitempty"`        // 已下载过同一视频时的处理方式，覆盖 downloader.dedupe
+	Mode         DownloadMode      `json:"mode,omitempty"`          // video 或 audio，未指定时由 ytdlp.extract_audio 决定
+	AudioFormat  string            `json:"audio_format,omitempty"`  // 音频模式的格式，覆盖 ytdlp.audio_format
+	AudioQuality string            `json:"audio_quality,omitempty"` // 音频模式的音质，覆盖 ytdlp.audio_quality
+	UseArchive   bool              `json:"archive,omitempty"`       // 单个视频也使用下载存档，已在存档中时任务失败
+	Playlist     string            `json:"playlist,omitempty"`      // 所属播放列表或频道的地址，由服务层为子任务设置
+	Archive      string            `json:"-"`                       // yt-dlp --download-archive 文件，由服务层在下载前设置
 }
 
+// DedupePolicy 创建任务时发现同一视频（相同提取器、视频ID和格式）已下载或正在下载的处理方式
+type DedupePolicy string
+
+const (
+	// DedupeReuse 已下载完成时创建一个链接到已有文件的新任务，正在下载时返回该任务
+	DedupeReuse DedupePolicy = "reuse"
+	// DedupeSkip 不创建新任务，直接返回已有的任务
+	DedupeSkip DedupePolicy = "skip"
+	// DedupeForce 不检查重复，总是重新下载
+	DedupeForce DedupePolicy = "force"
+)
+
+// DownloadMode 下载的内容
+type DownloadMode string
+
+const (
+	// ModeVideo 下载视频
+	ModeVideo DownloadMode = "video"
+	// ModeAudio 只保留音频，yt-dlp 使用 -x 提取，抖音从视频中提取音轨或下载背景音乐
+	ModeAudio DownloadMode = "audio"
+)
+
 // DownloadResponse 下载响应
 type DownloadResponse struct {
 	ID       string            `json:"id"`
@@ -36,6 +66,30 @@ type DownloadResponse struct {
 	Created  time.Time         `json:"created"`
 	Updated  time.Time         `json:"updated"`
 	Title    string            `json:"title,omitempty"`
+	Attempts int               `json:"attempts,omitempty"`       // 已执行的下载次数（包括重试）
+	History  []AttemptError    `json:"attempt_errors,omitempty"` // 每次失败尝试的错误记录
+	ParentID string            `json:"parent_id,omitempty"`      // 所属播放列表任务的ID
+	Children []string          `json:"children,omitempty"`       // 播放列表任务的子任务ID
+	Owner    string            `json:"owner,omitempty"`          // 创建任务的API密钥名称，未启用认证时为空
+
+	BytesDownloaded int64   `json:"bytes_downloaded,omitempty"` // 当前文件已下载的字节数
+	BytesTotal      int64   `json:"bytes_total,omitempty"`      // 当前文件的总字节数（可能是估计值）
+	SpeedBps        float64 `json:"speed_bps,omitempty"`        // 下载速度（字节/秒）
+	ETASeconds      int64   `json:"eta_seconds,omitempty"`      // 预计剩余时间（秒）
+	Fragment        int     `json:"fragment,omitempty"`         // 分片下载时当前的分片序号
+	FragmentCount   int     `json:"fragment_count,omitempty"`   // 分片总数
+	Postprocessor   string  `json:"postprocessor,omitempty"`    // 正在运行的后处理器，例如 Merger
+
+	Stage         DownloadStage `json:"stage,omitempty"`          // 当前所处的下载阶段，Progress 为按阶段加权后的总进度
+	StageProgress float64       `json:"stage_progress,omitempty"` // 当前阶段的进度
+}
+
+// AttemptError 一次失败的下载尝试
+type AttemptError struct {
+	Attempt   int       `json:"attempt"`
+	Error     string    `json:"error"`
+	Retryable bool      `json:"retryable"`
+	Time      time.Time `json:"time"`
 }
 
 // DownloadStatus 下载状态
@@ -43,12 +97,24 @@ type DownloadStatus string
 
 const (
 	StatusPending     DownloadStatus = "pending"
+	StatusPaused      DownloadStatus = "paused" // 排队中被暂停，恢复后继续排队
 	StatusDownloading DownloadStatus = "downloading"
 	StatusCompleted   DownloadStatus = "completed"
 	StatusFailed      DownloadStatus = "failed"
 	StatusCancelled   DownloadStatus = "cancelled"
 )
 
+// DownloadStage 下载阶段
+type DownloadStage string
+
+const (
+	StageResolving        DownloadStage = "resolving"         // 解析视频信息和下载地址
+	StageDownloadingVideo DownloadStage = "downloading_video" // 下载视频（或音视频合一的文件）
+	StageDownloadingAudio DownloadStage = "downloading_audio" // 下载单独的音频
+	StageMerging          DownloadStage = "merging"           // 使用 ffmpeg 合并音视频
+	StagePostProcessing   DownloadStage = "post_processing"   // 其他后处理，例如转换格式、写入元数据
+)
+
 // VideoInfo 视频信息
 type VideoInfo struct {
 	Title       string            `json:"title"`
@@ -71,53 +137,21 @@ type VideoFormat struct {
 	Filesize   int64  `json:"filesize,omitempty"`
 	URL        string `json:"url,omitempty"`
 	Quality    string `json:"quality"`
+	VCodec     string `json:"vcodec,omitempty"` // 视频编码，纯音频格式为 none
+	ACodec     string `json:"acodec,omitempty"` // 音频编码，纯视频格式为 none
 }
 
 // Downloader 下载器接口
 type Downloader interface {
-	Download(req *DownloadRequest) (*DownloadResponse, error)
+	// Download 同步执行下载，ctx 取消时中止下载，成功时返回包含文件路径的下载结果
+	Download(ctx context.Context, req *DownloadRequest, callback ProgressCallback) (*DownloadResponse, error)
+	// GetProgress 获取正在执行的任务的进度
 	GetProgress(id string) (*DownloadResponse, error)
+	// Cancel 取消正在执行的任务
 	Cancel(id string) error
-	GetVideoInfo(url string) (*VideoInfo, error)
+	// GetVideoInfo 获取视频信息，ctx 取消或超时时中止解析
+	GetVideoInfo(ctx context.Context, url string) (*VideoInfo, error)
 }
 
 // ProgressCallback 进度回调函数
 type ProgressCallback func(progress *DownloadResponse)
-
-// parseProgress 解析进度信息
-func parseProgress(line string) *DownloadResponse {
-	resp := &DownloadResponse{}
-
-	// 解析进度百分比
-	progressRegex := regexp.MustCompile(`(\d+\.?\d*)%`)
-	if matches := progressRegex.FindStringSubmatch(line); len(matches) > 1 {
-		if pct, err := strconv.ParseFloat(matches[1], 64); err == nil {
-			resp.Progress = pct
-		}
-	}
-
-	// 解析下载速度
-	speedRegex := regexp.MustCompile(`(\d+\.?\d*\s*[KMG]?i?B/s)`)
-	if matches := speedRegex.FindStringSubmatch(line); len(matches) > 1 {
-		resp.Speed = matches[1]
-	}
-
-	// 解析预计剩余时间
-	etaRegex := regexp.MustCompile(`ETA\s+(\d+:\d+)`)
-	if matches := etaRegex.FindStringSubmatch(line); len(matches) > 1 {
-		resp.ETA = matches[1]
-	}
-
-	// 解析文件大小
-	sizeRegex := regexp.MustCompile(`(\d+\.?\d*\s*[KMG]?i?B)\s+of\s+(\d+\.?\d*\s*[KMG]?i?B)`)
-	if matches := sizeRegex.FindStringSubmatch(line); len(matches) > 2 {
-		// 可以添加下载大小信息到元数据
-		if resp.Metadata == nil {
-			resp.Metadata = make(map[string]string)
-		}
-		resp.Metadata["downloaded"] = matches[1]
-		resp.Metadata["total_size"] = matches[2]
-	}
-
-	return resp
-}
@@ -0,0 +1,117 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// douyinVideoIDRegex 从抖音链接中提取视频ID
+var douyinVideoIDRegex = regexp.MustCompile(`(?:/video/|/share/video/|modal_id=)(\d+)`)
+
+// getDouyinRealUrl 依次尝试多种方式获取抖音视频的真实播放地址和标题
+func (y *YtdlpDownloader) getDouyinRealUrl(ctx context.Context, url string) (string, string, error) {
+	douyin := NewDouyinDownloader(y.config, y.proxies)
+
+	// 1. 解析视频页面的 RENDER_DATA
+	video, info, err := douyin.resolveVideo(ctx, url)
+	if err == nil {
+		for _, playURL := range video.PlayURLs {
+			if y.isValidURL(ctx, playURL) {
+				logrus.Infof("获取到抖音视频地址(页面数据): %s", playURL)
+				return playURL, info.Title, nil
+			}
+		}
+		logrus.Debugf("页面数据中的播放地址均不可用")
+	} else {
+		logrus.Debugf("解析抖音页面数据失败: %v", err)
+	}
+
+	// 2. 根据视频ID调用抖音接口
+	videoID := ""
+	if video != nil {
+		videoID = video.AwemeID
+	}
+	if videoID == "" {
+		resolved := url
+		if cleanURL, err := douyin.cleanURL(ctx, url); err == nil {
+			resolved = cleanURL
+		}
+		if matches := douyinVideoIDRegex.FindStringSubmatch(resolved); len(matches) > 1 {
+			videoID = matches[1]
+		}
+	}
+
+	if videoID != "" {
+		apis := []func(context.Context, string) (string, string, error){
+			y.getDouyinVideoByWebAPI,
+			y.getDouyinVideoByOfficialAPI,
+			y.getDouyinVideoByMobileAPI,
+		}
+		for _, api := range apis {
+			videoURL, title, err := api(ctx, videoID)
+			if err == nil {
+				return videoURL, title, nil
+			}
+			logrus.Debugf("抖音接口解析失败: %v", err)
+		}
+	}
+
+	// 3. 使用curl抓取页面
+	videoURL, title, err := y.getDouyinVideoByDirectCurl(ctx, url)
+	if err == nil {
+		return videoURL, title, nil
+	}
+	logrus.Debugf("curl解析抖音页面失败: %v", err)
+
+	// 4. 第三方解析服务
+	return y.getDouyinVideoByThirdPartyAPI(ctx, url)
+}
+
+// DownloadDouyin 解析抖音视频真实地址后直接通过HTTP下载，支持断点续传
+func (y *YtdlpDownloader) DownloadDouyin(ctx context.Context, req *DownloadRequest, progressCallback func(*DownloadResponse)) (string, error) {
+	return y.downloadDouyin(ctx, req, newStageTracker(progressCallback))
+}
+
+// downloadDouyin 下载抖音视频，解析地址期间处于 resolving 阶段
+func (y *YtdlpDownloader) downloadDouyin(ctx context.Context, req *DownloadRequest, stages *stageTracker) (string, error) {
+	stages.enter(StageResolving)
+	videoURL, title, err := y.getDouyinRealUrl(ctx, y.convertDouyinUrl(req.URL))
+	if err != nil {
+		return "", fmt.Errorf("获取抖音视频地址失败: %w", err)
+	}
+
+	// 生成带有任务ID前缀的输出文件名
+	var outputFile string
+	if req.Output != "" {
+		dir := filepath.Dir(req.Output)
+		filename := filepath.Base(req.Output)
+		outputFile = filepath.Join(dir, fmt.Sprintf("%s_%s", req.TaskID, filename))
+	} else {
+		name := sanitizeFilename(title)
+		if runes := []rune(name); len(runes) > 80 {
+			name = string(runes[:80])
+		}
+		outputFile = filepath.Join(y.config.Downloader.OutputDir, fmt.Sprintf("%s_%s.mp4", req.TaskID, name))
+	}
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return "", fmt.Errorf("创建下载目录失败: %w", err)
+	}
+
+	headers := resolveSite(y.config, douyinSiteURL).httpHeaders()
+	headers["Accept"] = "*/*"
+
+	logrus.Infof("开始下载抖音视频: %s -> %s", videoURL, outputFile)
+	if _, err := httpDownload(ctx, y.proxies.downloadClient(), videoURL, headers, outputFile, stages.report); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("下载已取消: %w", ctx.Err())
+		}
+		return "", fmt.Errorf("下载抖音视频失败: %w", err)
+	}
+
+	return toAudio(ctx, req, outputFile, stages.report)
+}
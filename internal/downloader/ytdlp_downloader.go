@@ -3,6 +3,7 @@ package downloader
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -15,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"video-hunter/internal/config"
@@ -24,7 +26,9 @@ import (
 
 // YtdlpDownloader 使用yt-dlp的下载器实现
 type YtdlpDownloader struct {
-	config *config.Config
+	config  *config.Config
+	proxies *ProxyRouter
+	tracker *taskTracker
 }
 
 // Config 下载器配置
@@ -36,8 +40,8 @@ type Config struct {
 	Timeout    int
 }
 
-// NewYtdlpDownloader 创建新的yt-dlp下载器
-func NewYtdlpDownloader(config *config.Config) *YtdlpDownloader {
+// NewYtdlpDownloader 创建新的yt-dlp下载器，proxies 为服务共享的代理路由
+func NewYtdlpDownloader(config *config.Config, proxies *ProxyRouter) *YtdlpDownloader {
 	if config.YtDlp.Path == "" {
 		config.YtDlp.Path = "yt-dlp" // 默认使用系统 PATH 中的 yt-dlp
 	}
@@ -50,12 +54,197 @@ func NewYtdlpDownloader(config *config.Config) *YtdlpDownloader {
 	}
 
 	return &YtdlpDownloader{
-		config: config,
+		config:  config,
+		proxies: proxies,
+		tracker: newTaskTracker(),
 	}
 }
 
+// Name 提供者名称
+func (y *YtdlpDownloader) Name() string {
+	return "ytdlp"
+}
+
+// Priority yt-dlp作为通用下载器，优先级最低
+func (y *YtdlpDownloader) Priority() int {
+	return 0
+}
+
+// CanHandle yt-dlp可以尝试处理所有http(s)链接
+func (y *YtdlpDownloader) CanHandle(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// GetProgress 获取下载进度
+func (y *YtdlpDownloader) GetProgress(id string) (*DownloadResponse, error) {
+	return y.tracker.get(id)
+}
+
+// Cancel 取消下载
+func (y *YtdlpDownloader) Cancel(id string) error {
+	return y.tracker.cancel(id)
+}
+
+// Download 实现下载接口：执行yt-dlp下载，并完成文件重命名、音视频合并等后处理
+func (y *YtdlpDownloader) Download(ctx context.Context, req *DownloadRequest, callback ProgressCallback) (*DownloadResponse, error) {
+	ctx, resp := y.tracker.start(ctx, req.TaskID, "")
+	defer y.tracker.finish(req.TaskID)
+
+	// 按下载阶段换算总进度
+	stages := newStageTracker(func(progress *DownloadResponse) {
+		y.tracker.update(req.TaskID, progress)
+		if callback != nil {
+			callback(progress)
+		}
+	})
+	stages.enter(StageResolving)
+
+	// 请求未指定格式时使用网站配置的默认格式，音频模式只下载音频
+	if req.Format == "" {
+		if req.Mode == ModeAudio {
+			req.Format = "bestaudio/best"
+		} else {
+			req.Format = resolveSite(y.config, req.URL).Format
+		}
+	}
+
+	// 特殊处理Pinterest视频
+	if req.Mode != ModeAudio && strings.Contains(req.URL, "pinterest.com") && (req.Format == "best" || req.Format == "") {
+		req.Format = y.selectPinterestFormat(ctx, req.URL)
+	}
+
+	actualFile, err := y.downloadFile(ctx, req, stages)
+	if err != nil {
+		return nil, err
+	}
+
+	// 验证文件是否真的存在
+	if _, err := os.Stat(actualFile); err != nil {
+		return nil, fmt.Errorf("下载完成但文件不存在: %v", err)
+	}
+
+	actualFile = y.finalizeFile(ctx, req.TaskID, actualFile, stages)
+
+	resp.Status = StatusCompleted
+	resp.Progress = 100
+	resp.File = actualFile
+	if fileInfo, err := os.Stat(actualFile); err == nil {
+		resp.Size = fileInfo.Size()
+	}
+	resp.Updated = time.Now()
+	return resp, nil
+}
+
+// selectPinterestFormat 为Pinterest视频选择最高分辨率的视频格式并组合音频格式
+func (y *YtdlpDownloader) selectPinterestFormat(ctx context.Context, url string) string {
+	// 先获取视频信息，找出可用的格式
+	info, err := y.GetVideoInfo(ctx, url)
+	if err != nil || len(info.Formats) == 0 {
+		// 如果获取视频信息失败，使用默认的best格式
+		logrus.Infof("检测到Pinterest视频，使用默认best格式")
+		return "best"
+	}
+
+	// 查找视频格式和音频格式
+	var videoFormat, audioFormat string
+	var maxHeight int
+
+	// 查找最高分辨率的视频格式
+	for _, format := range info.Formats {
+		if strings.Contains(format.Resolution, "x") {
+			parts := strings.Split(format.Resolution, "x")
+			if len(parts) == 2 {
+				if height, err := strconv.Atoi(parts[1]); err == nil {
+					if height > maxHeight {
+						maxHeight = height
+						videoFormat = format.FormatID
+					}
+				}
+			}
+		}
+	}
+
+	// 查找音频格式
+	for _, format := range info.Formats {
+		if strings.Contains(format.Resolution, "audio only") {
+			audioFormat = format.FormatID
+			break
+		}
+	}
+
+	// 如果找到了视频和音频格式，组合它们
+	if videoFormat != "" && audioFormat != "" {
+		logrus.Infof("检测到Pinterest视频，自动选择最佳格式: %s", videoFormat+"+"+audioFormat)
+		return videoFormat + "+" + audioFormat
+	} else if videoFormat != "" {
+		// 只找到视频格式
+		logrus.Infof("检测到Pinterest视频，自动选择视频格式: %s", videoFormat)
+		return videoFormat
+	}
+
+	// 使用默认的best格式
+	logrus.Infof("检测到Pinterest视频，使用默认best格式")
+	return "best"
+}
+
+// finalizeFile 确保文件名包含任务ID，并合并yt-dlp遗留的分离音视频文件
+func (y *YtdlpDownloader) finalizeFile(ctx context.Context, id, actualFile string, stages *stageTracker) string {
+	// 确保文件名包含任务ID
+	if id != "" && !strings.Contains(filepath.Base(actualFile), id) {
+		// 如果文件名不包含任务ID，尝试重命名文件
+		dir := filepath.Dir(actualFile)
+		ext := filepath.Ext(actualFile)
+		base := strings.TrimSuffix(filepath.Base(actualFile), ext)
+		newPath := filepath.Join(dir, id+"_"+base+ext)
+
+		if renameErr := os.Rename(actualFile, newPath); renameErr == nil {
+			actualFile = newPath
+			logrus.Infof("文件已重命名为: %s", actualFile)
+		} else {
+			logrus.Warnf("无法重命名文件 [%s]: %v", id, renameErr)
+		}
+	}
+
+	// 检查是否有对应的音频文件
+	if strings.Contains(actualFile, ".f") && strings.HasSuffix(actualFile, ".mp4") {
+		videoFile := actualFile
+		audioFile := ""
+
+		// 尝试查找对应的音频文件
+		filePattern := strings.TrimSuffix(actualFile, filepath.Ext(actualFile)) + ".*"
+		matches, _ := filepath.Glob(filePattern)
+
+		for _, match := range matches {
+			if match != actualFile && (strings.Contains(match, ".m4a") || strings.Contains(match, ".mp3") || strings.Contains(match, ".aac") || strings.Contains(match, ".opus")) {
+				audioFile = match
+				break
+			}
+		}
+
+		// 如果找到了音频文件，尝试合并
+		if audioFile != "" {
+			logrus.Infof("找到对应的音频文件: %s", audioFile)
+			mergedPath := filepath.Join(filepath.Dir(videoFile), id+"_merged"+filepath.Ext(videoFile))
+
+			// 使用ffmpeg合并视频和音频
+			stages.enter(StageMerging)
+			cmd := newCommand(ctx, "ffmpeg", "-i", videoFile, "-i", audioFile, "-c", "copy", mergedPath)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err == nil {
+				logrus.Infof("成功合并视频和音频到: %s", mergedPath)
+				actualFile = mergedPath
+			} else {
+				logrus.Errorf("合并视频和音频失败: %v, 错误输出: %s", err, stderr.String())
+			}
+		}
+	}
+
+	return actualFile
+}
+
 // GetVideoInfo 获取视频信息
-func (y *YtdlpDownloader) GetVideoInfo(url string) (*VideoInfo, error) {
+func (y *YtdlpDownloader) GetVideoInfo(ctx context.Context, url string) (*VideoInfo, error) {
 	// 对抖音视频使用专用解析方法
 	if strings.Contains(url, "douyin.com") || strings.Contains(url, "v.douyin.com") {
 		logrus.Info("检测到抖音视频，使用专用解析方法")
@@ -64,7 +253,7 @@ func (y *YtdlpDownloader) GetVideoInfo(url string) (*VideoInfo, error) {
 		url = y.convertDouyinUrl(url)
 
 		// 尝试直接获取抖音视频地址
-		videoURL, title, err := y.getDouyinRealUrl(url)
+		videoURL, title, err := y.getDouyinRealUrl(ctx, url)
 		if err == nil && videoURL != "" {
 			// 成功获取到视频地址，构造VideoInfo
 			info := &VideoInfo{
@@ -128,105 +317,27 @@ func (y *YtdlpDownloader) GetVideoInfo(url string) (*VideoInfo, error) {
 	args := []string{
 		"--dump-json",
 		"--no-playlist",
-		"--user-agent", y.config.YtDlp.UserAgent,
 		"--no-warnings",
 	}
-
-	// 为特定网站添加特殊请求头
-	if strings.Contains(url, "spankbang.com") {
-		args = append(args,
-			"--add-header", "Accept: text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8",
-			"--add-header", "Accept-Language: en-US,en;q=0.5",
-			"--add-header", "Accept-Encoding: gzip, deflate",
-			"--add-header", "DNT: 1",
-			"--add-header", "Connection: keep-alive",
-			"--add-header", "Upgrade-Insecure-Requests: 1",
-		)
-	} else if strings.Contains(url, "bilibili.com") {
-		// 为B站添加特殊请求头
-		bilibiliHeaders := map[string]string{
-			"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9",
-			"Accept-Language":           "zh-CN,zh;q=0.9,en;q=0.8",
-			"Accept-Encoding":           "gzip, deflate, br",
-			"DNT":                       "1",
-			"Connection":                "keep-alive",
-			"Upgrade-Insecure-Requests": "1",
-			"Sec-Fetch-Dest":            "document",
-			"Sec-Fetch-Mode":            "navigate",
-			"Sec-Fetch-Site":            "none",
-		}
-
-		// 添加B站特殊请求头
-		for key, value := range bilibiliHeaders {
-			args = append(args, "--add-header", fmt.Sprintf("%s: %s", key, value))
-		}
-
-		// 添加额外参数
-		args = append(args, "--no-check-certificate")
-	} else if strings.Contains(url, "douyin.com") || strings.Contains(url, "v.douyin.com") {
-		// 将抖音链接转换为短链接
-		url = y.convertDouyinUrl(url)
-
-		// 为抖音添加特殊请求头
-		douyinHeaders := map[string]string{
-			"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9",
-			"Accept-Language":           "zh-CN,zh;q=0.9,en;q=0.8",
-			"Accept-Encoding":           "gzip, deflate, br",
-			"DNT":                       "1",
-			"Connection":                "keep-alive",
-			"Upgrade-Insecure-Requests": "1",
-			"Sec-Fetch-Dest":            "document",
-			"Sec-Fetch-Mode":            "navigate",
-			"Sec-Fetch-Site":            "none",
-		}
-
-		// 添加抖音特殊请求头
-		for key, value := range douyinHeaders {
-			args = append(args, "--add-header", fmt.Sprintf("%s: %s", key, value))
-		}
-
-		// 添加抖音特定参数，不使用--cookies-from-browser
-		args = append(args, "--extractor-args", "douyin:app_version=9.9.10")
-		args = append(args, "--extractor-args", "douyin:device_platform=android")
-
-		// 使用移动端UA，这对抖音下载很重要
-		mobileUA := "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"
-		args = append(args, "--add-header", "User-Agent: "+mobileUA)
-		args = append(args, "--user-agent", mobileUA)
-
-		// 添加额外参数
-		args = append(args, "--no-check-certificate")
-	} else if strings.Contains(url, "pinterest.com") {
-		// 为Pinterest添加特殊请求头
-		pinterestHeaders := map[string]string{
-			"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9",
-			"Accept-Language":           "en-US,en;q=0.9",
-			"Accept-Encoding":           "gzip, deflate, br",
-			"DNT":                       "1",
-			"Connection":                "keep-alive",
-			"Upgrade-Insecure-Requests": "1",
-		}
-
-		// 添加Pinterest特殊请求头
-		for key, value := range pinterestHeaders {
-			args = append(args, "--add-header", fmt.Sprintf("%s: %s", key, value))
-		}
-	}
+	// 网站配置中的请求头、User-Agent、Referer 等
+	args = append(args, resolveSite(y.config, url).ytdlpArgs(ctx, nil)...)
+	proxy := y.proxies.route(url)
+	args = append(args, proxy.ytdlpArgs()...)
 
 	args = append(args, url)
-	cmd := exec.Command(y.config.YtDlp.Path, args...)
+	cmd := newCommand(ctx, y.config.YtDlp.Path, args...)
 
-	// 打印调试信息
-	fmt.Printf("DEBUG: 执行命令: %s %v\n", y.config.YtDlp.Path, args)
+	logrus.Debugf("执行命令: %s", commandLine(y.config.YtDlp.Path, args))
 
 	// 捕获错误输出
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
 	output, err := cmd.Output()
+	proxy.reportCommand(err, stderr.String())
 	if err != nil {
 		// 构造用户友好的错误信息
-		errMsg := stderr.String()
+		errMsg := redactCredentials(stderr.String())
 		info := &VideoInfo{
 			Title: "视频信息获取失败",
 			Formats: []VideoFormat{
@@ -275,7 +386,7 @@ func (y *YtdlpDownloader) GetVideoInfo(url string) (*VideoInfo, error) {
 			"original_error": fmt.Sprintf("获取视频信息失败: %v, stderr: %s", err, errMsg),
 		}
 
-		return info, fmt.Errorf("获取视频信息失败: %w, stderr: %s", err, stderr.String())
+		return info, fmt.Errorf("获取视频信息失败: %w, stderr: %s", err, errMsg)
 	}
 
 	// 检查输出是否为空
@@ -310,6 +421,11 @@ func (y *YtdlpDownloader) GetVideoInfo(url string) (*VideoInfo, error) {
 					Filesize:   getInt64(formatMap, "filesize"),
 					URL:        getString(formatMap, "url"),
 					Quality:    getString(formatMap, "quality"),
+					VCodec:     getString(formatMap, "vcodec"),
+					ACodec:     getString(formatMap, "acodec"),
+				}
+				if videoFormat.Filesize == 0 {
+					videoFormat.Filesize = getInt64(formatMap, "filesize_approx")
 				}
 				info.Formats = append(info.Formats, videoFormat)
 			}
@@ -319,18 +435,18 @@ func (y *YtdlpDownloader) GetVideoInfo(url string) (*VideoInfo, error) {
 	return info, nil
 }
 
-// Download 下载视频
-func (y *YtdlpDownloader) Download(req *DownloadRequest, progressCallback func(*DownloadResponse)) (string, error) {
-	// 对B站视频使用专用下载方法
-	if strings.Contains(req.URL, "bilibili.com") {
+// downloadFile 执行yt-dlp下载并返回实际文件路径，ctx 取消时会结束yt-dlp及其子进程
+func (y *YtdlpDownloader) downloadFile(ctx context.Context, req *DownloadRequest, stages *stageTracker) (string, error) {
+	// 对B站视频使用专用下载方法，音频模式不需要合并音视频
+	if req.Mode != ModeAudio && strings.Contains(req.URL, "bilibili.com") {
 		logrus.Info("检测到B站视频，使用专用下载方法")
-		return y.DownloadBilibili(req, progressCallback)
+		return y.downloadBilibili(ctx, req, stages)
 	}
 
 	// 对抖音视频使用专用下载方法
 	if strings.Contains(req.URL, "douyin.com") || strings.Contains(req.URL, "v.douyin.com") {
 		logrus.Info("检测到抖音视频，使用专用下载方法")
-		return y.DownloadDouyin(req, progressCallback)
+		return y.downloadDouyin(ctx, req, stages)
 	}
 
 	if y.config.YtDlp.Path == "" {
@@ -345,113 +461,25 @@ func (y *YtdlpDownloader) Download(req *DownloadRequest, progressCallback func(*
 	// 基本参数
 	args := []string{
 		"--no-playlist",
-		"--user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
 		"--no-warnings",
-		"-v",        // 添加详细输出
-		"--newline", // 确保每行都有换行符，便于解析
-	}
-
-	// 为SpankBang添加特殊请求头
-	if strings.Contains(req.URL, "spankbang.com") {
-		spankbangHeaders := map[string]string{
-			"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8",
-			"Accept-Language":           "en-US,en;q=0.5",
-			"Accept-Encoding":           "gzip, deflate",
-			"DNT":                       "1",
-			"Connection":                "keep-alive",
-			"Upgrade-Insecure-Requests": "1",
-			"Sec-Fetch-Dest":            "document",
-			"Sec-Fetch-Mode":            "navigate",
-			"Sec-Fetch-Site":            "none",
-			"Sec-Fetch-User":            "?1",
-			"Cache-Control":             "max-age=0",
-		}
-
-		// 添加SpankBang特殊请求头
-		for key, value := range spankbangHeaders {
-			args = append(args, "--add-header", fmt.Sprintf("%s: %s", key, value))
-		}
-
-		// 添加Referer
-		if req.Referer == "" {
-			args = append(args, "--referer", "https://spankbang.com/")
-		}
-	} else if strings.Contains(req.URL, "bilibili.com") {
-		// 为B站添加特殊请求头
-		bilibiliHeaders := map[string]string{
-			"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9",
-			"Accept-Language":           "zh-CN,zh;q=0.9,en;q=0.8",
-			"Accept-Encoding":           "gzip, deflate, br",
-			"DNT":                       "1",
-			"Connection":                "keep-alive",
-			"Upgrade-Insecure-Requests": "1",
-			"Sec-Fetch-Dest":            "document",
-			"Sec-Fetch-Mode":            "navigate",
-			"Sec-Fetch-Site":            "none",
-		}
-
-		// 添加B站特殊请求头
-		for key, value := range bilibiliHeaders {
-			args = append(args, "--add-header", fmt.Sprintf("%s: %s", key, value))
-		}
-
-		// 如果用户提供了cookies，使用它
-		if req.Cookies != "" {
-			args = append(args, "--cookies", req.Cookies)
-		}
+		"-v", // 添加详细输出
 	}
+	// 以 JSON 格式逐行输出进度，便于解析
+	args = append(args, ytdlpProgressArgs()...)
 
-	// 添加自定义请求头
-	if req.Headers != nil {
-		for key, value := range req.Headers {
-			args = append(args, "--add-header", fmt.Sprintf("%s: %s", key, value))
-		}
-	}
+	// 网站配置和请求中的请求头、User-Agent、Referer、Cookies 等
+	args = append(args, resolveSite(y.config, req.URL).ytdlpArgs(ctx, req)...)
+	proxy := y.proxies.route(req.URL)
+	args = append(args, proxy.ytdlpArgs()...)
 
-	// 添加Cookies
-	if req.Cookies != "" {
-		args = append(args, "--cookies", req.Cookies)
-	}
-
-	// 添加Referer
-	if req.Referer != "" {
-		args = append(args, "--referer", req.Referer)
-	}
-
-	// 添加格式选择
-	if req.Format != "" {
-		// 对B站视频使用特殊格式处理
-		if strings.Contains(req.URL, "bilibili.com") {
-			// 对于B站视频，使用可用的最佳格式而不是特定格式
-			// 这样可以避免请求需要会员的格式
-			if req.Format == "best" {
-				// 使用更通用的格式选择，确保同时下载视频和音频
-				args = append(args, "-f", "bestvideo+bestaudio/best")
-			} else {
-				// 如果用户指定了具体格式，尝试使用它，但添加备选项
-				args = append(args, "-f", req.Format+"/bestvideo+bestaudio/best")
-			}
-
-			// 确保合并视频和音频
-			args = append(args, "--merge-output-format", "mp4")
-
-			// 检查系统中是否安装了ffmpeg
-			_, err := exec.LookPath("ffmpeg")
-			if err == nil {
-				// 只有在ffmpeg存在的情况下才添加ffmpeg相关参数
-				args = append(args, "--ffmpeg-location", "ffmpeg")
-			} else {
-				logrus.Warnf("系统中未安装ffmpeg，视频和音频将不会被合并: %v", err)
-				logrus.Warnf("请安装ffmpeg以获得带音频的视频")
-			}
-
-			// 添加额外的参数以确保正确下载
-			args = append(args, "--no-check-certificate")
-		} else {
-			// 其他网站使用正常的格式选择
-			args = append(args, "-f", req.Format)
-		}
+	// 格式表达式翻译为 -f 和 -S
+	spec, err := ParseFormat(req.Format)
+	if err != nil {
+		return "", err
 	}
+	args = append(args, spec.Args()...)
+	// 音频模式：-x --audio-format --audio-quality
+	args = append(args, audioArgs(req)...)
 
 	// 添加其他选项
 	if req.Options != nil {
@@ -464,6 +492,17 @@ func (y *YtdlpDownloader) Download(req *DownloadRequest, progressCallback func(*
 		}
 	}
 
+	// 使用 aria2c 多连接下载
+	if aria2 := y.aria2Args(req); len(aria2) > 0 {
+		args = append(args, aria2...)
+		stages.useAria2()
+	}
+
+	// 下载存档：跳过已记录的视频，下载成功后记录
+	if req.Archive != "" {
+		args = append(args, "--download-archive", req.Archive)
+	}
+
 	// 生成带有任务ID前缀的输出文件名
 	outputTemplate := ""
 	if req.Output != "" {
@@ -473,7 +512,11 @@ func (y *YtdlpDownloader) Download(req *DownloadRequest, progressCallback func(*
 		outputTemplate = filepath.Join(dir, fmt.Sprintf("%s_%s", req.TaskID, filename))
 	} else {
 		// 默认输出路径
-		outputTemplate = filepath.Join(y.config.Downloader.OutputDir, fmt.Sprintf("%s_%(title)s.%(ext)s", req.TaskID))
+		outputTemplate = filepath.Join(y.config.Downloader.OutputDir, req.TaskID+"_%(title)s.%(ext)s")
+	}
+	// 音频模式由 yt-dlp 决定扩展名，固定的 .mp4 会让提取后的音频仍使用 .mp4
+	if req.Mode == ModeAudio {
+		outputTemplate = strings.TrimSuffix(outputTemplate, filepath.Ext(outputTemplate)) + ".%(ext)s"
 	}
 	args = append(args, "-o", outputTemplate)
 
@@ -481,8 +524,8 @@ func (y *YtdlpDownloader) Download(req *DownloadRequest, progressCallback func(*
 	args = append(args, req.URL)
 
 	// 创建独立的命令实例
-	cmd := exec.Command(y.config.YtDlp.Path, args...)
-	logrus.Infof("执行命令: %s %v", y.config.YtDlp.Path, args)
+	cmd := newCommand(ctx, y.config.YtDlp.Path, args...)
+	logrus.Infof("执行命令: %s", commandLine(y.config.YtDlp.Path, args))
 
 	// 创建管道读取输出
 	stdout, err := cmd.StdoutPipe()
@@ -508,6 +551,7 @@ func (y *YtdlpDownloader) Download(req *DownloadRequest, progressCallback func(*
 	var stderrOutput strings.Builder
 	var stdoutOutput strings.Builder
 	var actualFilePath string
+	var archived atomic.Bool
 
 	// 处理标准输出
 	go func() {
@@ -526,15 +570,14 @@ func (y *YtdlpDownloader) Download(req *DownloadRequest, progressCallback func(*
 				}
 			}
 
-			// 解析进度信息并调用回调
-			if strings.Contains(line, "%") {
-				progress := parseProgress(line)
-				if progressCallback != nil {
-					logrus.Debugf("发送进度更新: %f%% %s", progress.Progress, progress.Speed)
-					progressCallback(progress)
-				}
+			// 视频已在下载存档中
+			if strings.Contains(line, "has already been recorded in the archive") {
+				archived.Store(true)
 			}
 
+			// 解析格式选择和进度信息
+			stages.handleLine(line)
+
 			// 记录所有输出到日志
 			logrus.Debug("yt-dlp stdout:", line)
 		}
@@ -557,35 +600,45 @@ func (y *YtdlpDownloader) Download(req *DownloadRequest, progressCallback func(*
 				}
 			}
 
-			// 解析进度信息并调用回调
-			if strings.Contains(line, "%") {
-				progress := parseProgress(line)
-				if progressCallback != nil {
-					logrus.Debugf("从stderr发送进度更新: %f%% %s", progress.Progress, progress.Speed)
-					progressCallback(progress)
-				}
-			}
+			// 解析格式选择和进度信息
+			stages.handleLine(line)
 
 			logrus.Debug("yt-dlp stderr:", line)
 		}
 	}()
 
-	// 等待命令完成
-	err = cmd.Wait()
+	// 等待输出读取完毕后再回收进程
 	wg.Wait()
+	err = cmd.Wait()
+
+	// 任务被取消时不再处理输出
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("下载已取消: %w", ctx.Err())
+	}
+	proxy.reportCommand(err, stderrOutput.String())
 
 	if err != nil {
-		// 如果有错误，返回详细的错误信息
-		errMsg := fmt.Sprintf("下载失败: %v\n命令: %s %v\n标准输出:\n%s\n错误输出:\n%s",
-			err, y.config.YtDlp.Path, args, stdoutOutput.String(), stderrOutput.String())
-		logrus.Error(errMsg)
-		return "", fmt.Errorf(errMsg)
+		// 如果有错误，返回包含错误输出的详细错误信息，用于判断是否可以重试
+		procErr := &ProcessError{
+			Command: y.config.YtDlp.Path,
+			Args:    args,
+			Stdout:  stdoutOutput.String(),
+			Stderr:  stderrOutput.String(),
+			Err:     err,
+		}
+		logrus.Error(procErr.Error())
+		return "", procErr
+	}
+
+	if archived.Load() {
+		logrus.Infof("视频已在下载存档中 [%s]: %s", req.TaskID, req.URL)
+		return "", ErrArchived
 	}
 
 	// 如果没有从输出中获取到实际文件路径，尝试查找最近创建的文件
 	if actualFilePath == "" {
 		logrus.Warn("未从输出中检测到实际文件路径，尝试查找最近创建的文件")
-		foundPath, err := y.findActualFile(outputTemplate)
+		foundPath, err := y.findActualFile(ctx, outputTemplate)
 		if err != nil {
 			logrus.Errorf("查找实际文件失败: %v", err)
 			// 如果找不到实际文件，返回错误
@@ -604,11 +657,11 @@ func (y *YtdlpDownloader) Download(req *DownloadRequest, progressCallback func(*
 	// 确保文件存在且可访问
 	if _, err := os.Stat(actualFilePath); err != nil {
 		logrus.Warnf("检测到的文件路径不存在或不可访问: %s, 错误: %v", actualFilePath, err)
-		// 尝试使用相对路径
-		relPath := filepath.Join(y.config.Downloader.OutputDir, filepath.Base(actualFilePath))
+		// 尝试在输出目录中查找同名文件
+		relPath := filepath.Join(filepath.Dir(outputTemplate), filepath.Base(actualFilePath))
 		if _, err := os.Stat(relPath); err == nil {
 			actualFilePath = relPath
-			logrus.Infof("使用相对路径访问文件: %s", actualFilePath)
+			logrus.Infof("使用输出目录中的文件: %s", actualFilePath)
 		} else {
 			// 如果仍然找不到，返回模板路径
 			logrus.Warnf("无法访问下载的文件，返回模板路径: %s", outputTemplate)
@@ -616,6 +669,11 @@ func (y *YtdlpDownloader) Download(req *DownloadRequest, progressCallback func(*
 		}
 	}
 
+	// 音频模式返回提取后的音频文件
+	if req.Mode == ModeAudio {
+		actualFilePath = audioFileFor(actualFilePath)
+	}
+
 	// 返回实际下载的文件路径
 	return actualFilePath, nil
 }
@@ -623,7 +681,7 @@ func (y *YtdlpDownloader) Download(req *DownloadRequest, progressCallback func(*
 // processOutputFilename 处理输出文件名
 func (y *YtdlpDownloader) processOutputFilename(filename string) string {
 	// 清理文件名，移除不安全的字符
-	filename = y.sanitizeFilename(filename)
+	filename = sanitizeFilename(filename)
 
 	// 检查是否已经包含扩展名
 	if strings.Contains(filename, ".%(ext)s") {
@@ -646,7 +704,7 @@ func (y *YtdlpDownloader) processOutputFilename(filename string) string {
 }
 
 // sanitizeFilename 清理文件名，移除不安全的字符
-func (y *YtdlpDownloader) sanitizeFilename(filename string) string {
+func sanitizeFilename(filename string) string {
 	// 移除或替换不安全的字符
 	unsafeChars := []string{"/", "\\", ":", "*", "?", "\"", "<", ">", "|"}
 	result := filename
@@ -666,49 +724,6 @@ func (y *YtdlpDownloader) sanitizeFilename(filename string) string {
 	return result
 }
 
-// monitorProgress 监控下载进度
-func (y *YtdlpDownloader) monitorProgress(stdout, stderr interface{}, callback ProgressCallback) {
-	// 解析yt-dlp的输出来获取真实进度
-	scanner := bufio.NewScanner(stdout.(interface {
-		Read(p []byte) (n int, err error)
-	}).(interface {
-		Read(p []byte) (n int, err error)
-	}))
-
-	progressRegex := regexp.MustCompile(`(\d+\.?\d*)%`)
-	speedRegex := regexp.MustCompile(`(\d+\.?\d*)([KMGT]iB/s|B/s)`)
-	etaRegex := regexp.MustCompile(`ETA (\d+:\d+)`)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// 解析进度
-		if progressMatches := progressRegex.FindStringSubmatch(line); len(progressMatches) > 1 {
-			progress, _ := strconv.ParseFloat(progressMatches[1], 64)
-
-			// 解析速度
-			speed := ""
-			if speedMatches := speedRegex.FindStringSubmatch(line); len(speedMatches) > 2 {
-				speed = speedMatches[1] + " " + speedMatches[2]
-			}
-
-			// 解析ETA
-			eta := ""
-			if etaMatches := etaRegex.FindStringSubmatch(line); len(etaMatches) > 1 {
-				eta = etaMatches[1]
-			}
-
-			if callback != nil {
-				callback(&DownloadResponse{
-					Progress: progress,
-					Speed:    speed,
-					ETA:      eta,
-				})
-			}
-		}
-	}
-}
-
 // 辅助函数
 func getString(data map[string]interface{}, key string) string {
 	if val, ok := data[key]; ok {
@@ -733,8 +748,10 @@ func getInt64(data map[string]interface{}, key string) int64 {
 	return 0
 }
 
-// findActualFile 查找实际下载的文件
-func (y *YtdlpDownloader) findActualFile(outputTemplate string) (string, error) {
+// findActualFile 在输出模板所在的目录中查找实际下载的文件
+func (y *YtdlpDownloader) findActualFile(ctx context.Context, outputTemplate string) (string, error) {
+	dir := filepath.Dir(outputTemplate)
+
 	// 从输出模板中提取任务ID
 	templateBase := filepath.Base(outputTemplate)
 	var taskID string
@@ -747,11 +764,11 @@ func (y *YtdlpDownloader) findActualFile(outputTemplate string) (string, error)
 
 	// 如果没有任务ID，使用旧的方法查找最近创建的文件
 	if taskID == "" {
-		return y.findLatestFile()
+		return y.findLatestFile(dir)
 	}
 
 	// 扫描输出目录，查找匹配任务ID前缀的文件
-	files, err := os.ReadDir(y.config.Downloader.OutputDir)
+	files, err := os.ReadDir(dir)
 	if err != nil {
 		return "", fmt.Errorf("读取输出目录失败: %w", err)
 	}
@@ -783,7 +800,7 @@ func (y *YtdlpDownloader) findActualFile(outputTemplate string) (string, error)
 
 			// 检查文件名是否以任务ID开头
 			if strings.HasPrefix(file.Name(), taskID+"_") {
-				filePath := filepath.Join(y.config.Downloader.OutputDir, file.Name())
+				filePath := filepath.Join(dir, file.Name())
 				matchedFiles = append(matchedFiles, filePath)
 
 				// 检查是否是视频文件
@@ -826,10 +843,10 @@ func (y *YtdlpDownloader) findActualFile(outputTemplate string) (string, error)
 
 		// 创建合并后的文件名
 		mergedFilename := taskID + "_merged.mp4"
-		mergedPath := filepath.Join(y.config.Downloader.OutputDir, mergedFilename)
+		mergedPath := filepath.Join(dir, mergedFilename)
 
 		// 使用FFmpeg合并文件
-		cmd := exec.Command("ffmpeg", "-i", videoFile, "-i", audioFile, "-c:v", "copy", "-c:a", "aac", "-strict", "experimental", "-y", mergedPath)
+		cmd := newCommand(ctx, "ffmpeg", "-i", videoFile, "-i", audioFile, "-c:v", "copy", "-c:a", "aac", "-strict", "experimental", "-y", mergedPath)
 
 		// 设置标准错误输出，以便记录错误信息
 		var stderr bytes.Buffer
@@ -867,13 +884,13 @@ func (y *YtdlpDownloader) findActualFile(outputTemplate string) (string, error)
 
 	// 如果没有找到匹配任务ID的文件，回退到查找最近创建的文件
 	logrus.Warnf("未找到匹配任务ID %s 的文件，尝试查找最近创建的文件", taskID)
-	return y.findLatestFile()
+	return y.findLatestFile(dir)
 }
 
-// findLatestFile 查找最近创建的文件（作为备选方案）
-func (y *YtdlpDownloader) findLatestFile() (string, error) {
+// findLatestFile 查找输出目录中最近创建的文件（作为备选方案）
+func (y *YtdlpDownloader) findLatestFile(dir string) (string, error) {
 	// 扫描输出目录，查找最近创建的文件
-	files, err := os.ReadDir(y.config.Downloader.OutputDir)
+	files, err := os.ReadDir(dir)
 	if err != nil {
 		return "", fmt.Errorf("读取输出目录失败: %w", err)
 	}
@@ -924,12 +941,16 @@ func (y *YtdlpDownloader) findLatestFile() (string, error) {
 		return "", fmt.Errorf("未找到最近5分钟内创建的文件，最新文件创建于 %s", latestFile.ModTime().Format(time.RFC3339))
 	}
 
-	// 返回相对于工作区根目录的路径
-	return filepath.Join(y.config.Downloader.OutputDir, latestFile.Name()), nil
+	return filepath.Join(dir, latestFile.Name()), nil
 }
 
 // DownloadBilibili 专门处理B站视频下载
-func (y *YtdlpDownloader) DownloadBilibili(req *DownloadRequest, progressCallback func(*DownloadResponse)) (string, error) {
+func (y *YtdlpDownloader) DownloadBilibili(ctx context.Context, req *DownloadRequest, progressCallback func(*DownloadResponse)) (string, error) {
+	return y.downloadBilibili(ctx, req, newStageTracker(progressCallback))
+}
+
+// downloadBilibili 下载B站视频，分别报告视频、音频和合并阶段的进度
+func (y *YtdlpDownloader) downloadBilibili(ctx context.Context, req *DownloadRequest, stages *stageTracker) (string, error) {
 	if y.config.YtDlp.Path == "" {
 		return "", fmt.Errorf("yt-dlp 路径未配置，请检查 config.yaml 的 ytdlp.path")
 	}
@@ -942,56 +963,30 @@ func (y *YtdlpDownloader) DownloadBilibili(req *DownloadRequest, progressCallbac
 	// 基本参数 - 确保不包含--postprocessor-args
 	args := []string{
 		"--no-playlist",
-		"--user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
 		"--no-warnings",
-		"-v",        // 添加详细输出
-		"--newline", // 确保每行都有换行符，便于解析
-	}
-
-	// 为B站添加特殊请求头
-	bilibiliHeaders := map[string]string{
-		"Connection":                "keep-alive",
-		"Upgrade-Insecure-Requests": "1",
-		"Sec-Fetch-Mode":            "navigate",
-		"Sec-Fetch-Site":            "none",
-		"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9",
-		"Accept-Language":           "zh-CN,zh;q=0.9,en;q=0.8",
-		"Accept-Encoding":           "gzip, deflate, br",
-		"DNT":                       "1",
-		"Sec-Fetch-Dest":            "document",
+		"-v", // 添加详细输出
 	}
+	// 以 JSON 格式逐行输出进度，便于解析
+	args = append(args, ytdlpProgressArgs()...)
 
-	// 添加B站特殊请求头
-	for key, value := range bilibiliHeaders {
-		args = append(args, "--add-header", fmt.Sprintf("%s: %s", key, value))
-	}
-
-	// 如果用户提供了cookies，使用它
-	if req.Cookies != "" {
-		args = append(args, "--cookies", req.Cookies)
-	}
+	// 网站配置和请求中的请求头、User-Agent、Referer、Cookies 等
+	args = append(args, resolveSite(y.config, req.URL).ytdlpArgs(ctx, req)...)
+	proxy := y.proxies.route(req.URL)
+	args = append(args, proxy.ytdlpArgs()...)
 
-	// 添加自定义请求头
-	if req.Headers != nil {
-		for key, value := range req.Headers {
-			args = append(args, "--add-header", fmt.Sprintf("%s: %s", key, value))
-		}
+	// 格式表达式翻译为 -f、-S 和 --merge-output-format，与其他网站一致
+	spec, err := ParseFormat(req.Format)
+	if err != nil {
+		return "", err
 	}
-
-	// 添加格式选择 - 确保不使用--list-formats参数
-	if req.Format == "best" || req.Format == "" {
-		// 使用更通用的格式选择，确保同时下载视频和音频
+	if spec.Selector == "" {
+		// 没有指定格式选择器时，确保同时下载视频和音频
 		args = append(args, "-f", "bestvideo+bestaudio/best")
-	} else {
-		// 如果用户指定了具体格式，尝试使用它，但添加备选项
-		args = append(args, "-f", req.Format+"/bestvideo+bestaudio/best")
 	}
-
-	// 确保合并视频和音频
-	args = append(args, "--merge-output-format", "mp4")
+	args = append(args, spec.Args()...)
 
 	// 检查系统中是否安装了ffmpeg
-	_, err := exec.LookPath("ffmpeg")
+	_, err = exec.LookPath("ffmpeg")
 	if err == nil {
 		// 只有在ffmpeg存在的情况下才添加ffmpeg相关参数
 		args = append(args, "--ffmpeg-location", "ffmpeg")
@@ -1000,8 +995,16 @@ func (y *YtdlpDownloader) DownloadBilibili(req *DownloadRequest, progressCallbac
 		logrus.Warnf("请安装ffmpeg以获得带音频的视频")
 	}
 
-	// 添加额外的参数以确保正确下载
-	args = append(args, "--no-check-certificate")
+	// 使用 aria2c 多连接下载
+	if aria2 := y.aria2Args(req); len(aria2) > 0 {
+		args = append(args, aria2...)
+		stages.useAria2()
+	}
+
+	// 下载存档：跳过已记录的视频，下载成功后记录
+	if req.Archive != "" {
+		args = append(args, "--download-archive", req.Archive)
+	}
 
 	// 生成带有任务ID前缀的输出文件名
 	outputTemplate := ""
@@ -1012,7 +1015,7 @@ func (y *YtdlpDownloader) DownloadBilibili(req *DownloadRequest, progressCallbac
 		outputTemplate = filepath.Join(dir, fmt.Sprintf("%s_%s", req.TaskID, filename))
 	} else {
 		// 默认输出路径
-		outputTemplate = filepath.Join(y.config.Downloader.OutputDir, fmt.Sprintf("%s_%(title)s.%(ext)s", req.TaskID))
+		outputTemplate = filepath.Join(y.config.Downloader.OutputDir, req.TaskID+"_%(title)s.%(ext)s")
 	}
 	args = append(args, "-o", outputTemplate)
 
@@ -1020,8 +1023,8 @@ func (y *YtdlpDownloader) DownloadBilibili(req *DownloadRequest, progressCallbac
 	args = append(args, req.URL)
 
 	// 创建独立的命令实例
-	cmd := exec.Command(y.config.YtDlp.Path, args...)
-	logrus.Infof("执行命令: %s %v", y.config.YtDlp.Path, args)
+	cmd := newCommand(ctx, y.config.YtDlp.Path, args...)
+	logrus.Infof("执行命令: %s", commandLine(y.config.YtDlp.Path, args))
 
 	// 创建管道读取输出
 	stdout, err := cmd.StdoutPipe()
@@ -1047,6 +1050,7 @@ func (y *YtdlpDownloader) DownloadBilibili(req *DownloadRequest, progressCallbac
 	var stderrOutput strings.Builder
 	var stdoutOutput strings.Builder
 	var actualFilePath string
+	var archived atomic.Bool
 	var videoFile string
 	var audioFile string
 
@@ -1075,14 +1079,14 @@ func (y *YtdlpDownloader) DownloadBilibili(req *DownloadRequest, progressCallbac
 				}
 			}
 
-			// 解析进度信息并调用回调
-			if strings.Contains(line, "%") {
-				progress := parseProgress(line)
-				if progressCallback != nil {
-					progressCallback(progress)
-				}
+			// 视频已在下载存档中
+			if strings.Contains(line, "has already been recorded in the archive") {
+				archived.Store(true)
 			}
 
+			// 解析格式选择和进度信息
+			stages.handleLine(line)
+
 			// 记录所有输出到日志
 			logrus.Debug("yt-dlp stdout:", line)
 		}
@@ -1113,28 +1117,30 @@ func (y *YtdlpDownloader) DownloadBilibili(req *DownloadRequest, progressCallbac
 				}
 			}
 
-			// 解析进度信息并调用回调
-			if strings.Contains(line, "%") {
-				progress := parseProgress(line)
-				if progressCallback != nil {
-					progressCallback(progress)
-				}
-			}
+			// 解析格式选择和进度信息
+			stages.handleLine(line)
 
 			logrus.Debug("yt-dlp stderr:", line)
 		}
 	}()
 
-	// 等待命令完成
-	err = cmd.Wait()
+	// 等待输出读取完毕后再回收进程
 	wg.Wait()
+	err = cmd.Wait()
+
+	// 任务被取消时不再尝试合并
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("下载已取消: %w", ctx.Err())
+	}
+	proxy.reportCommand(err, stderrOutput.String())
 
 	if err != nil {
 		// 如果有错误，但是已经下载了文件，尝试手动合并
 		if videoFile != "" && audioFile != "" {
 			logrus.Warnf("yt-dlp合并失败，但已下载视频和音频文件，尝试手动合并")
-			mergedFile := filepath.Join(y.config.Downloader.OutputDir, req.TaskID+"_merged.mp4")
-			if mergedPath, mergeErr := y.mergeVideoAndAudio(videoFile, audioFile, mergedFile); mergeErr == nil {
+			mergedFile := filepath.Join(filepath.Dir(outputTemplate), req.TaskID+"_merged.mp4")
+			stages.enter(StageMerging)
+			if mergedPath, mergeErr := y.mergeVideoAndAudio(ctx, videoFile, audioFile, mergedFile); mergeErr == nil {
 				logrus.Infof("手动合并成功: %s", mergedPath)
 				return mergedPath, nil
 			} else {
@@ -1150,16 +1156,26 @@ func (y *YtdlpDownloader) DownloadBilibili(req *DownloadRequest, progressCallbac
 		}
 
 		// 如果没有下载任何文件，返回错误
-		errMsg := fmt.Sprintf("下载失败: %v\n命令: %s %v\n标准输出:\n%s\n错误输出:\n%s",
-			err, y.config.YtDlp.Path, args, stdoutOutput.String(), stderrOutput.String())
-		logrus.Error(errMsg)
-		return "", fmt.Errorf(errMsg)
+		procErr := &ProcessError{
+			Command: y.config.YtDlp.Path,
+			Args:    args,
+			Stdout:  stdoutOutput.String(),
+			Stderr:  stderrOutput.String(),
+			Err:     err,
+		}
+		logrus.Error(procErr.Error())
+		return "", procErr
+	}
+
+	if archived.Load() {
+		logrus.Infof("视频已在下载存档中 [%s]: %s", req.TaskID, req.URL)
+		return "", ErrArchived
 	}
 
 	// 如果没有从输出中获取到实际文件路径，尝试查找最近创建的文件
 	if actualFilePath == "" {
 		logrus.Warn("未从输出中检测到实际文件路径，尝试查找最近创建的文件")
-		foundPath, err := y.findActualFile(outputTemplate)
+		foundPath, err := y.findActualFile(ctx, outputTemplate)
 		if err != nil {
 			logrus.Errorf("查找实际文件失败: %v", err)
 			// 如果找不到实际文件，返回错误
@@ -1178,8 +1194,9 @@ func (y *YtdlpDownloader) DownloadBilibili(req *DownloadRequest, progressCallbac
 	// 检查是否需要手动合并视频和音频
 	if videoFile != "" && audioFile != "" && !strings.Contains(actualFilePath, "_merged") {
 		logrus.Info("检测到分离的视频和音频文件，尝试手动合并")
-		mergedFile := filepath.Join(y.config.Downloader.OutputDir, req.TaskID+"_merged.mp4")
-		if mergedPath, mergeErr := y.mergeVideoAndAudio(videoFile, audioFile, mergedFile); mergeErr == nil {
+		mergedFile := filepath.Join(filepath.Dir(outputTemplate), req.TaskID+"_merged.mp4")
+		stages.enter(StageMerging)
+		if mergedPath, mergeErr := y.mergeVideoAndAudio(ctx, videoFile, audioFile, mergedFile); mergeErr == nil {
 			logrus.Infof("手动合并成功: %s", mergedPath)
 			return mergedPath, nil
 		} else {
@@ -1192,26 +1209,6 @@ func (y *YtdlpDownloader) DownloadBilibili(req *DownloadRequest, progressCallbac
 	return actualFilePath, nil
 }
 
-// extractSpeed 从下载进度行中提取速度信息
-func extractSpeed(line string) string {
-	speedRegex := regexp.MustCompile(`(\d+\.?\d*[KMG]?i?B/s)`)
-	matches := speedRegex.FindStringSubmatch(line)
-	if len(matches) > 1 {
-		return matches[1]
-	}
-	return ""
-}
-
-// extractETA 从下载进度行中提取剩余时间信息
-func extractETA(line string) string {
-	etaRegex := regexp.MustCompile(`ETA:?\s*(\d+:\d+)`)
-	matches := etaRegex.FindStringSubmatch(line)
-	if len(matches) > 1 {
-		return matches[1]
-	}
-	return ""
-}
-
 // convertDouyinUrl 处理抖音链接
 func (y *YtdlpDownloader) convertDouyinUrl(url string) string {
 	// 如果是短链接，直接返回
@@ -1236,24 +1233,22 @@ func (y *YtdlpDownloader) convertDouyinUrl(url string) string {
 }
 
 // isValidURL 检查URL是否有效
-func (y *YtdlpDownloader) isValidURL(urlStr string) bool {
+func (y *YtdlpDownloader) isValidURL(ctx context.Context, urlStr string) bool {
 	// 创建HTTP客户端，只检查头信息
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse // 不跟随重定向
-		},
+	client := y.proxies.client(5 * time.Second)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse // 不跟随重定向
 	}
 
 	// 创建HEAD请求
-	req, err := http.NewRequest("HEAD", urlStr, nil)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", urlStr, nil)
 	if err != nil {
 		logrus.Debugf("创建HEAD请求失败: %v", err)
 		return false
 	}
 
 	// 添加请求头
-	req.Header.Set("User-Agent", y.config.Douyin.MobileUA)
+	resolveSite(y.config, douyinSiteURL).setHeaders(req.Header)
 
 	// 发送请求
 	resp, err := client.Do(req)
@@ -1268,24 +1263,21 @@ func (y *YtdlpDownloader) isValidURL(urlStr string) bool {
 }
 
 // getDouyinVideoByOfficialAPI 使用官方API获取抖音视频
-func (y *YtdlpDownloader) getDouyinVideoByOfficialAPI(videoID string) (string, string, error) {
+func (y *YtdlpDownloader) getDouyinVideoByOfficialAPI(ctx context.Context, videoID string) (string, string, error) {
 	// 构造API请求URL
 	apiURL := fmt.Sprintf("https://www.iesdouyin.com/web/api/v2/aweme/iteminfo/?item_ids=%s", videoID)
 
 	// 设置请求头，模拟移动端浏览器
-	client := &http.Client{
-		Timeout: time.Duration(y.config.Douyin.APITimeout) * time.Second,
-	}
-	req, err := http.NewRequest("GET", apiURL, nil)
+	client := y.proxies.client(time.Duration(y.config.Douyin.APITimeout) * time.Second)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return "", "", fmt.Errorf("创建请求失败: %w", err)
 	}
 
-	// 设置移动端User-Agent
-	req.Header.Set("User-Agent", y.config.Douyin.MobileUA)
+	// 设置网站配置中的请求头，接口返回 JSON
+	resolveSite(y.config, douyinSiteURL).setHeaders(req.Header)
 	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Referer", "https://www.douyin.com/")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	addCookies(ctx, req)
 
 	// 发送请求
 	resp, err := client.Do(req)
@@ -1338,7 +1330,7 @@ func (y *YtdlpDownloader) getDouyinVideoByOfficialAPI(videoID string) (string, s
 								// 替换域名，尝试获取无水印版本
 								noWatermarkURL := strings.Replace(url, "playwm", "play", 1)
 								// 验证URL是否有效
-								if y.isValidURL(noWatermarkURL) {
+								if y.isValidURL(ctx, noWatermarkURL) {
 									videoURL = noWatermarkURL
 									break
 								}
@@ -1361,25 +1353,22 @@ func (y *YtdlpDownloader) getDouyinVideoByOfficialAPI(videoID string) (string, s
 }
 
 // getDouyinVideoByMobileAPI 使用移动端API获取抖音视频
-func (y *YtdlpDownloader) getDouyinVideoByMobileAPI(videoID string) (string, string, error) {
+func (y *YtdlpDownloader) getDouyinVideoByMobileAPI(ctx context.Context, videoID string) (string, string, error) {
 	// 构造移动端API请求URL
 	apiURL := fmt.Sprintf("https://aweme.snssdk.com/aweme/v1/aweme/detail/?aweme_id=%s", videoID)
 
 	// 设置请求头，模拟移动端APP
-	client := &http.Client{
-		Timeout: time.Duration(y.config.Douyin.APITimeout) * time.Second,
-	}
-	req, err := http.NewRequest("GET", apiURL, nil)
+	client := y.proxies.client(time.Duration(y.config.Douyin.APITimeout) * time.Second)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return "", "", fmt.Errorf("创建请求失败: %w", err)
 	}
 
-	// 设置移动端APP的User-Agent和请求头
-	req.Header.Set("User-Agent", "com.ss.android.ugc.aweme/800 (Linux; U; Android 10; zh_CN; Pixel 4; Build/QQ3A.200805.001; Cronet/58.0.2991.0)")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9")
+	// 使用接口主机名匹配的网站配置（内置的 douyin-app 模拟抖音 APP）
+	resolveSite(y.config, apiURL).setHeaders(req.Header)
 	req.Header.Set("X-Khronos", fmt.Sprintf("%d", time.Now().Unix()))
 	req.Header.Set("X-Gorgon", "8404e4a20000"+fmt.Sprintf("%08x", time.Now().UnixNano()%0x100000000))
+	addCookies(ctx, req)
 
 	// 发送请求
 	resp, err := client.Do(req)
@@ -1417,7 +1406,7 @@ func (y *YtdlpDownloader) getDouyinVideoByMobileAPI(videoID string) (string, str
 					for _, u := range urlList {
 						if url, ok := u.(string); ok {
 							// 验证URL是否有效
-							if y.isValidURL(url) {
+							if y.isValidURL(ctx, url) {
 								videoURL = url
 								break
 							}
@@ -1439,7 +1428,7 @@ func (y *YtdlpDownloader) getDouyinVideoByMobileAPI(videoID string) (string, str
 }
 
 // getDouyinVideoByThirdPartyAPI 使用第三方解析服务获取抖音视频
-func (y *YtdlpDownloader) getDouyinVideoByThirdPartyAPI(url string) (string, string, error) {
+func (y *YtdlpDownloader) getDouyinVideoByThirdPartyAPI(ctx context.Context, url string) (string, string, error) {
 	// 这里可以添加多个第三方解析服务，如果一个失败可以尝试另一个
 	services := []string{
 		"https://api.douyin.wtf/api?url=",
@@ -1456,7 +1445,7 @@ func (y *YtdlpDownloader) getDouyinVideoByThirdPartyAPI(url string) (string, str
 
 	var lastError error
 	for _, service := range services {
-		videoURL, title, err := y.callThirdPartyAPI(service, url)
+		videoURL, title, err := y.callThirdPartyAPI(ctx, service, url)
 		if err == nil && videoURL != "" {
 			return videoURL, title, nil
 		}
@@ -1468,25 +1457,22 @@ func (y *YtdlpDownloader) getDouyinVideoByThirdPartyAPI(url string) (string, str
 }
 
 // callThirdPartyAPI 调用第三方解析API
-func (y *YtdlpDownloader) callThirdPartyAPI(serviceURL, videoURL string) (string, string, error) {
+func (y *YtdlpDownloader) callThirdPartyAPI(ctx context.Context, serviceURL, videoURL string) (string, string, error) {
 	// 构造API请求URL
 	apiURL := serviceURL + url.QueryEscape(videoURL)
 
 	logrus.Debugf("请求第三方API: %s", apiURL)
 
 	// 设置请求头
-	client := &http.Client{
-		Timeout: time.Duration(y.config.Douyin.APITimeout) * time.Second,
-	}
-	req, err := http.NewRequest("GET", apiURL, nil)
+	client := y.proxies.client(time.Duration(y.config.Douyin.APITimeout) * time.Second)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return "", "", fmt.Errorf("创建请求失败: %w", err)
 	}
 
-	// 设置请求头
-	req.Header.Set("User-Agent", y.config.Douyin.MobileUA)
+	// 设置网站配置中的请求头，接口返回 JSON
+	resolveSite(y.config, douyinSiteURL).setHeaders(req.Header)
 	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Referer", "https://www.douyin.com/")
 
 	// 发送请求
 	resp, err := client.Do(req)
@@ -1637,49 +1623,54 @@ func (y *YtdlpDownloader) extractVideoIDFromHTML(html string) string {
 }
 
 // getDouyinVideoByDirectCurl 使用curl命令获取抖音视频的真实地址
-func (y *YtdlpDownloader) getDouyinVideoByDirectCurl(url string) (string, string, error) {
+func (y *YtdlpDownloader) getDouyinVideoByDirectCurl(ctx context.Context, url string) (string, string, error) {
 	// 生成临时文件名
 	tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("douyin_%d.json", time.Now().UnixNano()))
 
-	// 构造curl命令
-	args := []string{
-		"-s", "-L",
-		"-A", y.config.Douyin.MobileUA,
-		"-H", "Accept: text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9",
-		"-H", "Accept-Language: zh-CN,zh;q=0.9,en;q=0.8",
-		"-H", "Connection: keep-alive",
-		"-H", "Upgrade-Insecure-Requests: 1",
-		"-H", "Sec-Fetch-Mode: navigate",
-		"-H", "Sec-Fetch-Site: none",
-		"-H", "Sec-Fetch-Dest: document",
-		"-H", "DNT: 1",
-		"-H", "Referer: https://www.douyin.com/",
+	// 构造curl命令，请求头来自网站配置，args[2] 为 User-Agent；-S 输出错误信息，用于判断代理是否可用
+	headers := resolveSite(y.config, douyinSiteURL).httpHeaders()
+	args := []string{"-sS", "-L", "-A", headers["User-Agent"]}
+	for _, key := range sortedKeys(headers) {
+		if key != "User-Agent" {
+			args = append(args, "-H", key+": "+headers[key])
+		}
+	}
+	if path := cookieFileFrom(ctx); path != "" {
+		args = append(args, "-b", path)
+	}
+	proxy := y.proxies.route(url)
+	args = append(args, proxy.curlArgs()...)
+	args = append(args,
 		"--max-time", fmt.Sprintf("%d", y.config.Douyin.APITimeout),
 		"-o", tempFile,
 		url,
-	}
+	)
 
 	// 执行curl命令
-	cmd := exec.Command("curl", args...)
+	cmd := newCommand(ctx, "curl", args...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	logrus.Debugf("执行curl命令: curl %v", args)
+	logrus.Debugf("执行curl命令: %s", commandLine("curl", args))
 
-	if err := cmd.Run(); err != nil {
+	err := cmd.Run()
+	proxy.reportCommand(err, stderr.String())
+	if err != nil {
 		// 如果失败，尝试使用不同的User-Agent重试
-		logrus.Warnf("curl命令执行失败，尝试使用不同的User-Agent重试: %v, stderr: %s", err, stderr.String())
+		logrus.Warnf("curl命令执行失败，尝试使用不同的User-Agent重试: %v, stderr: %s", err, redactCredentials(stderr.String()))
 
 		// 使用不同的User-Agent重试
 		alternativeUA := "Mozilla/5.0 (iPhone; CPU iPhone OS 16_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.0 Mobile/15E148 Safari/604.1"
 		args[2] = alternativeUA
 
-		cmd = exec.Command("curl", args...)
+		cmd = newCommand(ctx, "curl", args...)
 		stderr.Reset()
 		cmd.Stderr = &stderr
 
-		if err := cmd.Run(); err != nil {
-			return "", "", fmt.Errorf("curl命令执行失败: %w, stderr: %s", err, stderr.String())
+		err := cmd.Run()
+		proxy.reportCommand(err, stderr.String())
+		if err != nil {
+			return "", "", fmt.Errorf("curl命令执行失败: %w, stderr: %s", err, redactCredentials(stderr.String()))
 		}
 	}
 
@@ -1698,7 +1689,7 @@ func (y *YtdlpDownloader) getDouyinVideoByDirectCurl(url string) (string, string
 	if videoID != "" {
 		logrus.Infof("从HTML中提取到视频ID: %s", videoID)
 		// 使用视频ID尝试获取视频信息
-		return y.getDouyinVideoByOfficialAPI(videoID)
+		return y.getDouyinVideoByOfficialAPI(ctx, videoID)
 	}
 
 	// 2. 直接尝试提取视频URL
@@ -1788,28 +1779,25 @@ func (y *YtdlpDownloader) extractTitleFromHTML(html string) string {
 }
 
 // getDouyinVideoByWebAPI 使用抖音Web API获取视频信息
-func (y *YtdlpDownloader) getDouyinVideoByWebAPI(videoID string) (string, string, error) {
+func (y *YtdlpDownloader) getDouyinVideoByWebAPI(ctx context.Context, videoID string) (string, string, error) {
 	// 构造API请求URL
 	apiURL := fmt.Sprintf("https://www.douyin.com/aweme/v1/web/aweme/detail/?aweme_id=%s&aid=1128&version_name=23.5.0&device_platform=web", videoID)
 
 	// 设置请求头，模拟Web浏览器
-	client := &http.Client{
-		Timeout: time.Duration(y.config.Douyin.APITimeout) * time.Second,
-	}
-	req, err := http.NewRequest("GET", apiURL, nil)
+	client := y.proxies.client(time.Duration(y.config.Douyin.APITimeout) * time.Second)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return "", "", fmt.Errorf("创建请求失败: %w", err)
 	}
 
-	// 设置Web浏览器的User-Agent和请求头
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	// 使用抖音网站配置中的 User-Agent、Referer 和请求头，接口返回 JSON
+	resolveSite(y.config, apiURL).setHeaders(req.Header)
 	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Referer", "https://www.douyin.com/video/"+videoID)
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
 	req.Header.Set("Origin", "https://www.douyin.com")
 	req.Header.Set("Sec-Fetch-Site", "same-origin")
 	req.Header.Set("Sec-Fetch-Mode", "cors")
 	req.Header.Set("Sec-Fetch-Dest", "empty")
+	addCookies(ctx, req)
 
 	// 发送请求
 	resp, err := client.Do(req)
@@ -1857,7 +1845,7 @@ func (y *YtdlpDownloader) getDouyinVideoByWebAPI(videoID string) (string, string
 							// 替换域名，尝试获取无水印版本
 							noWatermarkURL := strings.Replace(url, "playwm", "play", 1)
 							// 验证URL是否有效
-							if y.isValidURL(noWatermarkURL) {
+							if y.isValidURL(ctx, noWatermarkURL) {
 								videoURL = noWatermarkURL
 								break
 							}
@@ -1879,7 +1867,7 @@ func (y *YtdlpDownloader) getDouyinVideoByWebAPI(videoID string) (string, string
 }
 
 // mergeVideoAndAudio 使用ffmpeg手动合并视频和音频文件
-func (y *YtdlpDownloader) mergeVideoAndAudio(videoFile, audioFile, outputFile string) (string, error) {
+func (y *YtdlpDownloader) mergeVideoAndAudio(ctx context.Context, videoFile, audioFile, outputFile string) (string, error) {
 	// 检查文件是否存在
 	if _, err := os.Stat(videoFile); err != nil {
 		return "", fmt.Errorf("视频文件不存在: %v", err)
@@ -1897,7 +1885,7 @@ func (y *YtdlpDownloader) mergeVideoAndAudio(videoFile, audioFile, outputFile st
 	}
 
 	// 使用ffmpeg合并
-	cmd := exec.Command("ffmpeg", "-i", videoFile, "-i", audioFile, "-c:v", "copy", "-c:a", "aac", "-strict", "experimental", outputFile)
+	cmd := newCommand(ctx, "ffmpeg", "-i", videoFile, "-i", audioFile, "-c:v", "copy", "-c:a", "aac", "-strict", "experimental", outputFile)
 	logrus.Infof("执行ffmpeg合并命令: %v", cmd.Args)
 
 	var stdout, stderr bytes.Buffer
@@ -3,21 +3,34 @@ package handler
 import (
 	"net/http"
 
+	"video-hunter/internal/config"
+	"video-hunter/internal/middleware"
 	"video-hunter/internal/service"
 
 	"github.com/gin-gonic/gin"
 )
 
 // RegisterRoutes 注册所有路由
-func RegisterRoutes(r *gin.Engine, svc *service.Service) {
+func RegisterRoutes(r *gin.Engine, svc *service.Service, cfg *config.Config) {
+	// 开销较大的接口按客户端IP限流，各自使用独立的预算
+	security := cfg.Security
+	videoInfoLimit := rateLimit("video-info", security.RateLimits.VideoInfo, security)
+	downloadLimit := rateLimit("download", security.RateLimits.Download, security)
+	directDownloadLimit := rateLimit("direct-download", security.RateLimits.DirectDownload, security)
+
 	// 静态文件
 	r.Static("/static", "./web/static")
 
 	// 模板文件
 	r.LoadHTMLGlob("web/templates/*")
 
+	// 未启用 security.auth 时所有接口都不需要认证
+	auth := middleware.NewAuthenticator(security.Auth)
+	requireRead := auth.Require(middleware.ScopeRead, false)
+	requireDownload := auth.Require(middleware.ScopeDownload, false)
+
 	// 直接下载到本地（最高优先级）
-	r.POST("/direct-download", svc.DirectDownload)
+	r.POST("/direct-download", directDownloadLimit, requireDownload, svc.DirectDownload)
 
 	// 主页
 	r.GET("/", func(c *gin.Context) {
@@ -37,18 +50,66 @@ func RegisterRoutes(r *gin.Engine, svc *service.Service) {
 	// API路由组
 	api := r.Group("/api")
 	{
+		// 认证API
+		api.GET("/auth/status", auth.Status)
+		api.POST("/auth/login", rateLimit("login", 0, security), auth.Login)
+		api.GET("/auth/me", auth.Require("", false), auth.Me)
+
 		// 下载相关API
-		api.POST("/download", svc.CreateDownload)
-		api.GET("/downloads", svc.GetDownloads)
-		api.GET("/downloads/:id", svc.GetDownload)
-		api.POST("/downloads/:id/cancel", svc.CancelDownload)
-		api.POST("/downloads/clear", svc.ClearDownloads)
-		api.GET("/downloads/:id/download", svc.DownloadFile)
+		api.POST("/download", downloadLimit, requireDownload, svc.CreateDownload)
+		api.POST("/playlists", downloadLimit, requireDownload, svc.CreatePlaylist)
+		api.POST("/downloads/batch", downloadLimit, requireDownload, svc.CreateBatch)
+		api.GET("/downloads", requireRead, svc.GetDownloads)
+		api.GET("/downloads/:id", requireRead, svc.GetDownload)
+		api.POST("/downloads/:id/cancel", requireDownload, svc.CancelDownload)
+		api.DELETE("/downloads/:id", requireDownload, svc.DeleteDownload)
+		api.POST("/downloads/clear", requireDownload, svc.ClearDownloads)
+		api.GET("/usage", requireRead, svc.GetUsage)
+		// 浏览器通过链接下载文件时无法设置请求头，允许使用 ?token= 参数
+		api.GET("/downloads/:id/download", auth.Require(middleware.ScopeRead, true), svc.DownloadFile)
+
+		// 进度事件流（Server-Sent Events），EventSource 无法设置请求头，允许使用 ?token= 参数
+		api.GET("/events", auth.Require(middleware.ScopeRead, true), svc.StreamEvents)
+		api.GET("/downloads/:id/events", auth.Require(middleware.ScopeRead, true), svc.StreamDownloadEvents)
+
+		// 下载队列API
+		api.GET("/queue", requireRead, svc.GetQueue)
+		api.POST("/downloads/:id/pause", requireDownload, svc.PauseDownload)
+		api.POST("/downloads/:id/resume", requireDownload, svc.ResumeDownload)
+		api.POST("/downloads/:id/priority", requireDownload, svc.SetDownloadPriority)
+		api.POST("/downloads/:id/move", requireDownload, svc.MoveDownload)
 
 		// 视频信息API
-		api.GET("/video-info", svc.GetVideoInfo)
+		api.GET("/video-info", videoInfoLimit, requireDownload, svc.GetVideoInfo)
+
+		// Webhook API
+		api.GET("/webhooks", requireRead, svc.ListWebhooks)
+		api.POST("/webhooks", requireDownload, svc.CreateWebhook)
+		api.DELETE("/webhooks/:id", requireDownload, svc.DeleteWebhook)
+		api.GET("/webhooks/:id/deliveries", requireRead, svc.GetWebhookDeliveries)
+
+		// 下载存档API
+		api.GET("/archive", requireRead, svc.GetArchive)
+		api.GET("/archive/export", requireRead, svc.ExportArchive)
+		api.POST("/archive/import", requireDownload, svc.ImportArchive)
+
+		// Cookie API
+		api.GET("/cookies", requireRead, svc.ListCookies)
+		api.POST("/cookies", requireDownload, svc.UploadCookies)
+		api.DELETE("/cookies/:id", requireDownload, svc.DeleteCookies)
+
+		// 代理池状态
+		api.GET("/proxies", auth.Require(middleware.ScopeAdmin, false), svc.GetProxies)
 	}
 
-	// WebSocket
-	r.GET("/ws", svc.HandleWebSocket)
+	// WebSocket，浏览器无法为 WebSocket 设置请求头，使用 ?token= 参数认证
+	r.GET("/ws", auth.Require(middleware.ScopeRead, true), svc.HandleWebSocket)
+}
+
+// rateLimit 创建接口的限流中间件，未单独配置预算时使用 security.rate_limit
+func rateLimit(name string, limit int, security config.SecurityConfig) gin.HandlerFunc {
+	if limit <= 0 {
+		limit = security.RateLimit
+	}
+	return middleware.NewRateLimiter(name, limit, security.RateLimitDuration).Middleware()
 }
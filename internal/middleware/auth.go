@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"video-hunter/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scope API 密钥的权限范围
+type Scope string
+
+const (
+	// ScopeRead 查看任务、队列和下载文件
+	ScopeRead Scope = "read"
+	// ScopeDownload 获取视频信息、创建和管理下载任务，包含 read
+	ScopeDownload Scope = "download"
+	// ScopeAdmin 查看和管理所有用户的任务，包含所有权限
+	ScopeAdmin Scope = "admin"
+)
+
+// identityKey 当前请求的身份在 gin.Context 中的键
+const identityKey = "identity"
+
+// Identity 通过认证的 API 密钥
+type Identity struct {
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// HasScope 判断是否拥有权限，admin 包含所有权限，download 包含 read
+func (i *Identity) HasScope(scope Scope) bool {
+	for _, s := range i.Scopes {
+		if s == scope || s == ScopeAdmin || (s == ScopeDownload && scope == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator 使用 security.auth.keys 中的静态密钥认证请求
+type Authenticator struct {
+	enabled bool
+	keys    []config.APIKeyConfig
+}
+
+// NewAuthenticator 创建认证器，配置已在加载时校验
+func NewAuthenticator(cfg config.AuthConfig) *Authenticator {
+	return &Authenticator{
+		enabled: cfg.Enabled,
+		keys:    cfg.Keys,
+	}
+}
+
+// Enabled 是否启用了认证
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+// Require 要求请求携带拥有指定权限的密钥，scope 为空时只要求通过认证；
+// allowQuery 为 true 时也接受 ?token= 参数，用于 WebSocket 和浏览器直接打开的下载链接
+func (a *Authenticator) Require(scope Scope, allowQuery bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.enabled {
+			c.Next()
+			return
+		}
+
+		identity := a.authenticate(requestToken(c, allowQuery))
+		if identity == nil {
+			c.Header("WWW-Authenticate", `Bearer realm="video-hunter"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "未登录或API密钥无效"})
+			return
+		}
+		if scope != "" && !identity.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API密钥没有 " + string(scope) + " 权限"})
+			return
+		}
+
+		c.Set(identityKey, identity)
+		c.Next()
+	}
+}
+
+// Status 返回是否需要登录，供网页判断是否显示登录框
+func (a *Authenticator) Status(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"enabled": a.enabled})
+}
+
+// Login 校验网页提交的API密钥，成功时返回密钥的名称和权限
+func (a *Authenticator) Login(c *gin.Context) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少token参数"})
+		return
+	}
+
+	if !a.enabled {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	identity := a.authenticate(body.Token)
+	if identity == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API密钥无效"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "name": identity.Name, "scopes": identity.Scopes})
+}
+
+// Me 返回当前请求的身份
+func (a *Authenticator) Me(c *gin.Context) {
+	identity := CurrentIdentity(c)
+	if identity == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "name": identity.Name, "scopes": identity.Scopes})
+}
+
+// authenticate 查找与令牌匹配的密钥，使用固定时间比较避免泄露密钥内容
+func (a *Authenticator) authenticate(token string) *Identity {
+	if token == "" {
+		return nil
+	}
+
+	var matched *config.APIKeyConfig
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(a.keys[i].Key), []byte(token)) == 1 {
+			matched = &a.keys[i]
+		}
+	}
+	if matched == nil {
+		return nil
+	}
+
+	identity := &Identity{Name: matched.Name}
+	for _, scope := range matched.Scopes {
+		identity.Scopes = append(identity.Scopes, Scope(scope))
+	}
+	return identity
+}
+
+// requestToken 依次从 Authorization: Bearer、X-API-Key 和 token 查询参数中读取令牌
+func requestToken(c *gin.Context, allowQuery bool) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if allowQuery {
+		return c.Query("token")
+	}
+	return ""
+}
+
+// CurrentIdentity 返回当前请求的身份，未启用认证时返回 nil
+func CurrentIdentity(c *gin.Context) *Identity {
+	if value, ok := c.Get(identityKey); ok {
+		if identity, ok := value.(*Identity); ok {
+			return identity
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"video-hunter/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdentityHasScope(t *testing.T) {
+	tests := []struct {
+		scopes []Scope
+		scope  Scope
+		want   bool
+	}{
+		{[]Scope{ScopeRead}, ScopeRead, true},
+		{[]Scope{ScopeRead}, ScopeDownload, false},
+		{[]Scope{ScopeRead}, ScopeAdmin, false},
+		{[]Scope{ScopeDownload}, ScopeRead, true},
+		{[]Scope{ScopeDownload}, ScopeDownload, true},
+		{[]Scope{ScopeDownload}, ScopeAdmin, false},
+		{[]Scope{ScopeAdmin}, ScopeRead, true},
+		{[]Scope{ScopeAdmin}, ScopeDownload, true},
+		{[]Scope{ScopeAdmin}, ScopeAdmin, true},
+		{[]Scope{ScopeRead, ScopeDownload}, ScopeDownload, true},
+		{nil, ScopeRead, false},
+	}
+	for _, tt := range tests {
+		identity := &Identity{Scopes: tt.scopes}
+		if got := identity.HasScope(tt.scope); got != tt.want {
+			t.Errorf("%v HasScope(%s) = %v, 期望 %v", tt.scopes, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticatorRequire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	auth := NewAuthenticator(config.AuthConfig{
+		Enabled: true,
+		Keys: []config.APIKeyConfig{
+			{Name: "viewer", Key: "read-key", Scopes: []string{"read"}},
+			{Name: "worker", Key: "download-key", Scopes: []string{"download"}},
+			{Name: "root", Key: "admin-key", Scopes: []string{"admin"}},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		scope      Scope
+		allowQuery bool
+		header     string
+		value      string
+		query      string
+		want       int
+		wantName   string
+	}{
+		{name: "没有密钥", scope: ScopeRead, want: http.StatusUnauthorized},
+		{name: "无效的密钥", scope: ScopeRead, header: "Authorization", value: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "Bearer 令牌", scope: ScopeRead, header: "Authorization", value: "Bearer read-key", want: http.StatusOK, wantName: "viewer"},
+		{name: "Bearer 不区分大小写", scope: ScopeRead, header: "Authorization", value: "bearer  read-key ", want: http.StatusOK, wantName: "viewer"},
+		{name: "其他认证方式", scope: ScopeRead, header: "Authorization", value: "Basic read-key", want: http.StatusUnauthorized},
+		{name: "X-API-Key", scope: ScopeDownload, header: "X-API-Key", value: "download-key", want: http.StatusOK, wantName: "worker"},
+		{name: "权限不足", scope: ScopeDownload, header: "X-API-Key", value: "read-key", want: http.StatusForbidden},
+		{name: "download 不包含 admin", scope: ScopeAdmin, header: "X-API-Key", value: "download-key", want: http.StatusForbidden},
+		{name: "admin 包含所有权限", scope: ScopeDownload, header: "X-API-Key", value: "admin-key", want: http.StatusOK, wantName: "root"},
+		{name: "只要求认证", header: "X-API-Key", value: "read-key", want: http.StatusOK, wantName: "viewer"},
+		{name: "不接受查询参数", scope: ScopeRead, query: "read-key", want: http.StatusUnauthorized},
+		{name: "接受查询参数", scope: ScopeRead, allowQuery: true, query: "read-key", want: http.StatusOK, wantName: "viewer"},
+		{name: "请求头优先于查询参数", scope: ScopeDownload, allowQuery: true, header: "X-API-Key", value: "read-key", query: "download-key", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			var name string
+			router.GET("/", auth.Require(tt.scope, tt.allowQuery), func(c *gin.Context) {
+				if identity := CurrentIdentity(c); identity != nil {
+					name = identity.Name
+				}
+				c.Status(http.StatusOK)
+			})
+
+			target := "/"
+			if tt.query != "" {
+				target += "?token=" + tt.query
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("状态码 = %d, 期望 %d", w.Code, tt.want)
+			}
+			if name != tt.wantName {
+				t.Errorf("身份 = %q, 期望 %q", name, tt.wantName)
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 响应缺少 WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestAuthenticatorDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	auth := NewAuthenticator(config.AuthConfig{Enabled: false})
+	router := gin.New()
+	router.GET("/", auth.Require(ScopeAdmin, false), func(c *gin.Context) {
+		if CurrentIdentity(c) != nil {
+			t.Error("未启用认证时不应有身份")
+		}
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("状态码 = %d, 期望 200", w.Code)
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORS 根据 security.cors_origins 设置跨域响应头，列表包含 "*" 时允许所有源
+func CORS(origins []string) gin.HandlerFunc {
+	allowAll := false
+	for _, origin := range origins {
+		if origin == "*" {
+			allowAll = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if origin != "" {
+			switch {
+			case allowAll:
+				c.Header("Access-Control-Allow-Origin", "*")
+			case OriginAllowed(origins, origin):
+				c.Header("Access-Control-Allow-Origin", origin)
+				c.Header("Vary", "Origin")
+			case preflight:
+				// 不在允许列表中的源，直接拒绝预检请求
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			default:
+				// 普通请求不返回跨域响应头，由浏览器拦截
+				c.Header("Vary", "Origin")
+				c.Next()
+				return
+			}
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-API-Key")
+			c.Header("Access-Control-Expose-Headers", "Retry-After, Content-Disposition")
+		}
+
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// OriginAllowed 判断源是否在允许列表中，比较时忽略大小写和末尾的斜杠
+func OriginAllowed(origins []string, origin string) bool {
+	origin = normalizeOrigin(origin)
+	for _, allowed := range origins {
+		if allowed == "*" || normalizeOrigin(allowed) == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// SameOrigin 判断请求的 Origin 是否与请求的 Host 相同
+func SameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+}
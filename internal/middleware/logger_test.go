@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRedactToken(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/downloads", "/api/downloads"},
+		{"/api/downloads?owner=alice", "/api/downloads?owner=alice"},
+		{"/ws?token=secret", "/ws?token=xxxxx"},
+		{"/api/downloads/1/download?token=secret&inline=1", "/api/downloads/1/download?inline=1&token=xxxxx"},
+		{"/api/events?token=a&token=b", "/api/events?token=xxxxx"},
+		{"/api/events?tok%65n=secret", "/api/events?token=xxxxx"},
+		{"/api/events?token=", "/api/events?token=xxxxx"},
+	}
+
+	for _, tt := range tests {
+		if got := redactToken(tt.path); got != tt.want {
+			t.Errorf("redactToken(%q) = %q, 期望 %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLoggerHidesToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	router := gin.New()
+	router.Use(gin.LoggerWithConfig(gin.LoggerConfig{Formatter: logFormatter, Output: &buf}))
+	router.GET("/ws", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ws?token=secret-key", nil))
+
+	if line := buf.String(); strings.Contains(line, "secret-key") || !strings.Contains(line, `"/ws?token=xxxxx"`) {
+		t.Errorf("访问日志没有隐藏 token: %s", line)
+	}
+}
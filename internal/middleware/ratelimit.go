@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// bucket 单个客户端的令牌桶
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter 按客户端 IP 限流的令牌桶：桶容量为 limit，每个 window 补满
+type RateLimiter struct {
+	name    string
+	limit   int
+	window  time.Duration
+	rate    float64 // 每秒补充的令牌数
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewRateLimiter 创建限流器，limit <= 0 或 window <= 0 时返回 nil，表示不限流
+func NewRateLimiter(name string, limit int, window time.Duration) *RateLimiter {
+	if limit <= 0 || window <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		name:    name,
+		limit:   limit,
+		window:  window,
+		rate:    float64(limit) / window.Seconds(),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow 消耗客户端的一个令牌，令牌不足时返回需要等待的时间
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweepLocked(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit), last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = math.Min(float64(l.limit), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweepLocked 每个时间窗口清理一次已经补满的令牌桶，避免记录无限增长
+func (l *RateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.swept) < l.window {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.window {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware 返回限流中间件，超出限制时返回 429 并设置 Retry-After
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	if l == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		ok, wait := l.Allow(ip)
+		if ok {
+			c.Next()
+			return
+		}
+
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		logrus.Warnf("请求过于频繁 [%s] %s %s", l.name, ip, c.Request.URL.Path)
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error":       "请求过于频繁，请稍后再试",
+			"retry_after": retryAfter,
+		})
+	}
+}
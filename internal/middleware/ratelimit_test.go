@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestLimiter 创建使用可控时钟的限流器
+func newTestLimiter(limit int, window time.Duration) (*RateLimiter, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	l := NewRateLimiter("test", limit, window)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestNewRateLimiterDisabled(t *testing.T) {
+	for _, tt := range []struct {
+		limit  int
+		window time.Duration
+	}{{0, time.Minute}, {-1, time.Minute}, {10, 0}} {
+		if l := NewRateLimiter("test", tt.limit, tt.window); l != nil {
+			t.Errorf("NewRateLimiter(%d, %v) 应返回 nil", tt.limit, tt.window)
+		}
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	type step struct {
+		advance  time.Duration
+		key      string
+		want     bool
+		wantWait time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		limit int
+		steps []step
+	}{
+		{
+			name:  "突发请求不超过桶容量",
+			limit: 3,
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: true},
+				{key: "a", want: true},
+				{key: "a", want: false, wantWait: 20 * time.Second},
+			},
+		},
+		{
+			name:  "按速率补充令牌",
+			limit: 3,
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: true},
+				{key: "a", want: true},
+				{advance: 10 * time.Second, key: "a", want: false, wantWait: 10 * time.Second},
+				{advance: 10 * time.Second, key: "a", want: true},
+				{key: "a", want: false, wantWait: 20 * time.Second},
+			},
+		},
+		{
+			name:  "令牌不超过桶容量",
+			limit: 2,
+			steps: []step{
+				{key: "a", want: true},
+				{advance: 10 * time.Minute, key: "a", want: true},
+				{key: "a", want: true},
+				{key: "a", want: false, wantWait: 30 * time.Second},
+			},
+		},
+		{
+			name:  "客户端之间互不影响",
+			limit: 1,
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: false, wantWait: time.Minute},
+				{key: "b", want: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, now := newTestLimiter(tt.limit, time.Minute)
+			for i, s := range tt.steps {
+				*now = now.Add(s.advance)
+				ok, wait := l.Allow(s.key)
+				if ok != s.want || wait != s.wantWait {
+					t.Errorf("第 %d 次请求 = %v, %v, 期望 %v, %v", i+1, ok, wait, s.want, s.wantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l, now := newTestLimiter(1, time.Minute)
+	l.Allow("a")
+	*now = now.Add(30 * time.Second)
+	l.Allow("b")
+
+	*now = now.Add(45 * time.Second)
+	l.Allow("c")
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("补满的令牌桶应被清理")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Error("未补满的令牌桶不应被清理")
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		forwardedFor   []string
+		want           []int
+	}{
+		{
+			name:         "默认不信任 X-Forwarded-For",
+			forwardedFor: []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+			want:         []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:           "来自受信任代理时按转发的地址限流",
+			trustedProxies: []string{"192.0.2.0/24"},
+			forwardedFor:   []string{"1.1.1.1", "2.2.2.2", "1.1.1.1", "1.1.1.1"},
+			want:           []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newTestLimiter(2, time.Minute)
+			router := gin.New()
+			if err := router.SetTrustedProxies(tt.trustedProxies); err != nil {
+				t.Fatalf("SetTrustedProxies 失败: %v", err)
+			}
+			router.GET("/", l.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			for i, ip := range tt.forwardedFor {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = "192.0.2.1:1234"
+				req.Header.Set("X-Forwarded-For", ip)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				if w.Code != tt.want[i] {
+					t.Errorf("第 %d 次请求状态码 = %d, 期望 %d", i+1, w.Code, tt.want[i])
+				}
+				if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "30" {
+					t.Errorf("Retry-After = %q, 期望 30", w.Header().Get("Retry-After"))
+				}
+			}
+		})
+	}
+
+	var disabled *RateLimiter
+	router := gin.New()
+	router.GET("/", disabled.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("不限流时状态码 = %d", w.Code)
+		}
+	}
+}
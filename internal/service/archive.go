@@ -0,0 +1,522 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"video-hunter/internal/config"
+	"video-hunter/internal/downloader"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// maxArchiveImportSize 导入下载存档的最大长度
+const maxArchiveImportSize = 16 << 20
+
+// archiveEntry 下载存档中的一条记录，与 yt-dlp --download-archive 的格式相同："<提取器> <视频ID>"
+type archiveEntry struct {
+	Extractor string `json:"extractor"`
+	ID        string `json:"id"`
+}
+
+// String 返回存档文件中的一行
+func (e archiveEntry) String() string {
+	return e.Extractor + " " + e.ID
+}
+
+// parseArchiveLine 解析存档文件中的一行，提取器名称统一为小写
+func parseArchiveLine(line string) (archiveEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return archiveEntry{}, false
+	}
+	return archiveEntry{Extractor: strings.ToLower(fields[0]), ID: fields[1]}, true
+}
+
+// downloadArchive 管理下载存档文件，保存在任务数据库所在目录的 archives/ 下：
+// 每个用户有一个记录所有下载的存档 archives/<转义后的用户名>.txt，每个播放列表或频道（订阅）另有一个存档
+// archives/<转义后的用户名>/<地址哈希>.txt，地址记录在同目录的 playlists.json 中；
+// yt-dlp 下载时直接读写这些文件，已记录的视频不会再次下载
+type downloadArchive struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// playlistArchive 一个播放列表或频道的存档
+type playlistArchive struct {
+	URL   string `json:"url"`
+	Count int    `json:"count"`
+}
+
+// 存档目录中记录文件名格式的文件，旧格式直接使用用户名的最后一段作为文件名，不同用户可能共用同一存档
+const (
+	archiveLayoutFile = ".layout"
+	archiveLayout     = "2"
+)
+
+// newDownloadArchive 创建下载存档，保存在 dataDir 的 archives/ 下
+func newDownloadArchive(cfg config.DatabaseConfig) *downloadArchive {
+	a := &downloadArchive{dir: filepath.Join(dataDir(cfg), "archives")}
+	a.migrateLayout()
+	return a
+}
+
+// ownerName 返回存档文件使用的名称：小写字母、数字和 - 保持不变，其他字节转义为 %XX，
+// 不同的用户名不会得到相同的名称，在不区分大小写的文件系统上也是如此；
+// 未启用认证时所有任务共用 _default，转义后过长的名称改用用户名的哈希
+func ownerName(owner string) string {
+	if owner == "" {
+		return "_default"
+	}
+	var b strings.Builder
+	for i := 0; i < len(owner); i++ {
+		c := owner[i]
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	if b.Len() > 200 {
+		sum := sha256.Sum256([]byte(owner))
+		return "_" + hex.EncodeToString(sum[:])
+	}
+	return b.String()
+}
+
+// migrateLayout 将旧格式的存档文件和播放列表存档目录改为转义后的名称，完成后记录格式版本，只执行一次
+func (a *downloadArchive) migrateLayout() {
+	marker := filepath.Join(a.dir, archiveLayoutFile)
+	if data, err := os.ReadFile(marker); err == nil && strings.TrimSpace(string(data)) == archiveLayout {
+		return
+	}
+
+	entries, err := os.ReadDir(a.dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logrus.Errorf("读取下载存档目录失败: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		legacy, isFile := strings.CutSuffix(entry.Name(), ".txt")
+		if isFile == entry.IsDir() || legacy == "_default" {
+			continue
+		}
+		name := ownerName(legacy)
+		if name == legacy {
+			continue
+		}
+		if isFile {
+			name += ".txt"
+		}
+		from, to := filepath.Join(a.dir, entry.Name()), filepath.Join(a.dir, name)
+		if _, err := os.Stat(to); err == nil {
+			logrus.Warnf("下载存档 %s 已存在，跳过迁移 %s", to, from)
+			continue
+		}
+		if err := os.Rename(from, to); err != nil {
+			logrus.Errorf("迁移下载存档 %s 失败: %v", from, err)
+			return
+		}
+		logrus.Infof("已迁移下载存档: %s -> %s", from, to)
+	}
+
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		logrus.Errorf("创建下载存档目录失败: %v", err)
+		return
+	}
+	if err := os.WriteFile(marker, []byte(archiveLayout+"\n"), 0644); err != nil {
+		logrus.Errorf("写入下载存档格式版本失败: %v", err)
+	}
+}
+
+// path 返回用户的存档文件路径
+func (a *downloadArchive) path(owner string) string {
+	return filepath.Join(a.dir, ownerName(owner)+".txt")
+}
+
+// playlistPath 返回用户某个播放列表或频道的存档文件路径，playlistURL 为规范化后的地址
+func (a *downloadArchive) playlistPath(owner, playlistURL string) string {
+	sum := sha256.Sum256([]byte(playlistURL))
+	return filepath.Join(a.dir, ownerName(owner), hex.EncodeToString(sum[:8])+".txt")
+}
+
+// indexPath 返回记录用户播放列表存档地址的索引文件
+func (a *downloadArchive) indexPath(owner string) string {
+	return filepath.Join(a.dir, ownerName(owner), "playlists.json")
+}
+
+// register 在索引中记录播放列表存档对应的地址
+func (a *downloadArchive) register(owner, playlistURL string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	index, err := a.readIndexLocked(owner)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(a.playlistPath(owner, playlistURL))
+	if index[name] == playlistURL {
+		return nil
+	}
+	index[name] = playlistURL
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.indexPath(owner)), 0755); err != nil {
+		return fmt.Errorf("创建下载存档目录失败: %w", err)
+	}
+	if err := os.WriteFile(a.indexPath(owner), data, 0644); err != nil {
+		return fmt.Errorf("写入播放列表存档索引失败: %w", err)
+	}
+	return nil
+}
+
+// playlists 列出用户的播放列表存档及其记录数，按地址排序
+func (a *downloadArchive) playlists(owner string) ([]playlistArchive, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	index, err := a.readIndexLocked(owner)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]playlistArchive, 0, len(index))
+	for _, playlistURL := range index {
+		entries, err := a.readLocked(a.playlistPath(owner, playlistURL))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, playlistArchive{URL: playlistURL, Count: len(entries)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].URL < list[j].URL })
+	return list, nil
+}
+
+// readIndexLocked 读取播放列表存档索引（文件名到地址），调用方需持有 a.mu
+func (a *downloadArchive) readIndexLocked(owner string) (map[string]string, error) {
+	index := make(map[string]string)
+	data, err := os.ReadFile(a.indexPath(owner))
+	if errors.Is(err, fs.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取播放列表存档索引失败: %w", err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("解析播放列表存档索引失败: %w", err)
+	}
+	return index, nil
+}
+
+// entries 读取存档文件中的所有记录，忽略无法解析的行和重复的记录
+func (a *downloadArchive) entries(path string) ([]archiveEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.readLocked(path)
+}
+
+// contains 返回存档文件中所有记录组成的集合
+func (a *downloadArchive) contains(path string) (map[string]bool, error) {
+	entries, err := a.entries(path)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		set[entry.String()] = true
+	}
+	return set, nil
+}
+
+// add 将不在存档中的记录追加到存档文件，返回新增的记录数；
+// 只追加不重写文件，避免覆盖 yt-dlp 同时写入的记录
+func (a *downloadArchive) add(path string, entries []archiveEntry) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	existing, err := a.readLocked(path)
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, entry := range existing {
+		seen[entry.String()] = true
+	}
+
+	var lines strings.Builder
+	added := 0
+	for _, entry := range entries {
+		if seen[entry.String()] {
+			continue
+		}
+		seen[entry.String()] = true
+		lines.WriteString(entry.String() + "\n")
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("创建下载存档目录失败: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("打开下载存档失败: %w", err)
+	}
+	if _, err := file.WriteString(lines.String()); err != nil {
+		file.Close()
+		return 0, fmt.Errorf("写入下载存档失败: %w", err)
+	}
+	return added, file.Close()
+}
+
+// readLocked 读取存档文件，调用方需持有 a.mu
+func (a *downloadArchive) readLocked(path string) ([]archiveEntry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取下载存档失败: %w", err)
+	}
+	defer file.Close()
+
+	var entries []archiveEntry
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry, ok := parseArchiveLine(scanner.Text())
+		if !ok || seen[entry.String()] {
+			continue
+		}
+		seen[entry.String()] = true
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取下载存档失败: %w", err)
+	}
+	return entries, nil
+}
+
+// archiveFor 返回下载时使用的存档文件：播放列表的子任务使用该播放列表的存档，
+// 请求中指定 archive 的单个视频使用用户的存档，dedupe 为 force 或音频模式时不使用存档
+func (s *Service) archiveFor(req *downloader.DownloadRequest, owner string) string {
+	if req.Mode == downloader.ModeAudio || (req.Playlist == "" && !req.UseArchive) {
+		return ""
+	}
+	if policy, err := s.dedupePolicy(req); err == nil && policy == downloader.DedupeForce {
+		return ""
+	}
+	if req.Playlist != "" {
+		return s.archive.playlistPath(owner, req.Playlist)
+	}
+	return s.archive.path(owner)
+}
+
+// recordArchive 将下载完成的视频记录到所有者的存档，播放列表的子任务同时记录到播放列表的存档；
+// yt-dlp 以外的下载器不会自己写入存档
+func (s *Service) recordArchive(req *downloader.DownloadRequest, download *downloader.DownloadResponse) {
+	s.mu.RLock()
+	owner, line := download.Owner, download.Metadata["archive_id"]
+	s.mu.RUnlock()
+
+	entry, ok := parseArchiveLine(line)
+	if !ok {
+		return
+	}
+	paths := []string{s.archive.path(owner)}
+	if req.Playlist != "" {
+		paths = append(paths, s.archive.playlistPath(owner, req.Playlist))
+	}
+	for _, path := range paths {
+		if _, err := s.archive.add(path, []archiveEntry{entry}); err != nil {
+			logrus.Errorf("记录下载存档失败 [%s]: %v", download.ID, err)
+		}
+	}
+}
+
+// finishArchived 将 yt-dlp 因已在存档中而跳过的播放列表子任务标记为完成，任务没有文件
+func (s *Service) finishArchived(id string, download *downloader.DownloadResponse) {
+	s.mu.Lock()
+	download.Status = downloader.StatusCompleted
+	download.Progress = 100
+	download.Stage = ""
+	download.StageProgress = 0
+	if download.Metadata == nil {
+		download.Metadata = make(map[string]string)
+	}
+	download.Metadata["archived"] = "true"
+	download.Updated = time.Now()
+	s.mu.Unlock()
+	logrus.Infof("视频已在下载存档中，跳过下载 [%s]", id)
+
+	s.publish(id, download)
+	s.notify(eventCompleted, id)
+}
+
+// archiveOwner 返回接口要处理的存档所有者：默认为当前用户，管理员可以通过 ?owner= 指定用户
+func archiveOwner(c *gin.Context) string {
+	if owner, all := ownerFilter(c); !all {
+		return owner
+	}
+	return requesterFrom(c).name
+}
+
+// archiveScope 返回接口要处理的存档所有者、规范化的播放列表地址和存档文件：
+// 指定 ?playlist= 时为该播放列表或频道的存档，否则为用户的存档；地址无效时返回错误响应
+func (s *Service) archiveScope(c *gin.Context) (owner, playlist, path string, ok bool) {
+	owner = archiveOwner(c)
+	if raw := c.Query("playlist"); raw != "" {
+		key, err := canonicalURL(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return "", "", "", false
+		}
+		return owner, key, s.archive.playlistPath(owner, key), true
+	}
+	return owner, "", s.archive.path(owner), true
+}
+
+// GetArchive 列出当前用户下载存档中的记录和所有播放列表存档，?playlist= 指定播放列表或频道时列出该播放列表存档的记录；
+// 管理员可以通过 ?owner= 查看其他用户的存档
+func (s *Service) GetArchive(c *gin.Context) {
+	owner, playlist, path, ok := s.archiveScope(c)
+	if !ok {
+		return
+	}
+
+	entries, err := s.archive.entries(path)
+	if err != nil {
+		logrus.Errorf("读取下载存档失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取下载存档失败"})
+		return
+	}
+	if entries == nil {
+		entries = []archiveEntry{}
+	}
+	result := gin.H{
+		"owner":   owner,
+		"count":   len(entries),
+		"entries": entries,
+	}
+	if playlist != "" {
+		result["playlist"] = playlist
+	} else {
+		playlists, err := s.archive.playlists(owner)
+		if err != nil {
+			logrus.Errorf("读取播放列表存档失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "读取播放列表存档失败"})
+			return
+		}
+		result["playlists"] = playlists
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ExportArchive 以 yt-dlp --download-archive 格式导出下载存档，?playlist= 指定播放列表或频道的存档
+func (s *Service) ExportArchive(c *gin.Context) {
+	_, _, path, ok := s.archiveScope(c)
+	if !ok {
+		return
+	}
+
+	entries, err := s.archive.entries(path)
+	if err != nil {
+		logrus.Errorf("读取下载存档失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取下载存档失败"})
+		return
+	}
+
+	var data strings.Builder
+	for _, entry := range entries {
+		data.WriteString(entry.String() + "\n")
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(path)))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(data.String()))
+}
+
+// ImportArchive 合并 yt-dlp --download-archive 格式的存档，请求体为存档文本，
+// 也可以通过 multipart 表单的 file 字段上传，?playlist= 指定导入到播放列表或频道的存档；返回新增、重复和无法解析的记录数
+func (s *Service) ImportArchive(c *gin.Context) {
+	owner, playlist, path, ok := s.archiveScope(c)
+	if !ok {
+		return
+	}
+
+	var body io.Reader = c.Request.Body
+	if c.ContentType() == "multipart/form-data" {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 file 字段"})
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传的文件失败"})
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	var entries []archiveEntry
+	var invalid []int
+	scanner := bufio.NewScanner(io.LimitReader(body, maxArchiveImportSize))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, ok := parseArchiveLine(line)
+		if !ok {
+			invalid = append(invalid, n)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取存档内容失败: " + err.Error()})
+		return
+	}
+
+	if playlist != "" {
+		if err := s.archive.register(owner, playlist); err != nil {
+			logrus.Errorf("记录播放列表存档失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "导入下载存档失败"})
+			return
+		}
+	}
+	added, err := s.archive.add(path, entries)
+	if err != nil {
+		logrus.Errorf("导入下载存档失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入下载存档失败"})
+		return
+	}
+	logrus.Infof("已导入下载存档 [%s]: 新增 %d 条", path, added)
+
+	if invalid == nil {
+		invalid = []int{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"added":         added,
+		"duplicates":    len(entries) - added,
+		"invalid_lines": invalid,
+	})
+}
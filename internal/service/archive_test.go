@@ -0,0 +1,238 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"video-hunter/internal/config"
+	"video-hunter/internal/downloader"
+)
+
+func TestOwnerName(t *testing.T) {
+	tests := []struct {
+		owner string
+		want  string
+	}{
+		{"", "_default"},
+		{"alice", "alice"},
+		{"ci-bot-2", "ci-bot-2"},
+		{"Alice", "%41lice"},
+		{"team/alice", "team%2Falice"},
+		{"_default", "%5Fdefault"},
+		{"..", "%2E%2E"},
+		{"a%2Fb", "a%252%46b"},
+		{"张三", "%E5%BC%A0%E4%B8%89"},
+	}
+	for _, tt := range tests {
+		if got := ownerName(tt.owner); got != tt.want {
+			t.Errorf("ownerName(%q) = %q, 期望 %q", tt.owner, got, tt.want)
+		}
+	}
+
+	// 不同的用户名不会得到相同的文件名，不区分大小写时也是如此
+	owners := []string{"", "alice", "Alice", "ALICE", "team/alice", "team\\alice", "alice.", "_default", "%41lice", strings.Repeat("x", 300), strings.Repeat("x", 301)}
+	seen := make(map[string]string)
+	for _, owner := range owners {
+		name := strings.ToLower(ownerName(owner))
+		if other, ok := seen[name]; ok {
+			t.Errorf("%q 和 %q 的存档文件名相同: %s", owner, other, name)
+		}
+		seen[name] = owner
+		if len(name) > 200 || strings.ContainsAny(name, `/\.`) {
+			t.Errorf("ownerName(%q) = %q 不是安全的文件名", owner, name)
+		}
+	}
+}
+
+func TestArchiveMigrateLayout(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"alice.txt":          "youtube a\n",
+		"Bob.txt":            "youtube b\n",
+		"_default.txt":       "youtube d\n",
+		"Bob/0123abcd.txt":   "youtube p\n",
+		"Bob/playlists.json": "{}",
+		"notes.md":           "keep",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, "archives", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &downloadArchive{dir: filepath.Join(dir, "archives")}
+	a.migrateLayout()
+
+	want := map[string]string{
+		"alice.txt":            "youtube a\n",
+		"%42ob.txt":            "youtube b\n",
+		"_default.txt":         "youtube d\n",
+		"%42ob/0123abcd.txt":   "youtube p\n",
+		"%42ob/playlists.json": "{}",
+		"notes.md":             "keep",
+		archiveLayoutFile:      archiveLayout + "\n",
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(a.dir, name))
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v, 期望 %q", name, data, err, content)
+		}
+	}
+	if a.path("Bob") != filepath.Join(a.dir, "%42ob.txt") {
+		t.Errorf("path(Bob) = %s", a.path("Bob"))
+	}
+
+	// 已迁移过的目录不再处理，新格式的名称不会被再次转义
+	a.migrateLayout()
+	if _, err := os.Stat(filepath.Join(a.dir, "%42ob.txt")); err != nil {
+		t.Errorf("再次迁移后存档丢失: %v", err)
+	}
+}
+
+func TestParseArchiveLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		want   archiveEntry
+		wantOK bool
+	}{
+		{"youtube abc", archiveEntry{"youtube", "abc"}, true},
+		{"  BiliBili   BV1xx  ", archiveEntry{"bilibili", "BV1xx"}, true},
+		{"youtube", archiveEntry{}, false},
+		{"youtube abc extra", archiveEntry{}, false},
+		{"", archiveEntry{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseArchiveLine(tt.line)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseArchiveLine(%q) = %+v, %v, 期望 %+v, %v", tt.line, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestDownloadArchiveAdd(t *testing.T) {
+	a := &downloadArchive{dir: filepath.Join(t.TempDir(), "archives")}
+	path := a.path("alice")
+
+	// yt-dlp 写入的记录，包括重复和无法解析的行
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("youtube a\nYoutube a\ninvalid\n\nyoutube b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		entries []archiveEntry
+		want    int
+	}{
+		{[]archiveEntry{{"youtube", "a"}}, 0},
+		{[]archiveEntry{{"youtube", "c"}, {"youtube", "c"}, {"youtube", "b"}}, 1},
+		{[]archiveEntry{{"bilibili", "a"}, {"youtube", "d"}}, 2},
+		{nil, 0},
+	}
+	for i, step := range steps {
+		added, err := a.add(path, step.entries)
+		if err != nil || added != step.want {
+			t.Errorf("第 %d 次添加 = %d, %v, 期望 %d", i+1, added, err, step.want)
+		}
+	}
+
+	entries, err := a.entries(path)
+	if err != nil {
+		t.Fatalf("读取存档失败: %v", err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.String())
+	}
+	want := []string{"youtube a", "youtube b", "youtube c", "bilibili a", "youtube d"}
+	if !slices.Equal(got, want) {
+		t.Errorf("存档记录 = %q, 期望 %q", got, want)
+	}
+
+	// 只追加新记录，不重写 yt-dlp 写入的内容
+	data, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(data), "youtube a\nYoutube a\ninvalid\n") {
+		t.Errorf("存档文件被重写: %q", data)
+	}
+
+	set, err := a.contains(path)
+	if err != nil || !set["youtube c"] || set["youtube x"] {
+		t.Errorf("contains = %v, %v", set, err)
+	}
+	if entries, err := a.entries(a.path("bob")); err != nil || entries != nil {
+		t.Errorf("不存在的存档 = %v, %v, 期望为空", entries, err)
+	}
+}
+
+func TestDownloadArchivePlaylists(t *testing.T) {
+	a := &downloadArchive{dir: filepath.Join(t.TempDir(), "archives")}
+	const (
+		first  = "https://www.youtube.com/@a/videos"
+		second = "https://www.youtube.com/playlist?list=PL1"
+	)
+
+	for _, playlist := range []string{second, first, second} {
+		if err := a.register("alice", playlist); err != nil {
+			t.Fatalf("register 失败: %v", err)
+		}
+	}
+	if _, err := a.add(a.playlistPath("alice", first), []archiveEntry{{"youtube", "a"}, {"youtube", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := a.playlists("alice")
+	if err != nil {
+		t.Fatalf("playlists 失败: %v", err)
+	}
+	want := []playlistArchive{{URL: first, Count: 2}, {URL: second, Count: 0}}
+	if !slices.Equal(list, want) {
+		t.Errorf("playlists = %+v, 期望 %+v", list, want)
+	}
+
+	// 播放列表存档按用户区分
+	if a.playlistPath("alice", first) == a.playlistPath("bob", first) {
+		t.Error("不同用户的播放列表存档不应相同")
+	}
+	if list, err := a.playlists("bob"); err != nil || len(list) != 0 {
+		t.Errorf("其他用户的 playlists = %+v, %v, 期望为空", list, err)
+	}
+}
+
+func TestArchiveFor(t *testing.T) {
+	const playlist = "https://www.youtube.com/@a/videos"
+	archive := &downloadArchive{dir: "archives"}
+
+	tests := []struct {
+		name   string
+		dedupe string
+		req    downloader.DownloadRequest
+		want   string
+	}{
+		{"单个视频默认不使用存档", "", downloader.DownloadRequest{}, ""},
+		{"请求指定 archive", "", downloader.DownloadRequest{UseArchive: true}, archive.path("alice")},
+		{"播放列表的子任务", "", downloader.DownloadRequest{Playlist: playlist}, archive.playlistPath("alice", playlist)},
+		{"dedupe 为 force", "", downloader.DownloadRequest{Playlist: playlist, Dedupe: downloader.DedupeForce}, ""},
+		{"配置的 dedupe 为 force", "force", downloader.DownloadRequest{UseArchive: true}, ""},
+		{"音频模式", "", downloader.DownloadRequest{UseArchive: true, Mode: downloader.ModeAudio}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{
+				config:  &config.Config{Downloader: config.DownloaderConfig{Dedupe: tt.dedupe}},
+				archive: archive,
+			}
+			if got := s.archiveFor(&tt.req, "alice"); got != tt.want {
+				t.Errorf("archiveFor = %q, 期望 %q", got, tt.want)
+			}
+		})
+	}
+}
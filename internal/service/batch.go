@@ -0,0 +1,196 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"video-hunter/internal/downloader"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// maxBatchSize 一次批量下载最多包含的链接数
+const maxBatchSize = 500
+
+// batchRequest 批量下载请求，除 urls、text 外的字段作为每个子任务的默认设置（格式、请求头、Cookie 等）
+type batchRequest struct {
+	downloader.DownloadRequest
+	URLs []string `json:"urls,omitempty"`
+	Text string   `json:"text,omitempty"` // 每行一个链接，忽略空行和 # 开头的行
+}
+
+// batchItem 批量下载中每个链接的结果
+type batchItem struct {
+	Index     int    `json:"index"` // 在提交的链接中的序号，从1开始
+	URL       string `json:"url"`
+	TaskID    string `json:"task_id,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"` // 与前面的链接重复，task_id 为前一个链接的任务
+	Error     string `json:"error,omitempty"`
+
+	first *batchItem // 重复链接第一次出现时的结果
+}
+
+// CreateBatch 为一组链接各创建一个下载任务，返回每个链接的结果和汇总进度的批次任务ID；
+// 无效的链接不影响其余链接，重复的链接只下载一次
+func (s *Service) CreateBatch(c *gin.Context) {
+	var req batchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if !s.prepareRequest(c, &req.DownloadRequest) {
+		return
+	}
+	if _, err := s.dedupePolicy(&req.DownloadRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	urls := req.batchURLs()
+	if len(urls) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 urls 或 text 参数"})
+		return
+	}
+	if len(urls) > maxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("一次最多提交 %d 个链接", maxBatchSize)})
+		return
+	}
+
+	owner := requesterFrom(c).name
+	if !s.checkQuota(c, owner) {
+		return
+	}
+
+	// 校验并去重，items 与 valid 一一对应
+	results := make([]*batchItem, 0, len(urls))
+	seen := make(map[string]*batchItem)
+	var valid []*batchItem
+	var items []groupItem
+	for i, rawURL := range urls {
+		result := &batchItem{Index: i + 1, URL: rawURL}
+		results = append(results, result)
+
+		key, err := s.validateDownloadURL(rawURL)
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		if first, ok := seen[key]; ok {
+			result.Duplicate = true
+			result.first = first
+			result.Error = fmt.Sprintf("与第 %d 个链接重复", first.Index)
+			continue
+		}
+		seen[key] = result
+		valid = append(valid, result)
+		items = append(items, groupItem{
+			URL:      rawURL,
+			Metadata: map[string]string{"batch_index": strconv.Itoa(result.Index)},
+		})
+	}
+
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有有效的链接", "items": results})
+		return
+	}
+
+	parent := &downloader.DownloadResponse{
+		Title: fmt.Sprintf("批量下载 (%d 个链接)", len(items)),
+		Owner: owner,
+		Metadata: map[string]string{
+			"type":  "batch",
+			"total": strconv.Itoa(len(items)),
+		},
+	}
+	// 批次任务本身不下载，不保存链接
+	req.URL = ""
+	if err := s.createTaskGroup(parent, &req.DownloadRequest, items); err != nil {
+		logrus.Warnf("拒绝批量下载任务: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("下载队列剩余容量不足，无法加入 %d 个链接", len(items))})
+		return
+	}
+
+	for i, result := range valid {
+		result.TaskID = parent.Children[i]
+	}
+	for _, result := range results {
+		if result.first != nil {
+			result.TaskID = result.first.TaskID
+		}
+	}
+	logrus.Infof("已创建批量下载任务 [%s]，共 %d 个链接，%d 个无效或重复", parent.ID, len(items), len(results)-len(items))
+
+	c.JSON(http.StatusOK, gin.H{
+		"batch_id": parent.ID,
+		"batch":    parent,
+		"created":  len(items),
+		"rejected": len(results) - len(items),
+		"items":    results,
+	})
+}
+
+// batchURLs 合并 url、urls 和 text 中的链接，保持提交的顺序
+func (r *batchRequest) batchURLs() []string {
+	var urls []string
+	add := func(value string) {
+		if value = strings.TrimSpace(value); value != "" && !strings.HasPrefix(value, "#") {
+			urls = append(urls, value)
+		}
+	}
+
+	add(r.URL)
+	for _, value := range r.URLs {
+		add(value)
+	}
+	for _, line := range strings.Split(r.Text, "\n") {
+		add(line)
+	}
+	return urls
+}
+
+// errUnsupportedURL 没有下载器可以处理该链接
+var errUnsupportedURL = errors.New("不支持的链接")
+
+// validateDownloadURL 校验链接是否为 http/https 地址并且有下载器可以处理，返回用于去重的规范形式
+func (s *Service) validateDownloadURL(rawURL string) (string, error) {
+	key, err := canonicalURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if len(s.registry.ResolveAll(rawURL)) == 0 {
+		return "", errUnsupportedURL
+	}
+	return key, nil
+}
+
+// canonicalURL 返回链接的规范形式：协议和主机名小写，去掉默认端口、片段和路径末尾的 /
+func canonicalURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "", errors.New("无效的URL，必须是 http 或 https 地址")
+	}
+
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
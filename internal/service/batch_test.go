@@ -0,0 +1,80 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"video-hunter/internal/downloader"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{url: "https://www.youtube.com/watch?v=abc", want: "https://www.youtube.com/watch?v=abc"},
+		{url: "HTTPS://WWW.YouTube.com/watch?v=abc", want: "https://www.youtube.com/watch?v=abc"},
+		{url: "https://www.youtube.com:443/watch?v=abc#t=10", want: "https://www.youtube.com/watch?v=abc"},
+		{url: "http://example.com:80/video/", want: "http://example.com/video"},
+		{url: "http://example.com:8080/video", want: "http://example.com:8080/video"},
+		{url: "https://example.com:80/video", want: "https://example.com:80/video"},
+		{url: "http://[::1]:80/video", want: "http://[::1]/video"},
+		{url: "http://[2001:DB8::1]:8080/", want: "http://[2001:db8::1]:8080"},
+		{url: "https://example.com/Video", want: "https://example.com/Video"},
+		{url: "ftp://example.com/video", wantErr: true},
+		{url: "example.com/video", wantErr: true},
+		{url: "https:///video", wantErr: true},
+		{url: "://bad", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := canonicalURL(tt.url)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("canonicalURL(%q) = %q, 期望返回错误", tt.url, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("canonicalURL(%q) = %q, %v, 期望 %q", tt.url, got, err, tt.want)
+		}
+	}
+}
+
+func TestBatchURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		req  batchRequest
+		want []string
+	}{
+		{
+			name: "按 url、urls、text 的顺序合并",
+			req: batchRequest{
+				DownloadRequest: downloader.DownloadRequest{URL: "https://a.com/1"},
+				URLs:            []string{"https://a.com/2", " https://a.com/3 "},
+				Text:            "https://a.com/4\nhttps://a.com/5",
+			},
+			want: []string{"https://a.com/1", "https://a.com/2", "https://a.com/3", "https://a.com/4", "https://a.com/5"},
+		},
+		{
+			name: "忽略空行和注释",
+			req:  batchRequest{Text: "# 收藏夹\r\n\nhttps://a.com/1\r\n  \n  # https://a.com/2\n"},
+			want: []string{"https://a.com/1"},
+		},
+		{
+			name: "保留重复的链接，由调用方标记",
+			req:  batchRequest{URLs: []string{"https://a.com/1", "https://a.com/1"}},
+			want: []string{"https://a.com/1", "https://a.com/1"},
+		},
+		{name: "没有链接", req: batchRequest{URLs: []string{"", " "}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.batchURLs(); !slices.Equal(got, tt.want) {
+				t.Errorf("batchURLs = %q, 期望 %q", got, tt.want)
+			}
+		})
+	}
+}
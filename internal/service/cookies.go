@@ -0,0 +1,491 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"video-hunter/internal/config"
+	"video-hunter/internal/downloader"
+	"video-hunter/internal/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// maxCookieUploadSize 上传 Cookie 的最大长度
+const maxCookieUploadSize = 1 << 20
+
+// cookieVault 加密保存上传的 Cookie，密钥来自 security.cookie_secret 或自动生成的 cookies.key
+type cookieVault struct {
+	aead cipher.AEAD
+	mu   sync.Mutex // 保证同一用户同一域名只有一份 Cookie
+}
+
+// newCookieVault 创建 Cookie 加密器；使用内存存储时 Cookie 不落盘，使用临时密钥
+func newCookieVault(cfg *config.Config) (*cookieVault, error) {
+	key, err := cookieKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("初始化 Cookie 加密失败: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("初始化 Cookie 加密失败: %w", err)
+	}
+	return &cookieVault{aead: aead}, nil
+}
+
+// cookieKey 返回 AES-256 密钥：security.cookie_secret 的 SHA-256，
+// 未配置时读取数据库目录下的 cookies.key，不存在则生成
+func cookieKey(cfg *config.Config) ([]byte, error) {
+	if cfg.Security.CookieSecret != "" {
+		sum := sha256.Sum256([]byte(cfg.Security.CookieSecret))
+		return sum[:], nil
+	}
+
+	key := make([]byte, 32)
+	if cfg.Database.Driver == "memory" || cfg.Database.Driver == "" {
+		_, err := rand.Read(key)
+		return key, err
+	}
+
+	path := filepath.Join(dataDir(cfg.Database), "cookies.key")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("Cookie 密钥文件无效: %s", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("读取 Cookie 密钥失败: %w", err)
+	}
+
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("生成 Cookie 密钥失败: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建 Cookie 密钥目录失败: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("保存 Cookie 密钥失败: %w", err)
+	}
+	logrus.Infof("已生成 Cookie 加密密钥: %s", path)
+	return key, nil
+}
+
+// seal 加密数据，随机 nonce 放在密文前面
+func (v *cookieVault) seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return v.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open 解密 seal 加密的数据
+func (v *cookieVault) open(ciphertext []byte) ([]byte, error) {
+	size := v.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, errors.New("Cookie 数据已损坏")
+	}
+	plaintext, err := v.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+	if err != nil {
+		return nil, errors.New("无法解密 Cookie，加密密钥可能已更改")
+	}
+	return plaintext, nil
+}
+
+// cookieSetInfo API 返回的 Cookie 集合，不包含 Cookie 内容
+type cookieSetInfo struct {
+	ID      string     `json:"id"`
+	Domain  string     `json:"domain"`
+	Owner   string     `json:"owner,omitempty"`
+	Count   int        `json:"count"`
+	Expires *time.Time `json:"expires,omitempty"` // 最早过期的 Cookie 的过期时间
+	Expired bool       `json:"expired"`           // 至少一个 Cookie 已过期，需要重新上传
+	Created time.Time  `json:"created"`
+	Updated time.Time  `json:"updated"`
+}
+
+// newCookieSetInfo 生成 API 返回的 Cookie 集合
+func newCookieSetInfo(set *store.CookieSet) cookieSetInfo {
+	return cookieSetInfo{
+		ID:      set.ID,
+		Domain:  set.Domain,
+		Owner:   set.Owner,
+		Count:   set.Count,
+		Expires: set.Expires,
+		Expired: cookieSetExpired(set),
+		Created: set.Created,
+		Updated: set.Updated,
+	}
+}
+
+// cookieSetExpired 判断 Cookie 集合中是否有已过期的 Cookie
+func cookieSetExpired(set *store.CookieSet) bool {
+	return set.Expires != nil && set.Expires.Before(time.Now())
+}
+
+// cookieUpload 上传 Cookie 的请求
+type cookieUpload struct {
+	Domain  string          `json:"domain"`  // 为空时使用 Cookie 中最短的域名，只允许来自同一网站
+	Content string          `json:"content"` // Netscape cookies.txt 或 JSON 文本
+	Cookies json.RawMessage `json:"cookies"` // 浏览器扩展导出的 JSON 数组
+}
+
+// jsonCookie 浏览器扩展（expirationDate）或 Playwright/Puppeteer（expires）导出的 Cookie
+type jsonCookie struct {
+	Domain         string   `json:"domain"`
+	Name           string   `json:"name"`
+	Value          string   `json:"value"`
+	Path           string   `json:"path"`
+	Secure         bool     `json:"secure"`
+	HTTPOnly       bool     `json:"httpOnly"`
+	HostOnly       bool     `json:"hostOnly"`
+	Session        bool     `json:"session"`
+	ExpirationDate *float64 `json:"expirationDate"`
+	Expires        *float64 `json:"expires"` // -1 表示会话 Cookie
+}
+
+// parseCookies 解析 Netscape cookies.txt 或 JSON 格式的 Cookie
+func parseCookies(data []byte) ([]*http.Cookie, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || (data[0] != '[' && data[0] != '{') {
+		return downloader.ParseNetscapeCookies(data)
+	}
+
+	var items []jsonCookie
+	if data[0] == '{' {
+		var item jsonCookie
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, fmt.Errorf("解析 JSON Cookie 失败: %w", err)
+		}
+		items = append(items, item)
+	} else if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("解析 JSON Cookie 失败: %w", err)
+	}
+
+	cookies := make([]*http.Cookie, 0, len(items))
+	for i, item := range items {
+		if item.Domain == "" || item.Name == "" {
+			return nil, fmt.Errorf("第 %d 个 Cookie 缺少 domain 或 name", i+1)
+		}
+		cookie := &http.Cookie{
+			Domain:   strings.ToLower(item.Domain),
+			Name:     item.Name,
+			Value:    item.Value,
+			Path:     item.Path,
+			Secure:   item.Secure,
+			HttpOnly: item.HTTPOnly,
+		}
+		if !item.HostOnly && !strings.HasPrefix(cookie.Domain, ".") {
+			cookie.Domain = "." + cookie.Domain
+		}
+		expires := item.ExpirationDate
+		if expires == nil {
+			expires = item.Expires
+		}
+		if !item.Session && expires != nil && *expires > 0 {
+			cookie.Expires = time.Unix(int64(*expires), 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies, nil
+}
+
+// normalizeCookieDomain 规范化 Cookie 集合的域名：小写，去掉开头的 .
+func normalizeCookieDomain(domain string) (string, error) {
+	domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain == "" || strings.ContainsAny(domain, "/:@ ") {
+		return "", fmt.Errorf("域名无效: %q", domain)
+	}
+	return domain, nil
+}
+
+// cookieRelated 判断 Cookie 是否属于该域名：Cookie 的域名是该域名、其子域名或其上级域名
+func cookieRelated(cookie *http.Cookie, domain string) bool {
+	cookieDomain := strings.TrimPrefix(cookie.Domain, ".")
+	return downloader.CookieMatchesHost(cookie, domain) ||
+		cookieDomain == domain || strings.HasSuffix(cookieDomain, "."+domain)
+}
+
+// ListCookies 列出当前用户上传的 Cookie 集合，管理员可以看到所有用户的并通过 ?owner= 过滤
+func (s *Service) ListCookies(c *gin.Context) {
+	owner, all := ownerFilter(c)
+	sets, err := s.store.ListCookieSets()
+	if err != nil {
+		logrus.Errorf("读取 Cookie 集合失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取 Cookie 集合失败"})
+		return
+	}
+
+	infos := make([]cookieSetInfo, 0, len(sets))
+	for _, set := range sets {
+		if all || set.Owner == owner {
+			infos = append(infos, newCookieSetInfo(set))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Domain != infos[j].Domain {
+			return infos[i].Domain < infos[j].Domain
+		}
+		return infos[i].Owner < infos[j].Owner
+	})
+	c.JSON(http.StatusOK, infos)
+}
+
+// UploadCookies 上传一个域名的 Cookie，支持 Netscape cookies.txt 和浏览器扩展导出的 JSON；
+// 请求体为 JSON（domain + content 或 cookies），也可以通过 multipart 表单的 domain、file 字段上传。
+// 同一用户同一域名的 Cookie 会被替换，与该域名无关的 Cookie 被忽略
+func (s *Service) UploadCookies(c *gin.Context) {
+	var req cookieUpload
+	var content []byte
+	if c.ContentType() == "multipart/form-data" {
+		req.Domain = c.PostForm("domain")
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 file 字段"})
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传的文件失败"})
+			return
+		}
+		content, err = io.ReadAll(io.LimitReader(f, maxCookieUploadSize))
+		f.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传的文件失败"})
+			return
+		}
+	} else {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCookieUploadSize)
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+			return
+		}
+		content = []byte(req.Content)
+		if len(req.Cookies) > 0 {
+			content = req.Cookies
+		}
+	}
+
+	cookies, err := parseCookies(content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(cookies) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有可以导入的 Cookie"})
+		return
+	}
+
+	// 未指定域名时使用最短的 Cookie 域名，通常是网站的主域名；来自多个网站时无法推断
+	inferred := req.Domain == ""
+	if inferred {
+		for _, cookie := range cookies {
+			domain := strings.TrimPrefix(cookie.Domain, ".")
+			if req.Domain == "" || len(domain) < len(req.Domain) {
+				req.Domain = domain
+			}
+		}
+	}
+	domain, err := normalizeCookieDomain(req.Domain)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var kept []*http.Cookie
+	var expires *time.Time
+	for _, cookie := range cookies {
+		if !cookieRelated(cookie, domain) {
+			if inferred {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Cookie 来自多个网站，请通过 domain 指定要导入的域名"})
+				return
+			}
+			continue
+		}
+		kept = append(kept, cookie)
+		if !cookie.Expires.IsZero() && (expires == nil || cookie.Expires.Before(*expires)) {
+			expires = &cookie.Expires
+		}
+	}
+	if len(kept) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("没有属于 %s 的 Cookie", domain)})
+		return
+	}
+
+	data, err := s.cookies.seal(downloader.FormatNetscapeCookies(kept))
+	if err != nil {
+		logrus.Errorf("加密 Cookie 失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "加密 Cookie 失败"})
+		return
+	}
+
+	owner := requesterFrom(c).name
+	set, replaced, err := s.saveCookieSet(owner, domain, func(set *store.CookieSet) {
+		set.Count = len(kept)
+		set.Expires = expires
+		set.Data = data
+	})
+	if err != nil {
+		logrus.Errorf("保存 Cookie 集合失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存 Cookie 集合失败"})
+		return
+	}
+	logrus.Infof("已保存 %s 的 Cookie [%s]: %d 个", domain, set.ID, set.Count)
+
+	status := http.StatusCreated
+	if replaced {
+		status = http.StatusOK
+	}
+	c.JSON(status, gin.H{
+		"cookie_set": newCookieSetInfo(set),
+		"replaced":   replaced,
+		"ignored":    len(cookies) - len(kept),
+	})
+}
+
+// saveCookieSet 创建或更新用户某个域名的 Cookie 集合，replaced 表示替换了已有的集合
+func (s *Service) saveCookieSet(owner, domain string, update func(*store.CookieSet)) (*store.CookieSet, bool, error) {
+	s.cookies.mu.Lock()
+	defer s.cookies.mu.Unlock()
+
+	sets, err := s.store.ListCookieSets()
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	set := &store.CookieSet{ID: uuid.New().String(), Domain: domain, Owner: owner, Created: now}
+	replaced := false
+	for _, existing := range sets {
+		if existing.Owner == owner && existing.Domain == domain {
+			set, replaced = existing, true
+			break
+		}
+	}
+	update(set)
+	set.Updated = now
+	return set, replaced, s.store.SaveCookieSet(set)
+}
+
+// DeleteCookies 删除 Cookie 集合
+func (s *Service) DeleteCookies(c *gin.Context) {
+	id := c.Param("id")
+	user := requesterFrom(c)
+
+	sets, err := s.store.ListCookieSets()
+	if err != nil {
+		logrus.Errorf("读取 Cookie 集合失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取 Cookie 集合失败"})
+		return
+	}
+	var found *store.CookieSet
+	for _, set := range sets {
+		if set.ID == id && user.canSee(set.Owner) {
+			found = set
+			break
+		}
+	}
+	if found == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": store.ErrCookieSetNotFound.Error()})
+		return
+	}
+
+	if err := s.store.DeleteCookieSet(id); err != nil && !errors.Is(err, store.ErrCookieSetNotFound) {
+		logrus.Errorf("删除 Cookie 集合失败 [%s]: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除 Cookie 集合失败"})
+		return
+	}
+	logrus.Infof("已删除 %s 的 Cookie [%s]", found.Domain, id)
+	c.JSON(http.StatusOK, gin.H{"message": "Cookie 已删除"})
+}
+
+// cookieSetFor 返回用户上传的与链接域名匹配的 Cookie 集合，多个匹配时使用最长（最具体）的域名
+func (s *Service) cookieSetFor(owner, rawURL string) (*store.CookieSet, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, nil
+	}
+	host := strings.ToLower(u.Hostname())
+
+	sets, err := s.store.ListCookieSets()
+	if err != nil {
+		return nil, err
+	}
+	var best *store.CookieSet
+	for _, set := range sets {
+		if set.Owner != owner || (host != set.Domain && !strings.HasSuffix(host, "."+set.Domain)) {
+			continue
+		}
+		if best == nil || len(set.Domain) > len(best.Domain) {
+			best = set
+		}
+	}
+	return best, nil
+}
+
+// withCookies 将链接匹配的 Cookie 解密到临时的 cookies.txt 并放入 ctx，
+// 由 yt-dlp 和抖音原生请求使用；返回的 cleanup 删除临时文件
+func (s *Service) withCookies(ctx context.Context, owner, rawURL string) (context.Context, func()) {
+	set, err := s.cookieSetFor(owner, rawURL)
+	if err != nil {
+		logrus.Warnf("读取 Cookie 集合失败，不使用上传的 Cookie: %v", err)
+	}
+	if set == nil {
+		return ctx, func() {}
+	}
+	if cookieSetExpired(set) {
+		logrus.Warnf("%s 的 Cookie 已有过期的条目 (%s)，请重新上传", set.Domain, set.Expires.Format(time.RFC3339))
+	}
+
+	data, err := s.cookies.open(set.Data)
+	if err != nil {
+		logrus.Errorf("解密 %s 的 Cookie 失败: %v", set.Domain, err)
+		return ctx, func() {}
+	}
+	file, err := os.CreateTemp("", "video-hunter-cookies-*.txt")
+	if err != nil {
+		logrus.Errorf("创建临时 Cookie 文件失败: %v", err)
+		return ctx, func() {}
+	}
+	cleanup := func() { os.Remove(file.Name()) }
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		logrus.Errorf("写入临时 Cookie 文件失败: %v", err)
+		return ctx, func() {}
+	}
+
+	logrus.Debugf("使用 %s 的 Cookie: %s", set.Domain, rawURL)
+	return downloader.WithCookieFile(ctx, file.Name()), cleanup
+}
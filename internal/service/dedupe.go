@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"video-hunter/internal/downloader"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// dedupePolicy 返回请求使用的重复视频处理方式，未指定时使用 downloader.dedupe
+func (s *Service) dedupePolicy(req *downloader.DownloadRequest) (downloader.DedupePolicy, error) {
+	policy := req.Dedupe
+	if policy == "" {
+		policy = downloader.DedupePolicy(s.config.Downloader.Dedupe)
+	}
+	switch policy {
+	case "":
+		return downloader.DedupeReuse, nil
+	case downloader.DedupeReuse, downloader.DedupeSkip, downloader.DedupeForce:
+		return policy, nil
+	}
+	return "", fmt.Errorf("dedupe 必须是 reuse、skip 或 force: %s", policy)
+}
+
+// deduplicate 解析视频的规范标识并按 dedupe 处理重复的视频；已响应请求时返回 handled 为 true，
+// 否则返回的 key 应保存到新任务中（无法解析标识时为零值，按普通任务下载）
+func (s *Service) deduplicate(c *gin.Context, req *downloader.DownloadRequest, owner string) (key downloader.VideoKey, handled bool) {
+	policy, err := s.dedupePolicy(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return key, true
+	}
+	if policy == downloader.DedupeForce || req.URL == "" {
+		return key, false
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), s.infoTimeout())
+	defer cancel()
+	ctx, cleanup := s.withCookies(ctx, owner, req.URL)
+	defer cleanup()
+
+	key, err = s.videoKey(ctx, req)
+	if err != nil {
+		logrus.Warnf("解析视频标识失败，跳过重复检查 [%s]: %v", req.URL, err)
+		return key, false
+	}
+
+	existing, completed := s.findDuplicate(owner, key.String())
+	if existing == nil {
+		return key, false
+	}
+
+	// 正在下载的任务无法共享文件，两种方式都返回已有的任务
+	if policy == downloader.DedupeSkip || !completed {
+		logrus.Infof("视频已下载或正在下载 [%s]，返回已有任务 %s", key, existing.ID)
+		s.respondTask(c, existing)
+		return key, true
+	}
+
+	download, err := s.reuseDownload(existing, req, owner, key)
+	if err != nil {
+		logrus.Warnf("复用已下载的文件失败，重新下载 [%s]: %v", key, err)
+		return key, false
+	}
+	s.respondTask(c, download)
+	return key, true
+}
+
+// dedupeBatchItem 在批量下载的子任务开始下载前解析视频标识并按 dedupe 处理重复的视频
+// （提交时逐个解析上百个链接太慢）；重复时任务直接完成并返回 true
+func (s *Service) dedupeBatchItem(ctx context.Context, id string, req *downloader.DownloadRequest, download *downloader.DownloadResponse) bool {
+	s.mu.RLock()
+	owner := download.Owner
+	pending := download.Metadata["batch_index"] != "" && download.Metadata["video_key"] == ""
+	s.mu.RUnlock()
+	if !pending {
+		return false
+	}
+	policy, err := s.dedupePolicy(req)
+	if err != nil || policy == downloader.DedupeForce {
+		return false
+	}
+
+	infoCtx, cancel := context.WithTimeout(ctx, s.infoTimeout())
+	key, err := s.videoKey(infoCtx, req)
+	cancel()
+	if err != nil {
+		logrus.Warnf("解析视频标识失败，跳过重复检查 [%s]: %v", req.URL, err)
+		return false
+	}
+
+	// 查找重复和记录标识在同一把锁内完成，同一批次中的相同视频只会下载一次
+	s.mu.Lock()
+	existing, completed := s.findDuplicateLocked(owner, key.String(), id)
+	setVideoKey(download, key)
+	s.mu.Unlock()
+	if existing == nil {
+		return false
+	}
+
+	var file string
+	if policy == downloader.DedupeReuse && completed {
+		if file, err = s.linkDownload(existing, id, owner); err != nil {
+			logrus.Warnf("复用已下载的文件失败，重新下载 [%s]: %v", key, err)
+			return false
+		}
+	}
+
+	// 没有复用文件时任务指向已有的任务，与单个下载返回已有任务一致
+	s.mu.Lock()
+	download.Status = downloader.StatusCompleted
+	download.Progress = 100
+	download.Stage = ""
+	download.StageProgress = 0
+	if file != "" {
+		download.File = file
+		download.Size = existing.Size
+		download.Metadata["reused_from"] = existing.ID
+	} else {
+		download.Metadata["duplicate_of"] = existing.ID
+	}
+	if download.Title == "" {
+		download.Title = existing.Title
+	}
+	download.Updated = time.Now()
+	existingID := existing.ID
+	s.mu.Unlock()
+	logrus.Infof("视频已下载或正在下载 [%s]，批量任务 %s 使用任务 %s 的结果", key, id, existingID)
+
+	s.publish(id, download)
+	s.notify(eventCompleted, id)
+	return true
+}
+
+// videoKey 使用第一个支持解析标识的下载器解析视频的规范标识，格式未指定时使用 ytdlp.format
+func (s *Service) videoKey(ctx context.Context, req *downloader.DownloadRequest) (downloader.VideoKey, error) {
+	for _, provider := range s.registry.ResolveAll(req.URL) {
+		if p, ok := provider.(downloader.IdentityProvider); ok {
+			extractor, videoID, err := p.Identify(ctx, req.URL)
+			if err != nil {
+				return downloader.VideoKey{}, err
+			}
+			return downloader.VideoKey{Extractor: extractor, VideoID: videoID, Format: s.keyFormat(req)}, nil
+		}
+	}
+	return downloader.VideoKey{}, errors.New("没有支持解析视频标识的下载器")
+}
+
+// keyFormat 返回视频标识中的格式，未指定时依次使用网站配置的 format 和 ytdlp.format；
+// 音频模式使用音频格式和音质，不与视频下载视为重复
+func (s *Service) keyFormat(req *downloader.DownloadRequest) string {
+	if req.Mode == downloader.ModeAudio {
+		return "audio-" + req.AudioFormat + "-" + req.AudioQuality
+	}
+	if req.Format != "" {
+		return req.Format
+	}
+	if site := s.config.Site(req.URL); site != nil && site.Format != "" {
+		return site.Format
+	}
+	if s.config.YtDlp.Format != "" {
+		return s.config.YtDlp.Format
+	}
+	return "best"
+}
+
+// setVideoKey 在任务的元数据中记录视频标识和下载存档中的记录
+func setVideoKey(download *downloader.DownloadResponse, key downloader.VideoKey) {
+	if key.Extractor == "" || key.VideoID == "" {
+		return
+	}
+	if download.Metadata == nil {
+		download.Metadata = make(map[string]string)
+	}
+	download.Metadata["video_key"] = key.String()
+	download.Metadata["archive_id"] = key.ArchiveID()
+}
+
+// findDuplicate 查找同一用户相同视频的任务，优先返回文件仍然存在的已完成任务（completed 为 true），
+// 其次返回未结束的任务；失败、取消和文件已删除的任务不算重复
+func (s *Service) findDuplicate(owner, key string) (download *downloader.DownloadResponse, completed bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findDuplicateLocked(owner, key, "")
+}
+
+// findDuplicateLocked 与 findDuplicate 相同，调用方需持有锁，exclude 为要排除的任务
+func (s *Service) findDuplicateLocked(owner, key, exclude string) (download *downloader.DownloadResponse, completed bool) {
+	var active *downloader.DownloadResponse
+	for _, candidate := range s.downloads {
+		if candidate.ID == exclude || candidate.Owner != owner || candidate.Metadata["video_key"] != key {
+			continue
+		}
+		switch candidate.Status {
+		case downloader.StatusCompleted:
+			if candidate.File == "" {
+				continue
+			}
+			if _, err := os.Stat(candidate.File); err == nil {
+				return candidate, true
+			}
+		case downloader.StatusFailed, downloader.StatusCancelled:
+		default:
+			active = candidate
+		}
+	}
+	return active, false
+}
+
+// reuseDownload 创建一个已完成的新任务，文件以硬链接（不支持时复制）指向已下载的文件，
+// 删除任意一个任务的文件都不影响另一个
+func (s *Service) reuseDownload(existing *downloader.DownloadResponse, req *downloader.DownloadRequest, owner string, key downloader.VideoKey) (*downloader.DownloadResponse, error) {
+	s.mu.RLock()
+	title, size, existingID := existing.Title, existing.Size, existing.ID
+	s.mu.RUnlock()
+
+	id := uuid.New().String()
+	target, err := s.linkDownload(existing, id, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	download := &downloader.DownloadResponse{
+		ID:       id,
+		Status:   downloader.StatusCompleted,
+		Progress: 100,
+		Title:    title,
+		File:     target,
+		Size:     size,
+		Owner:    owner,
+		Created:  now,
+		Updated:  now,
+		Metadata: map[string]string{
+			"reused_from": existingID,
+		},
+	}
+	setVideoKey(download, key)
+	if req.SaveToLocal {
+		download.Metadata["save_to_local"] = "true"
+	}
+
+	original := *req
+	original.TaskID = id
+	s.mu.Lock()
+	s.downloads[id] = download
+	s.requests[id] = &original
+	s.mu.Unlock()
+
+	logrus.Infof("视频已下载 [%s]，任务 %s 复用任务 %s 的文件", key, id, existingID)
+	s.publish(id, download)
+	s.notify(eventCreated, id)
+	s.notify(eventCompleted, id)
+	return download, nil
+}
+
+// linkDownload 将已有任务的文件以硬链接（不支持时复制）保存为任务 id 的文件，返回新文件的路径
+func (s *Service) linkDownload(existing *downloader.DownloadResponse, id, owner string) (string, error) {
+	s.mu.RLock()
+	source, existingID := existing.File, existing.ID
+	s.mu.RUnlock()
+
+	name := strings.TrimPrefix(filepath.Base(source), existingID+"_")
+	target := filepath.Join(s.ownerDir(owner), id+"_"+name)
+	if err := linkOrCopy(source, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// respondTask 返回任务的当前状态，在锁内序列化，避免与下载协程竞争
+func (s *Service) respondTask(c *gin.Context, download *downloader.DownloadResponse) {
+	s.mu.RLock()
+	data, err := json.Marshal(download)
+	s.mu.RUnlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "序列化任务失败"})
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// linkOrCopy 为文件创建硬链接，跨文件系统等无法链接时复制文件
+func linkOrCopy(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("创建下载目录失败: %w", err)
+	}
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(target)
+		return fmt.Errorf("复制文件失败: %w", err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"video-hunter/internal/config"
+	"video-hunter/internal/downloader"
+)
+
+func TestFindDuplicate(t *testing.T) {
+	const key = "youtube:abc:best"
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "done_video.mp4")
+	if err := os.WriteFile(file, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	task := func(id, owner string, status downloader.DownloadStatus, file, videoKey string) *downloader.DownloadResponse {
+		return &downloader.DownloadResponse{
+			ID:       id,
+			Owner:    owner,
+			Status:   status,
+			File:     file,
+			Metadata: map[string]string{"video_key": videoKey},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		tasks         []*downloader.DownloadResponse
+		owner         string
+		exclude       string
+		want          string
+		wantCompleted bool
+	}{
+		{
+			name:          "已完成的任务",
+			tasks:         []*downloader.DownloadResponse{task("a", "alice", downloader.StatusCompleted, file, key)},
+			owner:         "alice",
+			want:          "a",
+			wantCompleted: true,
+		},
+		{
+			name: "已完成优先于正在下载",
+			tasks: []*downloader.DownloadResponse{
+				task("a", "alice", downloader.StatusDownloading, "", key),
+				task("b", "alice", downloader.StatusCompleted, file, key),
+			},
+			owner:         "alice",
+			want:          "b",
+			wantCompleted: true,
+		},
+		{
+			name:  "正在下载的任务",
+			tasks: []*downloader.DownloadResponse{task("a", "alice", downloader.StatusPending, "", key)},
+			owner: "alice",
+			want:  "a",
+		},
+		{
+			name:  "其他用户的任务",
+			tasks: []*downloader.DownloadResponse{task("a", "bob", downloader.StatusCompleted, file, key)},
+			owner: "alice",
+		},
+		{
+			name:  "不同的格式",
+			tasks: []*downloader.DownloadResponse{task("a", "alice", downloader.StatusCompleted, file, "youtube:abc:1080p")},
+			owner: "alice",
+		},
+		{
+			name: "失败和取消的任务",
+			tasks: []*downloader.DownloadResponse{
+				task("a", "alice", downloader.StatusFailed, "", key),
+				task("b", "alice", downloader.StatusCancelled, "", key),
+			},
+			owner: "alice",
+		},
+		{
+			name: "文件已删除的任务",
+			tasks: []*downloader.DownloadResponse{
+				task("a", "alice", downloader.StatusCompleted, filepath.Join(dir, "missing.mp4"), key),
+				task("b", "alice", downloader.StatusCompleted, "", key),
+			},
+			owner: "alice",
+		},
+		{
+			name:    "排除任务自身",
+			tasks:   []*downloader.DownloadResponse{task("a", "alice", downloader.StatusDownloading, "", key)},
+			owner:   "alice",
+			exclude: "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{downloads: make(map[string]*downloader.DownloadResponse)}
+			for _, task := range tt.tasks {
+				s.downloads[task.ID] = task
+			}
+
+			s.mu.RLock()
+			got, completed := s.findDuplicateLocked(tt.owner, key, tt.exclude)
+			s.mu.RUnlock()
+			id := ""
+			if got != nil {
+				id = got.ID
+			}
+			if id != tt.want || completed != tt.wantCompleted {
+				t.Errorf("findDuplicateLocked = %q, %v, 期望 %q, %v", id, completed, tt.want, tt.wantCompleted)
+			}
+		})
+	}
+}
+
+func TestDedupePolicy(t *testing.T) {
+	tests := []struct {
+		config  string
+		req     downloader.DedupePolicy
+		want    downloader.DedupePolicy
+		wantErr bool
+	}{
+		{"", "", downloader.DedupeReuse, false},
+		{"skip", "", downloader.DedupeSkip, false},
+		{"skip", downloader.DedupeForce, downloader.DedupeForce, false},
+		{"", downloader.DedupeReuse, downloader.DedupeReuse, false},
+		{"", "always", "", true},
+		{"never", "", "", true},
+	}
+	for _, tt := range tests {
+		s := &Service{config: &config.Config{Downloader: config.DownloaderConfig{Dedupe: tt.config}}}
+		got, err := s.dedupePolicy(&downloader.DownloadRequest{Dedupe: tt.req})
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("dedupePolicy(%q, %q) = %q, %v, 期望 %q", tt.config, tt.req, got, err, tt.want)
+		}
+	}
+}
+
+func TestKeyFormat(t *testing.T) {
+	cfg := &config.Config{
+		YtDlp: config.YtDlpConfig{Format: "1080p"},
+		Sites: []config.SiteConfig{{Name: "bilibili", Hosts: []string{"bilibili.com"}, Format: "720p"}},
+	}
+
+	tests := []struct {
+		name   string
+		config *config.Config
+		req    downloader.DownloadRequest
+		want   string
+	}{
+		{"请求指定格式", cfg, downloader.DownloadRequest{URL: "https://www.bilibili.com/video/BV1", Format: "4k"}, "4k"},
+		{"网站配置的格式", cfg, downloader.DownloadRequest{URL: "https://www.bilibili.com/video/BV1"}, "720p"},
+		{"ytdlp.format", cfg, downloader.DownloadRequest{URL: "https://www.youtube.com/watch?v=abc"}, "1080p"},
+		{"没有配置格式", &config.Config{}, downloader.DownloadRequest{URL: "https://www.youtube.com/watch?v=abc"}, "best"},
+		{
+			"音频模式",
+			cfg,
+			downloader.DownloadRequest{URL: "https://www.youtube.com/watch?v=abc", Mode: downloader.ModeAudio, Format: "4k", AudioFormat: "mp3", AudioQuality: "0"},
+			"audio-mp3-0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{config: tt.config}
+			if got := s.keyFormat(&tt.req); got != tt.want {
+				t.Errorf("keyFormat = %q, 期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetVideoKey(t *testing.T) {
+	download := &downloader.DownloadResponse{}
+	setVideoKey(download, downloader.VideoKey{})
+	if download.Metadata != nil {
+		t.Errorf("没有标识时不应记录: %v", download.Metadata)
+	}
+
+	setVideoKey(download, downloader.VideoKey{Extractor: "youtube", VideoID: "abc", Format: "best"})
+	if got := download.Metadata["video_key"]; got != "youtube:abc:best" {
+		t.Errorf("video_key = %q, 期望 youtube:abc:best", got)
+	}
+	if got := download.Metadata["archive_id"]; got != "youtube abc" {
+		t.Errorf("archive_id = %q, 期望 \"youtube abc\"", got)
+	}
+}
+
+func TestLinkDownload(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "old_video.mp4")
+	if err := os.WriteFile(source, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Service{config: &config.Config{Downloader: config.DownloaderConfig{OutputDir: filepath.Join(dir, "downloads")}}}
+	target, err := s.linkDownload(&downloader.DownloadResponse{ID: "old", File: source}, "new", "")
+	if err != nil {
+		t.Fatalf("linkDownload 失败: %v", err)
+	}
+	if want := filepath.Join(dir, "downloads", "new_video.mp4"); target != want {
+		t.Errorf("新文件 = %q, 期望 %q", target, want)
+	}
+
+	// 删除原文件不影响复用的文件
+	if err := os.Remove(source); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(target); err != nil || string(data) != "video" {
+		t.Errorf("读取复用的文件 = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// sseKeepAlive 没有事件时发送注释行的间隔，避免代理关闭空闲连接
+	sseKeepAlive = 15 * time.Second
+	// sseRetry 建议客户端断线后重连的等待时间（毫秒）
+	sseRetry = 3000
+)
+
+// StreamEvents 通过 Server-Sent Events 推送当前用户任务的进度，消息与 WebSocket 相同；
+// ?ids= 指定只推送的任务ID（逗号分隔）
+func (s *Service) StreamEvents(c *gin.Context) {
+	s.streamEvents(c, parseTaskIDs(c.Query("ids")))
+}
+
+// StreamDownloadEvents 通过 Server-Sent Events 推送单个任务的进度
+func (s *Service) StreamDownloadEvents(c *gin.Context) {
+	id := c.Param("id")
+	if _, exists := s.lookupTask(c, id); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "下载任务不存在"})
+		return
+	}
+	s.streamEvents(c, []string{id})
+}
+
+// streamEvents 推送事件直到客户端断开；带有 Last-Event-ID 的重连请求补发错过的事件，
+// 无法补发时（事件已不在历史中或服务已重启）先发送所有任务的当前状态
+func (s *Service) streamEvents(c *gin.Context, ids []string) {
+	client := s.hub.register(nil, requesterFrom(c), ids)
+	defer s.hub.unregister(client)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// 禁止 nginx 缓冲响应
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+
+	replayed := false
+	if lastID, ok := lastEventID(c); ok {
+		if events, ok := s.hub.missed(client, lastID); ok {
+			for _, event := range events {
+				writeSSE(w, event.ID, event.Data)
+			}
+			replayed = true
+		}
+	}
+	if !replayed {
+		for _, message := range s.progressSnapshot(client, nil) {
+			if data, err := json.Marshal(message); err == nil {
+				writeSSE(w, client.since, data)
+			}
+		}
+	}
+	w.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-client.send:
+			if err := writeSSE(w, event.ID, event.Data); err != nil {
+				return
+			}
+			w.Flush()
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		case <-client.done:
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSE 写入一条事件，id 为 0 时不设置事件ID
+func writeSSE(w io.Writer, id uint64, data []byte) error {
+	if id > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
+
+// lastEventID 读取客户端最后收到的事件ID，EventSource 重连时通过 Last-Event-ID 请求头发送，
+// 也可以使用 ?last_event_id= 参数
+func lastEventID(c *gin.Context) (uint64, bool) {
+	value := c.GetHeader("Last-Event-ID")
+	if value == "" {
+		value = c.Query("last_event_id")
+	}
+	if value == "" {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
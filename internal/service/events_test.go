@@ -0,0 +1,145 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"video-hunter/internal/downloader"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHubMissed(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    requester
+		history []uint64 // 保留在历史中的事件序号，注册前共广播了 5 个事件
+		after   uint64
+		want    []uint64
+		wantOK  bool
+	}{
+		{"补发之后的事件", requester{admin: true}, []uint64{1, 2, 3, 4, 5}, 2, []uint64{3, 4, 5}, true},
+		{"没有错过事件", requester{admin: true}, []uint64{1, 2, 3, 4, 5}, 5, nil, true},
+		{"只补发可以访问的任务", requester{name: "alice"}, []uint64{1, 2, 3, 4, 5}, 0, []uint64{1, 3, 5}, true},
+		{"历史刚好覆盖", requester{admin: true}, []uint64{3, 4, 5}, 2, []uint64{3, 4, 5}, true},
+		{"事件已不在历史中", requester{admin: true}, []uint64{4, 5}, 2, nil, false},
+		{"没有历史", requester{admin: true}, nil, 2, nil, false},
+		{"服务重启前的事件ID", requester{admin: true}, []uint64{1, 2, 3, 4, 5}, 9, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newHub()
+			for i := 1; i <= 5; i++ {
+				owner := "alice"
+				if i%2 == 0 {
+					owner = "bob"
+				}
+				hub.broadcast("task", owner, nil)
+			}
+			hub.history = slices.DeleteFunc(hub.history, func(event hubEvent) bool {
+				return !slices.Contains(tt.history, event.ID)
+			})
+			client := hub.register(nil, tt.user, nil)
+			// 注册之后的事件通过发送队列接收，不会重复补发
+			hub.broadcast("task", "alice", nil)
+
+			events, ok := hub.missed(client, tt.after)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, 期望 %v", ok, tt.wantOK)
+			}
+			var got []uint64
+			for _, event := range events {
+				got = append(got, event.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("补发 %v, 期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamEventsReplay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		header string
+		query  string
+		want   []string // 响应中的事件ID
+	}{
+		{name: "首次连接发送当前状态", want: []string{"3"}},
+		{name: "重连时补发错过的事件", header: "1", want: []string{"2", "3"}},
+		{name: "没有错过事件", header: "3", want: nil},
+		{name: "通过查询参数指定", query: "2", want: []string{"3"}},
+		{name: "无法补发时发送当前状态", header: "9", want: []string{"3"}},
+		{name: "无效的事件ID", header: "abc", want: []string{"3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{
+				downloads: map[string]*downloader.DownloadResponse{"a": {ID: "a"}},
+				hub:       newHub(),
+			}
+			for _, id := range []string{"a", "a", "a"} {
+				s.hub.broadcast(id, "", map[string]string{"id": id})
+			}
+
+			target := "/events"
+			if tt.query != "" {
+				target += "?last_event_id=" + tt.query
+			}
+			// 请求已结束，写完补发的事件后立即返回
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+			if tt.header != "" {
+				req.Header.Set("Last-Event-ID", tt.header)
+			}
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = req
+			s.StreamEvents(c)
+
+			if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var ids []string
+			scanner := bufio.NewScanner(w.Body)
+			for scanner.Scan() {
+				if id, ok := strings.CutPrefix(scanner.Text(), "id: "); ok {
+					ids = append(ids, id)
+				}
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("事件ID = %q, 期望 %q\n%s", ids, tt.want, w.Body)
+			}
+			if len(s.hub.clients) != 0 {
+				t.Error("连接结束后应从连接列表中移除")
+			}
+		})
+	}
+}
+
+func TestWriteSSE(t *testing.T) {
+	tests := []struct {
+		id   uint64
+		data string
+		want string
+	}{
+		{7, `{"id":"a"}`, "id: 7\ndata: {\"id\":\"a\"}\n\n"},
+		{0, `{}`, "data: {}\n\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if err := writeSSE(&b, tt.id, []byte(tt.data)); err != nil || b.String() != tt.want {
+			t.Errorf("writeSSE(%d) = %q, %v, 期望 %q", tt.id, b.String(), err, tt.want)
+		}
+	}
+}
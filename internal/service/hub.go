@@ -0,0 +1,325 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// wsSendBuffer 每个客户端发送队列的长度，队列写满的慢客户端会被断开
+	wsSendBuffer = 256
+	// wsWriteWait 单条消息的写超时
+	wsWriteWait = 10 * time.Second
+	// wsPongWait 等待客户端 pong 的时间，超时视为连接已断开
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod 发送 ping 的间隔，必须小于 wsPongWait
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsMaxMessageSize 客户端消息的最大长度
+	wsMaxMessageSize = 64 * 1024
+	// eventHistorySize 保留的最近事件数，供 SSE 客户端断线重连后补发
+	eventHistorySize = 1000
+)
+
+// eventHub 管理所有 WebSocket 和 SSE 连接：每个连接有独立的发送队列和写协程，
+// 广播只向队列投递消息，不会在下载协程中直接写连接
+type eventHub struct {
+	mu      sync.RWMutex
+	clients map[*hubClient]struct{}
+	seq     uint64     // 最后一个事件的序号
+	history []hubEvent // 最近的事件，按序号递增
+}
+
+// hubEvent 一条广播的事件，ID 即 SSE 的事件ID
+type hubEvent struct {
+	ID     uint64
+	TaskID string
+	Owner  string
+	Data   []byte
+}
+
+// hubClient 一个 WebSocket 或 SSE 连接
+type hubClient struct {
+	hub   *eventHub
+	conn  *websocket.Conn // SSE 连接为 nil
+	user  requester       // 只接收该用户可以访问的任务消息
+	send  chan hubEvent   // 等待写协程发送的事件
+	done  chan struct{}   // 连接被移除时关闭
+	since uint64          // 注册时最后一个事件的序号，之后的事件都会进入发送队列
+
+	mu  sync.RWMutex
+	ids map[string]bool // 订阅的任务ID，为 nil 时接收所有可以访问的任务
+
+	closeOnce sync.Once
+}
+
+// wsCommand 客户端发送的订阅消息
+type wsCommand struct {
+	Action string   `json:"action"` // subscribe 或 unsubscribe
+	IDs    []string `json:"ids"`
+}
+
+// newHub 创建连接管理器
+func newHub() *eventHub {
+	return &eventHub{clients: make(map[*hubClient]struct{})}
+}
+
+// register 添加连接，conn 为 nil 表示 SSE 连接；ids 不为空时只接收这些任务的消息
+func (h *eventHub) register(conn *websocket.Conn, user requester, ids []string) *hubClient {
+	client := &hubClient{
+		hub:  h,
+		conn: conn,
+		user: user,
+		send: make(chan hubEvent, wsSendBuffer),
+		done: make(chan struct{}),
+	}
+	if len(ids) > 0 {
+		client.subscribe(ids)
+	}
+
+	h.mu.Lock()
+	client.since = h.seq
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+	return client
+}
+
+// missed 返回序号在 after 之后、连接注册之前的、连接可以接收的历史事件；
+// 这些事件已经不在历史中（或 after 来自服务重启之前）时返回 false
+func (h *eventHub) missed(client *hubClient, after uint64) ([]hubEvent, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if after > client.since {
+		return nil, false
+	}
+	if after < client.since && (len(h.history) == 0 || h.history[0].ID > after+1) {
+		return nil, false
+	}
+
+	var events []hubEvent
+	for _, event := range h.history {
+		if event.ID > after && event.ID <= client.since && client.accepts(event) {
+			events = append(events, event)
+		}
+	}
+	return events, true
+}
+
+// unregister 移除连接并结束它的写协程，可以重复调用
+func (h *eventHub) unregister(client *hubClient) {
+	h.mu.Lock()
+	delete(h.clients, client)
+	h.mu.Unlock()
+
+	client.closeOnce.Do(func() {
+		close(client.done)
+	})
+}
+
+// broadcast 记录事件，并向可以访问该所有者任务、并且订阅了该任务的连接发送，
+// 发送队列已满的连接会被断开，避免慢客户端拖慢下载或占用内存
+func (h *eventHub) broadcast(id, owner string, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		logrus.Errorf("序列化WebSocket消息失败: %v", err)
+		return
+	}
+
+	var slow []*hubClient
+	h.mu.Lock()
+	h.seq++
+	event := hubEvent{ID: h.seq, TaskID: id, Owner: owner, Data: data}
+	h.history = append(h.history, event)
+	if len(h.history) > eventHistorySize {
+		h.history = append(h.history[:0:0], h.history[len(h.history)-eventHistorySize:]...)
+	}
+	// 在锁内投递，保证每个连接收到的事件按序号递增
+	for client := range h.clients {
+		if !client.accepts(event) {
+			continue
+		}
+		if !client.enqueue(event) {
+			slow = append(slow, client)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, client := range slow {
+		logrus.Warnf("客户端 %s 接收过慢，已断开连接", client.remoteAddr())
+		h.unregister(client)
+	}
+}
+
+// close 断开所有连接
+func (h *eventHub) close() {
+	h.mu.RLock()
+	clients := make([]*hubClient, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		h.unregister(client)
+	}
+}
+
+// enqueue 将事件放入发送队列，队列已满时返回 false
+func (c *hubClient) enqueue(event hubEvent) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+	}
+
+	select {
+	case c.send <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendJSON 将只发给该连接的消息放入发送队列，队列已满时断开连接
+func (c *hubClient) sendJSON(message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		logrus.Errorf("序列化WebSocket消息失败: %v", err)
+		return
+	}
+	if !c.enqueue(hubEvent{Data: data}) {
+		logrus.Warnf("客户端 %s 接收过慢，已断开连接", c.remoteAddr())
+		c.hub.unregister(c)
+	}
+}
+
+// remoteAddr 返回连接的地址，用于日志
+func (c *hubClient) remoteAddr() string {
+	if c.conn == nil {
+		return "SSE"
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+// accepts 判断连接是否应该收到事件
+func (c *hubClient) accepts(event hubEvent) bool {
+	return c.user.canSee(event.Owner) && c.subscribed(event.TaskID)
+}
+
+// subscribed 判断连接是否订阅了任务
+func (c *hubClient) subscribed(id string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ids == nil || c.ids[id]
+}
+
+// subscribe 订阅任务，ids 为空时恢复为接收所有任务
+func (c *hubClient) subscribe(ids []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(ids) == 0 {
+		c.ids = nil
+		return
+	}
+	if c.ids == nil {
+		c.ids = make(map[string]bool)
+	}
+	for _, id := range ids {
+		c.ids[id] = true
+	}
+}
+
+// unsubscribe 取消订阅任务
+func (c *hubClient) unsubscribe(ids []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ids == nil {
+		c.ids = make(map[string]bool)
+	}
+	for _, id := range ids {
+		delete(c.ids, id)
+	}
+}
+
+// writeJSON 直接写入一条消息，只能在写协程启动前调用
+func (c *hubClient) writeJSON(message interface{}) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteJSON(message)
+}
+
+// writePump WebSocket 连接唯一的写协程：发送队列中的消息并定时发送 ping
+func (c *hubClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case event := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, event.Data); err != nil {
+				logrus.Debugf("发送WebSocket消息失败: %v", err)
+				c.hub.unregister(c)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.hub.unregister(c)
+				return
+			}
+		case <-c.done:
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
+
+// readPump 读取客户端消息直到连接断开，收到 pong 时延长读超时
+func (c *hubClient) readPump(handle func(cmd *wsCommand)) {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logrus.Errorf("读取WebSocket消息失败: %v", err)
+			}
+			return
+		}
+		// 收到任何消息都说明连接仍然可用
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var cmd wsCommand
+		if err := json.Unmarshal(data, &cmd); err != nil || cmd.Action == "" {
+			continue
+		}
+		handle(&cmd)
+	}
+}
+
+// parseTaskIDs 解析逗号分隔的任务ID列表
+func parseTaskIDs(value string) []string {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
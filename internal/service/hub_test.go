@@ -0,0 +1,139 @@
+package service
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// drain 取出连接发送队列中已有的事件的任务ID
+func drain(client *hubClient) []string {
+	var ids []string
+	for {
+		select {
+		case event := <-client.send:
+			ids = append(ids, event.TaskID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestHubBroadcastFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		user requester
+		ids  []string
+		want []string
+	}{
+		{"普通用户只收到自己的任务", requester{name: "alice"}, nil, []string{"a1", "a2"}},
+		{"管理员收到所有任务", requester{admin: true}, nil, []string{"a1", "b1", "a2"}},
+		{"只收到订阅的任务", requester{name: "alice"}, []string{"a2"}, []string{"a2"}},
+		{"订阅其他用户的任务无效", requester{name: "alice"}, []string{"b1"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newHub()
+			client := hub.register(nil, tt.user, tt.ids)
+			hub.broadcast("a1", "alice", "a1")
+			hub.broadcast("b1", "bob", "b1")
+			hub.broadcast("a2", "alice", "a2")
+
+			if got := drain(client); !slices.Equal(got, tt.want) {
+				t.Errorf("收到 %q, 期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHubSubscribe(t *testing.T) {
+	hub := newHub()
+	client := hub.register(nil, requester{admin: true}, []string{"a"})
+
+	client.subscribe([]string{"b"})
+	client.unsubscribe([]string{"a"})
+	for _, id := range []string{"a", "b", "c"} {
+		hub.broadcast(id, "", nil)
+	}
+	if got := drain(client); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("收到 %q, 期望 [b]", got)
+	}
+
+	// 订阅空列表时恢复为接收所有任务
+	client.subscribe(nil)
+	for _, id := range []string{"a", "b", "c"} {
+		hub.broadcast(id, "", nil)
+	}
+	if got := drain(client); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("收到 %q, 期望 [a b c]", got)
+	}
+}
+
+func TestHubEvictsSlowClient(t *testing.T) {
+	hub := newHub()
+	slow := hub.register(nil, requester{admin: true}, nil)
+	fast := hub.register(nil, requester{admin: true}, nil)
+
+	for i := 0; i < wsSendBuffer; i++ {
+		hub.broadcast("a", "", nil)
+		drain(fast)
+	}
+	select {
+	case <-slow.done:
+		t.Fatal("发送队列未满时不应断开连接")
+	default:
+	}
+
+	// 队列写满后的下一条事件断开慢客户端，不影响其他连接
+	hub.broadcast("b", "", nil)
+	select {
+	case <-slow.done:
+	default:
+		t.Fatal("发送队列已满的连接应被断开")
+	}
+	if _, ok := hub.clients[slow]; ok {
+		t.Error("被断开的连接应从连接列表中移除")
+	}
+	if got := drain(fast); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("其他连接收到 %q, 期望 [b]", got)
+	}
+
+	// 断开后继续广播和发送不会阻塞，重复移除也是安全的
+	hub.broadcast("c", "", nil)
+	slow.sendJSON("pong")
+	hub.unregister(slow)
+}
+
+func TestHubSendJSONEvictsSlowClient(t *testing.T) {
+	hub := newHub()
+	client := hub.register(nil, requester{admin: true}, nil)
+	for i := 0; i < wsSendBuffer; i++ {
+		client.sendJSON(map[string]int{"i": i})
+	}
+	client.sendJSON(map[string]int{"i": wsSendBuffer})
+	select {
+	case <-client.done:
+	default:
+		t.Fatal("发送队列已满的连接应被断开")
+	}
+
+	event := <-client.send
+	var message map[string]int
+	if err := json.Unmarshal(event.Data, &message); err != nil || message["i"] != 0 {
+		t.Errorf("第一条消息 = %s, 期望 {\"i\":0}", event.Data)
+	}
+}
+
+func TestHubHistoryLimit(t *testing.T) {
+	hub := newHub()
+	for i := 0; i < eventHistorySize+10; i++ {
+		hub.broadcast("a", "", nil)
+	}
+	if len(hub.history) != eventHistorySize {
+		t.Fatalf("保留了 %d 个事件, 期望 %d", len(hub.history), eventHistorySize)
+	}
+	if first, last := hub.history[0].ID, hub.history[len(hub.history)-1].ID; first != 11 || last != eventHistorySize+10 {
+		t.Errorf("事件序号范围 = %d-%d, 期望 11-%d", first, last, eventHistorySize+10)
+	}
+}
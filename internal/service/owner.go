@@ -0,0 +1,137 @@
+package service
+
+import (
+	"io/fs"
+	"net/http"
+	"path/filepath"
+
+	"video-hunter/internal/downloader"
+	"video-hunter/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requester 发起请求的用户
+type requester struct {
+	name  string // API密钥名称，未启用认证时为空
+	admin bool   // 管理员可以查看和操作所有用户的任务
+}
+
+// requesterFrom 返回当前请求的用户，未启用认证时视为管理员
+func requesterFrom(c *gin.Context) requester {
+	identity := middleware.CurrentIdentity(c)
+	if identity == nil {
+		return requester{admin: true}
+	}
+	return requester{name: identity.Name, admin: identity.HasScope(middleware.ScopeAdmin)}
+}
+
+// owns 判断用户是否可以访问任务
+func (r requester) owns(download *downloader.DownloadResponse) bool {
+	return r.admin || download.Owner == r.name
+}
+
+// canSee 判断用户是否可以接收某个所有者的任务消息
+func (r requester) canSee(owner string) bool {
+	return r.admin || owner == r.name
+}
+
+// lookupTask 查找用户可以访问的任务，其他用户的任务视为不存在；
+// 返回的任务仍会被下载协程修改，读取字段或序列化时需要持有 s.mu
+func (s *Service) lookupTask(c *gin.Context, id string) (*downloader.DownloadResponse, bool) {
+	user := requesterFrom(c)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	download, exists := s.downloads[id]
+	if !exists || !user.owns(download) {
+		return nil, false
+	}
+	return download, true
+}
+
+// ownerFilter 返回列表和清空操作要处理的所有者：普通用户只能处理自己的任务，
+// 管理员默认处理所有任务，可以通过 ?owner= 指定用户；返回 all 为 true 表示不过滤
+func ownerFilter(c *gin.Context) (owner string, all bool) {
+	user := requesterFrom(c)
+	if !user.admin {
+		return user.name, false
+	}
+	if owner, ok := c.GetQuery("owner"); ok {
+		return owner, false
+	}
+	return "", true
+}
+
+// quotaBytes 返回用户的磁盘配额（字节），0 表示不限制
+func (s *Service) quotaBytes(owner string) int64 {
+	// 未启用认证时只有一个用户，不限制配额
+	if !s.config.Security.Auth.Enabled {
+		return 0
+	}
+	quota := s.config.Downloader.UserQuotaMB
+	for _, key := range s.config.Security.Auth.Keys {
+		if key.Name == owner && key.QuotaMB > 0 {
+			quota = key.QuotaMB
+		}
+	}
+	return int64(quota) * 1024 * 1024
+}
+
+// ownerDir 返回用户的下载目录，启用认证时每个用户使用 downloader.output_dir 下的独立子目录
+func (s *Service) ownerDir(owner string) string {
+	name := filepath.Base(owner)
+	if owner == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+		return s.config.Downloader.OutputDir
+	}
+	return filepath.Join(s.config.Downloader.OutputDir, name)
+}
+
+// diskUsage 统计用户下载目录占用的空间和文件数
+func (s *Service) diskUsage(owner string) (used int64, files int) {
+	filepath.WalkDir(s.ownerDir(owner), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			used += info.Size()
+			files++
+		}
+		return nil
+	})
+	return used, files
+}
+
+// checkQuota 判断用户是否还有磁盘配额，超出时返回 false 并响应 507
+func (s *Service) checkQuota(c *gin.Context, owner string) bool {
+	quota := s.quotaBytes(owner)
+	if quota <= 0 {
+		return true
+	}
+	if used, _ := s.diskUsage(owner); used >= quota {
+		c.JSON(http.StatusInsufficientStorage, gin.H{
+			"error":       "磁盘配额已用完，请删除部分已下载的文件后再试",
+			"used_bytes":  used,
+			"quota_bytes": quota,
+		})
+		return false
+	}
+	return true
+}
+
+// GetUsage 获取当前用户的磁盘占用和配额
+func (s *Service) GetUsage(c *gin.Context) {
+	owner := requesterFrom(c).name
+	if name, ok := c.GetQuery("owner"); ok && requesterFrom(c).admin {
+		owner = name
+	}
+
+	used, files := s.diskUsage(owner)
+	c.JSON(http.StatusOK, gin.H{
+		"owner":       owner,
+		"used_bytes":  used,
+		"quota_bytes": s.quotaBytes(owner),
+		"files":       files,
+	})
+}
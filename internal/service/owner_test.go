@@ -0,0 +1,141 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"video-hunter/internal/config"
+	"video-hunter/internal/downloader"
+	"video-hunter/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newOwnerTestRouter 创建包含 alice 和 bob 两个任务的服务，按路由表的方式挂载认证
+func newOwnerTestRouter(enabled bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	s := &Service{
+		downloads: map[string]*downloader.DownloadResponse{
+			"a": {ID: "a", Owner: "alice"},
+			"b": {ID: "b", Owner: "bob"},
+		},
+	}
+	auth := middleware.NewAuthenticator(config.AuthConfig{
+		Enabled: enabled,
+		Keys: []config.APIKeyConfig{
+			{Name: "alice", Key: "alice-key", Scopes: []string{"download"}},
+			{Name: "bob", Key: "bob-key", Scopes: []string{"read"}},
+			{Name: "root", Key: "admin-key", Scopes: []string{"admin"}},
+		},
+	})
+
+	router := gin.New()
+	requireRead := auth.Require(middleware.ScopeRead, false)
+	router.GET("/downloads", requireRead, s.GetDownloads)
+	router.GET("/downloads/:id", requireRead, s.GetDownload)
+	return router
+}
+
+func TestGetDownloadOwner(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		key     string
+		id      string
+		want    int
+	}{
+		{"访问自己的任务", true, "alice-key", "a", http.StatusOK},
+		{"其他用户的任务视为不存在", true, "alice-key", "b", http.StatusNotFound},
+		{"只读密钥访问自己的任务", true, "bob-key", "b", http.StatusOK},
+		{"只读密钥访问其他用户的任务", true, "bob-key", "a", http.StatusNotFound},
+		{"管理员访问任意任务", true, "admin-key", "b", http.StatusOK},
+		{"任务不存在", true, "admin-key", "c", http.StatusNotFound},
+		{"未启用认证时访问任意任务", false, "", "a", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newOwnerTestRouter(tt.enabled)
+			req := httptest.NewRequest(http.MethodGet, "/downloads/"+tt.id, nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("状态码 = %d, 期望 %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestGetDownloadsOwnerFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		key     string
+		query   string
+		want    []string
+	}{
+		{"普通用户只看到自己的任务", true, "alice-key", "", []string{"a"}},
+		{"普通用户不能指定 owner", true, "alice-key", "?owner=bob", []string{"a"}},
+		{"管理员默认看到所有任务", true, "admin-key", "", []string{"a", "b"}},
+		{"管理员按 owner 过滤", true, "admin-key", "?owner=bob", []string{"b"}},
+		{"管理员查询没有任务的用户", true, "admin-key", "?owner=", nil},
+		{"未启用认证时看到所有任务", false, "", "", []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newOwnerTestRouter(tt.enabled)
+			req := httptest.NewRequest(http.MethodGet, "/downloads"+tt.query, nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("状态码 = %d: %s", w.Code, w.Body)
+			}
+
+			var downloads []downloader.DownloadResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &downloads); err != nil {
+				t.Fatalf("解析响应失败: %v", err)
+			}
+			var ids []string
+			for _, download := range downloads {
+				ids = append(ids, download.ID)
+			}
+			slices.Sort(ids)
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("任务 = %q, 期望 %q", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequesterCanSee(t *testing.T) {
+	tests := []struct {
+		user  requester
+		owner string
+		want  bool
+	}{
+		{requester{name: "alice"}, "alice", true},
+		{requester{name: "alice"}, "bob", false},
+		{requester{name: "alice"}, "", false},
+		{requester{name: "root", admin: true}, "bob", true},
+		{requester{admin: true}, "", true},
+	}
+	for _, tt := range tests {
+		if got := tt.user.canSee(tt.owner); got != tt.want {
+			t.Errorf("%+v canSee(%q) = %v, 期望 %v", tt.user, tt.owner, got, tt.want)
+		}
+		if got := tt.user.owns(&downloader.DownloadResponse{Owner: tt.owner}); got != tt.want {
+			t.Errorf("%+v owns(%q) = %v, 期望 %v", tt.user, tt.owner, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"video-hunter/internal/downloader"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// playlistRequest 创建播放列表任务的请求，除 items 外的字段会应用到每个子任务
+type playlistRequest struct {
+	downloader.DownloadRequest
+	Items string `json:"items,omitempty"` // 要下载的条目，例如 "1-3,7,10-"，为空时下载全部
+}
+
+// CreatePlaylist 展开播放列表、频道或多P视频，为每个条目创建一个子任务
+func (s *Service) CreatePlaylist(c *gin.Context) {
+	var req playlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少URL参数或参数格式错误"})
+		return
+	}
+	if !s.prepareRequest(c, &req.DownloadRequest) {
+		return
+	}
+	// 子任务记录规范化的播放列表地址，每个播放列表或频道使用单独的下载存档
+	playlistKey, err := canonicalURL(req.URL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Playlist = playlistKey
+
+	owner := requesterFrom(c).name
+	if !s.checkQuota(c, owner) {
+		return
+	}
+
+	selected, err := downloader.ParsePlaylistItems(req.Items)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), s.infoTimeout())
+	defer cancel()
+	ctx, cleanup := s.withCookies(ctx, owner, req.URL)
+	defer cleanup()
+
+	playlist, err := s.getPlaylist(ctx, req.URL)
+	if err != nil {
+		logrus.Errorf("获取播放列表失败: %v", err)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": fmt.Sprintf("获取播放列表超时（%s）", s.infoTimeout())})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取播放列表失败: " + downloader.ErrorSummary(err)})
+		return
+	}
+
+	var entries []downloader.PlaylistEntry
+	for _, entry := range playlist.Entries {
+		if selected(entry.Index) {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "播放列表中没有符合条件的条目"})
+		return
+	}
+
+	// 跳过下载存档中已有的条目，重新下载频道时只下载新视频
+	entries, archived, err := s.skipArchived(&req.DownloadRequest, owner, entries)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("播放列表中的 %d 个条目都已在下载存档中", archived)})
+		return
+	}
+
+	parent, err := s.createPlaylistTasks(&req, owner, playlist, entries, archived)
+	if err != nil {
+		logrus.Warnf("拒绝播放列表任务 [%s]: %v", req.URL, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("下载队列剩余容量不足，无法加入 %d 个条目", len(entries))})
+		return
+	}
+
+	c.JSON(http.StatusOK, parent)
+}
+
+// getPlaylist 使用第一个支持播放列表的下载器列出条目
+func (s *Service) getPlaylist(ctx context.Context, url string) (*downloader.Playlist, error) {
+	for _, provider := range s.registry.ResolveAll(url) {
+		if p, ok := provider.(downloader.PlaylistProvider); ok {
+			return p.GetPlaylist(ctx, url)
+		}
+	}
+	return nil, fmt.Errorf("没有支持播放列表的下载器: %s", url)
+}
+
+// createPlaylistTasks 创建播放列表任务及其子任务，并将子任务全部加入下载队列
+func (s *Service) createPlaylistTasks(req *playlistRequest, owner string, playlist *downloader.Playlist, entries []downloader.PlaylistEntry, archived int) (*downloader.DownloadResponse, error) {
+	parent := &downloader.DownloadResponse{
+		Title: playlist.Title,
+		Owner: owner,
+		Metadata: map[string]string{
+			"type":        "playlist",
+			"playlist_id": playlist.ID,
+			"total":       strconv.Itoa(len(entries)),
+		},
+	}
+	if req.Items != "" {
+		parent.Metadata["items"] = req.Items
+	}
+	if archived > 0 {
+		parent.Metadata["archived"] = strconv.Itoa(archived)
+	}
+
+	items := make([]groupItem, 0, len(entries))
+	for _, entry := range entries {
+		child := &downloader.DownloadResponse{
+			Metadata: map[string]string{"playlist_index": strconv.Itoa(entry.Index)},
+		}
+		setVideoKey(child, downloader.VideoKey{Extractor: entry.Extractor, VideoID: entry.ID, Format: s.keyFormat(&req.DownloadRequest)})
+		items = append(items, groupItem{
+			URL:      entry.URL,
+			Title:    entry.Title,
+			Metadata: child.Metadata,
+		})
+	}
+
+	if err := s.archive.register(owner, req.Playlist); err != nil {
+		logrus.Warnf("记录播放列表存档失败 [%s]: %v", req.URL, err)
+	}
+	if err := s.createTaskGroup(parent, &req.DownloadRequest, items); err != nil {
+		return nil, err
+	}
+	logrus.Infof("已创建播放列表任务 [%s]: %s，共 %d 个条目", parent.ID, playlist.Title, len(entries))
+	return parent, nil
+}
+
+// skipArchived 去掉播放列表存档中已有的条目，返回剩余的条目和跳过的数量；dedupe 为 force 或音频模式时不跳过
+func (s *Service) skipArchived(req *downloader.DownloadRequest, owner string, entries []downloader.PlaylistEntry) ([]downloader.PlaylistEntry, int, error) {
+	policy, err := s.dedupePolicy(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if policy == downloader.DedupeForce || req.Mode == downloader.ModeAudio {
+		return entries, 0, nil
+	}
+
+	known, err := s.archive.contains(s.archive.playlistPath(owner, req.Playlist))
+	if err != nil {
+		logrus.Warnf("读取下载存档失败，不跳过已下载的条目: %v", err)
+		return entries, 0, nil
+	}
+
+	remaining := make([]downloader.PlaylistEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Extractor != "" && known[entry.Extractor+" "+entry.ID] {
+			continue
+		}
+		remaining = append(remaining, entry)
+	}
+	return remaining, len(entries) - len(remaining), nil
+}
+
+// groupItem 任务组中的一个子任务
+type groupItem struct {
+	URL      string
+	Title    string
+	Metadata map[string]string
+}
+
+// createTaskGroup 创建父任务和子任务，子任务使用 base 请求的其余字段，并全部加入下载队列；
+// 队列容量不足时撤销整个任务组。父任务的状态和进度由 updatePlaylist 根据子任务汇总
+func (s *Service) createTaskGroup(parent *downloader.DownloadResponse, base *downloader.DownloadRequest, items []groupItem) error {
+	now := time.Now()
+	parent.ID = uuid.New().String()
+	parent.Status = downloader.StatusPending
+	parent.Created = now
+	parent.Updated = now
+	parent.Children = nil
+
+	parentReq := *base
+	parentReq.TaskID = parent.ID
+
+	childReqs := make([]*downloader.DownloadRequest, 0, len(items))
+	children := make([]*downloader.DownloadResponse, 0, len(items))
+	for _, item := range items {
+		childID := uuid.New().String()
+		parent.Children = append(parent.Children, childID)
+
+		childReq := *base
+		childReq.URL = item.URL
+		childReq.TaskID = childID
+		childReqs = append(childReqs, &childReq)
+
+		child := &downloader.DownloadResponse{
+			ID:       childID,
+			Status:   downloader.StatusPending,
+			Title:    item.Title,
+			ParentID: parent.ID,
+			Owner:    parent.Owner,
+			Created:  now,
+			Updated:  now,
+			Metadata: make(map[string]string, len(item.Metadata)+1),
+		}
+		for k, v := range item.Metadata {
+			child.Metadata[k] = v
+		}
+		if base.SaveToLocal {
+			child.Metadata["save_to_local"] = "true"
+		}
+		children = append(children, child)
+	}
+
+	// 保存任务记录，下载过程中会修改请求，因此单独保存一份原始请求
+	s.mu.Lock()
+	s.downloads[parent.ID] = parent
+	s.requests[parent.ID] = &parentReq
+	for i, child := range children {
+		original := *childReqs[i]
+		s.downloads[child.ID] = child
+		s.requests[child.ID] = &original
+	}
+	s.mu.Unlock()
+
+	s.saveTask(parent.ID)
+	tasks := make([]*downloadTask, 0, len(children))
+	for i, child := range children {
+		s.saveTask(child.ID)
+		tasks = append(tasks, s.newDownloadTask(child.ID, childReqs[i]))
+	}
+
+	// 子任务必须全部加入队列，队列容量不足时撤销整个任务组
+	if err := s.scheduler.enqueueAll(tasks); err != nil {
+		for _, child := range children {
+			s.releaseTask(child.ID)
+			s.discardTask(child.ID)
+		}
+		s.discardTask(parent.ID)
+		return err
+	}
+
+	s.broadcastProgress(parent.ID, parent)
+	s.notify(eventCreated, parent.ID)
+	for _, child := range children {
+		s.notify(eventCreated, child.ID)
+	}
+	return nil
+}
+
+// updatePlaylist 根据子任务汇总播放列表任务的状态和进度，并保存和广播；
+// 状态发生变化时向 webhook 发送对应的事件
+func (s *Service) updatePlaylist(id string) {
+	s.mu.Lock()
+	parent, exists := s.downloads[id]
+	if !exists {
+		s.mu.Unlock()
+		return
+	}
+	previous := parent.Status
+	s.aggregatePlaylistLocked(parent)
+	status := parent.Status
+	s.mu.Unlock()
+
+	s.saveTask(id)
+	s.broadcastProgress(id, parent)
+
+	if status == previous {
+		return
+	}
+	switch status {
+	case downloader.StatusDownloading:
+		s.notify(eventStarted, id)
+	case downloader.StatusCompleted:
+		s.notify(eventCompleted, id)
+	case downloader.StatusFailed:
+		s.notify(eventFailed, id)
+	case downloader.StatusCancelled:
+		s.notify(eventCancelled, id)
+	}
+}
+
+// refreshPlaylists 重新汇总所有播放列表任务的状态
+func (s *Service) refreshPlaylists() {
+	s.mu.RLock()
+	var ids []string
+	for id, download := range s.downloads {
+		if len(download.Children) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, id := range ids {
+		s.updatePlaylist(id)
+	}
+}
+
+// aggregatePlaylistLocked 汇总子任务的状态：进度取未取消子任务的平均值，
+// 有子任务未结束时为下载中，全部结束后有失败为失败，全部取消为取消，否则为完成
+func (s *Service) aggregatePlaylistLocked(parent *downloader.DownloadResponse) {
+	counts := make(map[downloader.DownloadStatus]int)
+	var progress float64
+	for _, childID := range parent.Children {
+		child, ok := s.downloads[childID]
+		if !ok {
+			continue
+		}
+		counts[child.Status]++
+		switch child.Status {
+		case downloader.StatusCompleted:
+			progress += 100
+		case downloader.StatusCancelled:
+		default:
+			progress += child.Progress
+		}
+	}
+
+	total := len(parent.Children)
+	finished := counts[downloader.StatusCompleted] + counts[downloader.StatusFailed] + counts[downloader.StatusCancelled]
+	active := total - counts[downloader.StatusCancelled]
+	if active > 0 {
+		parent.Progress = progress / float64(active)
+	}
+
+	parent.Error = ""
+	switch {
+	case finished < total && (counts[downloader.StatusDownloading] > 0 || finished > 0):
+		parent.Status = downloader.StatusDownloading
+	case finished < total:
+		parent.Status = downloader.StatusPending
+	case counts[downloader.StatusCancelled] == total:
+		parent.Status = downloader.StatusCancelled
+	case counts[downloader.StatusFailed] > 0:
+		parent.Status = downloader.StatusFailed
+		parent.Error = fmt.Sprintf("%d/%d 个条目下载失败", counts[downloader.StatusFailed], total)
+	default:
+		parent.Status = downloader.StatusCompleted
+		parent.Progress = 100
+	}
+
+	if parent.Metadata == nil {
+		parent.Metadata = make(map[string]string)
+	}
+	parent.Metadata["completed"] = strconv.Itoa(counts[downloader.StatusCompleted])
+	parent.Metadata["failed"] = strconv.Itoa(counts[downloader.StatusFailed])
+	parent.Metadata["cancelled"] = strconv.Itoa(counts[downloader.StatusCancelled])
+	parent.Metadata["downloading"] = strconv.Itoa(counts[downloader.StatusDownloading])
+	parent.Updated = time.Now()
+}
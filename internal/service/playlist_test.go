@@ -0,0 +1,129 @@
+package service
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"video-hunter/internal/config"
+	"video-hunter/internal/downloader"
+)
+
+func TestAggregatePlaylist(t *testing.T) {
+	type child struct {
+		status   downloader.DownloadStatus
+		progress float64
+	}
+
+	tests := []struct {
+		name         string
+		children     []child
+		want         downloader.DownloadStatus
+		wantProgress float64
+		wantError    string
+	}{
+		{
+			name:     "全部排队中",
+			children: []child{{downloader.StatusPending, 0}, {downloader.StatusPending, 0}},
+			want:     downloader.StatusPending,
+		},
+		{
+			name:         "有子任务在下载",
+			children:     []child{{downloader.StatusDownloading, 50}, {downloader.StatusPending, 0}},
+			want:         downloader.StatusDownloading,
+			wantProgress: 25,
+		},
+		{
+			name:         "部分结束时为下载中",
+			children:     []child{{downloader.StatusCompleted, 100}, {downloader.StatusPending, 0}},
+			want:         downloader.StatusDownloading,
+			wantProgress: 50,
+		},
+		{
+			name:         "全部完成",
+			children:     []child{{downloader.StatusCompleted, 100}, {downloader.StatusCompleted, 100}},
+			want:         downloader.StatusCompleted,
+			wantProgress: 100,
+		},
+		{
+			name:         "取消的子任务不计入进度",
+			children:     []child{{downloader.StatusCompleted, 100}, {downloader.StatusCancelled, 30}},
+			want:         downloader.StatusCompleted,
+			wantProgress: 100,
+		},
+		{
+			name:         "有失败时为失败",
+			children:     []child{{downloader.StatusCompleted, 100}, {downloader.StatusFailed, 40}, {downloader.StatusCancelled, 0}},
+			want:         downloader.StatusFailed,
+			wantProgress: 70,
+			wantError:    "1/3 个条目下载失败",
+		},
+		{
+			name:     "全部取消",
+			children: []child{{downloader.StatusCancelled, 0}, {downloader.StatusCancelled, 0}},
+			want:     downloader.StatusCancelled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{downloads: make(map[string]*downloader.DownloadResponse)}
+			parent := &downloader.DownloadResponse{ID: "parent", Error: "旧的错误"}
+			for i, c := range tt.children {
+				id := string(rune('a' + i))
+				s.downloads[id] = &downloader.DownloadResponse{ID: id, Status: c.status, Progress: c.progress}
+				parent.Children = append(parent.Children, id)
+			}
+
+			s.aggregatePlaylistLocked(parent)
+			if parent.Status != tt.want || parent.Progress != tt.wantProgress || parent.Error != tt.wantError {
+				t.Errorf("汇总结果 = %s, %v, %q, 期望 %s, %v, %q",
+					parent.Status, parent.Progress, parent.Error, tt.want, tt.wantProgress, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestSkipArchived(t *testing.T) {
+	const playlist = "https://www.youtube.com/@a/videos"
+	archive := &downloadArchive{dir: filepath.Join(t.TempDir(), "archives")}
+	if _, err := archive.add(archive.playlistPath("alice", playlist), []archiveEntry{{"youtube", "a"}, {"youtube", "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	entries := []downloader.PlaylistEntry{
+		{Index: 1, ID: "a", Extractor: "youtube"},
+		{Index: 2, ID: "b", Extractor: "youtube"},
+		{Index: 3, ID: "c", Extractor: "youtube"},
+		{Index: 4, ID: "c"},
+	}
+
+	tests := []struct {
+		name        string
+		owner       string
+		req         downloader.DownloadRequest
+		want        []int
+		wantSkipped int
+	}{
+		{"跳过存档中的条目", "alice", downloader.DownloadRequest{Playlist: playlist}, []int{2, 4}, 2},
+		{"其他用户的存档", "bob", downloader.DownloadRequest{Playlist: playlist}, []int{1, 2, 3, 4}, 0},
+		{"dedupe 为 force", "alice", downloader.DownloadRequest{Playlist: playlist, Dedupe: downloader.DedupeForce}, []int{1, 2, 3, 4}, 0},
+		{"音频模式", "alice", downloader.DownloadRequest{Playlist: playlist, Mode: downloader.ModeAudio}, []int{1, 2, 3, 4}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{config: &config.Config{}, archive: archive}
+			remaining, skipped, err := s.skipArchived(&tt.req, tt.owner, entries)
+			if err != nil {
+				t.Fatalf("skipArchived 失败: %v", err)
+			}
+			var got []int
+			for _, entry := range remaining {
+				got = append(got, entry.Index)
+			}
+			if !slices.Equal(got, tt.want) || skipped != tt.wantSkipped {
+				t.Errorf("剩余 %v, 跳过 %d, 期望 %v, %d", got, skipped, tt.want, tt.wantSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetProxies 返回代理池中各个代理的健康状态
+func (s *Service) GetProxies(c *gin.Context) {
+	c.JSON(http.StatusOK, s.proxies.Status())
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"net/http"
+	"time"
+
+	"video-hunter/internal/downloader"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// GetQueue 获取等待队列的顺序和调度状态，普通用户只能看到自己的任务在队列中的位置
+func (s *Service) GetQueue(c *gin.Context) {
+	running, queued := s.scheduler.stats()
+
+	c.JSON(http.StatusOK, gin.H{
+		"max_concurrent": s.scheduler.maxConcurrent,
+		"max_queue":      s.scheduler.maxQueue,
+		"running":        running,
+		"queued":         queued,
+		"items":          s.queueItems(c),
+	})
+}
+
+// queueItems 返回调用方可以看到的队列项，非管理员只能看到自己的任务
+func (s *Service) queueItems(c *gin.Context) []QueueItem {
+	user := requesterFrom(c)
+
+	items := s.scheduler.snapshot()
+	if user.admin {
+		return items
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	owned := items[:0]
+	for _, item := range items {
+		if download, ok := s.downloads[item.ID]; ok && user.owns(download) {
+			owned = append(owned, item)
+		}
+	}
+	return owned
+}
+
+// PauseDownload 暂停排队中的任务
+func (s *Service) PauseDownload(c *gin.Context) {
+	s.setQueuedStatus(c, downloader.StatusPending, downloader.StatusPaused, "下载已暂停")
+}
+
+// ResumeDownload 恢复被暂停的任务
+func (s *Service) ResumeDownload(c *gin.Context) {
+	s.setQueuedStatus(c, downloader.StatusPaused, downloader.StatusPending, "下载已恢复")
+}
+
+// setQueuedStatus 在等待队列中暂停或恢复任务
+func (s *Service) setQueuedStatus(c *gin.Context, from, to downloader.DownloadStatus, message string) {
+	id := c.Param("id")
+
+	s.mu.Lock()
+	download, exists := s.downloads[id]
+	if !exists || !requesterFrom(c).owns(download) {
+		s.mu.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "下载任务不存在"})
+		return
+	}
+
+	// 只能操作仍在排队的任务，已开始下载的任务请使用取消
+	if download.Status != from {
+		s.mu.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "任务当前状态为 " + string(download.Status) + "，无法执行该操作"})
+		return
+	}
+
+	// 在持有服务锁时修改状态，避免调度器在此期间启动任务
+	if err := s.scheduler.setPaused(id, to == downloader.StatusPaused); err != nil {
+		s.mu.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	download.Status = to
+	download.Updated = time.Now()
+	s.mu.Unlock()
+
+	logrus.Infof("%s [%s]", message, id)
+	s.publish(id, download)
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// SetDownloadPriority 修改排队中任务的优先级
+func (s *Service) SetDownloadPriority(c *gin.Context) {
+	id := c.Param("id")
+	if _, exists := s.lookupTask(c, id); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "下载任务不存在"})
+		return
+	}
+
+	var body struct {
+		Priority *int `json:"priority"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Priority == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少priority参数"})
+		return
+	}
+
+	if err := s.scheduler.setPriority(id, *body.Priority); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.updatePriority(id, *body.Priority)
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "priority": *body.Priority})
+}
+
+// MoveDownload 将排队中的任务移动到等待队列的指定位置（从0开始）
+func (s *Service) MoveDownload(c *gin.Context) {
+	id := c.Param("id")
+	if _, exists := s.lookupTask(c, id); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "下载任务不存在"})
+		return
+	}
+
+	var body struct {
+		Position *int `json:"position"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Position == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少position参数"})
+		return
+	}
+
+	priority, err := s.scheduler.move(id, *body.Position)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.updatePriority(id, priority)
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "priority": priority, "items": s.queueItems(c)})
+}
+
+// updatePriority 保存任务调整后的优先级，使重启后恢复的顺序保持一致
+func (s *Service) updatePriority(id string, priority int) {
+	s.mu.Lock()
+	if req, ok := s.requests[id]; ok {
+		req.Priority = priority
+	}
+	s.mu.Unlock()
+
+	s.saveTask(id)
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"video-hunter/internal/downloader"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// retryBaseDelay 第一次重试前的等待时间，之后每次翻倍
+	retryBaseDelay = 2 * time.Second
+	// retryMaxDelay 重试等待时间上限
+	retryMaxDelay = time.Minute
+)
+
+// retryDelay 计算第 attempt 次失败后的等待时间：指数退避并加入随机抖动，避免多个任务同时重试
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << (attempt - 1)
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	// 在 [delay/2, delay) 范围内随机
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
+// downloadWithRetry 执行下载，遇到临时性错误时按 downloader.max_retries 退避重试
+// 整个任务（包括重试）受最长下载时间限制，每次尝试在长时间没有进度时中止并重试
+func (s *Service) downloadWithRetry(ctx context.Context, id string, req *downloader.DownloadRequest, download *downloader.DownloadResponse) (*downloader.DownloadResponse, error) {
+	maxRetries := s.config.Downloader.MaxRetries
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	callback := s.newProgressCallback(id, download)
+
+	timeout := s.downloadTimeout(req)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrDownloadTimeout)
+		defer cancel()
+	}
+	stallTimeout := s.stallTimeout(req)
+
+	// timedOut 超时由最长下载时间触发时返回超时错误
+	timedOut := func() error {
+		if errors.Is(context.Cause(ctx), ErrDownloadTimeout) {
+			logrus.Warnf("下载超时 [%s]: 超过 %s 仍未完成", id, timeout)
+			return fmt.Errorf("%w: 超过 %s 仍未完成", ErrDownloadTimeout, timeout)
+		}
+		return nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		s.mu.Lock()
+		download.Attempts = attempt
+		s.mu.Unlock()
+
+		attemptCtx, touch, stop := withStallTimeout(ctx, stallTimeout)
+		result, err := s.downloadWithProviders(attemptCtx, req, func(progress *downloader.DownloadResponse) {
+			touch(transferring(progress.Stage))
+			callback(progress)
+		})
+		stalled := errors.Is(context.Cause(attemptCtx), ErrDownloadStalled)
+		stop()
+
+		// 已在下载存档中不算失败，不记录和重试
+		if err == nil || s.isCancelled(download) || errors.Is(err, downloader.ErrArchived) {
+			return result, err
+		}
+		if timeoutErr := timedOut(); timeoutErr != nil {
+			return nil, timeoutErr
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		// 停滞的下载进程已被结束，作为临时性错误重试
+		retryable := stalled
+		if stalled {
+			err = fmt.Errorf("%w: %s 内没有下载进度", ErrDownloadStalled, stallTimeout)
+		} else {
+			retryable = downloader.IsRetryable(err)
+		}
+		s.recordAttempt(download, attempt, err, retryable)
+
+		if !retryable || attempt > maxRetries {
+			if retryable {
+				logrus.Warnf("下载失败 [%s]: 已达到最大重试次数 %d", id, maxRetries)
+			}
+			return nil, err
+		}
+
+		delay := retryDelay(attempt)
+		logrus.Warnf("下载失败 [%s]，%s 后进行第 %d/%d 次重试: %s", id, delay.Round(time.Millisecond), attempt, maxRetries, downloader.ErrorSummary(err))
+		s.saveTask(id)
+		s.broadcastRetry(id, download, attempt, maxRetries, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if timeoutErr := timedOut(); timeoutErr != nil {
+				return nil, timeoutErr
+			}
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// recordAttempt 在任务记录中保存一次失败尝试
+func (s *Service) recordAttempt(download *downloader.DownloadResponse, attempt int, err error, retryable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	download.History = append(download.History, downloader.AttemptError{
+		Attempt:   attempt,
+		Error:     downloader.ErrorSummary(err),
+		Retryable: retryable,
+		Time:      time.Now(),
+	})
+	download.Speed = ""
+	download.ETA = ""
+	download.SpeedBps = 0
+	download.ETASeconds = 0
+	download.Updated = time.Now()
+}
+
+// isCancelled 判断任务是否已被用户取消
+func (s *Service) isCancelled(download *downloader.DownloadResponse) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return download.Status == downloader.StatusCancelled
+}
+
+// broadcastRetry 广播任务即将重试的消息
+func (s *Service) broadcastRetry(id string, download *downloader.DownloadResponse, attempt, maxRetries int, delay time.Duration, err error) {
+	s.mu.RLock()
+	owner, status := download.Owner, download.Status
+	s.mu.RUnlock()
+
+	s.broadcast(id, owner, map[string]interface{}{
+		"type":        "retry",
+		"id":          id,
+		"status":      status,
+		"attempt":     attempt,
+		"max_retries": maxRetries,
+		"delay":       delay.Seconds(),
+		"retry_at":    time.Now().Add(delay),
+		"error":       downloader.ErrorSummary(err),
+	})
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrQueueFull 等待队列已满
+	ErrQueueFull = errors.New("下载队列已满")
+	// ErrNotQueued 任务不在等待队列中（已开始、已结束或不存在）
+	ErrNotQueued = errors.New("任务不在等待队列中")
+)
+
+// queuedTask 等待队列中的任务
+type queuedTask struct {
+	task     *downloadTask
+	priority int
+	paused   bool
+}
+
+// QueueItem 等待队列中任务的快照
+type QueueItem struct {
+	ID       string `json:"id"`
+	Position int    `json:"position"`
+	Priority int    `json:"priority"`
+	Paused   bool   `json:"paused"`
+}
+
+// scheduler 下载调度器：按优先级排队，同时运行的任务数不超过 maxConcurrent
+type scheduler struct {
+	mu            sync.Mutex
+	queue         []*queuedTask // 按优先级从高到低排列，同优先级先进先出
+	running       int
+	maxConcurrent int
+	maxQueue      int // 等待队列容量，<= 0 表示不限制
+	run           func(task *downloadTask)
+	stopped       bool           // 停止后不再启动新任务
+	wg            sync.WaitGroup // 正在运行的任务
+}
+
+// newScheduler 创建下载调度器，run 在独立协程中同步执行一个任务
+func newScheduler(maxConcurrent, maxQueue int, run func(task *downloadTask)) *scheduler {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	return &scheduler{
+		maxConcurrent: maxConcurrent,
+		maxQueue:      maxQueue,
+		run:           run,
+	}
+}
+
+// enqueue 将任务加入等待队列，队列已满时返回 ErrQueueFull
+func (s *scheduler) enqueue(task *downloadTask, priority int, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxQueue > 0 && len(s.queue) >= s.maxQueue {
+		return ErrQueueFull
+	}
+	s.insertLocked(&queuedTask{task: task, priority: priority, paused: paused})
+	s.dispatchLocked()
+	return nil
+}
+
+// enqueueAll 按各自请求的优先级将一组任务全部加入等待队列，剩余容量不足时一个也不加入并返回 ErrQueueFull
+func (s *scheduler) enqueueAll(tasks []*downloadTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxQueue > 0 && len(s.queue)+len(tasks) > s.maxQueue {
+		return ErrQueueFull
+	}
+	for _, task := range tasks {
+		s.insertLocked(&queuedTask{task: task, priority: task.Req.Priority})
+	}
+	s.dispatchLocked()
+	return nil
+}
+
+// requeue 将恢复的任务加入等待队列，不受队列容量限制
+func (s *scheduler) requeue(task *downloadTask, priority int, paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.insertLocked(&queuedTask{task: task, priority: priority, paused: paused})
+	s.dispatchLocked()
+}
+
+// remove 将任务移出等待队列，任务不在队列中时返回 false
+func (s *scheduler) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.takeLocked(id)
+	return ok
+}
+
+// setPaused 暂停或恢复等待中的任务，暂停的任务保留队列位置但不会被调度
+func (s *scheduler) setPaused(id string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.indexLocked(id)
+	if index < 0 {
+		return ErrNotQueued
+	}
+	s.queue[index].paused = paused
+	if !paused {
+		s.dispatchLocked()
+	}
+	return nil
+}
+
+// setPriority 修改等待中任务的优先级并重新排队
+func (s *scheduler) setPriority(id string, priority int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.takeLocked(id)
+	if !ok {
+		return ErrNotQueued
+	}
+	item.priority = priority
+	s.insertLocked(item)
+	s.dispatchLocked()
+	return nil
+}
+
+// move 将等待中的任务移动到指定位置，并调整其优先级以保持队列有序，返回调整后的优先级
+func (s *scheduler) move(id string, position int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.takeLocked(id)
+	if !ok {
+		return 0, ErrNotQueued
+	}
+
+	if position < 0 {
+		position = 0
+	}
+	if position > len(s.queue) {
+		position = len(s.queue)
+	}
+
+	// 优先级必须介于前后相邻任务之间
+	if position > 0 && item.priority > s.queue[position-1].priority {
+		item.priority = s.queue[position-1].priority
+	}
+	if position < len(s.queue) && item.priority < s.queue[position].priority {
+		item.priority = s.queue[position].priority
+	}
+
+	s.queue = append(s.queue, nil)
+	copy(s.queue[position+1:], s.queue[position:])
+	s.queue[position] = item
+	s.dispatchLocked()
+	return item.priority, nil
+}
+
+// snapshot 返回等待队列的当前顺序
+func (s *scheduler) snapshot() []QueueItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]QueueItem, 0, len(s.queue))
+	for i, item := range s.queue {
+		items = append(items, QueueItem{
+			ID:       item.task.ID,
+			Position: i,
+			Priority: item.priority,
+			Paused:   item.paused,
+		})
+	}
+	return items
+}
+
+// stats 返回正在运行和等待中的任务数
+func (s *scheduler) stats() (running, queued int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running, len(s.queue)
+}
+
+// insertLocked 将任务插入到最后一个优先级不低于它的任务之后
+func (s *scheduler) insertLocked(item *queuedTask) {
+	position := len(s.queue)
+	for i, queued := range s.queue {
+		if queued.priority < item.priority {
+			position = i
+			break
+		}
+	}
+	s.queue = append(s.queue, nil)
+	copy(s.queue[position+1:], s.queue[position:])
+	s.queue[position] = item
+}
+
+// indexLocked 返回任务在等待队列中的位置，不存在时返回 -1
+func (s *scheduler) indexLocked(id string) int {
+	for i, item := range s.queue {
+		if item.task.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// takeLocked 从等待队列中取出任务
+func (s *scheduler) takeLocked(id string) (*queuedTask, bool) {
+	index := s.indexLocked(id)
+	if index < 0 {
+		return nil, false
+	}
+	item := s.queue[index]
+	s.queue = append(s.queue[:index], s.queue[index+1:]...)
+	return item, true
+}
+
+// stop 停止调度新任务并等待正在运行的任务结束，等待队列中的任务保持不变
+func (s *scheduler) stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// dispatchLocked 在并发数未满时启动队列中第一个未暂停的任务
+func (s *scheduler) dispatchLocked() {
+	for !s.stopped && s.running < s.maxConcurrent {
+		index := -1
+		for i, item := range s.queue {
+			if !item.paused {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return
+		}
+
+		task := s.queue[index].task
+		s.queue = append(s.queue[:index], s.queue[index+1:]...)
+		s.running++
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+			s.run(task)
+
+			s.mu.Lock()
+			s.running--
+			s.dispatchLocked()
+			s.mu.Unlock()
+		}()
+	}
+}
@@ -0,0 +1,373 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"video-hunter/internal/downloader"
+)
+
+// testRunner 记录任务的执行顺序，"block" 任务阻塞到 release 被调用，用于让后续任务留在等待队列中
+type testRunner struct {
+	mu      sync.Mutex
+	started []string
+	done    chan string
+	release chan struct{}
+}
+
+func newTestRunner() *testRunner {
+	return &testRunner{done: make(chan string, 100), release: make(chan struct{})}
+}
+
+func (r *testRunner) run(task *downloadTask) {
+	r.mu.Lock()
+	r.started = append(r.started, task.ID)
+	r.mu.Unlock()
+	if task.ID == "block" {
+		<-r.release
+	}
+	r.done <- task.ID
+}
+
+// wait 等待 n 个任务执行完毕
+func (r *testRunner) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("等待任务执行超时，已执行 %v", r.order())
+		}
+	}
+}
+
+func (r *testRunner) order() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.started)
+}
+
+// queuedTasks 任务ID和优先级
+type queuedTasks []struct {
+	id       string
+	priority int
+}
+
+// newBlockedScheduler 创建并发数为 1 的调度器，正在运行的 "block" 任务使之后的任务留在队列中
+func newBlockedScheduler(t *testing.T, maxQueue int, tasks queuedTasks) (*scheduler, *testRunner) {
+	t.Helper()
+	runner := newTestRunner()
+	s := newScheduler(1, maxQueue, runner.run)
+	if err := s.enqueue(&downloadTask{ID: "block"}, 0, false); err != nil {
+		t.Fatalf("enqueue block 失败: %v", err)
+	}
+	for _, task := range tasks {
+		if err := s.enqueue(&downloadTask{ID: task.id}, task.priority, false); err != nil {
+			t.Fatalf("enqueue %s 失败: %v", task.id, err)
+		}
+	}
+	return s, runner
+}
+
+// groupTask 创建 enqueueAll 使用的任务，优先级取自请求
+func groupTask(id string) *downloadTask {
+	return &downloadTask{ID: id, Req: &downloader.DownloadRequest{}}
+}
+
+func queueIDs(s *scheduler) []string {
+	var ids []string
+	for _, item := range s.snapshot() {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestSchedulerPriorityOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks queuedTasks
+		want  []string
+	}{
+		{
+			name:  "同优先级先进先出",
+			tasks: queuedTasks{{"a", 0}, {"b", 0}, {"c", 0}},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "高优先级在前",
+			tasks: queuedTasks{{"a", 0}, {"b", 5}, {"c", 0}, {"d", 5}},
+			want:  []string{"b", "d", "a", "c"},
+		},
+		{
+			name:  "负优先级在后",
+			tasks: queuedTasks{{"a", -1}, {"b", 0}, {"c", 1}},
+			want:  []string{"c", "b", "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, runner := newBlockedScheduler(t, 0, tt.tasks)
+			if got := queueIDs(s); !slices.Equal(got, tt.want) {
+				t.Errorf("队列顺序 = %v, 期望 %v", got, tt.want)
+			}
+
+			close(runner.release)
+			runner.wait(t, len(tt.tasks)+1)
+			want := append([]string{"block"}, tt.want...)
+			if got := runner.order(); !slices.Equal(got, want) {
+				t.Errorf("执行顺序 = %v, 期望 %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSchedulerQueueOperations(t *testing.T) {
+	tasks := queuedTasks{{"a", 0}, {"b", 0}, {"c", 0}}
+
+	tests := []struct {
+		name         string
+		op           func(s *scheduler) error
+		wantErr      error
+		want         []string
+		wantPaused   []string
+		wantPriority map[string]int
+	}{
+		{
+			name: "移动到队首时提高优先级",
+			op: func(s *scheduler) error {
+				_, err := s.move("c", 0)
+				return err
+			},
+			want:         []string{"c", "a", "b"},
+			wantPriority: map[string]int{"c": 0},
+		},
+		{
+			name: "移动到队尾",
+			op: func(s *scheduler) error {
+				_, err := s.move("a", 10)
+				return err
+			},
+			want: []string{"b", "c", "a"},
+		},
+		{
+			name: "移动到高优先级任务之前",
+			op: func(s *scheduler) error {
+				if err := s.setPriority("b", 5); err != nil {
+					return err
+				}
+				_, err := s.move("c", 0)
+				return err
+			},
+			want:         []string{"c", "b", "a"},
+			wantPriority: map[string]int{"c": 5, "b": 5},
+		},
+		{
+			name: "修改优先级后重新排队",
+			op: func(s *scheduler) error {
+				return s.setPriority("c", 1)
+			},
+			want:         []string{"c", "a", "b"},
+			wantPriority: map[string]int{"c": 1},
+		},
+		{
+			name: "暂停保留队列位置",
+			op: func(s *scheduler) error {
+				return s.setPaused("b", true)
+			},
+			want:       []string{"a", "b", "c"},
+			wantPaused: []string{"b"},
+		},
+		{
+			name: "移出队列",
+			op: func(s *scheduler) error {
+				if !s.remove("b") {
+					return ErrNotQueued
+				}
+				return nil
+			},
+			want: []string{"a", "c"},
+		},
+		{
+			name: "移动不在队列中的任务",
+			op: func(s *scheduler) error {
+				_, err := s.move("block", 0)
+				return err
+			},
+			wantErr: ErrNotQueued,
+			want:    []string{"a", "b", "c"},
+		},
+		{
+			name: "暂停不在队列中的任务",
+			op: func(s *scheduler) error {
+				return s.setPaused("missing", true)
+			},
+			wantErr: ErrNotQueued,
+			want:    []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, runner := newBlockedScheduler(t, 0, tasks)
+			defer close(runner.release)
+
+			if err := tt.op(s); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("错误 = %v, 期望 %v", err, tt.wantErr)
+			}
+
+			items := s.snapshot()
+			var ids, paused []string
+			for i, item := range items {
+				ids = append(ids, item.ID)
+				if item.Position != i {
+					t.Errorf("%s 的位置 = %d, 期望 %d", item.ID, item.Position, i)
+				}
+				if item.Paused {
+					paused = append(paused, item.ID)
+				}
+				if want, ok := tt.wantPriority[item.ID]; ok && item.Priority != want {
+					t.Errorf("%s 的优先级 = %d, 期望 %d", item.ID, item.Priority, want)
+				}
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("队列顺序 = %v, 期望 %v", ids, tt.want)
+			}
+			if !slices.Equal(paused, tt.wantPaused) {
+				t.Errorf("暂停的任务 = %v, 期望 %v", paused, tt.wantPaused)
+			}
+		})
+	}
+}
+
+func TestSchedulerSkipsPaused(t *testing.T) {
+	s, runner := newBlockedScheduler(t, 0, queuedTasks{{"a", 0}, {"b", 0}})
+	if err := s.setPaused("a", true); err != nil {
+		t.Fatalf("setPaused 失败: %v", err)
+	}
+
+	close(runner.release)
+	runner.wait(t, 2)
+	if got := queueIDs(s); !slices.Equal(got, []string{"a"}) {
+		t.Fatalf("暂停的任务应留在队列中, 队列 = %v", got)
+	}
+
+	// 恢复后立即调度
+	if err := s.setPaused("a", false); err != nil {
+		t.Fatalf("setPaused 失败: %v", err)
+	}
+	runner.wait(t, 1)
+	if got, want := runner.order(), []string{"block", "b", "a"}; !slices.Equal(got, want) {
+		t.Errorf("执行顺序 = %v, 期望 %v", got, want)
+	}
+}
+
+func TestSchedulerMaxQueue(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxQueue int
+		op       func(s *scheduler) error
+		wantErr  error
+		want     []string
+	}{
+		{
+			name:     "未满时加入",
+			maxQueue: 2,
+			op: func(s *scheduler) error {
+				return s.enqueue(&downloadTask{ID: "a"}, 0, false)
+			},
+			want: []string{"a"},
+		},
+		{
+			name:     "队列已满",
+			maxQueue: 2,
+			op: func(s *scheduler) error {
+				for _, id := range []string{"a", "b", "c"} {
+					if err := s.enqueue(&downloadTask{ID: id}, 0, false); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			wantErr: ErrQueueFull,
+			want:    []string{"a", "b"},
+		},
+		{
+			name:     "剩余容量不足时整组拒绝",
+			maxQueue: 2,
+			op: func(s *scheduler) error {
+				if err := s.enqueue(&downloadTask{ID: "a"}, 0, false); err != nil {
+					return err
+				}
+				return s.enqueueAll([]*downloadTask{groupTask("b"), groupTask("c")})
+			},
+			wantErr: ErrQueueFull,
+			want:    []string{"a"},
+		},
+		{
+			name:     "恢复的任务不受容量限制",
+			maxQueue: 1,
+			op: func(s *scheduler) error {
+				s.requeue(&downloadTask{ID: "a"}, 0, false)
+				s.requeue(&downloadTask{ID: "b"}, 0, false)
+				return nil
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name:     "不限制容量",
+			maxQueue: 0,
+			op: func(s *scheduler) error {
+				return s.enqueueAll([]*downloadTask{groupTask("a"), groupTask("b"), groupTask("c")})
+			},
+			want: []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, runner := newBlockedScheduler(t, tt.maxQueue, nil)
+			defer close(runner.release)
+
+			if err := tt.op(s); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("错误 = %v, 期望 %v", err, tt.wantErr)
+			}
+			if got := queueIDs(s); !slices.Equal(got, tt.want) {
+				t.Errorf("队列 = %v, 期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	s, runner := newBlockedScheduler(t, 0, queuedTasks{{"a", 0}})
+
+	stopped := make(chan struct{})
+	go func() {
+		s.stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("stop 应等待正在运行的任务结束")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(runner.release)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("任务结束后 stop 没有返回")
+	}
+
+	if got := runner.order(); !slices.Equal(got, []string{"block"}) {
+		t.Errorf("停止后不应启动新任务, 执行了 %v", got)
+	}
+	if got := queueIDs(s); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("等待队列 = %v, 期望保留 [a]", got)
+	}
+}
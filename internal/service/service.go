@@ -1,20 +1,20 @@
 package service
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"video-hunter/internal/config"
 	"video-hunter/internal/downloader"
+	"video-hunter/internal/middleware"
+	"video-hunter/internal/store"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -24,45 +24,248 @@ import (
 
 // Service 服务层
 type Service struct {
-	config     *config.Config
-	ytdlp      *downloader.YtdlpDownloader
-	douyin     *downloader.DouyinDownloader // 添加抖音下载器
-	downloads  map[string]*downloader.DownloadResponse
-	mu         sync.RWMutex
-	wsClients  map[*websocket.Conn]bool
-	wsMutex    sync.RWMutex
-	downloadCh chan *downloadTask
-	upgrader   websocket.Upgrader
+	config    *config.Config
+	registry  *downloader.Registry // 下载提供者注册表
+	store     store.Store
+	downloads map[string]*downloader.DownloadResponse
+	requests  map[string]*downloader.DownloadRequest // 任务的原始下载请求
+	cancels   map[string]context.CancelFunc          // 未结束任务的取消函数
+	ctx       context.Context                        // 所有任务上下文的父上下文，关闭服务时取消
+	shutdown  context.CancelFunc
+	mu        sync.RWMutex
+	hub       *eventHub               // WebSocket 和 SSE 连接，每个连接只接收其用户可以访问的任务消息
+	scheduler *scheduler              // 按优先级调度任务，限制同时下载的数量
+	webhooks  *webhookDispatcher      // 向 webhook 投递任务事件
+	archive   *downloadArchive        // 每个用户的 yt-dlp 下载存档
+	cookies   *cookieVault            // 加密保存通过API上传的 Cookie
+	proxies   *downloader.ProxyRouter // 按域名选择代理，检查代理池的健康状态
+	upgrader  websocket.Upgrader
 }
 
 // downloadTask 下载任务
 type downloadTask struct {
 	ID  string
 	Req *downloader.DownloadRequest
+	Ctx context.Context // 取消任务时结束正在运行的下载进程
 }
 
 // NewService 创建新的服务实例
-func NewService(cfg *config.Config) *Service {
+func NewService(cfg *config.Config) (*Service, error) {
+	taskStore, err := store.NewStore(cfg.Database)
+	if err != nil {
+		return nil, fmt.Errorf("初始化任务存储失败: %w", err)
+	}
+
+	proxies := downloader.NewProxyRouter(cfg)
+	registry, err := newRegistry(cfg, proxies)
+	if err != nil {
+		taskStore.Close()
+		return nil, err
+	}
+
+	webhooks, err := newWebhookDispatcher(cfg.Webhooks, taskStore)
+	if err != nil {
+		taskStore.Close()
+		return nil, err
+	}
+
+	cookies, err := newCookieVault(cfg)
+	if err != nil {
+		webhooks.close()
+		taskStore.Close()
+		return nil, err
+	}
+
+	ctx, shutdown := context.WithCancel(context.Background())
 	s := &Service{
-		config:     cfg,
-		ytdlp:      downloader.NewYtdlpDownloader(cfg),
-		douyin:     downloader.NewDouyinDownloader(), // 初始化抖音下载器
-		downloads:  make(map[string]*downloader.DownloadResponse),
-		wsClients:  make(map[*websocket.Conn]bool),
-		downloadCh: make(chan *downloadTask, 100), // 增加缓冲区大小到100
+		config:    cfg,
+		registry:  registry,
+		store:     taskStore,
+		downloads: make(map[string]*downloader.DownloadResponse),
+		requests:  make(map[string]*downloader.DownloadRequest),
+		cancels:   make(map[string]context.CancelFunc),
+		ctx:       ctx,
+		shutdown:  shutdown,
+		hub:       newHub(),
+		webhooks:  webhooks,
+		archive:   newDownloadArchive(cfg.Database),
+		cookies:   cookies,
+		proxies:   proxies,
 		upgrader: websocket.Upgrader{
+			// 同源页面或 security.cors_origins 允许的源才能建立连接
 			CheckOrigin: func(r *http.Request) bool {
-				return true
+				return middleware.SameOrigin(r) || middleware.OriginAllowed(cfg.Security.CorsOrigins, r.Header.Get("Origin"))
 			},
 		},
 	}
 
-	// 启动下载工作池
-	for i := 0; i < 10; i++ { // 增加工作协程数量到10
-		go s.downloadWorker()
+	// 启动下载调度器，同时下载的任务数由 downloader.max_concurrent 控制
+	s.scheduler = newScheduler(cfg.Downloader.MaxConcurrent, cfg.Downloader.MaxQueue, s.runTask)
+
+	// 恢复上次运行保存的任务
+	if err := s.restoreTasks(); err != nil {
+		taskStore.Close()
+		return nil, err
+	}
+
+	// 代理池健康检查
+	s.proxies.Start()
+
+	return s, nil
+}
+
+// newRegistry 注册内置的下载提供者，新增网站支持时在此注册新的提供者；
+// 所有下载器共享同一个代理路由
+func newRegistry(cfg *config.Config, proxies *downloader.ProxyRouter) (*downloader.Registry, error) {
+	// 配置中的默认格式在启动时校验，避免每个任务下载时才失败
+	if _, err := downloader.ParseFormat(cfg.YtDlp.Format); err != nil {
+		return nil, fmt.Errorf("ytdlp.format 无效: %w", err)
+	}
+	for _, site := range cfg.Sites {
+		if _, err := downloader.ParseFormat(site.Format); err != nil {
+			return nil, fmt.Errorf("网站 %s 的 format 无效: %w", site.Name, err)
+		}
+	}
+
+	registry := downloader.NewRegistry()
+
+	providers := []downloader.Provider{
+		downloader.NewYtdlpDownloader(cfg, proxies),
+	}
+	if cfg.Douyin.EnableDirectAPI {
+		providers = append(providers, downloader.NewDouyinDownloader(cfg, proxies))
+	}
+	if cfg.Aria2.RPCURL != "" {
+		providers = append(providers, downloader.NewAria2Downloader(cfg, proxies))
+	}
+
+	for _, p := range providers {
+		if err := registry.Register(p); err != nil {
+			return nil, fmt.Errorf("注册下载器失败: %w", err)
+		}
+	}
+	return registry, nil
+}
+
+// Close 关闭服务持有的资源
+func (s *Service) Close() error {
+	// 不再启动新任务，结束正在运行的下载进程并等待任务退出，未完成的任务在下次启动时重新排队
+	s.shutdown()
+	s.scheduler.stop()
+
+	s.hub.close()
+	s.webhooks.close()
+	s.proxies.Stop()
+	return s.store.Close()
+}
+
+// CloseConnections 断开所有 WebSocket 和 SSE 连接，关闭服务器时调用，避免等待长连接结束
+func (s *Service) CloseConnections() {
+	s.hub.close()
+}
+
+// restoreTasks 从存储中加载任务，并重新排队未完成的任务
+func (s *Service) restoreTasks() error {
+	tasks, err := s.store.ListTasks()
+	if err != nil {
+		return fmt.Errorf("加载任务失败: %w", err)
+	}
+
+	var pending []*store.Task
+	s.mu.Lock()
+	for _, task := range tasks {
+		if task.Request == nil || task.Response == nil {
+			logrus.Warnf("跳过不完整的任务记录: %s", task.ID)
+			continue
+		}
+		s.downloads[task.ID] = task.Response
+		s.requests[task.ID] = task.Request
+
+		// 播放列表任务不下载，状态在子任务恢复后重新汇总
+		if len(task.Response.Children) > 0 {
+			continue
+		}
+
+		// 上次运行中断的任务重新排队，暂停的任务保持暂停
+		switch task.Response.Status {
+		case downloader.StatusPending, downloader.StatusDownloading:
+			task.Response.Status = downloader.StatusPending
+			task.Response.Progress = 0
+			task.Response.Speed = ""
+			task.Response.ETA = ""
+			task.Response.SpeedBps = 0
+			task.Response.ETASeconds = 0
+			task.Response.Stage = ""
+			task.Response.StageProgress = 0
+			task.Response.Attempts = 0
+			task.Response.History = nil
+			pending = append(pending, task)
+		case downloader.StatusPaused:
+			pending = append(pending, task)
+		}
 	}
+	s.mu.Unlock()
 
-	return s
+	for _, task := range pending {
+		s.saveTask(task.ID)
+		req := *task.Request
+		s.scheduler.requeue(s.newDownloadTask(task.ID, &req), req.Priority, task.Response.Status == downloader.StatusPaused)
+	}
+	s.refreshPlaylists()
+
+	logrus.Infof("已恢复 %d 个任务，其中 %d 个重新排队", len(tasks), len(pending))
+	return nil
+}
+
+// saveTask 将任务当前状态写入存储
+func (s *Service) saveTask(id string) {
+	s.mu.RLock()
+	download, exists := s.downloads[id]
+	req := s.requests[id]
+	if !exists || req == nil {
+		s.mu.RUnlock()
+		return
+	}
+	// 在锁内复制一份快照，避免序列化时与下载协程竞争
+	response := *download
+	response.Metadata = make(map[string]string, len(download.Metadata))
+	for k, v := range download.Metadata {
+		response.Metadata[k] = v
+	}
+	response.History = append([]downloader.AttemptError(nil), download.History...)
+	request := *req
+	s.mu.RUnlock()
+
+	task := &store.Task{
+		ID:       id,
+		Request:  &request,
+		Response: &response,
+		Created:  response.Created,
+		Updated:  response.Updated,
+	}
+	if err := s.store.SaveTask(task); err != nil {
+		logrus.Errorf("保存任务失败 [%s]: %v", id, err)
+	}
+}
+
+// prepareRequest 校验请求的格式表达式，并按 mode 补全音频设置，校验失败时返回错误响应；
+// options 和 cookies 会原样传给 yt-dlp（可以执行命令、读写任意路径），只允许管理员使用
+func (s *Service) prepareRequest(c *gin.Context, req *downloader.DownloadRequest) bool {
+	// playlist 只由服务层为播放列表的子任务设置
+	req.Playlist = ""
+	if !requesterFrom(c).admin && (len(req.Options) > 0 || req.Cookies != "") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有管理员可以设置 options 和 cookies，请通过 /api/cookies 上传 Cookie"})
+		return false
+	}
+	if _, err := downloader.ParseFormat(req.Format); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的格式: " + err.Error()})
+		return false
+	}
+	if err := downloader.ApplyMode(s.config, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
 }
 
 // CreateDownload 创建下载任务
@@ -72,6 +275,21 @@ func (s *Service) CreateDownload(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
 		return
 	}
+	if !s.prepareRequest(c, &req) {
+		return
+	}
+
+	// 超出磁盘配额时不再创建任务
+	owner := requesterFrom(c).name
+	if !s.checkQuota(c, owner) {
+		return
+	}
+
+	// 已下载过同一视频时按 dedupe 返回或复用已有的任务
+	videoKey, handled := s.deduplicate(c, &req, owner)
+	if handled {
+		return
+	}
 
 	// 生成下载ID
 	downloadID := uuid.New().String()
@@ -86,32 +304,48 @@ func (s *Service) CreateDownload(c *gin.Context) {
 		Updated:  time.Now(),
 		File:     req.Output,
 		Metadata: make(map[string]string),
+		Owner:    owner,
 	}
 
 	// 保存save_to_local信息
 	if req.SaveToLocal {
 		download.Metadata["save_to_local"] = "true"
 	}
+	setVideoKey(download, videoKey)
 
-	// 保存下载记录
+	// 保存下载记录，下载过程中会修改请求，因此单独保存一份原始请求
+	original := req
 	s.mu.Lock()
 	s.downloads[downloadID] = download
+	s.requests[downloadID] = &original
 	s.mu.Unlock()
+	s.saveTask(downloadID)
 
-	// 异步开始下载
-	go s.startDownload(downloadID, &req)
+	// 加入下载队列，队列已满时撤销任务记录
+	if err := s.queueDownload(downloadID, &req); err != nil {
+		s.discardTask(downloadID)
+		logrus.Warnf("拒绝下载任务 [%s]: %v", downloadID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "下载队列已满，请稍后再试"})
+		return
+	}
+	s.notify(eventCreated, downloadID)
 
-	c.JSON(http.StatusOK, download)
+	// 任务可能已经开始下载，在锁内序列化
+	s.respondTask(c, download)
 }
 
-// GetDownloads 获取所有下载任务
+// GetDownloads 获取当前用户的下载任务，管理员获取所有用户的任务
 func (s *Service) GetDownloads(c *gin.Context) {
+	owner, all := ownerFilter(c)
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	downloads := make([]*downloader.DownloadResponse, 0, len(s.downloads))
 	for _, download := range s.downloads {
-		downloads = append(downloads, download)
+		if all || download.Owner == owner {
+			downloads = append(downloads, download)
+		}
 	}
 
 	c.JSON(http.StatusOK, downloads)
@@ -119,46 +353,173 @@ func (s *Service) GetDownloads(c *gin.Context) {
 
 // GetDownload 获取单个下载任务
 func (s *Service) GetDownload(c *gin.Context) {
-	id := c.Param("id")
-
-	s.mu.RLock()
-	download, exists := s.downloads[id]
-	s.mu.RUnlock()
-
+	download, exists := s.lookupTask(c, c.Param("id"))
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "下载任务不存在"})
 		return
 	}
 
-	c.JSON(http.StatusOK, download)
+	s.respondTask(c, download)
 }
 
 // CancelDownload 取消下载任务
 func (s *Service) CancelDownload(c *gin.Context) {
 	id := c.Param("id")
+	if _, exists := s.lookupTask(c, id); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "下载任务不存在"})
+		return
+	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	switch err := s.cancelTask(id); {
+	case errors.Is(err, store.ErrTaskNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "下载任务不存在"})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "下载已取消"})
+	}
+}
 
+// errTaskFinished 任务已处于最终状态
+var errTaskFinished = errors.New("任务已结束，无法取消")
+
+// cancelTask 取消任务，播放列表任务会取消所有未结束的子任务
+func (s *Service) cancelTask(id string) error {
+	s.mu.Lock()
 	download, exists := s.downloads[id]
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "下载任务不存在"})
-		return
+		s.mu.Unlock()
+		return store.ErrTaskNotFound
+	}
+
+	// 已结束的任务不能取消
+	if isFinalStatus(download.Status) {
+		s.mu.Unlock()
+		return errTaskFinished
+	}
+
+	// 播放列表任务的状态由子任务汇总得出
+	if children := download.Children; len(children) > 0 {
+		s.mu.Unlock()
+		for _, child := range children {
+			if err := s.cancelTask(child); err != nil && !errors.Is(err, errTaskFinished) {
+				logrus.Warnf("取消子任务失败 [%s]: %v", child, err)
+			}
+		}
+		return nil
 	}
 
 	download.Status = downloader.StatusCancelled
 	download.Updated = time.Now()
+	cancel := s.cancels[id]
+	s.mu.Unlock()
+
+	// 排队中的任务直接移出队列；正在运行的任务结束下载进程，未完成文件由下载协程在进程退出后清理
+	if s.scheduler.remove(id) {
+		s.releaseTask(id)
+	} else if cancel != nil {
+		cancel()
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "下载已取消"})
+	s.publish(id, download)
+	s.notify(eventCancelled, id)
+	return nil
 }
 
-// ClearDownloads 清空所有下载记录
+// ClearDownloads 清空当前用户的下载记录，管理员清空所有记录或 ?owner= 指定用户的记录；
+// ?delete_files=true 时同时删除已下载的文件以释放磁盘配额
 func (s *Service) ClearDownloads(c *gin.Context) {
+	owner, all := ownerFilter(c)
+	deleteFiles := c.Query("delete_files") == "true"
+
+	if all {
+		if err := s.store.ClearTasks(); err != nil {
+			logrus.Errorf("清空任务存储失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "清空下载记录失败"})
+			return
+		}
+	}
+
 	s.mu.Lock()
-	s.downloads = make(map[string]*downloader.DownloadResponse)
+	var ids []string
+	for id, download := range s.downloads {
+		if all || download.Owner == owner {
+			ids = append(ids, id)
+		}
+	}
 	s.mu.Unlock()
 
-	c.JSON(http.StatusOK, gin.H{"message": "已清空所有下载记录"})
+	for _, id := range ids {
+		s.scheduler.remove(id)
+		s.releaseTask(id)
+	}
+
+	s.mu.Lock()
+	var files []string
+	for _, id := range ids {
+		if download, ok := s.downloads[id]; ok && deleteFiles && download.Status == downloader.StatusCompleted && download.File != "" {
+			files = append(files, download.File)
+		}
+		delete(s.downloads, id)
+		delete(s.requests, id)
+	}
+	s.mu.Unlock()
+
+	if !all {
+		for _, id := range ids {
+			if err := s.store.DeleteTask(id); err != nil {
+				logrus.Errorf("删除任务记录失败 [%s]: %v", id, err)
+			}
+		}
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			logrus.Warnf("删除文件失败 %s: %v", file, err)
+		}
+	}
+
+	logrus.Infof("已清空 %d 条下载记录，删除 %d 个文件", len(ids), len(files))
+	c.JSON(http.StatusOK, gin.H{"message": "已清空所有下载记录", "cleared": len(ids)})
+}
+
+// DeleteDownload 删除已结束的任务记录及其下载的文件
+func (s *Service) DeleteDownload(c *gin.Context) {
+	id := c.Param("id")
+	download, exists := s.lookupTask(c, id)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "下载任务不存在"})
+		return
+	}
+
+	s.mu.RLock()
+	status, file, children := download.Status, download.File, download.Children
+	s.mu.RUnlock()
+
+	if !isFinalStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "任务尚未结束，请先取消"})
+		return
+	}
+
+	// 播放列表任务连同子任务一起删除
+	for _, childID := range append(children, id) {
+		s.mu.RLock()
+		child, ok := s.downloads[childID]
+		var childFile string
+		if ok && child.Status == downloader.StatusCompleted {
+			childFile = child.File
+		}
+		s.mu.RUnlock()
+
+		if childFile != "" {
+			if err := os.Remove(childFile); err != nil && !os.IsNotExist(err) {
+				logrus.Warnf("删除文件失败 %s: %v", childFile, err)
+			}
+		}
+		s.discardTask(childID)
+	}
+
+	logrus.Infof("已删除下载任务 [%s]: %s", id, file)
+	c.JSON(http.StatusOK, gin.H{"message": "下载任务已删除"})
 }
 
 // GetVideoInfo 获取视频信息
@@ -169,21 +530,26 @@ func (s *Service) GetVideoInfo(c *gin.Context) {
 		return
 	}
 
-	var info *downloader.VideoInfo
-	var err error
+	// 使用可以处理该链接的最高优先级下载器获取视频信息，超时后结束解析进程
+	ctx, cancel := context.WithTimeout(c.Request.Context(), s.infoTimeout())
+	defer cancel()
+	ctx, cleanup := s.withCookies(ctx, requesterFrom(c).name, url)
+	defer cleanup()
 
-	// 判断是否为抖音链接
-	if s.isDouyinURL(url) {
-		// 使用抖音下载器获取视频信息
-		info, err = s.douyin.GetVideoInfo(url)
-	} else {
-		// 使用yt-dlp下载器获取其他视频信息
-		info, err = s.ytdlp.GetVideoInfo(url)
+	var info *downloader.VideoInfo
+	provider, err := s.registry.Resolve(url)
+	if err == nil {
+		info, err = provider.GetVideoInfo(ctx, url)
 	}
 
 	if err != nil {
 		logrus.Errorf("获取视频信息失败: %v", err)
 
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": fmt.Sprintf("获取视频信息超时（%s）", s.infoTimeout())})
+			return
+		}
+
 		// 对抖音视频提供更友好的错误信息
 		if strings.Contains(url, "douyin.com") || strings.Contains(url, "v.douyin.com") {
 			// 如果是抖音视频，提供更友好的错误信息
@@ -232,81 +598,125 @@ func (s *Service) GetVideoInfo(c *gin.Context) {
 	c.JSON(http.StatusOK, info)
 }
 
-// isDouyinURL 判断是否为抖音链接
-func (s *Service) isDouyinURL(urlStr string) bool {
-	patterns := []string{
-		`^https?://v\.douyin\.com/`,
-		`^https?://www\.douyin\.com/video/`,
-		`^https?://www\.douyin\.com/share/video/`,
-	}
-
-	for _, pattern := range patterns {
-		matched, _ := regexp.MatchString(pattern, urlStr)
-		if matched {
-			return true
-		}
-	}
-	return false
-}
-
-// HandleWebSocket WebSocket处理器
+// HandleWebSocket WebSocket处理器，?ids= 指定只订阅的任务ID（逗号分隔），
+// 连接建立后可以发送 {"action":"subscribe"|"unsubscribe","ids":[...]} 调整订阅
 func (s *Service) HandleWebSocket(c *gin.Context) {
 	conn, err := s.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		logrus.Errorf("WebSocket升级失败: %v", err)
 		return
 	}
-	defer conn.Close()
 
 	// 添加到客户端列表
-	s.wsMutex.Lock()
-	s.wsClients[conn] = true
-	s.wsMutex.Unlock()
+	user := requesterFrom(c)
+	client := s.hub.register(conn, user, parseTaskIDs(c.Query("ids")))
+	defer s.hub.unregister(client)
 
 	logrus.Info("WebSocket连接已建立")
 
-	// 发送当前所有下载状态
-	s.mu.RLock()
-	for id, download := range s.downloads {
-		message := map[string]interface{}{
-			"type":     "progress",
-			"id":       id,
-			"progress": download.Progress,
-			"speed":    download.Speed,
-			"eta":      download.ETA,
-			"status":   download.Status,
-			"file":     download.File,
-		}
-		if err := conn.WriteJSON(message); err != nil {
+	// 写协程启动前直接发送当前的下载状态
+	for _, message := range s.progressSnapshot(client, nil) {
+		if err := client.writeJSON(message); err != nil {
 			logrus.Errorf("发送初始状态失败: %v", err)
+			conn.Close()
+			return
 		}
 	}
-	s.mu.RUnlock()
+	go client.writePump()
+
+	// 保持连接并处理订阅消息
+	client.readPump(func(cmd *wsCommand) {
+		switch cmd.Action {
+		case "subscribe":
+			client.subscribe(cmd.IDs)
+			// 发送新订阅任务的当前状态
+			for _, message := range s.progressSnapshot(client, cmd.IDs) {
+				client.sendJSON(message)
+			}
+		case "unsubscribe":
+			client.unsubscribe(cmd.IDs)
+		default:
+			client.sendJSON(map[string]interface{}{"type": "error", "error": "未知的操作: " + cmd.Action})
+		}
+	})
+}
 
-	// 保持连接并处理消息
-	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
-			logrus.Errorf("读取WebSocket消息失败: %v", err)
-			break
+// progressSnapshot 返回连接可以访问并且订阅了的任务的当前状态，ids 不为空时只返回这些任务
+func (s *Service) progressSnapshot(client *hubClient, ids []string) []map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var messages []map[string]interface{}
+	if len(ids) > 0 {
+		for _, id := range ids {
+			if download, ok := s.downloads[id]; ok && client.user.owns(download) {
+				messages = append(messages, progressMessage(id, download))
+			}
 		}
+		return messages
 	}
 
-	// 连接关闭时清理
-	s.wsMutex.Lock()
-	delete(s.wsClients, conn)
-	s.wsMutex.Unlock()
+	for id, download := range s.downloads {
+		if client.user.owns(download) && client.subscribed(id) {
+			messages = append(messages, progressMessage(id, download))
+		}
+	}
+	return messages
 }
 
-// downloadWorker 下载工作协程
-func (s *Service) downloadWorker() {
-	for task := range s.downloadCh {
-		s.processDownload(task.ID, task.Req)
+// runTask 执行调度器分配的下载任务
+func (s *Service) runTask(task *downloadTask) {
+	// 排队期间已被取消的任务直接跳过
+	if task.Ctx.Err() == nil {
+		s.processDownload(task.Ctx, task.ID, task.Req)
+	}
+	s.releaseTask(task.ID)
+}
+
+// releaseTask 释放任务的取消函数
+func (s *Service) releaseTask(id string) {
+	s.mu.Lock()
+	cancel, ok := s.cancels[id]
+	delete(s.cancels, id)
+	s.mu.Unlock()
+
+	if ok {
+		cancel()
 	}
 }
 
+// isFinalStatus 判断任务是否已处于最终状态
+func isFinalStatus(status downloader.DownloadStatus) bool {
+	return status == downloader.StatusCompleted ||
+		status == downloader.StatusFailed ||
+		status == downloader.StatusCancelled
+}
+
+// finishCancelled 在下载进程退出后清理被取消任务的未完成文件
+func (s *Service) finishCancelled(id string, req *downloader.DownloadRequest, download *downloader.DownloadResponse) {
+	dirs := []string{s.ownerDir(download.Owner)}
+	if req.Output != "" && filepath.Dir(req.Output) != filepath.Clean(dirs[0]) {
+		dirs = append(dirs, filepath.Dir(req.Output))
+	}
+	for _, dir := range dirs {
+		for _, file := range downloader.CleanupPartialFiles(dir, id) {
+			logrus.Infof("已删除未完成文件 [%s]: %s", id, file)
+		}
+	}
+
+	s.mu.Lock()
+	download.Status = downloader.StatusCancelled
+	download.Speed = ""
+	download.ETA = ""
+	download.Updated = time.Now()
+	s.mu.Unlock()
+
+	logrus.Infof("下载已取消 [%s]", id)
+	s.publish(id, download)
+}
+
 // processDownload 处理下载任务
-func (s *Service) processDownload(id string, req *downloader.DownloadRequest) {
+func (s *Service) processDownload(ctx context.Context, id string, req *downloader.DownloadRequest) {
 	logrus.Infof("开始处理下载任务: %s, URL: %s", id, req.URL)
 
 	// 获取下载记录
@@ -321,60 +731,148 @@ func (s *Service) processDownload(id string, req *downloader.DownloadRequest) {
 
 	// 更新状态为下载中
 	s.mu.Lock()
+	if download.Status == downloader.StatusCancelled {
+		s.mu.Unlock()
+		return
+	}
 	download.Status = downloader.StatusDownloading
 	download.Updated = time.Now()
 	s.mu.Unlock()
 
-	// 广播进度更新
-	s.broadcastProgress(id, download)
-
-	// 判断是否为抖音链接
-	if s.isDouyinURL(req.URL) {
-		// 使用抖音下载器
-		resp, err := s.douyin.Download(req)
-		if err == nil && resp != nil {
-			// 更新下载信息
-			s.mu.Lock()
-			download.Status = resp.Status
-			download.Progress = resp.Progress
-			download.Speed = resp.Speed
-			download.ETA = resp.ETA
-			download.File = resp.File
-			download.Error = resp.Error
-			download.Updated = time.Now()
-			s.mu.Unlock()
-
-			// 广播进度更新
-			s.broadcastProgress(id, download)
-			return
-		}
-		// 如果抖音下载器失败，记录错误
-		logrus.Errorf("抖音下载器失败: %v，将尝试使用yt-dlp下载器", err)
-	}
-
-	// 使用yt-dlp下载器作为后备
-	s.processYtdlpDownload(id, req, download)
-}
+	// 保存并广播进度更新
+	s.publish(id, download)
+	s.notify(eventStarted, id)
 
-// processYtdlpDownload 使用yt-dlp处理下载
-func (s *Service) processYtdlpDownload(id string, req *downloader.DownloadRequest, download *downloader.DownloadResponse) {
-	// 使用任务ID作为文件名前缀
-	if req.Output == "" {
-		req.Output = filepath.Join(s.config.Downloader.OutputDir, id+"_video.mp4")
+	// 使用任务ID作为文件名前缀，启用认证时文件保存在用户自己的下载目录中
+	s.mu.RLock()
+	owner := download.Owner
+	s.mu.RUnlock()
+	if req.Output == "" && req.Mode == downloader.ModeAudio {
+		req.Output = filepath.Join(s.ownerDir(owner), id+"_audio.mp4")
+	} else if req.Output == "" {
+		req.Output = filepath.Join(s.ownerDir(owner), id+"_video.mp4")
+	} else if owner != "" {
+		req.Output = filepath.Join(s.ownerDir(owner), id+"_"+filepath.Base(req.Output))
 	} else {
 		// 如果有指定输出文件名，在前面加上任务ID
 		dir := filepath.Dir(req.Output)
 		base := filepath.Base(req.Output)
 		req.Output = filepath.Join(dir, id+"_"+base)
 	}
+	req.Archive = s.archiveFor(req, owner)
+
+	// 使用所有者上传的与链接域名匹配的 Cookie
+	ctx, cleanup := s.withCookies(ctx, owner, req.URL)
+	defer cleanup()
+
+	// 批量下载的子任务在开始下载时才处理重复的视频
+	if s.dedupeBatchItem(ctx, id, req, download) {
+		return
+	}
+
+	result, err := s.downloadWithRetry(ctx, id, req, download)
+
+	// 服务正在关闭：保留任务记录和未完成文件，下次启动时重新排队继续下载
+	if s.ctx.Err() != nil && !s.isCancelled(download) {
+		logrus.Infof("服务关闭，下载任务将在下次启动时继续 [%s]", id)
+		return
+	}
+
+	// 任务已取消：进程已结束，清理未完成文件并保持取消状态
+	if s.isCancelled(download) || ctx.Err() != nil {
+		s.finishCancelled(id, req, download)
+		return
+	}
+
+	// 播放列表的子任务已在存档中时视为完成；单个任务没有下载文件，不能标记为完成
+	if errors.Is(err, downloader.ErrArchived) && req.Playlist != "" {
+		s.finishArchived(id, download)
+		return
+	}
+	if err != nil {
+		s.failDownload(id, download, err)
+		return
+	}
+
+	// 更新下载状态
+	s.mu.Lock()
+	download.Status = downloader.StatusCompleted
+	download.Progress = 100
+	download.Stage = ""
+	download.StageProgress = 0
+	download.File = result.File
+	download.Size = result.Size
+	if result.Title != "" {
+		download.Title = result.Title
+	}
+	download.Updated = time.Now()
+	s.mu.Unlock()
+	logrus.Infof("下载完成 [%s]: %s", id, result.File)
+
+	// 保存并广播进度更新
+	s.publish(id, download)
+	if req.Mode != downloader.ModeAudio {
+		s.recordArchive(req, download)
+	}
+	s.notify(eventCompleted, id)
+}
+
+// downloadWithProviders 按优先级依次尝试可以处理该链接的下载器，直到成功或任务被取消
+func (s *Service) downloadWithProviders(ctx context.Context, req *downloader.DownloadRequest, callback downloader.ProgressCallback) (*downloader.DownloadResponse, error) {
+	providers := s.registry.ResolveAll(req.URL)
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("没有可以处理该链接的下载器: %s", req.URL)
+	}
+
+	var lastErr error
+	for i, provider := range providers {
+		// 每个下载器使用请求的副本，避免前一个下载器的修改影响后续尝试
+		attempt := *req
+		logrus.Infof("使用下载器 %s 处理任务 [%s]", provider.Name(), req.TaskID)
+
+		result, err := provider.Download(ctx, &attempt, callback)
+		if err == nil {
+			return result, nil
+		}
+		// 已在下载存档中时其他下载器也不应再下载
+		if ctx.Err() != nil || errors.Is(err, downloader.ErrArchived) {
+			return nil, err
+		}
+
+		lastErr = err
+		if i < len(providers)-1 {
+			logrus.Errorf("下载器 %s 失败 [%s]: %v，将尝试下一个下载器", provider.Name(), req.TaskID, err)
+		}
+	}
+	return nil, lastErr
+}
 
+// failDownload 将任务标记为失败
+func (s *Service) failDownload(id string, download *downloader.DownloadResponse, err error) {
+	s.mu.Lock()
+	download.Status = downloader.StatusFailed
+	download.Error = downloader.ErrorSummary(err)
+	download.Updated = time.Now()
+	s.mu.Unlock()
+	logrus.Errorf("下载失败 [%s]: %v", id, err)
+
+	// 保存并广播进度更新
+	s.publish(id, download)
+	s.notify(eventFailed, id)
+}
+
+// newProgressCallback 创建限制更新频率的进度回调
+func (s *Service) newProgressCallback(id string, download *downloader.DownloadResponse) downloader.ProgressCallback {
 	// 上次进度更新时间
 	var lastProgressTime time.Time
 	// 上次进度值
 	var lastProgress float64
+	// 上次的后处理器
+	var lastPostprocessor string
+	// 上次的下载阶段
+	var lastStage downloader.DownloadStage
 
-	// 创建进度回调
-	progressCallback := func(progress *downloader.DownloadResponse) {
+	return func(progress *downloader.DownloadResponse) {
 		// 获取当前时间
 		now := time.Now()
 
@@ -383,199 +881,138 @@ func (s *Service) processYtdlpDownload(id string, req *downloader.DownloadReques
 		// 2. 距离上次更新已经过去至少1秒
 		// 3. 进度变化超过1%
 		// 4. 进度达到100%（完成）
+		// 5. 进入新的下载阶段或后处理步骤
 		if lastProgressTime.IsZero() ||
 			now.Sub(lastProgressTime) >= time.Second ||
 			progress.Progress-lastProgress >= 1.0 ||
-			progress.Progress >= 100.0 {
+			progress.Progress >= 100.0 ||
+			progress.Stage != lastStage ||
+			progress.Postprocessor != lastPostprocessor {
 
 			s.mu.Lock()
+			// 已取消的任务不再接受进度更新
+			if download.Status == downloader.StatusCancelled {
+				s.mu.Unlock()
+				return
+			}
 			download.Progress = progress.Progress
 			download.Speed = progress.Speed
 			download.ETA = progress.ETA
+			download.BytesDownloaded = progress.BytesDownloaded
+			download.BytesTotal = progress.BytesTotal
+			download.SpeedBps = progress.SpeedBps
+			download.ETASeconds = progress.ETASeconds
+			download.Fragment = progress.Fragment
+			download.FragmentCount = progress.FragmentCount
+			download.Postprocessor = progress.Postprocessor
+			download.Stage = progress.Stage
+			download.StageProgress = progress.StageProgress
 			download.Updated = now
 			s.mu.Unlock()
 
-			// 发送进度更新
-			s.broadcastProgress(id, download)
+			// 保存并发送进度更新
+			s.publish(id, download)
 
 			// 更新上次进度时间和值
 			lastProgressTime = now
 			lastProgress = progress.Progress
+			lastPostprocessor = progress.Postprocessor
+			lastStage = progress.Stage
 
 			// 仅在进度变化显著时记录日志
 			logrus.Infof("下载进度 [%s]: %.1f%% %s", id, progress.Progress, progress.Speed)
 		}
 	}
+}
 
-	// 特殊处理Pinterest视频
-	if strings.Contains(req.URL, "pinterest.com") && (req.Format == "best" || req.Format == "") {
-		// 先获取视频信息，找出可用的格式
-		info, err := s.ytdlp.GetVideoInfo(req.URL)
-		if err == nil && len(info.Formats) > 0 {
-			// 查找视频格式和音频格式
-			var videoFormat, audioFormat string
-			var maxHeight int
-
-			// 查找最高分辨率的视频格式
-			for _, format := range info.Formats {
-				if strings.Contains(format.Resolution, "x") {
-					parts := strings.Split(format.Resolution, "x")
-					if len(parts) == 2 {
-						if height, err := strconv.Atoi(parts[1]); err == nil {
-							if height > maxHeight {
-								maxHeight = height
-								videoFormat = format.FormatID
-							}
-						}
-					}
-				}
-			}
+// newDownloadTask 为任务创建可取消的上下文
+func (s *Service) newDownloadTask(id string, req *downloader.DownloadRequest) *downloadTask {
+	ctx, cancel := context.WithCancel(s.ctx)
 
-			// 查找音频格式
-			for _, format := range info.Formats {
-				if strings.Contains(format.Resolution, "audio only") {
-					audioFormat = format.FormatID
-					break
-				}
-			}
+	s.mu.Lock()
+	s.cancels[id] = cancel
+	s.mu.Unlock()
 
-			// 如果找到了视频和音频格式，组合它们
-			if videoFormat != "" && audioFormat != "" {
-				req.Format = videoFormat + "+" + audioFormat
-				logrus.Infof("检测到Pinterest视频，自动选择最佳格式: %s", req.Format)
-			} else if videoFormat != "" {
-				// 只找到视频格式
-				req.Format = videoFormat
-				logrus.Infof("检测到Pinterest视频，自动选择视频格式: %s", req.Format)
-			} else {
-				// 使用默认的best格式
-				req.Format = "best"
-				logrus.Infof("检测到Pinterest视频，使用默认best格式")
-			}
-		} else {
-			// 如果获取视频信息失败，使用默认的best格式
-			req.Format = "best"
-			logrus.Infof("检测到Pinterest视频，使用默认best格式")
-		}
+	return &downloadTask{
+		ID:  id,
+		Req: req,
+		Ctx: ctx,
 	}
+}
 
-	// 使用yt-dlp下载器
-	actualFile, err := s.ytdlp.Download(req, progressCallback)
+// queueDownload 将任务加入下载队列，队列已满时返回 ErrQueueFull
+func (s *Service) queueDownload(id string, req *downloader.DownloadRequest) error {
+	if err := s.scheduler.enqueue(s.newDownloadTask(id, req), req.Priority, false); err != nil {
+		s.releaseTask(id)
+		return err
+	}
+	return nil
+}
 
+// discardTask 删除未能加入队列的任务记录
+func (s *Service) discardTask(id string) {
 	s.mu.Lock()
-	if err != nil {
-		download.Status = downloader.StatusFailed
-		download.Error = err.Error()
-		logrus.Errorf("下载失败 [%s]: %v", id, err)
-	} else {
-		// 验证文件是否真的存在
-		if _, fileErr := os.Stat(actualFile); fileErr != nil {
-			download.Status = downloader.StatusFailed
-			download.Error = fmt.Sprintf("下载完成但文件不存在: %v", fileErr)
-			logrus.Errorf("下载完成但文件不存在 [%s]: %v", id, fileErr)
-		} else {
-			// 确保文件名包含任务ID
-			if !strings.Contains(filepath.Base(actualFile), id) {
-				// 如果文件名不包含任务ID，尝试重命名文件
-				dir := filepath.Dir(actualFile)
-				ext := filepath.Ext(actualFile)
-				base := strings.TrimSuffix(filepath.Base(actualFile), ext)
-				newPath := filepath.Join(dir, id+"_"+base+ext)
-
-				if renameErr := os.Rename(actualFile, newPath); renameErr == nil {
-					actualFile = newPath
-					logrus.Infof("文件已重命名为: %s", actualFile)
-				} else {
-					logrus.Warnf("无法重命名文件 [%s]: %v", id, renameErr)
-				}
-			}
-
-			// 检查是否有对应的音频文件
-			if strings.Contains(actualFile, ".f") && strings.HasSuffix(actualFile, ".mp4") {
-				videoFile := actualFile
-				audioFile := ""
-
-				// 尝试查找对应的音频文件
-				filePattern := strings.Replace(actualFile, ".f", ".f", 1)
-				filePattern = strings.TrimSuffix(filePattern, filepath.Ext(filePattern)) + ".*"
-				matches, _ := filepath.Glob(filePattern)
-
-				for _, match := range matches {
-					if match != actualFile && (strings.Contains(match, ".m4a") || strings.Contains(match, ".mp3") || strings.Contains(match, ".aac") || strings.Contains(match, ".opus")) {
-						audioFile = match
-						break
-					}
-				}
-
-				// 如果找到了音频文件，尝试合并
-				if audioFile != "" {
-					logrus.Infof("找到对应的音频文件: %s", audioFile)
-					mergedPath := filepath.Join(filepath.Dir(videoFile), id+"_merged"+filepath.Ext(videoFile))
-
-					// 使用ffmpeg合并视频和音频
-					cmd := exec.Command("ffmpeg", "-i", videoFile, "-i", audioFile, "-c", "copy", mergedPath)
-					var stderr bytes.Buffer
-					cmd.Stderr = &stderr
-					if err := cmd.Run(); err == nil {
-						logrus.Infof("成功合并视频和音频到: %s", mergedPath)
-						actualFile = mergedPath
-					} else {
-						logrus.Errorf("合并视频和音频失败: %v, 错误输出: %s", err, stderr.String())
-					}
-				}
-			}
+	delete(s.downloads, id)
+	delete(s.requests, id)
+	s.mu.Unlock()
 
-			// 更新下载状态
-			download.Status = downloader.StatusCompleted
-			download.Progress = 100
-			download.File = actualFile
-			logrus.Infof("下载完成 [%s]: %s", id, download.File)
-		}
+	if err := s.store.DeleteTask(id); err != nil {
+		logrus.Errorf("删除任务记录失败 [%s]: %v", id, err)
 	}
-	download.Updated = time.Now()
-	s.mu.Unlock()
+}
 
-	// 广播进度更新
+// publish 保存任务状态并广播给客户端，子任务变化时同步更新所属的播放列表任务
+func (s *Service) publish(id string, download *downloader.DownloadResponse) {
+	s.saveTask(id)
 	s.broadcastProgress(id, download)
-}
 
-// startDownload 开始下载任务
-func (s *Service) startDownload(id string, req *downloader.DownloadRequest) {
-	s.downloadCh <- &downloadTask{
-		ID:  id,
-		Req: req,
+	if download.ParentID != "" {
+		s.updatePlaylist(download.ParentID)
 	}
 }
 
 // broadcastProgress 广播下载进度
 func (s *Service) broadcastProgress(id string, download *downloader.DownloadResponse) {
+	// 在锁内生成消息，避免与进度回调同时读写任务
+	s.mu.RLock()
+	owner := download.Owner
+	message := progressMessage(id, download)
+	s.mu.RUnlock()
+
 	// 记录日志
-	logrus.Infof("下载进度 [%s]: %.1f%% %s", id, download.Progress, download.Speed)
-
-	// 准备要发送的消息
-	message := map[string]interface{}{
-		"type":     "progress",
-		"id":       id,
-		"progress": download.Progress,
-		"speed":    download.Speed,
-		"eta":      download.ETA,
-		"status":   download.Status,
-		"file":     download.File,
-		"error":    download.Error,
-		"updated":  download.Updated,
-	}
-
-	// 广播到所有WebSocket客户端
-	s.wsMutex.RLock()
-	for client := range s.wsClients {
-		err := client.WriteJSON(message)
-		if err != nil {
-			logrus.Errorf("发送WebSocket消息失败: %v", err)
-			client.Close()
-			delete(s.wsClients, client)
-		}
+	logrus.Infof("下载进度 [%s]: %.1f%% %s", id, message["progress"], message["speed"])
+
+	s.broadcast(id, owner, message)
+}
+
+// progressMessage 生成任务进度消息
+func progressMessage(id string, download *downloader.DownloadResponse) map[string]interface{} {
+	return map[string]interface{}{
+		"type":             "progress",
+		"id":               id,
+		"progress":         download.Progress,
+		"speed":            download.Speed,
+		"eta":              download.ETA,
+		"status":           download.Status,
+		"file":             download.File,
+		"error":            download.Error,
+		"attempts":         download.Attempts,
+		"updated":          download.Updated,
+		"bytes_downloaded": download.BytesDownloaded,
+		"bytes_total":      download.BytesTotal,
+		"speed_bps":        download.SpeedBps,
+		"eta_seconds":      download.ETASeconds,
+		"fragment":         download.Fragment,
+		"fragment_count":   download.FragmentCount,
+		"postprocessor":    download.Postprocessor,
+		"stage":            download.Stage,
+		"stage_progress":   download.StageProgress,
 	}
-	s.wsMutex.RUnlock()
+}
+
+// broadcast 向可以访问该所有者任务并订阅了该任务的WebSocket客户端发送消息
+func (s *Service) broadcast(id, owner string, message map[string]interface{}) {
+	s.hub.broadcast(id, owner, message)
 }
 
 // DownloadFile 下载文件到用户本地
@@ -583,9 +1020,7 @@ func (s *Service) DownloadFile(c *gin.Context) {
 	id := c.Param("id")
 	logrus.Infof("DownloadFile API被调用，ID: %s", id)
 
-	s.mu.RLock()
-	download, exists := s.downloads[id]
-	s.mu.RUnlock()
+	download, exists := s.lookupTask(c, id)
 
 	if !exists {
 		logrus.Errorf("下载任务不存在: %s", id)
@@ -593,29 +1028,34 @@ func (s *Service) DownloadFile(c *gin.Context) {
 		return
 	}
 
-	if download.Status != downloader.StatusCompleted {
-		logrus.Errorf("下载尚未完成: %s, 状态: %s", id, download.Status)
+	// 任务仍在下载时会被其他协程修改，在锁内复制需要的字段
+	s.mu.RLock()
+	status, file := download.Status, download.File
+	s.mu.RUnlock()
+
+	if status != downloader.StatusCompleted {
+		logrus.Errorf("下载尚未完成: %s, 状态: %s", id, status)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "下载尚未完成"})
 		return
 	}
 
 	// 检查文件是否存在
-	if download.File == "" {
+	if file == "" {
 		logrus.Errorf("文件路径为空 [%s]", id)
 		c.JSON(http.StatusNotFound, gin.H{"error": "文件路径为空"})
 		return
 	}
 
-	logrus.Infof("原始文件路径: %s", download.File)
+	logrus.Infof("原始文件路径: %s", file)
 
 	// 构建绝对路径
 	var absPath string
-	if filepath.IsAbs(download.File) {
-		absPath = download.File
+	if filepath.IsAbs(file) {
+		absPath = file
 	} else {
 		// 如果是相对路径，转换为绝对路径
 		var err error
-		absPath, err = filepath.Abs(download.File)
+		absPath, err = filepath.Abs(file)
 		if err != nil {
 			logrus.Errorf("无法获取绝对路径 [%s]: %v", id, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "无法获取绝对路径"})
@@ -679,8 +1119,11 @@ func (s *Service) DirectDownload(c *gin.Context) {
 	logrus.Info("DirectDownload API被调用")
 
 	type reqBody struct {
-		URL    string `json:"url"`
-		Format string `json:"format"`
+		URL          string                  `json:"url"`
+		Format       string                  `json:"format"`
+		Mode         downloader.DownloadMode `json:"mode"`
+		AudioFormat  string                  `json:"audio_format"`
+		AudioQuality string                  `json:"audio_quality"`
 	}
 	var req reqBody
 	if err := c.ShouldBindJSON(&req); err != nil || req.URL == "" {
@@ -697,31 +1140,50 @@ func (s *Service) DirectDownload(c *gin.Context) {
 	filePath := filepath.Join(tmpDir, filename)
 
 	dlReq := &downloader.DownloadRequest{
-		URL:    req.URL,
-		Format: req.Format,
-		Output: filePath,
+		URL:          req.URL,
+		Format:       req.Format,
+		Output:       filePath,
+		TaskID:       uuid.New().String(),
+		Mode:         req.Mode,
+		AudioFormat:  req.AudioFormat,
+		AudioQuality: req.AudioQuality,
+	}
+	if !s.prepareRequest(c, dlReq) {
+		return
 	}
 
-	// 下载到临时文件
-	logrus.Info("调用yt-dlp下载器")
-	actualFile, err := s.ytdlp.Download(dlReq, nil)
+	// 下载到临时文件，客户端断开连接时结束下载进程
+	ctx, cleanup := s.withCookies(c.Request.Context(), requesterFrom(c).name, req.URL)
+	defer cleanup()
+	result, err := s.downloadWithProviders(ctx, dlReq, nil)
 	if err != nil {
 		logrus.Errorf("下载失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "下载失败: " + err.Error()})
 		return
 	}
 
+	actualFile := result.File
 	logrus.Infof("下载完成，文件路径: %s", actualFile)
 
 	// 设置响应头，返回文件流
 	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Disposition", "attachment; filename=\"video.mp4\"")
-	c.File(filePath)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="video%s"`, filepath.Ext(actualFile)))
+	c.File(actualFile)
 
 	// 下载完成后删除临时文件
 	go func() {
 		time.Sleep(10 * time.Second)
-		os.Remove(filePath)
-		logrus.Infof("临时文件已删除: %s", filePath)
+		os.Remove(actualFile)
+		logrus.Infof("临时文件已删除: %s", actualFile)
 	}()
 }
+
+// dataDir 返回任务数据库所在的目录，未使用 SQLite 时为 ./data
+func dataDir(cfg config.DatabaseConfig) string {
+	if cfg.Driver == "sqlite" || cfg.Driver == "sqlite3" {
+		if path := store.SQLitePath(cfg.DSN); path != "" {
+			return filepath.Dir(path)
+		}
+	}
+	return "./data"
+}
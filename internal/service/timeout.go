@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"video-hunter/internal/downloader"
+)
+
+var (
+	// ErrDownloadTimeout 下载超过了允许的最长时间
+	ErrDownloadTimeout = errors.New("下载超时")
+	// ErrDownloadStalled 下载长时间没有进度
+	ErrDownloadStalled = errors.New("下载停滞")
+)
+
+// defaultInfoTimeout 未配置 downloader.info_timeout 时获取视频信息的超时时间
+const defaultInfoTimeout = 60 * time.Second
+
+// downloadTimeout 返回任务的最长下载时间，请求中的 timeout 优先于 downloader.timeout，0 表示不限制
+func (s *Service) downloadTimeout(req *downloader.DownloadRequest) time.Duration {
+	seconds := s.config.Downloader.Timeout
+	if req.Timeout > 0 {
+		seconds = req.Timeout
+	}
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// stallTimeout 返回任务允许没有进度的最长时间，请求中的 stall_timeout 优先于 downloader.stall_timeout，0 表示不检测
+func (s *Service) stallTimeout(req *downloader.DownloadRequest) time.Duration {
+	seconds := s.config.Downloader.StallTimeout
+	if req.StallTimeout > 0 {
+		seconds = req.StallTimeout
+	}
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// infoTimeout 返回获取视频信息的超时时间
+func (s *Service) infoTimeout() time.Duration {
+	if s.config.Downloader.InfoTimeout <= 0 {
+		return defaultInfoTimeout
+	}
+	return time.Duration(s.config.Downloader.InfoTimeout) * time.Second
+}
+
+// transferring 判断进度是否处于传输数据的下载阶段；解析地址、合并和其他后处理（ffmpeg）期间没有下载进度，不检测停滞
+func transferring(stage downloader.DownloadStage) bool {
+	return stage == "" || stage == downloader.StageDownloadingVideo || stage == downloader.StageDownloadingAudio
+}
+
+// withStallTimeout 返回在 timeout 内没有调用 touch 时自动取消的上下文，取消原因为 ErrDownloadStalled；
+// touch(false) 暂停计时，直到下一次 touch(true)
+func withStallTimeout(parent context.Context, timeout time.Duration) (ctx context.Context, touch func(active bool), stop func()) {
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(parent)
+		return ctx, func(bool) {}, cancel
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := time.AfterFunc(timeout, func() {
+		cancel(ErrDownloadStalled)
+	})
+	touch = func(active bool) {
+		if active {
+			timer.Reset(timeout)
+		} else {
+			timer.Stop()
+		}
+	}
+	stop = func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+	return ctx, touch, stop
+}
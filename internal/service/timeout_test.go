@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"video-hunter/internal/config"
+	"video-hunter/internal/downloader"
+)
+
+func TestDownloadTimeouts(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    config.DownloaderConfig
+		req       downloader.DownloadRequest
+		wantTotal time.Duration
+		wantStall time.Duration
+	}{
+		{"使用配置", config.DownloaderConfig{Timeout: 300, StallTimeout: 60}, downloader.DownloadRequest{}, 300 * time.Second, time.Minute},
+		{"请求优先", config.DownloaderConfig{Timeout: 300, StallTimeout: 60}, downloader.DownloadRequest{Timeout: 10, StallTimeout: 5}, 10 * time.Second, 5 * time.Second},
+		{"请求只能覆盖为正数", config.DownloaderConfig{Timeout: 300, StallTimeout: 60}, downloader.DownloadRequest{Timeout: -1}, 300 * time.Second, time.Minute},
+		{"0 表示不限制", config.DownloaderConfig{}, downloader.DownloadRequest{}, 0, 0},
+		{"未配置时请求仍然有效", config.DownloaderConfig{}, downloader.DownloadRequest{Timeout: 10, StallTimeout: 5}, 10 * time.Second, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{config: &config.Config{Downloader: tt.config}}
+			if got := s.downloadTimeout(&tt.req); got != tt.wantTotal {
+				t.Errorf("downloadTimeout = %v, 期望 %v", got, tt.wantTotal)
+			}
+			if got := s.stallTimeout(&tt.req); got != tt.wantStall {
+				t.Errorf("stallTimeout = %v, 期望 %v", got, tt.wantStall)
+			}
+		})
+	}
+
+	s := &Service{config: &config.Config{}}
+	if got := s.infoTimeout(); got != defaultInfoTimeout {
+		t.Errorf("未配置时 infoTimeout = %v, 期望 %v", got, defaultInfoTimeout)
+	}
+}
+
+func TestTransferring(t *testing.T) {
+	tests := []struct {
+		stage downloader.DownloadStage
+		want  bool
+	}{
+		{"", true},
+		{downloader.StageDownloadingVideo, true},
+		{downloader.StageDownloadingAudio, true},
+		{downloader.StageMerging, false},
+		{downloader.StagePostProcessing, false},
+	}
+	for _, tt := range tests {
+		if got := transferring(tt.stage); got != tt.want {
+			t.Errorf("transferring(%q) = %v, 期望 %v", tt.stage, got, tt.want)
+		}
+	}
+}
+
+func TestWithStallTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name      string
+		timeout   time.Duration
+		run       func(touch func(bool), stop func())
+		wantCause error // nil 表示上下文仍未结束
+	}{
+		{
+			name:      "没有进度时取消",
+			timeout:   timeout,
+			run:       func(func(bool), func()) { time.Sleep(3 * timeout) },
+			wantCause: ErrDownloadStalled,
+		},
+		{
+			name:    "有进度时重新计时",
+			timeout: timeout,
+			run: func(touch func(bool), _ func()) {
+				for i := 0; i < 20; i++ {
+					time.Sleep(timeout / 10)
+					touch(true)
+				}
+			},
+		},
+		{
+			name:    "暂停时不计时",
+			timeout: timeout,
+			run: func(touch func(bool), _ func()) {
+				touch(false)
+				time.Sleep(3 * timeout)
+			},
+		},
+		{
+			name:    "恢复后重新计时",
+			timeout: timeout,
+			run: func(touch func(bool), _ func()) {
+				touch(false)
+				time.Sleep(2 * timeout)
+				touch(true)
+				time.Sleep(3 * timeout)
+			},
+			wantCause: ErrDownloadStalled,
+		},
+		{
+			name:      "stop 取消上下文",
+			timeout:   timeout,
+			run:       func(_ func(bool), stop func()) { stop() },
+			wantCause: context.Canceled,
+		},
+		{
+			name:    "0 表示不检测",
+			timeout: 0,
+			run: func(touch func(bool), _ func()) {
+				touch(false)
+				time.Sleep(2 * timeout)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, touch, stop := withStallTimeout(context.Background(), tt.timeout)
+			defer stop()
+
+			tt.run(touch, stop)
+			if tt.wantCause == nil {
+				if err := ctx.Err(); err != nil {
+					t.Fatalf("上下文已结束: %v", context.Cause(ctx))
+				}
+				return
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+				t.Fatal("上下文没有结束")
+			}
+			if cause := context.Cause(ctx); !errors.Is(cause, tt.wantCause) {
+				t.Errorf("取消原因 = %v, 期望 %v", cause, tt.wantCause)
+			}
+		})
+	}
+
+	// 父上下文取消时一起结束
+	parent, cancel := context.WithCancel(context.Background())
+	ctx, _, stop := withStallTimeout(parent, timeout)
+	defer stop()
+	cancel()
+	<-ctx.Done()
+	if cause := context.Cause(ctx); !errors.Is(cause, context.Canceled) {
+		t.Errorf("取消原因 = %v, 期望 %v", cause, context.Canceled)
+	}
+}
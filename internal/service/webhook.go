@@ -0,0 +1,607 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"video-hunter/internal/config"
+	"video-hunter/internal/downloader"
+	"video-hunter/internal/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// 任务事件
+const (
+	eventCreated   = "created"
+	eventStarted   = "started"
+	eventCompleted = "completed"
+	eventFailed    = "failed"
+	eventCancelled = "cancelled"
+)
+
+// 投递状态
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+)
+
+// configWebhookPrefix 配置文件中的 webhook 的ID前缀
+const configWebhookPrefix = "config-"
+
+// webhookPayload webhook 请求体
+type webhookPayload struct {
+	ID        string                       `json:"id"` // 事件ID，同一事件发给各个 webhook 的ID相同
+	Event     string                       `json:"event"`
+	Timestamp time.Time                    `json:"timestamp"`
+	Task      *downloader.DownloadResponse `json:"task"`
+	File      string                       `json:"file,omitempty"` // 下载完成的文件的绝对路径
+}
+
+// webhookInfo API 返回的 webhook，不包含签名密钥
+type webhookInfo struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name,omitempty"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events"`
+	Owner   string    `json:"owner,omitempty"`
+	Source  string    `json:"source"` // config 或 api
+	Signed  bool      `json:"signed"` // 是否配置了签名密钥
+	Created time.Time `json:"created"`
+}
+
+// webhookDispatcher 向订阅了任务事件的 webhook 投递请求，失败时按指数退避重试
+type webhookDispatcher struct {
+	store      store.Store
+	client     *http.Client
+	public     *http.Client // 普通用户的 webhook 使用，只连接公网地址
+	maxRetries int
+	configured []*store.Webhook // 配置文件中的 webhook，不能通过API删除
+
+	mu    sync.RWMutex
+	hooks []*store.Webhook // 通过API创建的 webhook
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newWebhookDispatcher 加载配置文件和存储中的 webhook
+func newWebhookDispatcher(cfg config.WebhooksConfig, taskStore store.Store) (*webhookDispatcher, error) {
+	hooks, err := taskStore.ListWebhooks()
+	if err != nil {
+		return nil, fmt.Errorf("加载 webhook 失败: %w", err)
+	}
+
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	// 连接时检查解析出的地址，避免域名在创建后改为解析到内网地址；
+	// 不使用环境变量中的代理，否则检查的是代理的地址
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkWebhookDial,
+	}).DialContext
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &webhookDispatcher{
+		store:      taskStore,
+		client:     &http.Client{Timeout: timeout},
+		public:     &http.Client{Timeout: timeout, Transport: transport},
+		maxRetries: max(cfg.MaxRetries, 0),
+		hooks:      hooks,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for _, endpoint := range cfg.Endpoints {
+		d.configured = append(d.configured, &store.Webhook{
+			ID:     configWebhookPrefix + endpoint.Name,
+			Name:   endpoint.Name,
+			URL:    endpoint.URL,
+			Secret: endpoint.Secret,
+			Events: endpoint.Events,
+		})
+	}
+	d.abandonPending()
+	return d, nil
+}
+
+// abandonPending 将上次运行时等待重试的投递标记为失败：请求体不保存，重启后无法继续重试
+func (d *webhookDispatcher) abandonPending() {
+	abandoned := 0
+	for _, hook := range d.list() {
+		deliveries, err := d.store.ListDeliveries(hook.ID, store.MaxDeliveries)
+		if err != nil {
+			logrus.Errorf("查询投递记录失败 [%s]: %v", hook.ID, err)
+			continue
+		}
+		for _, delivery := range deliveries {
+			if delivery.Status != deliveryPending {
+				continue
+			}
+			delivery.Status = deliveryFailed
+			delivery.Error = "服务重启，未完成的重试已放弃"
+			delivery.NextRetry = nil
+			delivery.Updated = time.Now()
+			d.record(delivery)
+			abandoned++
+		}
+	}
+	if abandoned > 0 {
+		logrus.Warnf("已放弃 %d 个上次运行时等待重试的 webhook 投递", abandoned)
+	}
+}
+
+// list 返回所有 webhook，配置文件中的在前
+func (d *webhookDispatcher) list() []*store.Webhook {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append(slices.Clone(d.configured), d.hooks...)
+}
+
+// get 查找 webhook
+func (d *webhookDispatcher) get(id string) (*store.Webhook, bool) {
+	for _, hook := range d.list() {
+		if hook.ID == id {
+			return hook, true
+		}
+	}
+	return nil, false
+}
+
+// add 保存通过API创建的 webhook
+func (d *webhookDispatcher) add(hook *store.Webhook) error {
+	if err := d.store.SaveWebhook(hook); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.hooks = append(d.hooks, hook)
+	d.mu.Unlock()
+	return nil
+}
+
+// remove 删除通过API创建的 webhook
+func (d *webhookDispatcher) remove(id string) error {
+	if err := d.store.DeleteWebhook(id); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.hooks = slices.DeleteFunc(d.hooks, func(hook *store.Webhook) bool { return hook.ID == id })
+	d.mu.Unlock()
+	return nil
+}
+
+// dispatch 向订阅了事件、并且可以接收该任务的 webhook 异步投递请求
+func (d *webhookDispatcher) dispatch(event, taskID, owner string, body []byte) {
+	for _, hook := range d.list() {
+		if !webhookSubscribes(hook, event) || (hook.Owner != "" && hook.Owner != owner) {
+			continue
+		}
+
+		delivery := &store.WebhookDelivery{
+			ID:        uuid.New().String(),
+			WebhookID: hook.ID,
+			Event:     event,
+			TaskID:    taskID,
+			Status:    deliveryPending,
+			Created:   time.Now(),
+			Updated:   time.Now(),
+		}
+		d.wg.Add(1)
+		go func(hook *store.Webhook) {
+			defer d.wg.Done()
+			d.deliver(hook, delivery, body)
+		}(hook)
+	}
+}
+
+// deliver 投递一次事件，临时性失败按 webhooks.max_retries 退避重试，每次尝试后更新投递记录
+func (d *webhookDispatcher) deliver(hook *store.Webhook, delivery *store.WebhookDelivery, body []byte) {
+	for attempt := 1; ; attempt++ {
+		statusCode, err := d.send(hook, delivery, body)
+
+		delivery.Attempts = attempt
+		delivery.StatusCode = statusCode
+		delivery.Error = ""
+		delivery.NextRetry = nil
+		delivery.Updated = time.Now()
+
+		retryable := false
+		switch {
+		case err != nil:
+			delivery.Error = err.Error()
+			retryable = d.ctx.Err() == nil
+		case statusCode >= 200 && statusCode < 300:
+			delivery.Status = deliverySucceeded
+		default:
+			delivery.Error = fmt.Sprintf("HTTP %d", statusCode)
+			retryable = statusCode >= 500 || statusCode == http.StatusTooManyRequests || statusCode == http.StatusRequestTimeout
+		}
+
+		if delivery.Status != deliverySucceeded {
+			if !retryable || attempt > d.maxRetries {
+				delivery.Status = deliveryFailed
+				logrus.Warnf("webhook 投递失败 [%s] %s %s: %s", hook.ID, delivery.Event, delivery.TaskID, delivery.Error)
+			} else {
+				next := time.Now().Add(retryDelay(attempt))
+				delivery.NextRetry = &next
+			}
+		}
+		d.record(delivery)
+
+		if delivery.NextRetry == nil {
+			return
+		}
+		select {
+		case <-time.After(time.Until(*delivery.NextRetry)):
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// send 发送一次请求，返回响应状态码
+func (d *webhookDispatcher) send(hook *store.Webhook, delivery *store.WebhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "video-hunter-webhook")
+	req.Header.Set("X-Video-Hunter-Event", delivery.Event)
+	req.Header.Set("X-Video-Hunter-Delivery", delivery.ID)
+	req.Header.Set("X-Video-Hunter-Timestamp", timestamp)
+	if hook.Secret != "" {
+		req.Header.Set("X-Video-Hunter-Signature", "sha256="+signWebhook(hook.Secret, timestamp, body))
+	}
+
+	client := d.client
+	if hook.Owner != "" {
+		client = d.public
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	return resp.StatusCode, nil
+}
+
+// record 保存投递记录
+func (d *webhookDispatcher) record(delivery *store.WebhookDelivery) {
+	if err := d.store.SaveDelivery(delivery); err != nil {
+		logrus.Errorf("保存 webhook 投递记录失败 [%s]: %v", delivery.ID, err)
+	}
+}
+
+// close 停止重试并等待正在进行的投递结束
+func (d *webhookDispatcher) close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// signWebhook 计算签名：以密钥对 "<时间戳>.<请求体>" 做 HMAC-SHA256
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// blockedPrefixes 普通用户的 webhook 不能访问的地址段：本机、内网、运营商级 NAT、链路本地（包括云服务器的元数据地址）、
+// 基准测试、文档、保留和组播等 IANA 特殊用途地址
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.88.99.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/96"), // 未指定地址、本机和已废弃的 IPv4 兼容地址
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("fec0::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// 嵌入了 IPv4 地址的 IPv6 地址段，检查时取出其中的 IPv4 地址
+var (
+	nat64Prefix     = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFourPrefix = netip.MustParsePrefix("2002::/16")
+)
+
+// unwrapAddress 取出 IPv4 映射地址、NAT64 和 6to4 地址中的 IPv4 地址，去掉 IPv6 的区域
+func unwrapAddress(addr netip.Addr) netip.Addr {
+	addr = addr.WithZone("").Unmap()
+	if !addr.Is6() {
+		return addr
+	}
+	b := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[12:16]))
+	case sixToFourPrefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[2:6]))
+	}
+	return addr
+}
+
+// publicAddress 判断是否为普通用户的 webhook 可以访问的公网地址
+func publicAddress(addr netip.Addr) bool {
+	addr = unwrapAddress(addr)
+	if !addr.IsValid() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkWebhookDial 在连接前检查解析出的地址
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if addr, err := netip.ParseAddr(host); err != nil || !publicAddress(addr) {
+		return fmt.Errorf("webhook 地址不能指向本机或内网: %s", host)
+	}
+	return nil
+}
+
+// checkWebhookHost 创建普通用户的 webhook 时检查地址解析出的所有IP都是公网地址
+func checkWebhookHost(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("webhook 地址无效: %s", rawURL)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("无法解析 webhook 地址: %s", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !publicAddress(addr) {
+			return fmt.Errorf("webhook 地址不能指向本机或内网: %s", u.Hostname())
+		}
+	}
+	return nil
+}
+
+// webhookSubscribes 判断 webhook 是否订阅了事件，未指定事件时订阅所有事件
+func webhookSubscribes(hook *store.Webhook, event string) bool {
+	return len(hook.Events) == 0 || slices.Contains(hook.Events, event)
+}
+
+// configuredWebhook 判断是否为配置文件中的 webhook
+func configuredWebhook(hook *store.Webhook) bool {
+	return strings.HasPrefix(hook.ID, configWebhookPrefix) && hook.Created.IsZero()
+}
+
+// notify 向 webhook 发送任务事件，请求体包含任务记录和下载文件的路径
+func (s *Service) notify(event, id string) {
+	s.mu.RLock()
+	download, exists := s.downloads[id]
+	if !exists {
+		s.mu.RUnlock()
+		return
+	}
+	owner := download.Owner
+	payload := webhookPayload{
+		ID:        uuid.New().String(),
+		Event:     event,
+		Timestamp: time.Now(),
+		Task:      download,
+	}
+	if download.Status == downloader.StatusCompleted && download.File != "" {
+		if file, err := filepath.Abs(download.File); err == nil {
+			payload.File = file
+		}
+	}
+	body, err := json.Marshal(payload)
+	s.mu.RUnlock()
+
+	if err != nil {
+		logrus.Errorf("序列化 webhook 事件失败 [%s]: %v", id, err)
+		return
+	}
+	s.webhooks.dispatch(event, id, owner, body)
+}
+
+// ListWebhooks 列出当前用户的 webhook，管理员还可以看到配置文件中的 webhook
+func (s *Service) ListWebhooks(c *gin.Context) {
+	user := requesterFrom(c)
+
+	webhooks := make([]webhookInfo, 0)
+	for _, hook := range s.webhooks.list() {
+		if user.canSee(hook.Owner) {
+			webhooks = append(webhooks, newWebhookInfo(hook))
+		}
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// CreateWebhook 创建 webhook，未指定 secret 时生成随机密钥，密钥只在创建时返回一次；
+// 普通用户创建的 webhook 只接收自己任务的事件，并且不能指向本机或内网地址
+func (s *Service) CreateWebhook(c *gin.Context) {
+	var req struct {
+		Name   string   `json:"name"`
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if err := config.ValidateWebhook(req.URL, req.Events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := requesterFrom(c)
+	if !user.admin {
+		if err := checkWebhookHost(c.Request.Context(), req.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if req.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成签名密钥失败"})
+			return
+		}
+		req.Secret = hex.EncodeToString(secret)
+	}
+
+	hook := &store.Webhook{
+		ID:      uuid.New().String(),
+		Name:    req.Name,
+		URL:     req.URL,
+		Secret:  req.Secret,
+		Events:  req.Events,
+		Created: time.Now(),
+	}
+	// 管理员创建的 webhook 接收所有任务的事件
+	if !user.admin {
+		hook.Owner = user.name
+	}
+
+	if err := s.webhooks.add(hook); err != nil {
+		logrus.Errorf("保存 webhook 失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存 webhook 失败"})
+		return
+	}
+	logrus.Infof("已创建 webhook [%s]: %s", hook.ID, hook.URL)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": newWebhookInfo(hook),
+		"secret":  hook.Secret,
+	})
+}
+
+// DeleteWebhook 删除通过API创建的 webhook
+func (s *Service) DeleteWebhook(c *gin.Context) {
+	hook, exists := s.lookupWebhook(c)
+	if !exists {
+		return
+	}
+	if configuredWebhook(hook) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "配置文件中的 webhook 不能通过API删除"})
+		return
+	}
+
+	if err := s.webhooks.remove(hook.ID); err != nil && !errors.Is(err, store.ErrWebhookNotFound) {
+		logrus.Errorf("删除 webhook 失败 [%s]: %v", hook.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除 webhook 失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "webhook 已删除"})
+}
+
+// GetWebhookDeliveries 获取 webhook 最近的投递记录，?limit= 默认 50
+func (s *Service) GetWebhookDeliveries(c *gin.Context) {
+	hook, exists := s.lookupWebhook(c)
+	if !exists {
+		return
+	}
+
+	limit := 50
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit 必须是正整数"})
+			return
+		}
+		limit = min(n, store.MaxDeliveries)
+	}
+
+	deliveries, err := s.store.ListDeliveries(hook.ID, limit)
+	if err != nil {
+		logrus.Errorf("查询投递记录失败 [%s]: %v", hook.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询投递记录失败"})
+		return
+	}
+	if deliveries == nil {
+		deliveries = []*store.WebhookDelivery{}
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// lookupWebhook 查找当前用户可以访问的 webhook，不存在时响应 404
+func (s *Service) lookupWebhook(c *gin.Context) (*store.Webhook, bool) {
+	hook, exists := s.webhooks.get(c.Param("id"))
+	if !exists || !requesterFrom(c).canSee(hook.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook 不存在"})
+		return nil, false
+	}
+	return hook, true
+}
+
+// newWebhookInfo 生成 API 返回的 webhook
+func newWebhookInfo(hook *store.Webhook) webhookInfo {
+	info := webhookInfo{
+		ID:      hook.ID,
+		Name:    hook.Name,
+		URL:     hook.URL,
+		Events:  hook.Events,
+		Owner:   hook.Owner,
+		Source:  "api",
+		Signed:  hook.Secret != "",
+		Created: hook.Created,
+	}
+	if configuredWebhook(hook) {
+		info.Source = "config"
+	}
+	if info.Events == nil {
+		info.Events = config.WebhookEvents
+	}
+	return info
+}
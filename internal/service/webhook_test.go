@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"video-hunter/internal/config"
+	"video-hunter/internal/store"
+)
+
+func TestCheckWebhookHost(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://93.184.216.34/hook", false},
+		{"http://[2606:2800:220:1:248:1893:25c8:1946]:8080/hook", false},
+		{"http://127.0.0.1:8080/hook", true},
+		{"http://localhost/hook", true},
+		{"http://10.0.0.5/hook", true},
+		{"http://192.168.1.1/hook", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://0.0.0.0/hook", true},
+		{"http://[::1]/hook", true},
+		{"http://[::ffff:127.0.0.1]/hook", true},
+		{"http://[fd00::1]/hook", true},
+		{"http://100.100.100.200/latest/meta-data", true},
+		{"http://0.1.2.3/hook", true},
+		{"http://192.0.0.170/hook", true},
+		{"http://198.18.0.1/hook", true},
+		{"http://240.0.0.1/hook", true},
+		{"http://255.255.255.255/hook", true},
+		{"http://[64:ff9b::10.0.0.1]/hook", true},
+		{"http://[64:ff9b::a9fe:a9fe]/hook", true},
+		{"http://[64:ff9b::5db8:d822]/hook", false},
+		{"http://[2002:c0a8:0101::1]/hook", true},
+		{"http://[::ffff:10.0.0.1]/hook", true},
+		{"http://[::127.0.0.1]/hook", true},
+		{"http://[fe80::1%25eth0]/hook", true},
+	}
+
+	for _, tt := range tests {
+		err := checkWebhookHost(context.Background(), tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkWebhookHost(%q) = %v, 期望错误 %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckWebhookDial(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"93.184.216.34:443", false},
+		{"127.0.0.1:80", true},
+		{"172.16.0.1:80", true},
+		{"[fe80::1]:80", true},
+		{"[fe80::1%eth0]:80", true},
+		{"100.64.0.1:80", true},
+		{"[64:ff9b::7f00:1]:80", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", false},
+		{"example.com:80", true},
+	}
+
+	for _, tt := range tests {
+		err := checkWebhookDial("tcp", tt.address, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkWebhookDial(%q) = %v, 期望错误 %v", tt.address, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebhookDispatcherAbandonsPending(t *testing.T) {
+	taskStore := store.NewMemoryStore()
+	next := time.Now().Add(time.Minute)
+	deliveries := []*store.WebhookDelivery{
+		{ID: "pending", WebhookID: "config-ci", Status: deliveryPending, Attempts: 1, NextRetry: &next, Created: time.Now()},
+		{ID: "succeeded", WebhookID: "config-ci", Status: deliverySucceeded, Attempts: 1, Created: time.Now()},
+	}
+	for _, delivery := range deliveries {
+		if err := taskStore.SaveDelivery(delivery); err != nil {
+			t.Fatalf("SaveDelivery 失败: %v", err)
+		}
+	}
+
+	cfg := config.WebhooksConfig{Endpoints: []config.WebhookConfig{{Name: "ci", URL: "http://127.0.0.1/hook"}}}
+	d, err := newWebhookDispatcher(cfg, taskStore)
+	if err != nil {
+		t.Fatalf("newWebhookDispatcher 失败: %v", err)
+	}
+	defer d.close()
+
+	saved, err := taskStore.ListDeliveries("config-ci", 10)
+	if err != nil {
+		t.Fatalf("ListDeliveries 失败: %v", err)
+	}
+	want := map[string]string{"pending": deliveryFailed, "succeeded": deliverySucceeded}
+	for _, delivery := range saved {
+		if delivery.Status != want[delivery.ID] {
+			t.Errorf("%s 的状态 = %s, 期望 %s", delivery.ID, delivery.Status, want[delivery.ID])
+		}
+		if delivery.NextRetry != nil {
+			t.Errorf("%s 不应再有 next_retry", delivery.ID)
+		}
+	}
+}
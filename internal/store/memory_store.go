@@ -0,0 +1,219 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// MemoryStore 基于内存的任务存储，主要用于测试
+type MemoryStore struct {
+	tasks      map[string][]byte
+	webhooks   []*Webhook
+	deliveries map[string][]*WebhookDelivery // 按 webhook 分组，按创建时间顺序排列
+	cookieSets []*CookieSet
+	mu         sync.RWMutex
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tasks:      make(map[string][]byte),
+		deliveries: make(map[string][]*WebhookDelivery),
+	}
+}
+
+// SaveTask 保存任务
+func (m *MemoryStore) SaveTask(task *Task) error {
+	// 序列化后保存，避免调用方后续修改影响存储内容
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.tasks[task.ID] = data
+	m.mu.Unlock()
+	return nil
+}
+
+// GetTask 获取单个任务
+func (m *MemoryStore) GetTask(id string) (*Task, error) {
+	m.mu.RLock()
+	data, ok := m.tasks[id]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+
+	var task Task
+	if err := json.Unmarshal(data, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ListTasks 列出所有任务
+func (m *MemoryStore) ListTasks() ([]*Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tasks := make([]*Task, 0, len(m.tasks))
+	for _, data := range m.tasks {
+		var task Task
+		if err := json.Unmarshal(data, &task); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, &task)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Created.Before(tasks[j].Created)
+	})
+	return tasks, nil
+}
+
+// DeleteTask 删除单个任务
+func (m *MemoryStore) DeleteTask(id string) error {
+	m.mu.Lock()
+	delete(m.tasks, id)
+	m.mu.Unlock()
+	return nil
+}
+
+// ClearTasks 删除所有任务
+func (m *MemoryStore) ClearTasks() error {
+	m.mu.Lock()
+	m.tasks = make(map[string][]byte)
+	m.mu.Unlock()
+	return nil
+}
+
+// SaveWebhook 保存 webhook
+func (m *MemoryStore) SaveWebhook(hook *Webhook) error {
+	copied := *hook
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, existing := range m.webhooks {
+		if existing.ID == hook.ID {
+			m.webhooks[i] = &copied
+			return nil
+		}
+	}
+	m.webhooks = append(m.webhooks, &copied)
+	return nil
+}
+
+// ListWebhooks 列出所有 webhook
+func (m *MemoryStore) ListWebhooks() ([]*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hooks := make([]*Webhook, 0, len(m.webhooks))
+	for _, hook := range m.webhooks {
+		copied := *hook
+		hooks = append(hooks, &copied)
+	}
+	return hooks, nil
+}
+
+// DeleteWebhook 删除 webhook 及其投递记录
+func (m *MemoryStore) DeleteWebhook(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, hook := range m.webhooks {
+		if hook.ID == id {
+			m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+			delete(m.deliveries, id)
+			return nil
+		}
+	}
+	return ErrWebhookNotFound
+}
+
+// SaveDelivery 保存投递记录
+func (m *MemoryStore) SaveDelivery(delivery *WebhookDelivery) error {
+	copied := *delivery
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deliveries := m.deliveries[delivery.WebhookID]
+	for i, existing := range deliveries {
+		if existing.ID == delivery.ID {
+			deliveries[i] = &copied
+			return nil
+		}
+	}
+	deliveries = append(deliveries, &copied)
+	if len(deliveries) > MaxDeliveries {
+		deliveries = deliveries[len(deliveries)-MaxDeliveries:]
+	}
+	m.deliveries[delivery.WebhookID] = deliveries
+	return nil
+}
+
+// ListDeliveries 列出 webhook 最近的投递记录
+func (m *MemoryStore) ListDeliveries(webhookID string, limit int) ([]*WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	deliveries := m.deliveries[webhookID]
+	result := make([]*WebhookDelivery, 0, limit)
+	for i := len(deliveries) - 1; i >= 0 && len(result) < limit; i-- {
+		copied := *deliveries[i]
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+// SaveCookieSet 保存 Cookie 集合
+func (m *MemoryStore) SaveCookieSet(set *CookieSet) error {
+	copied := *set
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, existing := range m.cookieSets {
+		if existing.ID == set.ID {
+			m.cookieSets[i] = &copied
+			return nil
+		}
+	}
+	m.cookieSets = append(m.cookieSets, &copied)
+	return nil
+}
+
+// ListCookieSets 列出所有 Cookie 集合
+func (m *MemoryStore) ListCookieSets() ([]*CookieSet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sets := make([]*CookieSet, 0, len(m.cookieSets))
+	for _, set := range m.cookieSets {
+		copied := *set
+		sets = append(sets, &copied)
+	}
+	return sets, nil
+}
+
+// DeleteCookieSet 删除 Cookie 集合
+func (m *MemoryStore) DeleteCookieSet(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, set := range m.cookieSets {
+		if set.ID == id {
+			m.cookieSets = append(m.cookieSets[:i], m.cookieSets[i+1:]...)
+			return nil
+		}
+	}
+	return ErrCookieSetNotFound
+}
+
+// Close 关闭存储
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// migration 数据库结构迁移
+type migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// migrations 按版本顺序排列的迁移列表，只能追加，不能修改已发布的迁移
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create_tasks",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS tasks (
+				id         TEXT PRIMARY KEY,
+				status     TEXT NOT NULL,
+				request    TEXT NOT NULL,
+				response   TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_tasks_created_at ON tasks(created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status)`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_webhooks",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS webhooks (
+				id         TEXT PRIMARY KEY,
+				data       TEXT NOT NULL,
+				created_at DATETIME NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+				id         TEXT PRIMARY KEY,
+				webhook_id TEXT NOT NULL,
+				data       TEXT NOT NULL,
+				created_at DATETIME NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at)`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_cookie_sets",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS cookie_sets (
+				id         TEXT PRIMARY KEY,
+				owner      TEXT NOT NULL,
+				domain     TEXT NOT NULL,
+				data       TEXT NOT NULL,
+				created_at DATETIME NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_cookie_sets_owner_domain ON cookie_sets(owner, domain)`,
+		},
+	},
+}
+
+// migrate 执行尚未应用的迁移
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("创建迁移记录表失败: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("读取当前数据库版本失败: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("开始迁移事务失败: %w", err)
+		}
+
+		for _, stmt := range m.Statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("执行迁移 %d_%s 失败: %w", m.Version, m.Name, err)
+			}
+		}
+
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+			m.Version, m.Name, time.Now()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("记录迁移 %d_%s 失败: %w", m.Version, m.Name, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("提交迁移 %d_%s 失败: %w", m.Version, m.Name, err)
+		}
+
+		logrus.Infof("数据库迁移已应用: %d_%s", m.Version, m.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,347 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore 基于SQLite的任务存储
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore 打开SQLite数据库并执行迁移
+func NewSQLiteStore(dsn string) (*SQLiteStore, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("数据库连接字符串不能为空")
+	}
+
+	// 确保数据库文件所在目录存在
+	if path := SQLitePath(dsn); path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("创建数据库目录失败: %w", err)
+		}
+	}
+
+	dsn, err := sqliteDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("打开数据库失败: %w", err)
+	}
+
+	// SQLite 同一时间只允许一个写入者
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// sqliteDefaults 连接参数的默认值，DSN 中已指定（包括驱动支持的别名）时不覆盖
+var sqliteDefaults = []struct {
+	key     string
+	aliases []string
+	value   string
+}{
+	{"_busy_timeout", []string{"_timeout"}, "5000"},
+	{"_journal_mode", []string{"_journal"}, "WAL"},
+}
+
+// sqliteDSN 在 DSN 的查询参数中补充默认的连接参数，支持普通路径、file: URI 和已有的查询参数
+func sqliteDSN(dsn string) (string, error) {
+	base, rawQuery, _ := strings.Cut(dsn, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("数据库连接字符串的参数无效: %w", err)
+	}
+	for _, param := range sqliteDefaults {
+		if query.Has(param.key) || slices.ContainsFunc(param.aliases, query.Has) {
+			continue
+		}
+		query.Set(param.key, param.value)
+	}
+	return base + "?" + query.Encode(), nil
+}
+
+// SQLitePath 返回 DSN 对应的数据库文件路径，内存数据库返回空字符串
+func SQLitePath(dsn string) string {
+	base, rawQuery, _ := strings.Cut(dsn, "?")
+	path := strings.TrimPrefix(base, "file:")
+	query, _ := url.ParseQuery(rawQuery)
+	if path == "" || path == ":memory:" || query.Get("mode") == "memory" {
+		return ""
+	}
+	return path
+}
+
+// SaveTask 保存任务
+func (s *SQLiteStore) SaveTask(task *Task) error {
+	request, err := json.Marshal(task.Request)
+	if err != nil {
+		return fmt.Errorf("序列化下载请求失败: %w", err)
+	}
+	response, err := json.Marshal(task.Response)
+	if err != nil {
+		return fmt.Errorf("序列化下载响应失败: %w", err)
+	}
+
+	status := ""
+	if task.Response != nil {
+		status = string(task.Response.Status)
+	}
+
+	_, err = s.db.Exec(`INSERT INTO tasks (id, status, request, response, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			status = excluded.status,
+			request = excluded.request,
+			response = excluded.response,
+			updated_at = excluded.updated_at`,
+		task.ID, status, string(request), string(response), task.Created, task.Updated)
+	if err != nil {
+		return fmt.Errorf("保存任务失败: %w", err)
+	}
+	return nil
+}
+
+// GetTask 获取单个任务
+func (s *SQLiteStore) GetTask(id string) (*Task, error) {
+	row := s.db.QueryRow(`SELECT id, request, response, created_at, updated_at FROM tasks WHERE id = ?`, id)
+	task, err := scanTask(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	return task, err
+}
+
+// ListTasks 列出所有任务
+func (s *SQLiteStore) ListTasks() ([]*Task, error) {
+	rows, err := s.db.Query(`SELECT id, request, response, created_at, updated_at FROM tasks ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("查询任务失败: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// DeleteTask 删除单个任务
+func (s *SQLiteStore) DeleteTask(id string) error {
+	if _, err := s.db.Exec(`DELETE FROM tasks WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("删除任务失败: %w", err)
+	}
+	return nil
+}
+
+// ClearTasks 删除所有任务
+func (s *SQLiteStore) ClearTasks() error {
+	if _, err := s.db.Exec(`DELETE FROM tasks`); err != nil {
+		return fmt.Errorf("清空任务失败: %w", err)
+	}
+	return nil
+}
+
+// SaveWebhook 保存 webhook
+func (s *SQLiteStore) SaveWebhook(hook *Webhook) error {
+	data, err := json.Marshal(hook)
+	if err != nil {
+		return fmt.Errorf("序列化 webhook 失败: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO webhooks (id, data, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET data = excluded.data`,
+		hook.ID, string(data), hook.Created)
+	if err != nil {
+		return fmt.Errorf("保存 webhook 失败: %w", err)
+	}
+	return nil
+}
+
+// ListWebhooks 列出所有 webhook
+func (s *SQLiteStore) ListWebhooks() ([]*Webhook, error) {
+	rows, err := s.db.Query(`SELECT data FROM webhooks ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("查询 webhook 失败: %w", err)
+	}
+	defer rows.Close()
+
+	var hooks []*Webhook
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var hook Webhook
+		if err := json.Unmarshal([]byte(data), &hook); err != nil {
+			return nil, fmt.Errorf("解析 webhook 失败: %w", err)
+		}
+		hooks = append(hooks, &hook)
+	}
+	return hooks, rows.Err()
+}
+
+// DeleteWebhook 删除 webhook 及其投递记录
+func (s *SQLiteStore) DeleteWebhook(id string) error {
+	result, err := s.db.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("删除 webhook 失败: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrWebhookNotFound
+	}
+	if _, err := s.db.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return fmt.Errorf("删除投递记录失败: %w", err)
+	}
+	return nil
+}
+
+// SaveDelivery 保存投递记录，并删除超出保留数量的旧记录
+func (s *SQLiteStore) SaveDelivery(delivery *WebhookDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("序列化投递记录失败: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO webhook_deliveries (id, webhook_id, data, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET data = excluded.data`,
+		delivery.ID, delivery.WebhookID, string(data), delivery.Created)
+	if err != nil {
+		return fmt.Errorf("保存投递记录失败: %w", err)
+	}
+
+	_, err = s.db.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ? AND id NOT IN (
+			SELECT id FROM webhook_deliveries WHERE webhook_id = ? ORDER BY created_at DESC LIMIT ?
+		)`, delivery.WebhookID, delivery.WebhookID, MaxDeliveries)
+	if err != nil {
+		return fmt.Errorf("清理投递记录失败: %w", err)
+	}
+	return nil
+}
+
+// ListDeliveries 列出 webhook 最近的投递记录
+func (s *SQLiteStore) ListDeliveries(webhookID string, limit int) ([]*WebhookDelivery, error) {
+	rows, err := s.db.Query(`SELECT data FROM webhook_deliveries WHERE webhook_id = ? ORDER BY created_at DESC LIMIT ?`,
+		webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("查询投递记录失败: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var delivery WebhookDelivery
+		if err := json.Unmarshal([]byte(data), &delivery); err != nil {
+			return nil, fmt.Errorf("解析投递记录失败: %w", err)
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// SaveCookieSet 保存 Cookie 集合
+func (s *SQLiteStore) SaveCookieSet(set *CookieSet) error {
+	data, err := json.Marshal(set)
+	if err != nil {
+		return fmt.Errorf("序列化 Cookie 集合失败: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO cookie_sets (id, owner, domain, data, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET owner = excluded.owner, domain = excluded.domain, data = excluded.data`,
+		set.ID, set.Owner, set.Domain, string(data), set.Created)
+	if err != nil {
+		return fmt.Errorf("保存 Cookie 集合失败: %w", err)
+	}
+	return nil
+}
+
+// ListCookieSets 列出所有 Cookie 集合
+func (s *SQLiteStore) ListCookieSets() ([]*CookieSet, error) {
+	rows, err := s.db.Query(`SELECT data FROM cookie_sets ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("查询 Cookie 集合失败: %w", err)
+	}
+	defer rows.Close()
+
+	var sets []*CookieSet
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var set CookieSet
+		if err := json.Unmarshal([]byte(data), &set); err != nil {
+			return nil, fmt.Errorf("解析 Cookie 集合失败: %w", err)
+		}
+		sets = append(sets, &set)
+	}
+	return sets, rows.Err()
+}
+
+// DeleteCookieSet 删除 Cookie 集合
+func (s *SQLiteStore) DeleteCookieSet(id string) error {
+	result, err := s.db.Exec(`DELETE FROM cookie_sets WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("删除 Cookie 集合失败: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrCookieSetNotFound
+	}
+	return nil
+}
+
+// Close 关闭数据库
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// rowScanner 兼容 *sql.Row 和 *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTask 从查询结果中读取任务
+func scanTask(row rowScanner) (*Task, error) {
+	var (
+		task                 Task
+		request, response    string
+		createdAt, updatedAt time.Time
+	)
+	if err := row.Scan(&task.ID, &request, &response, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(request), &task.Request); err != nil {
+		return nil, fmt.Errorf("解析下载请求失败: %w", err)
+	}
+	if err := json.Unmarshal([]byte(response), &task.Response); err != nil {
+		return nil, fmt.Errorf("解析下载响应失败: %w", err)
+	}
+	task.Created = createdAt
+	task.Updated = updatedAt
+
+	return &task, nil
+}
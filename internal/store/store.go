@@ -0,0 +1,113 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"video-hunter/internal/config"
+	"video-hunter/internal/downloader"
+)
+
+// ErrTaskNotFound 任务不存在
+var ErrTaskNotFound = errors.New("任务不存在")
+
+// ErrWebhookNotFound webhook 不存在
+var ErrWebhookNotFound = errors.New("webhook 不存在")
+
+// ErrCookieSetNotFound Cookie 集合不存在
+var ErrCookieSetNotFound = errors.New("Cookie 集合不存在")
+
+// MaxDeliveries 每个 webhook 保留的投递记录数
+const MaxDeliveries = 1000
+
+// Task 持久化的下载任务记录
+type Task struct {
+	ID       string                       `json:"id"`
+	Request  *downloader.DownloadRequest  `json:"request"`
+	Response *downloader.DownloadResponse `json:"response"`
+	Created  time.Time                    `json:"created"`
+	Updated  time.Time                    `json:"updated"`
+}
+
+// Webhook 通过API创建的 webhook 订阅
+type Webhook struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name,omitempty"`
+	URL     string    `json:"url"`
+	Secret  string    `json:"secret,omitempty"` // 用于 HMAC-SHA256 签名
+	Events  []string  `json:"events,omitempty"` // 订阅的事件，为空时订阅所有事件
+	Owner   string    `json:"owner,omitempty"`  // 只接收该用户任务的事件，为空时接收所有任务的事件
+	Created time.Time `json:"created"`
+}
+
+// WebhookDelivery 一次 webhook 投递，每次尝试后更新
+type WebhookDelivery struct {
+	ID         string     `json:"id"`
+	WebhookID  string     `json:"webhook_id"`
+	Event      string     `json:"event"`
+	TaskID     string     `json:"task_id"`
+	Status     string     `json:"status"` // pending / succeeded / failed
+	Attempts   int        `json:"attempts"`
+	StatusCode int        `json:"status_code,omitempty"` // 最后一次请求的响应状态码
+	Error      string     `json:"error,omitempty"`       // 最后一次请求的错误
+	NextRetry  *time.Time `json:"next_retry,omitempty"`
+	Created    time.Time  `json:"created"`
+	Updated    time.Time  `json:"updated"`
+}
+
+// CookieSet 通过API上传的一个域名的 Cookie，每个用户每个域名一份
+type CookieSet struct {
+	ID      string     `json:"id"`
+	Domain  string     `json:"domain"`
+	Owner   string     `json:"owner,omitempty"`
+	Count   int        `json:"count"`
+	Expires *time.Time `json:"expires,omitempty"` // 最早过期的 Cookie 的过期时间，全部为会话 Cookie 时为空
+	Data    []byte     `json:"data"`              // 加密后的 Netscape cookies.txt
+	Created time.Time  `json:"created"`
+	Updated time.Time  `json:"updated"`
+}
+
+// Store 任务存储接口
+type Store interface {
+	// SaveTask 保存任务（存在则更新）
+	SaveTask(task *Task) error
+	// GetTask 获取单个任务
+	GetTask(id string) (*Task, error)
+	// ListTasks 按创建时间顺序列出所有任务
+	ListTasks() ([]*Task, error)
+	// DeleteTask 删除单个任务
+	DeleteTask(id string) error
+	// ClearTasks 删除所有任务
+	ClearTasks() error
+	// SaveWebhook 保存 webhook（存在则更新）
+	SaveWebhook(hook *Webhook) error
+	// ListWebhooks 按创建时间顺序列出所有 webhook
+	ListWebhooks() ([]*Webhook, error)
+	// DeleteWebhook 删除 webhook 及其投递记录
+	DeleteWebhook(id string) error
+	// SaveDelivery 保存投递记录（存在则更新），每个 webhook 只保留最近 MaxDeliveries 条
+	SaveDelivery(delivery *WebhookDelivery) error
+	// ListDeliveries 按创建时间倒序列出 webhook 最近的投递记录
+	ListDeliveries(webhookID string, limit int) ([]*WebhookDelivery, error)
+	// SaveCookieSet 保存 Cookie 集合（存在则更新）
+	SaveCookieSet(set *CookieSet) error
+	// ListCookieSets 按创建时间顺序列出所有 Cookie 集合
+	ListCookieSets() ([]*CookieSet, error)
+	// DeleteCookieSet 删除 Cookie 集合
+	DeleteCookieSet(id string) error
+	// Close 关闭存储
+	Close() error
+}
+
+// NewStore 根据数据库配置创建存储
+func NewStore(cfg config.DatabaseConfig) (Store, error) {
+	switch cfg.Driver {
+	case "sqlite", "sqlite3":
+		return NewSQLiteStore(cfg.DSN)
+	case "memory", "":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("不支持的数据库驱动: %s", cfg.Driver)
+	}
+}
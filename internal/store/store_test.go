@@ -0,0 +1,340 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"video-hunter/internal/downloader"
+)
+
+// storeFactories 两种存储实现，每个用例分别在它们上运行
+var storeFactories = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+	{"sqlite", func(t *testing.T) Store {
+		s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "data", "test.db"))
+		if err != nil {
+			t.Fatalf("打开 SQLite 存储失败: %v", err)
+		}
+		return s
+	}},
+}
+
+// eachStore 在每种存储实现上运行 fn
+func eachStore(t *testing.T, fn func(t *testing.T, s Store)) {
+	for _, factory := range storeFactories {
+		t.Run(factory.name, func(t *testing.T) {
+			s := factory.open(t)
+			defer s.Close()
+			fn(t, s)
+		})
+	}
+}
+
+func newTask(id string, created time.Time, status downloader.DownloadStatus) *Task {
+	return &Task{
+		ID:       id,
+		Request:  &downloader.DownloadRequest{URL: "https://example.com/" + id},
+		Response: &downloader.DownloadResponse{ID: id, Status: status},
+		Created:  created,
+		Updated:  created,
+	}
+}
+
+func TestStoreTasks(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		run     func(s Store) error
+		wantIDs []string
+	}{
+		{
+			name: "按创建时间排序",
+			run: func(s Store) error {
+				for i, id := range []string{"c", "a", "b"} {
+					created := base.Add(time.Duration([]int{3, 1, 2}[i]) * time.Minute)
+					if err := s.SaveTask(newTask(id, created, downloader.StatusPending)); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			wantIDs: []string{"a", "b", "c"},
+		},
+		{
+			name: "保存已存在的任务时更新",
+			run: func(s Store) error {
+				if err := s.SaveTask(newTask("a", base, downloader.StatusPending)); err != nil {
+					return err
+				}
+				return s.SaveTask(newTask("a", base, downloader.StatusCompleted))
+			},
+			wantIDs: []string{"a"},
+		},
+		{
+			name: "删除任务",
+			run: func(s Store) error {
+				for i, id := range []string{"a", "b"} {
+					if err := s.SaveTask(newTask(id, base.Add(time.Duration(i)*time.Minute), downloader.StatusPending)); err != nil {
+						return err
+					}
+				}
+				return s.DeleteTask("a")
+			},
+			wantIDs: []string{"b"},
+		},
+		{
+			name: "清空任务",
+			run: func(s Store) error {
+				if err := s.SaveTask(newTask("a", base, downloader.StatusPending)); err != nil {
+					return err
+				}
+				return s.ClearTasks()
+			},
+			wantIDs: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachStore(t, func(t *testing.T, s Store) {
+				if err := tt.run(s); err != nil {
+					t.Fatalf("执行失败: %v", err)
+				}
+				tasks, err := s.ListTasks()
+				if err != nil {
+					t.Fatalf("ListTasks 失败: %v", err)
+				}
+				var ids []string
+				for _, task := range tasks {
+					ids = append(ids, task.ID)
+				}
+				if !slices.Equal(ids, tt.wantIDs) {
+					t.Errorf("任务 = %v, 期望 %v", ids, tt.wantIDs)
+				}
+			})
+		})
+	}
+}
+
+func TestStoreGetTask(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		if err := s.SaveTask(newTask("a", created, downloader.StatusCompleted)); err != nil {
+			t.Fatalf("SaveTask 失败: %v", err)
+		}
+
+		task, err := s.GetTask("a")
+		if err != nil {
+			t.Fatalf("GetTask 失败: %v", err)
+		}
+		if task.Response.Status != downloader.StatusCompleted || task.Request.URL != "https://example.com/a" {
+			t.Errorf("任务内容不一致: %+v %+v", task.Request, task.Response)
+		}
+		if !task.Created.Equal(created) {
+			t.Errorf("创建时间 = %v, 期望 %v", task.Created, created)
+		}
+
+		if _, err := s.GetTask("missing"); !errors.Is(err, ErrTaskNotFound) {
+			t.Errorf("不存在的任务返回 %v, 期望 ErrTaskNotFound", err)
+		}
+	})
+}
+
+func TestStoreWebhooks(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i, id := range []string{"h1", "h2"} {
+			hook := &Webhook{ID: id, URL: "https://example.com/" + id, Created: base.Add(time.Duration(i) * time.Minute)}
+			if err := s.SaveWebhook(hook); err != nil {
+				t.Fatalf("SaveWebhook 失败: %v", err)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			delivery := &WebhookDelivery{
+				ID:        string(rune('a' + i)),
+				WebhookID: "h1",
+				Status:    "pending",
+				Created:   base.Add(time.Duration(i) * time.Second),
+			}
+			if err := s.SaveDelivery(delivery); err != nil {
+				t.Fatalf("SaveDelivery 失败: %v", err)
+			}
+		}
+		// 更新已有的投递记录不新增记录
+		if err := s.SaveDelivery(&WebhookDelivery{ID: "a", WebhookID: "h1", Status: "succeeded", Created: base}); err != nil {
+			t.Fatalf("SaveDelivery 失败: %v", err)
+		}
+
+		deliveries, err := s.ListDeliveries("h1", 2)
+		if err != nil {
+			t.Fatalf("ListDeliveries 失败: %v", err)
+		}
+		if got := deliveryIDs(deliveries); !slices.Equal(got, []string{"c", "b"}) {
+			t.Errorf("最近的投递记录 = %v, 期望 [c b]", got)
+		}
+		deliveries, _ = s.ListDeliveries("h1", 10)
+		if len(deliveries) != 3 || deliveries[2].Status != "succeeded" {
+			t.Errorf("投递记录 = %v, 期望 3 条且 a 已更新", deliveryIDs(deliveries))
+		}
+
+		if err := s.DeleteWebhook("h1"); err != nil {
+			t.Fatalf("DeleteWebhook 失败: %v", err)
+		}
+		if err := s.DeleteWebhook("h1"); !errors.Is(err, ErrWebhookNotFound) {
+			t.Errorf("重复删除返回 %v, 期望 ErrWebhookNotFound", err)
+		}
+		if deliveries, _ := s.ListDeliveries("h1", 10); len(deliveries) != 0 {
+			t.Errorf("删除 webhook 后仍有 %d 条投递记录", len(deliveries))
+		}
+		hooks, err := s.ListWebhooks()
+		if err != nil {
+			t.Fatalf("ListWebhooks 失败: %v", err)
+		}
+		if len(hooks) != 1 || hooks[0].ID != "h2" {
+			t.Errorf("webhook 列表 = %+v, 期望只有 h2", hooks)
+		}
+	})
+}
+
+func TestStoreCookieSets(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		sets := []*CookieSet{
+			{ID: "s1", Domain: "bilibili.com", Owner: "alice", Count: 2, Data: []byte("secret"), Created: base},
+			{ID: "s2", Domain: "douyin.com", Count: 1, Data: []byte("other"), Created: base.Add(time.Minute)},
+		}
+		for _, set := range sets {
+			if err := s.SaveCookieSet(set); err != nil {
+				t.Fatalf("SaveCookieSet 失败: %v", err)
+			}
+		}
+		// 重新上传同一域名时更新
+		updated := *sets[0]
+		updated.Count = 5
+		if err := s.SaveCookieSet(&updated); err != nil {
+			t.Fatalf("SaveCookieSet 失败: %v", err)
+		}
+
+		got, err := s.ListCookieSets()
+		if err != nil {
+			t.Fatalf("ListCookieSets 失败: %v", err)
+		}
+		if len(got) != 2 || got[0].ID != "s1" || got[0].Count != 5 || string(got[0].Data) != "secret" {
+			t.Errorf("Cookie 集合 = %+v", got)
+		}
+
+		if err := s.DeleteCookieSet("s1"); err != nil {
+			t.Fatalf("DeleteCookieSet 失败: %v", err)
+		}
+		if err := s.DeleteCookieSet("s1"); !errors.Is(err, ErrCookieSetNotFound) {
+			t.Errorf("重复删除返回 %v, 期望 ErrCookieSetNotFound", err)
+		}
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// 重复打开时不再执行已应用的迁移
+	for i := 0; i < 2; i++ {
+		s, err := NewSQLiteStore(path)
+		if err != nil {
+			t.Fatalf("第 %d 次打开失败: %v", i+1, err)
+		}
+		s.Close()
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT version, name FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		t.Fatalf("查询迁移记录失败: %v", err)
+	}
+	defer rows.Close()
+	var applied []migration
+	for rows.Next() {
+		var m migration
+		if err := rows.Scan(&m.Version, &m.Name); err != nil {
+			t.Fatalf("读取迁移记录失败: %v", err)
+		}
+		applied = append(applied, m)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("已应用 %d 个迁移, 期望 %d 个", len(applied), len(migrations))
+	}
+	for i, m := range migrations {
+		if applied[i].Version != m.Version || applied[i].Name != m.Name {
+			t.Errorf("第 %d 个迁移 = %d_%s, 期望 %d_%s", i, applied[i].Version, applied[i].Name, m.Version, m.Name)
+		}
+	}
+
+	for _, table := range []string{"tasks", "webhooks", "webhook_deliveries", "cookie_sets"} {
+		var name string
+		if err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&name); err != nil {
+			t.Errorf("表 %s 不存在: %v", table, err)
+		}
+	}
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("迁移 %s 的版本为 %d, 期望 %d", m.Name, m.Version, i+1)
+		}
+	}
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+		path string
+	}{
+		{"./data/video-hunter.db", "./data/video-hunter.db?_busy_timeout=5000&_journal_mode=WAL", "./data/video-hunter.db"},
+		{"file:./data/a.db?cache=shared", "file:./data/a.db?_busy_timeout=5000&_journal_mode=WAL&cache=shared", "./data/a.db"},
+		{"a.db?_busy_timeout=100", "a.db?_busy_timeout=100&_journal_mode=WAL", "a.db"},
+		{"a.db?_timeout=100&_journal=DELETE", "a.db?_journal=DELETE&_timeout=100", "a.db"},
+		{":memory:", ":memory:?_busy_timeout=5000&_journal_mode=WAL", ""},
+		{"file::memory:?cache=shared", "file::memory:?_busy_timeout=5000&_journal_mode=WAL&cache=shared", ""},
+		{"file:a.db?mode=memory", "file:a.db?_busy_timeout=5000&_journal_mode=WAL&mode=memory", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			got, err := sqliteDSN(tt.dsn)
+			if err != nil {
+				t.Fatalf("sqliteDSN 失败: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("sqliteDSN = %q, 期望 %q", got, tt.want)
+			}
+			if path := SQLitePath(tt.dsn); path != tt.path {
+				t.Errorf("SQLitePath = %q, 期望 %q", path, tt.path)
+			}
+		})
+	}
+
+	if _, err := sqliteDSN("a.db?%zz"); err == nil {
+		t.Error("无效的查询参数应返回错误")
+	}
+}
+
+func deliveryIDs(deliveries []*WebhookDelivery) []string {
+	ids := make([]string, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.ID)
+	}
+	return ids
+}
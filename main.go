@@ -13,6 +13,7 @@ import (
 
 	"video-hunter/internal/config"
 	"video-hunter/internal/handler"
+	"video-hunter/internal/middleware"
 	"video-hunter/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -51,31 +52,43 @@ func main() {
 	// 设置日志
 	setupLogger(cfg)
 
+	// 监听非本机地址但没有启用认证时提醒
+	if !cfg.Security.Auth.Enabled && cfg.Server.Host != "127.0.0.1" && cfg.Server.Host != "localhost" {
+		logrus.Warnf("未启用 security.auth，服务监听在 %s:%d，所有人都可以访问API", cfg.Server.Host, cfg.Server.Port)
+	}
+
 	// 设置Gin模式
 	gin.SetMode(cfg.Server.Mode)
 
+	if err := run(cfg); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// run 创建服务并启动HTTP服务器，收到中断信号后关闭；出错时也会先关闭服务再返回，
+// 确保正在运行的下载任务被结束、任务存储被关闭
+func run(cfg *config.Config) error {
 	// 创建服务
-	svc := service.NewService(cfg)
+	svc, err := service.NewService(cfg)
+	if err != nil {
+		return fmt.Errorf("创建服务失败: %w", err)
+	}
+	defer svc.Close()
 
-	// 创建路由
-	router := gin.Default()
+	// 创建路由，访问日志隐藏 ?token= 中的API密钥
+	router := gin.New()
+	router.Use(middleware.Logger(), gin.Recovery())
 
-	// 设置CORS
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
+	// 只采信可信代理转发的客户端 IP，避免伪造 X-Forwarded-For 绕过限流
+	if err := router.SetTrustedProxies(cfg.Security.TrustedProxies); err != nil {
+		return fmt.Errorf("设置可信代理失败: %w", err)
+	}
 
-		c.Next()
-	})
+	// 设置CORS
+	router.Use(middleware.CORS(cfg.Security.CorsOrigins))
 
 	// 注册路由
-	handler.RegisterRoutes(router, svc)
+	handler.RegisterRoutes(router, svc, cfg)
 
 	// 创建HTTP服务器
 	server := &http.Server{
@@ -83,18 +96,26 @@ func main() {
 		Handler: router,
 	}
 
+	// 关闭服务器时断开 WebSocket 和 SSE 长连接，不等待它们自行结束
+	server.RegisterOnShutdown(svc.CloseConnections)
+
 	// 启动服务器
+	serverErr := make(chan error, 1)
 	go func() {
 		logrus.Infof("🚀 Video Hunter 服务启动在 http://%s:%d", cfg.Server.Host, cfg.Server.Port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logrus.Fatalf("服务器启动失败: %v", err)
+			serverErr <- err
 		}
 	}()
 
 	// 等待中断信号
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	select {
+	case <-quit:
+	case err := <-serverErr:
+		return fmt.Errorf("服务器启动失败: %w", err)
+	}
 
 	logrus.Info("🛑 正在关闭服务器...")
 
@@ -107,6 +128,7 @@ func main() {
 	}
 
 	logrus.Info("✅ 服务器已关闭")
+	return nil
 }
 
 // setupLogger 设置日志
@@ -5,16 +5,136 @@ class VideoHunterApp {
         this.downloads = new Map();
         this.currentDownloadId = null;
         this.pollingInterval = null;
+        this.token = localStorage.getItem('videoHunterToken') || '';
+        this.authEnabled = false;
+        this.started = false;
         this.init();
     }
 
-    init() {
+    async init() {
         this.bindEvents();
+
+        // 启用认证时先登录，再建立连接和加载列表
+        try {
+            const response = await fetch('/api/auth/status');
+            const status = await response.json();
+            this.authEnabled = status.enabled;
+        } catch (error) {
+            console.error('获取认证状态失败:', error);
+        }
+
+        if (!this.authEnabled) {
+            this.start();
+            return;
+        }
+
+        if (this.token) {
+            const response = await this.apiFetch('/api/auth/me');
+            if (response.ok) {
+                this.showCurrentUser(await response.json());
+                this.start();
+            }
+            return;
+        }
+        this.showLogin();
+    }
+
+    start() {
+        if (this.started) {
+            return;
+        }
+        this.started = true;
         this.connectWebSocket();
         this.loadDownloads();
         this.startProgressPolling();
     }
 
+    // 请求API，启用认证时携带API密钥，密钥失效时重新登录
+    async apiFetch(url, options = {}) {
+        const headers = { ...(options.headers || {}) };
+        if (this.token) {
+            headers['Authorization'] = `Bearer ${this.token}`;
+        }
+
+        const response = await fetch(url, { ...options, headers });
+        if (response.status === 401 && this.authEnabled) {
+            this.handleUnauthorized();
+        }
+        return response;
+    }
+
+    handleUnauthorized() {
+        this.token = '';
+        localStorage.removeItem('videoHunterToken');
+        this.started = false;
+        this.stopProgressPolling();
+        if (this.ws) {
+            this.ws.onclose = null;
+            this.ws.close();
+            this.ws = null;
+        }
+        this.showLogin('登录已失效，请重新输入API密钥');
+    }
+
+    showLogin(message = '') {
+        const error = document.getElementById('loginError');
+        error.textContent = message;
+        error.classList.toggle('hidden', !message);
+        document.getElementById('loginModal').classList.remove('hidden');
+        document.getElementById('loginModal').classList.add('flex');
+        document.getElementById('loginToken').focus();
+    }
+
+    hideLogin() {
+        document.getElementById('loginModal').classList.add('hidden');
+        document.getElementById('loginModal').classList.remove('flex');
+    }
+
+    async login() {
+        const token = document.getElementById('loginToken').value.trim();
+        if (!token) {
+            this.showLogin('请输入API密钥');
+            return;
+        }
+
+        try {
+            const response = await fetch('/api/auth/login', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                },
+                body: JSON.stringify({ token })
+            });
+            const data = await response.json().catch(() => ({}));
+            if (!response.ok) {
+                this.showLogin(data.error || `HTTP ${response.status}`);
+                return;
+            }
+
+            this.token = token;
+            localStorage.setItem('videoHunterToken', token);
+            document.getElementById('loginToken').value = '';
+            this.hideLogin();
+            this.showCurrentUser(data);
+            this.start();
+        } catch (error) {
+            this.showLogin(`登录失败: ${error.message}`);
+        }
+    }
+
+    logout() {
+        localStorage.removeItem('videoHunterToken');
+        window.location.reload();
+    }
+
+    showCurrentUser(identity) {
+        if (!identity || !identity.name) {
+            return;
+        }
+        document.getElementById('currentUser').textContent = `${identity.name} 退出`;
+        document.getElementById('logoutBtn').classList.remove('hidden');
+    }
+
     bindEvents() {
         // 下载按钮点击
         document.getElementById('downloadBtn').addEventListener('click', () => {
@@ -71,11 +191,26 @@ class VideoHunterApp {
         document.getElementById('helpBtn').addEventListener('click', () => {
             this.showHelp();
         });
+
+        // 登录
+        document.getElementById('loginForm').addEventListener('submit', (e) => {
+            e.preventDefault();
+            this.login();
+        });
+
+        // 退出登录
+        document.getElementById('logoutBtn').addEventListener('click', () => {
+            this.logout();
+        });
     }
 
     connectWebSocket() {
         const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-        const wsUrl = `${protocol}//${window.location.host}/ws`;
+        let wsUrl = `${protocol}//${window.location.host}/ws`;
+        if (this.token) {
+            // 浏览器无法为 WebSocket 设置请求头，通过查询参数传递API密钥
+            wsUrl += `?token=${encodeURIComponent(this.token)}`;
+        }
         
         this.ws = new WebSocket(wsUrl);
         
@@ -92,8 +227,12 @@ class VideoHunterApp {
         this.ws.onclose = () => {
             console.log('WebSocket连接已关闭');
             this.showNotification('连接断开，正在重连...', 'warning');
-            // 5秒后重连
-            setTimeout(() => this.connectWebSocket(), 5000);
+            // 5秒后重连，退出登录后不再重连
+            setTimeout(() => {
+                if (this.started) {
+                    this.connectWebSocket();
+                }
+            }, 5000);
         };
         
         this.ws.onerror = (error) => {
@@ -118,6 +257,7 @@ class VideoHunterApp {
                     progress: download.progress,
                     speed: download.speed,
                     eta: download.eta,
+                    stage: download.stage,
                     status: download.status,
                     file: download.file || existingDownload.file,
                     updated: new Date().toISOString()
@@ -141,6 +281,14 @@ class VideoHunterApp {
                 this.updateDownloadList();
             }
         }
+
+        // 处理重试消息
+        if (data.type === 'retry') {
+            this.showNotification(
+                `下载出错，${Math.round(data.delay)}秒后进行第 ${data.attempt}/${data.max_retries} 次重试: ${data.error}`,
+                'warning'
+            );
+        }
     }
 
     async startDownload() {
@@ -157,7 +305,7 @@ class VideoHunterApp {
 
         try {
             // 创建下载任务
-            const createResponse = await fetch('/api/download', {
+            const createResponse = await this.apiFetch('/api/download', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -166,7 +314,8 @@ class VideoHunterApp {
             });
 
             if (!createResponse.ok) {
-                throw new Error(`HTTP ${createResponse.status}: ${createResponse.statusText}`);
+                const data = await createResponse.json().catch(() => ({}));
+                throw new Error(data.error || `HTTP ${createResponse.status}: ${createResponse.statusText}`);
             }
 
             const downloadTask = await createResponse.json();
@@ -205,7 +354,7 @@ class VideoHunterApp {
         }
 
         try {
-            const response = await fetch(`/api/video-info?url=${encodeURIComponent(url)}`);
+            const response = await this.apiFetch(`/api/video-info?url=${encodeURIComponent(url)}`);
             if (!response.ok) {
                 throw new Error(`HTTP ${response.status}`);
             }
@@ -307,7 +456,7 @@ class VideoHunterApp {
 
     async loadDownloads() {
         try {
-            const response = await fetch('/api/downloads');
+            const response = await this.apiFetch('/api/downloads');
             if (!response.ok) {
                 throw new Error(`HTTP ${response.status}`);
             }
@@ -384,7 +533,7 @@ class VideoHunterApp {
                 
                 <div class="mb-3">
                     <div class="flex justify-between text-sm text-gray-600 mb-1">
-                        <span>进度: <span class="progress-text">${progress.toFixed(1)}%</span></span>
+                        <span>进度: <span class="progress-text">${progress.toFixed(1)}%</span> <span class="stage-text text-gray-500">${this.getStageText(download)}</span></span>
                         <span class="speed-text">${download.speed || ''} <span class="eta-text">${download.eta ? `ETA: ${download.eta}` : ''}</span></span>
                     </div>
                     <div class="w-full bg-gray-200 rounded-full h-2">
@@ -394,7 +543,19 @@ class VideoHunterApp {
 
                 <div class="flex justify-between items-center">
                     <div class="flex space-x-2">
-                        ${download.status === 'downloading' ? `
+                        ${download.status === 'pending' ? `
+                            <button onclick="app.pauseDownload('${download.id}')" 
+                                    class="text-yellow-600 hover:text-yellow-800 text-sm">
+                                <i class="fas fa-pause mr-1"></i>暂停
+                            </button>
+                        ` : ''}
+                        ${download.status === 'paused' ? `
+                            <button onclick="app.resumeDownload('${download.id}')" 
+                                    class="text-blue-600 hover:text-blue-800 text-sm">
+                                <i class="fas fa-play mr-1"></i>继续
+                            </button>
+                        ` : ''}
+                        ${['pending', 'paused', 'downloading'].includes(download.status) ? `
                             <button onclick="app.cancelDownload('${download.id}')" 
                                     class="text-red-600 hover:text-red-800 text-sm">
                                 <i class="fas fa-stop mr-1"></i>取消
@@ -424,6 +585,7 @@ class VideoHunterApp {
     getStatusClass(status) {
         switch (status) {
             case 'pending': return 'border-yellow-500';
+            case 'paused': return 'border-yellow-300';
             case 'downloading': return 'border-blue-500';
             case 'completed': return 'border-green-500';
             case 'failed': return 'border-red-500';
@@ -435,6 +597,7 @@ class VideoHunterApp {
     getStatusIcon(status) {
         switch (status) {
             case 'pending': return 'fas fa-clock';
+            case 'paused': return 'fas fa-pause-circle';
             case 'downloading': return 'fas fa-download';
             case 'completed': return 'fas fa-check-circle';
             case 'failed': return 'fas fa-times-circle';
@@ -446,6 +609,7 @@ class VideoHunterApp {
     getStatusText(status) {
         switch (status) {
             case 'pending': return '等待中';
+            case 'paused': return '已暂停';
             case 'downloading': return '下载中';
             case 'completed': return '已完成';
             case 'failed': return '失败';
@@ -454,9 +618,24 @@ class VideoHunterApp {
         }
     }
 
+    // 下载中的任务显示当前阶段
+    getStageText(download) {
+        if (download.status !== 'downloading') {
+            return '';
+        }
+        switch (download.stage) {
+            case 'resolving': return '解析中';
+            case 'downloading_video': return '下载视频';
+            case 'downloading_audio': return '下载音频';
+            case 'merging': return '合并音视频';
+            case 'post_processing': return '后处理';
+            default: return '';
+        }
+    }
+
     async cancelDownload(id) {
         try {
-            const response = await fetch(`/api/downloads/${id}/cancel`, {
+            const response = await this.apiFetch(`/api/downloads/${id}/cancel`, {
                 method: 'POST'
             });
 
@@ -472,6 +651,33 @@ class VideoHunterApp {
         }
     }
 
+    async pauseDownload(id) {
+        await this.updateQueuedDownload(id, 'pause', '下载已暂停', '暂停下载失败');
+    }
+
+    async resumeDownload(id) {
+        await this.updateQueuedDownload(id, 'resume', '下载已恢复', '恢复下载失败');
+    }
+
+    async updateQueuedDownload(id, action, successText, errorText) {
+        try {
+            const response = await this.apiFetch(`/api/downloads/${id}/${action}`, {
+                method: 'POST'
+            });
+
+            if (response.ok) {
+                this.showNotification(successText, 'success');
+                this.loadDownloads();
+            } else {
+                const data = await response.json().catch(() => ({}));
+                throw new Error(data.error || `HTTP ${response.status}`);
+            }
+        } catch (error) {
+            this.showNotification(`${errorText}: ${error.message}`, 'error');
+            console.error(`${errorText}:`, error);
+        }
+    }
+
     async clearAllDownloads() {
         // 使用更友好的确认对话框
         const confirmed = await this.showConfirmDialog(
@@ -488,7 +694,7 @@ class VideoHunterApp {
         this.showLoading('正在清空下载记录...');
 
         try {
-            const response = await fetch('/api/downloads/clear', {
+            const response = await this.apiFetch('/api/downloads/clear', {
                 method: 'POST'
             });
 
@@ -738,7 +944,7 @@ class VideoHunterApp {
     // 新增：只更新进度，不重新渲染整个列表
     async updateProgressOnly() {
         try {
-            const response = await fetch('/api/downloads');
+            const response = await this.apiFetch('/api/downloads');
             if (!response.ok) {
                 throw new Error(`HTTP ${response.status}`);
             }
@@ -784,6 +990,7 @@ class VideoHunterApp {
         const progressText = downloadElement.querySelector('.progress-text');
         const speedText = downloadElement.querySelector('.speed-text');
         const etaText = downloadElement.querySelector('.eta-text');
+        const stageText = downloadElement.querySelector('.stage-text');
         const updateTime = downloadElement.querySelector('.update-time');
         const statusText = downloadElement.querySelector('.font-medium');
 
@@ -799,6 +1006,9 @@ class VideoHunterApp {
         if (etaText) {
             etaText.textContent = download.eta ? `ETA: ${download.eta}` : '';
         }
+        if (stageText) {
+            stageText.textContent = this.getStageText(download);
+        }
         if (updateTime) {
             updateTime.textContent = new Date(download.updated).toLocaleTimeString('zh-CN');
         }
@@ -835,7 +1045,10 @@ class VideoHunterApp {
     async triggerFileDownload(downloadId) {
         try {
             // 创建一个隐藏的下载链接
-            const downloadUrl = `/api/downloads/${downloadId}/download`;
+            let downloadUrl = `/api/downloads/${downloadId}/download`;
+            if (this.token) {
+                downloadUrl += `?token=${encodeURIComponent(this.token)}`;
+            }
             const link = document.createElement('a');
             link.href = downloadUrl;
             link.download = ''; // 让浏览器自动处理文件名
@@ -867,6 +1080,18 @@ class VideoHunterApp {
                     <i class="fas fa-stop mr-1"></i>取消
                 </button>
             `;
+        } else if (download.status === 'pending' || download.status === 'paused') {
+            const paused = download.status === 'paused';
+            buttonContainer.innerHTML = `
+                <button onclick="app.${paused ? 'resumeDownload' : 'pauseDownload'}('${download.id}')" 
+                        class="text-yellow-600 hover:text-yellow-800 text-sm">
+                    <i class="fas ${paused ? 'fa-play' : 'fa-pause'} mr-1"></i>${paused ? '继续' : '暂停'}
+                </button>
+                <button onclick="app.cancelDownload('${download.id}')" 
+                        class="text-red-600 hover:text-red-800 text-sm">
+                    <i class="fas fa-stop mr-1"></i>取消
+                </button>
+            `;
         } else if (download.status === 'completed') {
             let buttons = '';
             // 始终显示"下载到本地"按钮